@@ -140,5 +140,14 @@ def learn():
     return jsonify({"success": True, "message": "calibrated data"})
 
 
+@app.route('/forget', methods=['POST'])
+def forget():
+    payload = request.get_json()
+    if payload is None or 'family' not in payload:
+        return jsonify({'success': False, 'message': 'must provide family'})
+    ai_cache.pop(payload['family'], None)
+    return jsonify({"success": True, "message": "forgot model"})
+
+
 if __name__ == "__main__":
     app.run(host='0.0.0.0')
@@ -1,10 +1,12 @@
 package main
 
 import (
+	"encoding/json"
 	"flag"
 	// "image"
 	// "image/color"
 	// "image/draw"
+	"io/ioutil"
 	"log"
 	// "math/rand"
 	"os"
@@ -14,12 +16,15 @@ import (
 	"runtime"
 	"runtime/pprof"
 	// "strconv"
+	"strings"
 	"time"
 
 	"fmt"
 
+	"github.com/Nimaapr/find3/server/main/src/analytics"
 	"github.com/Nimaapr/find3/server/main/src/api"
 	"github.com/Nimaapr/find3/server/main/src/database"
+	"github.com/Nimaapr/find3/server/main/src/models"
 	"github.com/Nimaapr/find3/server/main/src/mqtt"
 	"github.com/Nimaapr/find3/server/main/src/server"
 )
@@ -32,7 +37,8 @@ import (
 // Similarly, if the user specifies the option to profile CPU usage, the program sets up a routine that profiles CPU usage and writes to a file for 30 seconds.
 
 // Finally, the program runs the server and handles any errors that may occur.
-// If the user specifies a family database to dump, the program dumps the database, otherwise it runs the server.
+// If the user specifies a family database to dump, the program dumps the database. With -family and -export-device or
+// -erase-device it exports or erases one device of the family. Otherwise it runs the server.
 
 func main() {
 
@@ -68,7 +74,11 @@ func main() {
 	mqttAdmin := flag.String("mqtt-admin", "admin", "name for mqtt admin")
 	mqttPass := flag.String("mqtt-pass", "1234", "password for mqtt admin")
 	mqttDir := flag.String("mqtt-dir", "mosquitto_config", "location for mqtt admin")
+	mqttEmbedded := flag.Bool("mqtt-embedded", false, "run an MQTT broker in the server instead of mosquitto (listens on -mqtt-server, default :1883)")
 	dump := flag.String("dump", "", "family database to dump")
+	family := flag.String("family", "", "family of the device to export or erase")
+	exportDevice := flag.String("export-device", "", "device of -family to export to FAMILY.DEVICE.json")
+	eraseDevice := flag.String("erase-device", "", "device of -family to erase")
 	memprofile := flag.Bool("memprofile", false, "whether to profile memory")
 	cpuprofile := flag.Bool("cpuprofile", false, "whether to profile cpu")
 	var dataFolder string
@@ -93,6 +103,7 @@ func main() {
 	// setup debugging
 	database.Debug(*debug)
 	api.Debug(*debug)
+	analytics.Debug(*debug)
 	server.Debug(*debug)
 	mqtt.Debug = *debug
 
@@ -112,6 +123,10 @@ func main() {
 		mqtt.Server = *mqttServer
 	}
 	mqtt.MosquittoConfigDirectory = *mqttDir
+	mqtt.Embedded = *mqttEmbedded || os.Getenv("MQTT_EMBEDDED") == "1"
+	if mqtt.Embedded && mqtt.Server == "" {
+		mqtt.Server = ":1883"
+	}
 
 	api.AIPort = *aiPort
 	api.MainPort = *port
@@ -159,6 +174,17 @@ func main() {
 	}
 	if *dump != "" {
 		err = api.Dump(*dump)
+	} else if *exportDevice != "" {
+		err = exportDeviceToFile(*family, *exportDevice)
+	} else if *eraseDevice != "" {
+		var entry models.AuditEntry
+		entry, err = api.EraseDevice(*family, *eraseDevice, "cli")
+		if err == nil {
+			fmt.Printf("erased %s: %v\n", entry.Device, entry.Counts)
+			if entry.Counts["learning"] > 0 {
+				err = api.Calibrate(strings.ToLower(strings.TrimSpace(*family)), true)
+			}
+		}
 	} else {
 		err = server.Run()
 	}
@@ -167,3 +193,21 @@ func main() {
 		fmt.Println(err)
 	}
 }
+
+// exportDeviceToFile writes everything stored about a device to FAMILY.DEVICE.json
+func exportDeviceToFile(family, device string) (err error) {
+	export, err := api.ExportDevice(family, device, "cli")
+	if err != nil {
+		return
+	}
+	b, err := json.MarshalIndent(export, "", "  ")
+	if err != nil {
+		return
+	}
+	fname := fmt.Sprintf("%s.%s.json", export.Family, export.Device)
+	err = ioutil.WriteFile(fname, b, 0644)
+	if err == nil {
+		fmt.Println("wrote " + fname)
+	}
+	return
+}
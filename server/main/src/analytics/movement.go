@@ -0,0 +1,162 @@
+package analytics
+
+/*
+This code computes how devices move between the locations of a family, from the predictions stored in the
+location_predictions table over a time range.
+
+Dwell gives the time spent per visit at each location. The predictions of each device are split into sessions
+wherever there is no prediction for longer than VisitGap (the device was away or off), and each session is collapsed
+into visits (see api.CollapseVisits). A visit lasts from its first prediction until the first prediction of the next
+visit of the session, or until its own last prediction at the end of a session.
+
+Transitions counts, for each device, every pair of consecutive predictions by the location of their best guesses
+(see models.TransitionMatrix), across sessions too.
+
+Devices with randomized MAC addresses are left out unless they are asked for, as in Occupancy.
+*/
+
+import (
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/Nimaapr/find3/server/main/src/api"
+	"github.com/Nimaapr/find3/server/main/src/database"
+	"github.com/Nimaapr/find3/server/main/src/models"
+	"github.com/Nimaapr/find3/server/main/src/utils"
+)
+
+// VisitGap is the longest time without a prediction within a visit
+var VisitGap = 5 * time.Minute
+
+// Dwell returns the time spent at each location between from and to (in milliseconds)
+func Dwell(family string, from, to int64, showRandomized bool) (dwell []models.LocationDwell, err error) {
+	byDevice, err := predictionsByDevice(family, from, to, showRandomized)
+	if err != nil {
+		return
+	}
+	dwell = DwellFromPredictions(byDevice)
+	return
+}
+
+// Transitions returns the moves between locations between from and to (in milliseconds)
+func Transitions(family string, from, to int64, showRandomized bool) (matrix models.TransitionMatrix, err error) {
+	byDevice, err := predictionsByDevice(family, from, to, showRandomized)
+	if err != nil {
+		return
+	}
+	matrix = TransitionsFromPredictions(byDevice)
+	return
+}
+
+// DwellFromPredictions computes the dwell times from the time-ordered predictions of each device
+func DwellFromPredictions(byDevice map[string][]models.Prediction) (dwell []models.LocationDwell) {
+	gap := int64(VisitGap / time.Millisecond)
+	dwellMap := make(map[string]*models.LocationDwell)
+	addVisit := func(location string, duration int64) {
+		if _, ok := dwellMap[location]; !ok {
+			dwellMap[location] = &models.LocationDwell{Location: location}
+		}
+		dwellMap[location].Visits++
+		dwellMap[location].Total += duration
+		if duration > dwellMap[location].Max {
+			dwellMap[location].Max = duration
+		}
+	}
+
+	for _, predictions := range byDevice {
+		for _, session := range splitSessions(predictions, gap) {
+			visits := api.CollapseVisits(session)
+			for i, v := range visits {
+				end := v.Departure
+				if i+1 < len(visits) {
+					end = visits[i+1].Arrival
+				}
+				addVisit(v.Location, end-v.Arrival)
+			}
+		}
+	}
+
+	dwell = []models.LocationDwell{}
+	for _, d := range dwellMap {
+		d.Mean = d.Total / int64(d.Visits)
+		dwell = append(dwell, *d)
+	}
+	sort.Slice(dwell, func(i, j int) bool { return dwell[i].Location < dwell[j].Location })
+	return
+}
+
+// TransitionsFromPredictions counts the moves in the time-ordered predictions of each device
+func TransitionsFromPredictions(byDevice map[string][]models.Prediction) (matrix models.TransitionMatrix) {
+	counts := make(map[string]map[string]int)
+	locationMap := make(map[string]struct{})
+	for _, predictions := range byDevice {
+		previous := ""
+		for _, p := range predictions {
+			if len(p.Guesses) == 0 {
+				continue
+			}
+			location := p.Guesses[0].Location
+			locationMap[location] = struct{}{}
+			if previous != "" {
+				if _, ok := counts[previous]; !ok {
+					counts[previous] = make(map[string]int)
+				}
+				counts[previous][location]++
+			}
+			previous = location
+		}
+	}
+
+	matrix.Locations = []string{}
+	for location := range locationMap {
+		matrix.Locations = append(matrix.Locations, location)
+	}
+	sort.Strings(matrix.Locations)
+	matrix.Counts = make([][]int, len(matrix.Locations))
+	for i, fromLocation := range matrix.Locations {
+		matrix.Counts[i] = make([]int, len(matrix.Locations))
+		for j, toLocation := range matrix.Locations {
+			matrix.Counts[i][j] = counts[fromLocation][toLocation]
+		}
+	}
+	return
+}
+
+func predictionsByDevice(family string, from, to int64, showRandomized bool) (byDevice map[string][]models.Prediction, err error) {
+	if to < from {
+		err = errors.New("'to' must be after 'from'")
+		return
+	}
+	d, err := database.Open(family, true)
+	if err != nil {
+		return
+	}
+	predictions, err := d.GetAllPredictionsBetween(from, to)
+	d.Close()
+	if err != nil {
+		return
+	}
+	byDevice = make(map[string][]models.Prediction)
+	for _, p := range predictions {
+		if !showRandomized && utils.IsMacRandomized(p.Device) {
+			continue
+		}
+		byDevice[p.Device] = append(byDevice[p.Device], p)
+	}
+	return
+}
+
+// splitSessions splits time-ordered predictions wherever they are further apart than the gap
+func splitSessions(predictions []models.Prediction, gap int64) (sessions [][]models.Prediction) {
+	sessions = [][]models.Prediction{}
+	start := 0
+	for i := 1; i <= len(predictions); i++ {
+		if i == len(predictions) || predictions[i].Timestamp-predictions[i-1].Timestamp > gap {
+			sessions = append(sessions, predictions[start:i])
+			start = i
+		}
+	}
+	return
+}
@@ -0,0 +1,46 @@
+package analytics
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Nimaapr/find3/server/main/src/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMovement(t *testing.T) {
+	minute := int64(time.Minute / time.Millisecond)
+	byDevice := map[string][]models.Prediction{
+		"phone": {
+			at("phone", 0, "kitchen"),
+			at("phone", 1*minute, "kitchen"),
+			at("phone", 2*minute, "bedroom"),
+			at("phone", 4*minute, "bedroom"),
+			// gone for an hour
+			at("phone", 64*minute, "kitchen"),
+			at("phone", 66*minute, "kitchen"),
+		},
+		"laptop": {
+			at("laptop", 0, "office"),
+			at("laptop", 10*minute, "kitchen"),
+		},
+	}
+
+	dwell := DwellFromPredictions(byDevice)
+	assert.Equal(t, []models.LocationDwell{
+		{Location: "bedroom", Visits: 1, Total: 2 * minute, Mean: 2 * minute, Max: 2 * minute},
+		{Location: "kitchen", Visits: 3, Total: 4 * minute, Mean: 4 * minute / 3, Max: 2 * minute},
+		{Location: "office", Visits: 1, Total: 0, Mean: 0, Max: 0},
+	}, dwell)
+
+	matrix := TransitionsFromPredictions(byDevice)
+	assert.Equal(t, []string{"bedroom", "kitchen", "office"}, matrix.Locations)
+	assert.Equal(t, [][]int{
+		{1, 1, 0},
+		{1, 2, 0},
+		{0, 1, 0},
+	}, matrix.Counts)
+	p := matrix.Probabilities()
+	assert.InDelta(t, 2.0/3, p[1][1], 1e-9)
+	assert.Equal(t, []float64{0, 1, 0}, p[2])
+}
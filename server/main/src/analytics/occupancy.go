@@ -0,0 +1,227 @@
+package analytics
+
+/*
+This code computes occupancy, the number of unique devices at each location over intervals of time, from the
+predictions stored in the location_predictions table.
+
+The intervals are aligned to multiples of their length since the epoch (so hourly intervals start on the hour, in
+UTC). Each prediction counts its device at the best guess of the prediction, so a device that moves during an interval
+is counted at every location it went to, but only once in the total of the interval.
+
+Devices with a randomized MAC address (see utils.IsMacRandomized) are counted apart, and left out of the results
+unless they are asked for, since a phone that randomizes its address looks like many devices. When they are asked
+for, the pseudo-devices their addresses are linked to (see api.LinkRandomized) are counted instead, using the sensor
+data of the intervals.
+
+Computing an interval means reading all of its predictions, so the counts are stored in the occupancy_intervals and
+occupancy_rollups tables once an interval is over (plus OccupancyGracePeriod, to let late fingerprints arrive), and
+read back from there afterwards.
+*/
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/Nimaapr/find3/server/main/src/api"
+	"github.com/Nimaapr/find3/server/main/src/database"
+	"github.com/Nimaapr/find3/server/main/src/models"
+	"github.com/Nimaapr/find3/server/main/src/utils"
+)
+
+var (
+	// OccupancyGracePeriod is the time after the end of an interval before its rollup is stored
+	OccupancyGracePeriod = 5 * time.Minute
+	// MaxIntervals is the largest number of intervals returned at once
+	MaxIntervals = 10000
+)
+
+// Occupancy returns the number of unique devices at each location for each interval between from and to
+// (in milliseconds). Randomized MAC addresses are only counted when showRandomized is set.
+func Occupancy(family string, interval time.Duration, from, to int64, showRandomized bool) (occupancy []models.Occupancy, err error) {
+	intervalMs := int64(interval / time.Millisecond)
+	if interval < time.Minute {
+		err = errors.New("interval must be at least 1m")
+		return
+	}
+	if to < from || from < 0 {
+		err = errors.New("'to' must be after 'from'")
+		return
+	}
+	start := from - from%intervalMs
+	if (to-start)/intervalMs+1 > int64(MaxIntervals) {
+		err = fmt.Errorf("too many intervals, must be at most %d", MaxIntervals)
+		return
+	}
+
+	d, err := database.Open(family, true)
+	if err != nil {
+		return
+	}
+	defer d.Close()
+
+	stored, err := d.GetOccupancyRollups(intervalMs, start, to)
+	if err != nil {
+		return
+	}
+	rollups := make(map[int64]models.OccupancyRollup)
+	for _, r := range stored {
+		rollups[r.Start] = r
+	}
+
+	// compute the intervals that are not stored yet, in one pass over their predictions
+	missingFrom, missingTo := int64(-1), int64(-1)
+	for s := start; s <= to; s += intervalMs {
+		if _, ok := rollups[s]; !ok {
+			if missingFrom < 0 {
+				missingFrom = s
+			}
+			missingTo = s
+		}
+	}
+	if missingFrom >= 0 {
+		var predictions []models.Prediction
+		predictions, err = d.GetAllPredictionsBetween(missingFrom, missingTo+intervalMs-1)
+		if err != nil {
+			return
+		}
+		var sensors []models.SensorData
+		sensors, err = d.GetSensorsBetween(missingFrom, missingTo+intervalMs-1)
+		if err != nil {
+			return
+		}
+		computed := rollupOccupancy(predictions, intervalMs, api.LinkRandomized(sensors))
+		closed := time.Now().UTC().Add(-OccupancyGracePeriod).UnixNano() / int64(time.Millisecond)
+		toStore := []models.OccupancyRollup{}
+		for s := missingFrom; s <= missingTo; s += intervalMs {
+			if _, ok := rollups[s]; ok {
+				continue
+			}
+			r, ok := computed[s]
+			if !ok {
+				r = models.OccupancyRollup{Start: s, Interval: intervalMs, Locations: make(map[string]models.OccupancyCount)}
+			}
+			rollups[s] = r
+			if s+intervalMs <= closed {
+				toStore = append(toStore, r)
+			}
+		}
+		if len(toStore) > 0 {
+			logger.Log.Debugf("[%s] storing %d occupancy rollups", family, len(toStore))
+			err = d.AddOccupancyRollups(toStore)
+			if err != nil {
+				return
+			}
+		}
+	}
+
+	occupancy = []models.Occupancy{}
+	for s := start; s <= to; s += intervalMs {
+		r := rollups[s]
+		o := models.Occupancy{
+			Start:     s,
+			End:       s + intervalMs,
+			Total:     countOf(r.OccupancyCount, showRandomized),
+			Locations: make(map[string]int),
+		}
+		if showRandomized {
+			o.PseudoDevices = r.PseudoDeviceIDs
+		}
+		for location, count := range r.Locations {
+			if n := countOf(count, showRandomized); n > 0 {
+				o.Locations[location] = n
+			}
+		}
+		occupancy = append(occupancy, o)
+	}
+	return
+}
+
+// RollupOccupancy counts the unique devices at each location for the intervals of the predictions,
+// returning the rollups by the start of their interval
+func RollupOccupancy(predictions []models.Prediction, interval int64) (rollups map[int64]models.OccupancyRollup) {
+	return rollupOccupancy(predictions, interval, nil)
+}
+
+// rollupOccupancy is RollupOccupancy that also counts the pseudo-devices of the randomized addresses
+func rollupOccupancy(predictions []models.Prediction, interval int64, links map[string]string) (rollups map[int64]models.OccupancyRollup) {
+	type seen struct {
+		devices   map[string]struct{}
+		locations map[string]map[string]struct{}
+	}
+	intervals := make(map[int64]*seen)
+	for _, p := range predictions {
+		if len(p.Guesses) == 0 {
+			continue
+		}
+		start := p.Timestamp - p.Timestamp%interval
+		if _, ok := intervals[start]; !ok {
+			intervals[start] = &seen{
+				devices:   make(map[string]struct{}),
+				locations: make(map[string]map[string]struct{}),
+			}
+		}
+		location := p.Guesses[0].Location
+		if _, ok := intervals[start].locations[location]; !ok {
+			intervals[start].locations[location] = make(map[string]struct{})
+		}
+		intervals[start].devices[p.Device] = struct{}{}
+		intervals[start].locations[location][p.Device] = struct{}{}
+	}
+
+	rollups = make(map[int64]models.OccupancyRollup)
+	for start, s := range intervals {
+		r := models.OccupancyRollup{
+			Start:          start,
+			Interval:       interval,
+			Locations:      make(map[string]models.OccupancyCount),
+			OccupancyCount: countDevices(s.devices, links),
+		}
+		r.PseudoDeviceIDs = pseudoDevicesOf(s.devices, links)
+		for location, devices := range s.locations {
+			r.Locations[location] = countDevices(devices, links)
+		}
+		rollups[start] = r
+	}
+	return
+}
+
+func countDevices(devices map[string]struct{}, links map[string]string) (count models.OccupancyCount) {
+	for device := range devices {
+		if utils.IsMacRandomized(device) {
+			count.RandomizedDevices++
+		} else {
+			count.Devices++
+		}
+	}
+	count.PseudoDevices = len(pseudoDevicesOf(devices, links))
+	return
+}
+
+// pseudoDevicesOf returns the sorted pseudo-devices of the randomized devices
+func pseudoDevicesOf(devices map[string]struct{}, links map[string]string) (pseudoDevices []string) {
+	unique := make(map[string]struct{})
+	for device := range devices {
+		if pseudoDevice, ok := links[device]; ok {
+			unique[pseudoDevice] = struct{}{}
+		}
+	}
+	for pseudoDevice := range unique {
+		pseudoDevices = append(pseudoDevices, pseudoDevice)
+	}
+	sort.Strings(pseudoDevices)
+	return
+}
+
+func countOf(count models.OccupancyCount, showRandomized bool) int {
+	if showRandomized {
+		// rollups stored before the linking have no pseudo-devices
+		if count.PseudoDevices == 0 {
+			return count.Devices + count.RandomizedDevices
+		}
+		return count.Devices + count.PseudoDevices
+	}
+	return count.Devices
+}
@@ -0,0 +1,157 @@
+package analytics
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/Nimaapr/find3/server/main/src/api"
+	"github.com/Nimaapr/find3/server/main/src/database"
+	"github.com/Nimaapr/find3/server/main/src/models"
+	"github.com/Nimaapr/find3/server/main/src/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+// useTestDatabase makes a family in a temporary folder with a prediction for
+// each device at each location and time given
+func useTestDatabase(t *testing.T, family string, predictions []models.Prediction) func() {
+	folder, err := ioutil.TempDir("", "find3")
+	assert.Nil(t, err)
+	database.DataFolder = folder
+	addPredictions(t, family, predictions)
+	return func() { os.RemoveAll(folder) }
+}
+
+// addPredictions adds a prediction for each device at each location and time given
+func addPredictions(t *testing.T, family string, predictions []models.Prediction) {
+	d, err := database.Open(family)
+	assert.Nil(t, err)
+	defer d.Close()
+	for _, p := range predictions {
+		assert.Nil(t, d.AddSensor(models.SensorData{
+			Timestamp: p.Timestamp,
+			Family:    family,
+			Device:    p.Device,
+			Sensors:   map[string]map[string]interface{}{"wifi": {"aa:bb:cc:dd:ee:ff": -50}},
+		}))
+		assert.Nil(t, d.AddPrediction(p.Timestamp, p.Guesses))
+	}
+}
+
+func at(device string, timestamp int64, location string) models.Prediction {
+	return models.Prediction{
+		Device:    device,
+		Timestamp: timestamp,
+		Guesses:   []models.LocationPrediction{{Location: location, Probability: 0.9}},
+	}
+}
+
+func TestOccupancy(t *testing.T) {
+	hour := int64(time.Hour / time.Millisecond)
+	defer useTestDatabase(t, "testoccupancy", []models.Prediction{
+		at("wifi-00:11:22:33:44:55", 10, "kitchen"),
+		at("wifi-00:11:22:33:44:55", 20, "kitchen"),
+		at("wifi-00:11:22:33:44:55", 30, "bedroom"),
+		at("wifi-00:aa:22:33:44:55", 40, "kitchen"),
+		// randomized
+		at("wifi-02:11:22:33:44:55", 50, "kitchen"),
+		at("wifi-00:11:22:33:44:55", 2*hour+10, "bedroom"),
+	})()
+
+	occupancy, err := Occupancy("testoccupancy", time.Hour, 0, 2*hour+100, false)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(occupancy))
+	assert.Equal(t, models.Occupancy{Start: 0, End: hour, Total: 2, Locations: map[string]int{"kitchen": 2, "bedroom": 1}}, occupancy[0])
+	assert.Equal(t, 0, occupancy[1].Total)
+	assert.Equal(t, map[string]int{"bedroom": 1}, occupancy[2].Locations)
+
+	// the intervals are stored by now, and read back the same
+	d, err := database.Open("testoccupancy", true)
+	assert.Nil(t, err)
+	rollups, err := d.GetOccupancyRollups(hour, 0, 2*hour)
+	d.Close()
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(rollups))
+	assert.Equal(t, models.OccupancyCount{Devices: 2, RandomizedDevices: 1, PseudoDevices: 1}, rollups[0].Locations["kitchen"])
+
+	occupancy, err = Occupancy("testoccupancy", time.Hour, 0, 2*hour+100, true)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, occupancy[0].Total)
+	assert.Equal(t, 3, occupancy[0].Locations["kitchen"])
+
+	_, err = Occupancy("testoccupancy", time.Second, 0, hour, false)
+	assert.NotNil(t, err)
+}
+
+func TestOccupancyPseudoDevices(t *testing.T) {
+	minute := int64(time.Minute / time.Millisecond)
+	defer useTestDatabase(t, "testpseudo", []models.Prediction{
+		at("wifi-00:11:22:33:44:55", 10, "kitchen"),
+		// one phone changing its randomized address, the second starts right after the first stops
+		at("wifi-02:11:22:33:44:55", 20, "kitchen"),
+		at("wifi-02:11:22:33:44:55", minute, "kitchen"),
+		at("wifi-06:aa:bb:cc:dd:ee", 2*minute, "kitchen"),
+		// and one that is seen at the same time as the first, so it is another phone
+		at("wifi-0a:11:22:33:44:55", 30, "kitchen"),
+		at("wifi-0a:11:22:33:44:55", 40, "kitchen"),
+	})()
+
+	occupancy, err := Occupancy("testpseudo", time.Hour, 0, 10*minute, true)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(occupancy))
+	assert.Equal(t, 3, occupancy[0].Total)
+	assert.Equal(t, 3, occupancy[0].Locations["kitchen"])
+	assert.Equal(t, 2, len(occupancy[0].PseudoDevices))
+
+	occupancy, err = Occupancy("testpseudo", time.Hour, 0, 10*minute, false)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, occupancy[0].Total)
+	assert.Empty(t, occupancy[0].PseudoDevices)
+
+	// read back from the stored rollup
+	stored, err := Occupancy("testpseudo", time.Hour, 0, 10*minute, true)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, stored[0].Total)
+	assert.Equal(t, 2, len(stored[0].PseudoDevices))
+}
+
+func TestOccupancyPrivacy(t *testing.T) {
+	minute := int64(time.Minute / time.Millisecond)
+	defer useTestDatabase(t, "testprivacy", nil)()
+	_, err := api.SetPrivacy("testprivacy", true, 0, nil)
+	assert.Nil(t, err)
+	privacy, err := api.CurrentPrivacy("testprivacy", time.Now().UTC())
+	assert.Nil(t, err)
+	phone := privacy.DeviceID("wifi-00:11:22:33:44:55")
+	first := privacy.DeviceID("wifi-02:11:22:33:44:55")
+	second := privacy.DeviceID("wifi-06:aa:bb:cc:dd:ee")
+	assert.False(t, utils.IsMacRandomized(phone))
+	assert.True(t, utils.IsMacRandomized(first))
+	assert.True(t, utils.IsMacRandomized(second))
+
+	// the hashes of a phone changing its randomized address are still linked
+	addPredictions(t, "testprivacy", []models.Prediction{
+		at(phone, 10, "kitchen"),
+		at(first, 20, "kitchen"),
+		at(first, minute, "kitchen"),
+		at(second, 2*minute, "kitchen"),
+	})
+	occupancy, err := Occupancy("testprivacy", time.Hour, 0, 10*minute, true)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, occupancy[0].Total)
+	assert.Equal(t, 1, len(occupancy[0].PseudoDevices))
+	occupancy, err = Occupancy("testprivacy", time.Hour, 0, 10*minute, false)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, occupancy[0].Total)
+
+	// and hidden from the devices by location
+	byLocations, err := api.GetByLocation("testprivacy", 60, false, 0, 0, 0, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(byLocations))
+	assert.Equal(t, 1, byLocations[0].Total)
+	assert.Equal(t, phone, byLocations[0].Devices[0].Device)
+	byLocations, err = api.GetByLocation("testprivacy", 60, true, 0, 0, 0, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, byLocations[0].Total)
+}
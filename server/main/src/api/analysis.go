@@ -100,7 +100,7 @@ func AnalyzeSensorData(s models.SensorData) (aidata models.LocationAnalysis, err
 			DataFolder string            `json:"data_folder"`
 		}
 		var p2 ClassifyPayload
-		p2.Sensor = s
+		p2.Sensor = s.Numeric()
 		p2.DataFolder = DataFolder
 		url := "http://localhost:" + AIPort + "/classify"
 		bPayload, err := json.Marshal(p2)
@@ -169,6 +169,8 @@ func AnalyzeSensorData(s models.SensorData) (aidata models.LocationAnalysis, err
 
 	aResult := <-aChan
 	if aResult.err != nil || len(aResult.aidata.Predictions) == 0 {
+		// the naive bayes classification does not outlive the analysis
+		<-bChan
 		err = errors.Wrap(aResult.err, "problem with machine learnaing")
 		logger.Log.Error(aResult.err)
 		return
@@ -218,8 +220,27 @@ func AnalyzeSensorData(s models.SensorData) (aidata models.LocationAnalysis, err
 	}
 	var algorithmEfficacy map[string]map[string]models.BinaryStats
 	d.Get("AlgorithmEfficacy", &algorithmEfficacy)
+	var hierarchySettings models.HierarchySettings
+	d.Get("HierarchySettings", &hierarchySettings)
+	hierarchy, errHierarchy := d.GetLocationHierarchy()
+	trilateration := withBeacons(getTrilateration(d), getBeacons(d))
+	var floorplans map[string]models.Floorplan
+	var coordinates map[string]models.LocationCoordinate
+	if len(trilateration.AccessPoints) > 0 {
+		floorplans = getFloorplans(d)
+		coordinates = getLocationCoordinates(d)
+	}
 	d.Close()
 	aidata.Guesses = determineBestGuess(aidata, algorithmEfficacy)
+	aidata.Position = trilaterate(s, trilateration, floorplans, coordinates)
+
+	// classify the floor first, and then the room on it (see hierarchy.go)
+	if hierarchySettings.TwoStage && errHierarchy == nil && !aidata.IsUnknown {
+		aidata.Floors = classifyFloor(s, aidata.Guesses, hierarchy)
+		if len(aidata.Floors) > 0 {
+			aidata.Guesses = guessesOnFloor(aidata.Guesses, aidata.Floors[0].Location, hierarchy)
+		}
+	}
 
 	if aidata.IsUnknown {
 		aidata.Guesses = []models.LocationPrediction{
@@ -366,6 +387,37 @@ func GetByLocation(family string, minutesAgoInt int, showRandomized bool, active
 	var rollingData models.ReverseRollingData
 	errGotRollingData := d.Get("ReverseRollingData", &rollingData)
 
+	// link the randomized addresses into pseudo-devices, which are shown once, at their
+	// latest address, with the counts of all their addresses
+	links := make(map[string]string)
+	if showRandomized {
+		latestTime, errLatest := d.GetLastSensorTimestamp()
+		if errLatest == nil {
+			window, errWindow := d.GetSensorsBetween(latestTime-millisecondsAgo, latestTime)
+			if errWindow == nil {
+				links = LinkRandomized(window)
+			}
+		}
+	}
+	pseudoLatest := make(map[string]models.SensorData)
+	pseudoCounts := make(map[string]int)
+	pseudoFirstTime := make(map[string]time.Time)
+	for _, s := range sensors {
+		pseudoDevice, ok := links[s.Device]
+		if !ok {
+			continue
+		}
+		if latest, ok := pseudoLatest[pseudoDevice]; !ok || s.Timestamp > latest.Timestamp {
+			pseudoLatest[pseudoDevice] = s
+		}
+		pseudoCounts[pseudoDevice] += deviceCounts[s.Device]
+		if first, ok := deviceFirstTime[s.Device]; ok {
+			if pseudoFirstTime[pseudoDevice].IsZero() || first.Before(pseudoFirstTime[pseudoDevice]) {
+				pseudoFirstTime[pseudoDevice] = first
+			}
+		}
+	}
+
 	d.Close()
 
 	locations := make(map[string][]models.ByLocationDevice)
@@ -382,8 +434,16 @@ func GetByLocation(family string, minutesAgoInt int, showRandomized bool, active
 			// logger.Log.Warnf("missing deviceFirstTime for %s", s.Device)
 			continue
 		}
+		count, firstTime := deviceCounts[s.Device], deviceFirstTime[s.Device]
+		pseudoDevice := links[s.Device]
+		if pseudoDevice != "" {
+			if pseudoLatest[pseudoDevice].Device != s.Device {
+				continue
+			}
+			count, firstTime = pseudoCounts[pseudoDevice], pseudoFirstTime[pseudoDevice]
+		}
 		if errGotRollingData == nil {
-			if int(deviceCounts[s.Device])*int(rollingData.TimeBlock.Seconds())/60 < activeMinsThreshold {
+			if int(count)*int(rollingData.TimeBlock.Seconds())/60 < activeMinsThreshold {
 				continue
 			}
 		}
@@ -417,21 +477,25 @@ func GetByLocation(family string, minutesAgoInt int, showRandomized bool, active
 		}
 
 		dL := models.ByLocationDevice{
-			Device:      s.Device,
-			Timestamp:   time.Unix(0, s.Timestamp*1000000).UTC(),
-			Probability: a[0].Probability,
-			Randomized:  isRandomized,
-			NumScanners: numScanners,
-			FirstSeen:   deviceFirstTime[s.Device],
+			Device:       s.Device,
+			Timestamp:    time.Unix(0, s.Timestamp*1000000).UTC(),
+			Probability:  a[0].Probability,
+			Randomized:   isRandomized,
+			PseudoDevice: pseudoDevice,
+			NumScanners:  numScanners,
+			FirstSeen:    firstTime,
 		}
 		if errGotRollingData == nil {
-			dL.ActiveMins = int(deviceCounts[s.Device]) * int(rollingData.TimeBlock.Seconds()) / 60
+			dL.ActiveMins = int(count) * int(rollingData.TimeBlock.Seconds()) / 60
 		} else {
-			dL.ActiveMins = int(deviceCounts[s.Device]*30) / 60
+			dL.ActiveMins = int(count*30) / 60
 		}
-		vendor, vendorErr := utils.GetVendorFromOUI(s.Device)
-		if vendorErr == nil {
-			dL.Vendor = vendor
+		// the vendor of a hashed device would give away part of its address
+		if !utils.IsHashedDevice(s.Device) {
+			vendor, vendorErr := utils.GetVendorFromOUI(s.Device)
+			if vendorErr == nil {
+				dL.Vendor = vendor
+			}
 		}
 		locations[a[0].Location] = append(locations[a[0].Location], dL)
 	}
@@ -0,0 +1,98 @@
+package api
+
+/*
+This code makes the air pressures of a family relative to a barometer that stays in the building (see
+models.BarometerReference), which is stored in the keystore under "BarometerReference".
+
+The weather moves the pressure by several hPa over a few days while a floor is about 0.4 hPa, so a classifier that
+learned the absolute pressure finds the wrong floor once the weather changed. The difference to the reference measured
+at the same time does not drift. NormalizeSensorData keeps the latest pressure of the reference when its data comes in,
+and replaces the pressures of the other devices with their difference to it. The latest pressure is kept in memory, and
+read from the latest data of the reference the first time.
+*/
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Nimaapr/find3/server/main/src/database"
+	"github.com/Nimaapr/find3/server/main/src/models"
+)
+
+// BarometerReferenceMaxAge is how far apart in time the pressure of the reference and the one of
+// the data can be, for the pressure of the data to be made relative
+var BarometerReferenceMaxAge = 30 * time.Minute
+
+var globalBarometerReference = struct {
+	// References maps family -> reference
+	References map[string]models.BarometerReference
+	sync.Mutex
+}{}
+
+func init() {
+	globalBarometerReference.Lock()
+	defer globalBarometerReference.Unlock()
+	globalBarometerReference.References = make(map[string]models.BarometerReference)
+}
+
+// GetBarometerReference returns the reference barometer of a family, with its latest pressure
+func GetBarometerReference(family string) (reference models.BarometerReference, err error) {
+	globalBarometerReference.Lock()
+	defer globalBarometerReference.Unlock()
+	return getBarometerReference(family)
+}
+
+func getBarometerReference(family string) (reference models.BarometerReference, err error) {
+	reference, ok := globalBarometerReference.References[family]
+	if ok {
+		return
+	}
+	d, err := database.Open(family, true)
+	if err != nil {
+		return
+	}
+	defer d.Close()
+	d.Get("BarometerReference", &reference)
+	if reference.Device != "" {
+		if latest, errLatest := d.GetLatest(reference.Device); errLatest == nil {
+			reference.Read(latest)
+		}
+	}
+	globalBarometerReference.References[family] = reference
+	return
+}
+
+// SetBarometerReference makes a device the reference barometer of a family, an empty device removes it
+func SetBarometerReference(family, device string) (reference models.BarometerReference, err error) {
+	globalBarometerReference.Lock()
+	defer globalBarometerReference.Unlock()
+	d, err := database.Open(family, true)
+	if err != nil {
+		return
+	}
+	err = d.Set("BarometerReference", models.BarometerReference{Device: strings.TrimSpace(strings.ToLower(device))})
+	d.Close()
+	if err != nil {
+		return
+	}
+	delete(globalBarometerReference.References, family)
+	return getBarometerReference(family)
+}
+
+// applyBarometerReference keeps the pressure of the reference of the family of the sensor data,
+// or makes the pressures of the sensor data relative to it
+func applyBarometerReference(s *models.SensorData) (err error) {
+	globalBarometerReference.Lock()
+	defer globalBarometerReference.Unlock()
+	reference, err := getBarometerReference(s.Family)
+	if err != nil {
+		return
+	}
+	if reference.Read(*s) {
+		globalBarometerReference.References[s.Family] = reference
+		return
+	}
+	reference.Apply(s, int64(BarometerReferenceMaxAge/time.Millisecond))
+	return
+}
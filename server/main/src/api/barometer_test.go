@@ -0,0 +1,68 @@
+package api
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/Nimaapr/find3/server/main/src/learning/nb1"
+	"github.com/Nimaapr/find3/server/main/src/models"
+)
+
+func TestBarometerReference(t *testing.T) {
+	defer useTestDatabase(t, "testbarometer")()
+	minute := int64(60000)
+	barometer := func(device string, timestamp int64, location string, pressure float64) models.SensorData {
+		s := models.SensorData{
+			Timestamp: timestamp,
+			Family:    "testbarometer",
+			Device:    device,
+			Location:  location,
+			Sensors: map[string]map[string]interface{}{
+				"wifi":      {"aa:aa": -50.0},
+				"barometer": {"pressure": pressure},
+			},
+		}
+		assert.Nil(t, NormalizeSensorData(&s))
+		return s
+	}
+
+	// without a reference the pressure stays absolute
+	s := barometer("phone", minute, "", 1013.0)
+	assert.Equal(t, 1013.0, s.Sensors["barometer"]["pressure"])
+
+	_, err := SetBarometerReference("testbarometer", "Pi")
+	assert.Nil(t, err)
+	s = barometer("pi", minute, "", 1013.0)
+	assert.Equal(t, 1013.0, s.Sensors["barometer"]["pressure"])
+	reference, err := GetBarometerReference("testbarometer")
+	assert.Nil(t, err)
+	assert.Equal(t, models.BarometerReference{Device: "pi", Pressures: map[string]float64{"pressure": 1013.0}, Timestamp: minute}, reference)
+
+	// learning the ground floor and the first floor, 0.4 hPa higher up
+	datas := []models.SensorData{}
+	for i := 0; i < 10; i++ {
+		jitter := float64(i%3-1) * 0.03
+		datas = append(datas,
+			barometer("phone", 2*minute+int64(i), "ground", 1013.0+jitter),
+			barometer("phone", 3*minute+int64(i), "first", 1012.6+jitter))
+	}
+	assert.Equal(t, -0.4, datas[3].Sensors["barometer"]["pressure-relative"])
+	_, ok := datas[3].Sensors["barometer"]["pressure"]
+	assert.False(t, ok)
+	nb := nb1.New()
+	assert.Nil(t, nb.Fit(datas))
+
+	// a day later the weather brought the pressure down by 5 hPa, and the first floor is still found
+	day := 24 * 60 * minute
+	barometer("pi", day, "", 1008.0)
+	s = barometer("phone", day+minute, "", 1007.6)
+	assert.Equal(t, -0.4, s.Sensors["barometer"]["pressure-relative"])
+	pl, err := nb1.New().Classify(s)
+	assert.Nil(t, err)
+	assert.Equal(t, "first", pl[0].Key)
+
+	// an old pressure of the reference is not used
+	s = barometer("phone", 2*day, "", 1007.6)
+	assert.Equal(t, 1007.6, s.Sensors["barometer"]["pressure"])
+}
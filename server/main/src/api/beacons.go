@@ -0,0 +1,118 @@
+package api
+
+/*
+This code keeps the registry of the BLE beacons of a family (see models.Beacon), in the keystore under "Beacons" as a
+map from the identity to the beacon.
+
+The signals of the beacons are sent under the "beacon" sensor type keyed by their identity, so the classifiers learn a
+beacon as one sensor even as its MAC address changes. The beacons of the registry are at known places, and take part
+in trilateration like the access points (see trilateration.go), with the measured power they advertise.
+*/
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/Nimaapr/find3/server/main/src/database"
+	"github.com/Nimaapr/find3/server/main/src/models"
+)
+
+// GetBeacons returns the beacons of a family
+func GetBeacons(family string) (beacons map[string]models.Beacon, err error) {
+	d, err := database.Open(family, true)
+	if err != nil {
+		return
+	}
+	defer d.Close()
+	beacons = getBeacons(d)
+	return
+}
+
+func getBeacons(d *database.Database) (beacons map[string]models.Beacon) {
+	errGet := d.Get("Beacons", &beacons)
+	if errGet != nil || beacons == nil {
+		beacons = make(map[string]models.Beacon)
+	}
+	return
+}
+
+// SetBeacons places beacons, replacing their previous places
+func SetBeacons(family string, changes []models.Beacon) (beacons map[string]models.Beacon, err error) {
+	for i, b := range changes {
+		b.Identity, err = models.ParseBeacon(b.Identity)
+		if err != nil {
+			return
+		}
+		b.Name = strings.TrimSpace(b.Name)
+		b.Floor, err = CleanFloor(b.Floor)
+		if err != nil {
+			return
+		}
+		if b.Unit == "" {
+			b.Unit = models.UnitPixels
+		}
+		if b.Unit != models.UnitPixels && b.Unit != models.UnitMeters {
+			err = errors.New("unit must be 'px' or 'm'")
+			return
+		}
+		if b.X < 0 || b.Y < 0 {
+			err = errors.New("coordinates are from the top left corner and must be positive")
+			return
+		}
+		if b.TxPower > 0 || b.PathLossExponent < 0 {
+			err = errors.New("the path loss model of '" + b.Identity + "' is not valid")
+			return
+		}
+		changes[i] = b
+	}
+
+	d, err := database.Open(family, true)
+	if err != nil {
+		return
+	}
+	defer d.Close()
+	beacons = getBeacons(d)
+	for _, b := range changes {
+		beacons[b.Identity] = b
+	}
+	err = d.Set("Beacons", beacons)
+	return
+}
+
+// DeleteBeacon removes a beacon of a family
+func DeleteBeacon(family, identity string) (err error) {
+	identity, err = models.ParseBeacon(identity)
+	if err != nil {
+		return
+	}
+	d, err := database.Open(family, true)
+	if err != nil {
+		return
+	}
+	defer d.Close()
+	beacons := getBeacons(d)
+	if _, ok := beacons[identity]; !ok {
+		err = errors.New("no beacon '" + identity + "'")
+		return
+	}
+	delete(beacons, identity)
+	err = d.Set("Beacons", beacons)
+	return
+}
+
+// withBeacons adds the beacons to the access points of trilateration
+func withBeacons(trilateration models.Trilateration, beacons map[string]models.Beacon) models.Trilateration {
+	if len(beacons) == 0 {
+		return trilateration
+	}
+	accessPoints := make(map[string]models.AccessPoint, len(trilateration.AccessPoints)+len(beacons))
+	for mac, a := range trilateration.AccessPoints {
+		accessPoints[mac] = a
+	}
+	for identity, b := range beacons {
+		accessPoints[identity] = b.AccessPoint()
+	}
+	trilateration.AccessPoints = accessPoints
+	return trilateration
+}
@@ -0,0 +1,56 @@
+package api
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/Nimaapr/find3/server/main/src/models"
+)
+
+func TestBeacons(t *testing.T) {
+	defer useTestDatabase(t, "testbeacons")()
+
+	_, err := SetBeacons("testbeacons", []models.Beacon{{Identity: "20:25:64:b7:91:42", Floor: "1"}})
+	assert.NotNil(t, err)
+	_, err = SetBeacons("testbeacons", []models.Beacon{{Identity: "eddystone:edd1ebeac04e5defa017:000000000001", Floor: "1", TxPower: 4}})
+	assert.NotNil(t, err)
+
+	beacons, err := SetBeacons("testbeacons", []models.Beacon{
+		{Identity: "iBeacon:F7826DA6-4FA2-4E98-8024-BC5B71E0893E:1:1", Name: " door ", Floor: "1", X: 0, Y: 0, Unit: models.UnitMeters, TxPower: -59},
+		{Identity: "ibeacon:f7826da6-4fa2-4e98-8024-bc5b71e0893e:1:2", Floor: "1", X: 10, Y: 0, Unit: models.UnitMeters, TxPower: -59},
+		{Identity: "eddystone:edd1ebeac04e5defa017:000000000001", Floor: "1", X: 0, Y: 10, Unit: models.UnitMeters, TxPower: -59},
+		{Identity: "eddystone:edd1ebeac04e5defa017:000000000002", Floor: "2", X: 0, Y: 0, Unit: models.UnitMeters},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 4, len(beacons))
+	assert.Equal(t, "door", beacons["ibeacon:f7826da6-4fa2-4e98-8024-bc5b71e0893e:1:1"].Name)
+
+	assert.Nil(t, DeleteBeacon("testbeacons", "eddystone:EDD1EBEAC04E5DEFA017:000000000002"))
+	assert.NotNil(t, DeleteBeacon("testbeacons", "eddystone:edd1ebeac04e5defa017:000000000002"))
+	beacons, err = GetBeacons("testbeacons")
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(beacons))
+
+	// the beacons are access points of trilateration, the ones of the family are kept
+	trilateration, err := GetTrilateration("testbeacons")
+	assert.Nil(t, err)
+	trilateration.AccessPoints["aa"] = models.AccessPoint{Sensor: "wifi", Mac: "aa", Floor: "2", Unit: models.UnitMeters}
+	withBeacon := withBeacons(trilateration, beacons)
+	assert.Equal(t, 4, len(withBeacon.AccessPoints))
+	assert.Equal(t, 1, len(trilateration.AccessPoints))
+
+	// a device at (3, 4) meters hearing the beacons
+	s := models.SensorData{Family: "testbeacons", Device: "phone", Sensors: map[string]map[string]interface{}{"beacon": {}}}
+	for identity, b := range beacons {
+		s.Sensors["beacon"][identity] = b.TxPower - 10*trilateration.PathLossExponent*math.Log10(math.Hypot(b.X-3, b.Y-4))
+	}
+	assert.Nil(t, s.Validate())
+	position := trilaterate(s, withBeacon, nil, nil)
+	assert.NotNil(t, position)
+	assert.Equal(t, "1", position.Floor)
+	assert.InDelta(t, 3, position.X, 0.05)
+	assert.InDelta(t, 4, position.Y, 0.05)
+	assert.Equal(t, 3, position.AccessPoints)
+}
@@ -35,6 +35,10 @@ import (
 	"github.com/Nimaapr/find3/server/main/src/utils"
 )
 
+// CalibrationHandler, when set, is given the accuracy of a family after each
+// calibration with cross validation (it is used to publish it over MQTT)
+var CalibrationHandler func(summary models.CalibrationSummary)
+
 // Calibrate will send the sensor data for a specific family to the machine learning algorithms
 func Calibrate(family string, crossValidation ...bool) (err error) {
 	// gather the data
@@ -69,6 +73,15 @@ func Calibrate(family string, crossValidation ...bool) (err error) {
 		logger.Log.Error(errFit)
 	}
 
+	// fit the floors for two-stage classification
+	errFit = fitFloors(family, datasLearn)
+	if errFit != nil {
+		logger.Log.Warnf("[%s] floors: %s", family, errFit.Error())
+	}
+
+	// generate location analysis images, they do not need the python learning
+	GenerateImages(family)
+
 	// do the python learning
 	err = learnFromData(family, datasLearn)
 	if err != nil {
@@ -303,6 +316,16 @@ func findBestAlgorithm(datas []models.SensorData) (algorithmEfficacy map[string]
 		}
 	}
 
+	hierarchy := make(map[string]models.LocationHierarchy)
+	if d, errOpen := database.Open(datas[0].Family, true); errOpen == nil {
+		if h, errHierarchy := d.GetLocationHierarchy(); errHierarchy == nil {
+			hierarchy = h
+		}
+		d.Close()
+	}
+	truths := make([]string, len(aidatas))
+	guessed := make([]string, len(aidatas))
+
 	correct := 0
 	ProbabilitiesOfBestGuess := make([]float64, len(aidatas))
 	accuracyBreakdown := make(map[string]float64)
@@ -314,9 +337,14 @@ func findBestAlgorithm(datas []models.SensorData) (algorithmEfficacy map[string]
 		}
 		accuracyBreakdownTotal[datas[i].Location]++
 		bestGuess := determineBestGuess(aidatas[i], algorithmEfficacy)
+		if len(aidatas[i].Floors) > 0 {
+			bestGuess = guessesOnFloor(bestGuess, aidatas[i].Floors[0].Location, hierarchy)
+		}
 		if len(bestGuess) == 0 {
 			continue
 		}
+		truths[i] = datas[i].Location
+		guessed[i] = bestGuess[0].Location
 		if bestGuess[0].Location == datas[i].Location {
 			accuracyBreakdown[datas[i].Location]++
 			correct++
@@ -380,6 +408,11 @@ func findBestAlgorithm(datas []models.SensorData) (algorithmEfficacy map[string]
 	if err != nil {
 		logger.Log.Error(err)
 	}
+	accuracyLevels := levelAccuracy(truths, guessed, hierarchy)
+	err = db.Set("AccuracyBreakdownLevels", accuracyLevels)
+	if err != nil {
+		logger.Log.Error(err)
+	}
 	err = db.Set("PredictionAnalysis", predictionAnalysis)
 	if err != nil {
 		logger.Log.Error(err)
@@ -393,8 +426,16 @@ func findBestAlgorithm(datas []models.SensorData) (algorithmEfficacy map[string]
 		logger.Log.Error(err)
 	}
 
-	// generate location analysis images
-	go GenerateImages(datas[0].Family)
+	if CalibrationHandler != nil {
+		go CalibrationHandler(models.CalibrationSummary{
+			Family:    datas[0].Family,
+			Timestamp: time.Now().UTC().UnixNano() / int64(time.Millisecond),
+			Samples:   len(aidatas),
+			Accuracy:  float64(correct) / float64(len(datas)),
+			Locations: accuracyBreakdown,
+			Levels:    accuracyLevels,
+		})
+	}
 
 	// insert wardriving GPS
 	locations, _ := db.GetLocations()
@@ -449,6 +490,13 @@ func dumpSensorsToCSV(datas []models.SensorData, csvFile string) (err error) {
 	}
 	defer f.Close()
 
+	// the learning only takes numbers
+	numeric := make([]models.SensorData, len(datas))
+	for i := range datas {
+		numeric[i] = datas[i].Numeric()
+	}
+	datas = numeric
+
 	// determine all possible columns
 	sensorColumns := make(map[string]int)
 	columnCount := 1
@@ -238,6 +238,7 @@ func TestNB(t *testing.T) {
 	db.Close()
 
 	os.Remove("foo.db")
+	defer os.Remove("foo.db")
 	db, err = sql.Open("sqlite3", "foo.db")
 	assert.Nil(t, err)
 	tx, err = db.Begin()
@@ -0,0 +1,287 @@
+package api
+
+/*
+This code answers the requests of data subjects for a single device: the export of everything stored about it, and
+its erasure.
+
+ExportDevice collects the sensor data of the device, the predictions made from it, its events, the GPS coordinates
+recorded with its data and its settings (see models.DeviceExport).
+
+EraseDevice removes the device from the tables (see database.DeleteDevice) and from the keystore entries that name
+it: the passive learning settings ("ReverseRollingData"), the RSSI offsets, the privacy allowlist and the GPS
+coordinates of the locations. When the device had learning data, the models learned from it ("NB1", "NB2" and
+"NB1Floors", with the features of the naive Bayes models, and the model of the AI server in DataFolder) are removed
+too, and the AI server drops it from its cache, so the family has to be calibrated again before it classifies. The
+"models" count is the number of models that were removed. EraseHandler drops the device from the passive data that
+the server keeps in memory first, so that none of it is saved again after the erasure.
+
+Both record an entry in the audit log with the actor that asked for them.
+*/
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/mr-tron/base58/base58"
+	"github.com/pkg/errors"
+
+	"github.com/Nimaapr/find3/server/main/src/database"
+	"github.com/Nimaapr/find3/server/main/src/models"
+)
+
+// AuditLimit is the number of entries of the audit log returned by GetAudit
+var AuditLimit = 1000
+
+// EraseHandler, when set, is called before a device of a family is erased (it is used to drop the
+// device from the passive windows and settings of the server)
+var EraseHandler func(family, device string)
+
+// ExportDevice returns everything stored about a device and records the export in the audit log
+func ExportDevice(family, device, actor string) (export models.DeviceExport, err error) {
+	family = strings.TrimSpace(strings.ToLower(family))
+	device = strings.TrimSpace(strings.ToLower(device))
+	offsets, err := GetRSSIOffsets(family)
+	if err != nil {
+		return
+	}
+	privacy, err := GetPrivacy(family)
+	if err != nil {
+		return
+	}
+
+	d, err := database.Open(family, true)
+	if err != nil {
+		return
+	}
+	defer d.Close()
+	export = models.DeviceExport{
+		Family:   family,
+		Device:   device,
+		Exported: time.Now().UTC(),
+	}
+	export.Sensors, err = d.GetDeviceSensors(device)
+	if err != nil {
+		err = errors.Wrap(err, "no device '"+device+"'")
+		return
+	}
+	export.Predictions, err = d.GetPredictionsBetween(device, 0, time.Now().UTC().UnixNano()/int64(time.Millisecond))
+	if err != nil {
+		return
+	}
+	export.Events, err = d.GetEvents(database.EventFilter{Device: device})
+	if err != nil {
+		return
+	}
+	export.GPS, err = d.GetDeviceGPS(device)
+	if err != nil {
+		return
+	}
+	export.Positions, err = d.GetTrackedPositions(device, 0, time.Now().UTC().UnixNano()/int64(time.Millisecond))
+	if err != nil {
+		return
+	}
+
+	var rollingData models.ReverseRollingData
+	if errGet := d.Get("ReverseRollingData", &rollingData); errGet == nil {
+		export.Settings.LearningLocation = rollingData.DeviceLocation[device]
+		export.Settings.LearningGPS = rollingData.DeviceGPS[device]
+	}
+	export.Settings.RSSIOffset = offsets.Devices[device]
+	for _, allowed := range privacy.Allowlist {
+		if allowed == device {
+			export.Settings.Allowlisted = true
+		}
+	}
+
+	_, err = d.AddAuditEntry(models.AuditEntry{
+		Timestamp: export.Exported.UnixNano() / int64(time.Millisecond),
+		Action:    models.AuditExport,
+		Device:    device,
+		Actor:     actor,
+	})
+	return
+}
+
+// EraseDevice removes everything stored about a device and records the erasure in the audit log.
+// The counts of the entry say what was removed, "learning" is the number of learning data of the device.
+func EraseDevice(family, device, actor string) (entry models.AuditEntry, err error) {
+	family = strings.TrimSpace(strings.ToLower(family))
+	device = strings.TrimSpace(strings.ToLower(device))
+	if EraseHandler != nil {
+		EraseHandler(family, device)
+	}
+
+	d, err := database.Open(family, true)
+	if err != nil {
+		return
+	}
+	counts, err := d.DeleteDevice(device)
+	if err != nil {
+		d.Close()
+		return
+	}
+
+	// the passive learning settings
+	var rollingData models.ReverseRollingData
+	if errGet := d.Get("ReverseRollingData", &rollingData); errGet == nil {
+		_, learning := rollingData.DeviceLocation[device]
+		_, gps := rollingData.DeviceGPS[device]
+		if learning || gps {
+			delete(rollingData.DeviceLocation, device)
+			delete(rollingData.DeviceGPS, device)
+			if err = d.Set("ReverseRollingData", rollingData); err != nil {
+				d.Close()
+				return
+			}
+			counts["ReverseRollingData"] = 1
+		}
+	}
+
+	// the GPS coordinates of the locations, which keep the data they were taken from
+	for _, key := range []string{"customGPS", "autoGPS"} {
+		var gpsData map[string]models.SensorData
+		if errGet := d.Get(key, &gpsData); errGet != nil {
+			continue
+		}
+		removed := int64(0)
+		for location, s := range gpsData {
+			if s.Device == device {
+				s.Device = ""
+				gpsData[location] = s
+				removed++
+			}
+		}
+		if removed > 0 {
+			if err = d.Set(key, gpsData); err != nil {
+				d.Close()
+				return
+			}
+			counts[key] = removed
+		}
+	}
+
+	// the models learned from its data
+	if counts["learning"] > 0 {
+		for _, key := range []string{"NB1", "NB1Features", "NB2", FloorModel, FloorModel + "Features"} {
+			var deleted bool
+			if deleted, err = d.DeleteKey(key); err != nil {
+				d.Close()
+				return
+			}
+			if deleted {
+				counts["models"]++
+			}
+		}
+	}
+	d.Close()
+	if counts["learning"] > 0 {
+		var deleted bool
+		if deleted, err = forgetAIModel(family); err != nil {
+			return
+		}
+		if deleted {
+			counts["models"]++
+		}
+	}
+
+	// the settings that are kept in memory too
+	offsets, err := GetRSSIOffsets(family)
+	if err != nil {
+		return
+	}
+	if _, ok := offsets.Devices[device]; ok {
+		if _, err = SetRSSIOffsets(family, models.RSSIOffsets{Devices: map[string]float64{device: 0}}); err != nil {
+			return
+		}
+		counts["RSSIOffsets"] = 1
+	}
+
+	globalPrivacy.Lock()
+	privacy, err := loadPrivacy(family)
+	if err == nil {
+		allowlist := []string{}
+		for _, allowed := range privacy.Allowlist {
+			if allowed != device {
+				allowlist = append(allowlist, allowed)
+			}
+		}
+		if len(allowlist) != len(privacy.Allowlist) {
+			privacy.Allowlist = allowlist
+			err = savePrivacy(family, privacy)
+			counts["Privacy"] = 1
+		}
+	}
+	globalPrivacy.Unlock()
+	if err != nil {
+		return
+	}
+
+	globalLastLocation.Lock()
+	delete(globalLastLocation.Location[family], device)
+	globalLastLocation.Unlock()
+	globalZoneState.Lock()
+	delete(globalZoneState.Visits[family], device)
+	globalZoneState.Unlock()
+	forgetTracker(family, device, "")
+
+	entry = models.AuditEntry{
+		Timestamp: time.Now().UTC().UnixNano() / int64(time.Millisecond),
+		Action:    models.AuditErase,
+		Device:    device,
+		Actor:     actor,
+		Counts:    counts,
+	}
+	d, err = database.Open(family, true)
+	if err != nil {
+		return
+	}
+	defer d.Close()
+	entry.ID, err = d.AddAuditEntry(entry)
+	return
+}
+
+// forgetAIModel removes the model learned by the AI server, DataFolder/FAMILY.find3.ai with the family in base58,
+// and asks the AI server to drop it from its cache
+func forgetAIModel(family string) (deleted bool, err error) {
+	err = os.Remove(path.Join(DataFolder, base58.FastBase58Encoding([]byte(family))+".find3.ai"))
+	if err == nil {
+		deleted = true
+	} else if os.IsNotExist(err) {
+		err = nil
+	} else {
+		return
+	}
+
+	bPayload, err := json.Marshal(map[string]string{"family": family})
+	if err != nil {
+		return
+	}
+	req, err := http.NewRequest("POST", "http://localhost:"+AIPort+"/forget", bytes.NewBuffer(bPayload))
+	if err != nil {
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, errAI := httpClient.Do(req)
+	if errAI != nil {
+		// the cache of the AI server expires soon anyway, and without the file it is not loaded again
+		logger.Log.Warnf("[%s] could not reach the AI server to forget the model: %s", family, errAI.Error())
+		return
+	}
+	resp.Body.Close()
+	return
+}
+
+// GetAudit returns the latest entries of the audit log of a family, newest first
+func GetAudit(family string) (entries []models.AuditEntry, err error) {
+	d, err := database.Open(family, true)
+	if err != nil {
+		return
+	}
+	defer d.Close()
+	return d.GetAuditEntries(AuditLimit)
+}
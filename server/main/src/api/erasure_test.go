@@ -0,0 +1,101 @@
+package api
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/mr-tron/base58/base58"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/Nimaapr/find3/server/main/src/database"
+	"github.com/Nimaapr/find3/server/main/src/models"
+)
+
+func TestEraseDevice(t *testing.T) {
+	defer useTestDatabase(t, "testerase")()
+	defer func(dataFolder, aiPort string) { DataFolder, AIPort = dataFolder, aiPort }(DataFolder, AIPort)
+	DataFolder = database.DataFolder
+	// nothing listens on it
+	AIPort = "1"
+	aiModel := path.Join(DataFolder, base58.FastBase58Encoding([]byte("testerase"))+".find3.ai")
+	assert.Nil(t, ioutil.WriteFile(aiModel, []byte("model"), 0644))
+
+	d, err := database.Open("testerase")
+	assert.Nil(t, err)
+	datas := []models.SensorData{
+		{Timestamp: 1000, Family: "testerase", Device: "phone", Location: "kitchen", GPS: models.GPS{Latitude: 1, Longitude: 2},
+			Sensors: map[string]map[string]interface{}{"wifi": {"aa": -50}}},
+		{Timestamp: 2000, Family: "testerase", Device: "phone",
+			Sensors: map[string]map[string]interface{}{"wifi": {"aa": -60}}},
+		{Timestamp: 3000, Family: "testerase", Device: "other",
+			Sensors: map[string]map[string]interface{}{"wifi": {"aa": -70}}},
+	}
+	assert.Nil(t, d.AddSensors(datas))
+	assert.Nil(t, d.SetGPS(datas[0]))
+	assert.Nil(t, d.AddPrediction(2000, []models.LocationPrediction{{Location: "kitchen", Probability: 0.9}}))
+	assert.Nil(t, d.AddPrediction(3000, []models.LocationPrediction{{Location: "office", Probability: 0.8}}))
+	_, err = d.AddEvent(models.Event{Type: models.EventLocationChange, Device: "phone", Location: "kitchen", Timestamp: 2000})
+	assert.Nil(t, err)
+	assert.Nil(t, d.Set("ReverseRollingData", models.ReverseRollingData{
+		Family:         "testerase",
+		DeviceLocation: map[string]string{"phone": "kitchen", "other": "office"},
+		DeviceGPS:      map[string]models.GPS{},
+	}))
+	assert.Nil(t, d.Set("NB1", map[string]int{"model": 1}))
+	d.Close()
+	_, err = SetRSSIOffsets("testerase", models.RSSIOffsets{Devices: map[string]float64{"phone": 3, "other": 2}})
+	assert.Nil(t, err)
+	_, err = SetPrivacy("testerase", true, 0, []string{"phone"})
+	assert.Nil(t, err)
+
+	export, err := ExportDevice("testerase", "Phone", "127.0.0.1")
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(export.Sensors))
+	assert.Equal(t, 1, len(export.Predictions))
+	assert.Equal(t, 1, len(export.Events))
+	assert.Equal(t, 1, len(export.GPS))
+	assert.Equal(t, models.DeviceSettings{LearningLocation: "kitchen", RSSIOffset: 3, Allowlisted: true}, export.Settings)
+
+	entry, err := EraseDevice("testerase", "phone", "127.0.0.1")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), entry.Counts["learning"])
+	assert.Equal(t, int64(2), entry.Counts["sensors"])
+	assert.Equal(t, int64(1), entry.Counts["location_predictions"])
+	assert.Equal(t, int64(1), entry.Counts["gps"])
+	assert.Equal(t, int64(1), entry.Counts["events"])
+	// "NB1" and the model of the AI server
+	assert.Equal(t, int64(2), entry.Counts["models"])
+	_, err = os.Stat(aiModel)
+	assert.True(t, os.IsNotExist(err))
+
+	// nothing is left of the device, and the other device is kept
+	_, err = ExportDevice("testerase", "phone", "127.0.0.1")
+	assert.NotNil(t, err)
+	export, err = ExportDevice("testerase", "other", "127.0.0.1")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(export.Sensors))
+	assert.Equal(t, 1, len(export.Predictions))
+	assert.Equal(t, "office", export.Settings.LearningLocation)
+	offsets, err := GetRSSIOffsets("testerase")
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]float64{"other": 2}, offsets.Devices)
+	privacy, err := GetPrivacy("testerase")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{}, privacy.Allowlist)
+
+	d, err = database.Open("testerase", true)
+	assert.Nil(t, err)
+	var model map[string]int
+	assert.NotNil(t, d.Get("NB1", &model))
+	d.Close()
+
+	audit, err := GetAudit("testerase")
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(audit))
+	assert.Equal(t, models.AuditExport, audit[0].Action)
+	assert.Equal(t, "other", audit[0].Device)
+	assert.Equal(t, models.AuditErase, audit[1].Action)
+	assert.Equal(t, entry.Counts, audit[1].Counts)
+}
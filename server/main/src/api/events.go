@@ -0,0 +1,72 @@
+package api
+
+/*
+This code keeps track of the last known location of every device, so that changes in the best guess can be
+turned into events (see models.Event).
+
+DetectLocationChange is called with each new best guess of a device. It compares the guess with the previous location
+of the device, which is kept in memory. When the server has just started, the previous location is taken from the last
+prediction stored in the database before this fingerprint, so that a restart does not look like every device moved.
+*/
+
+import (
+	"sync"
+
+	"github.com/Nimaapr/find3/server/main/src/database"
+)
+
+type LastLocationMap struct {
+	// Location maps family -> device -> location
+	Location map[string]map[string]string
+	sync.RWMutex
+}
+
+var globalLastLocation LastLocationMap
+
+func init() {
+	globalLastLocation.Lock()
+	defer globalLastLocation.Unlock()
+	globalLastLocation.Location = make(map[string]map[string]string)
+}
+
+// DetectLocationChange records the current location of a device and returns
+// the previous location and whether it has changed.
+func DetectLocationChange(family, device, location string, timestamp int64) (previous string, changed bool) {
+	globalLastLocation.RLock()
+	previous, known := globalLastLocation.Location[family][device]
+	globalLastLocation.RUnlock()
+
+	if !known {
+		d, err := database.Open(family, true)
+		if err == nil {
+			_, guesses, errGet := d.GetLatestPredictionBefore(device, timestamp)
+			d.Close()
+			if errGet == nil && len(guesses) > 0 {
+				previous = guesses[0].Location
+				known = true
+			}
+		}
+	}
+
+	globalLastLocation.Lock()
+	if _, ok := globalLastLocation.Location[family]; !ok {
+		globalLastLocation.Location[family] = make(map[string]string)
+	}
+	globalLastLocation.Location[family][device] = location
+	globalLastLocation.Unlock()
+
+	changed = !known || previous != location
+	return
+}
+
+// GetLastLocations returns the last known location of each device of a family
+// that has been seen since the server started.
+func GetLastLocations(family string) (locations map[string]string) {
+	globalLastLocation.RLock()
+	defer globalLastLocation.RUnlock()
+	locations = make(map[string]string)
+	for device, location := range globalLastLocation.Location[family] {
+		locations[device] = location
+	}
+	return
+}
@@ -59,6 +59,43 @@ func SaveSensorData(p models.SensorData) (err error) {
 	return
 }
 
+// SaveSensorDatas will add several sensor data of a family to the database at once,
+// skipping the ones that are not valid, and returns the ones that were saved
+func SaveSensorDatas(family string, datas []models.SensorData) (saved []models.SensorData, err error) {
+	saved = make([]models.SensorData, 0, len(datas))
+	hasLocation := false
+	for _, p := range datas {
+		if errValid := p.Validate(); errValid != nil {
+			logger.Log.Warnf("[%s] skipping %s: %s", family, p.Device, errValid.Error())
+			continue
+		}
+		saved = append(saved, p)
+		hasLocation = hasLocation || p.Location != ""
+	}
+	db, err := database.Open(family)
+	if err != nil {
+		return
+	}
+	err = db.AddSensors(saved)
+	if err == nil {
+		for _, p := range saved {
+			if p.GPS.Longitude != 0 && p.GPS.Latitude != 0 {
+				db.SetGPS(p)
+			}
+		}
+	}
+	db.Close()
+	if err != nil {
+		saved = nil
+		return
+	}
+
+	if hasLocation {
+		go updateCounter(family)
+	}
+	return
+}
+
 // SavePrediction will add sensor data to the database
 func SavePrediction(s models.SensorData, p models.LocationAnalysis) (err error) {
 	db, err := database.Open(s.Family)
@@ -0,0 +1,254 @@
+package api
+
+/*
+This code keeps the floorplans of a family and the coordinates of its locations on them (see models.Floorplan and
+models.LocationCoordinate).
+
+The images are stored as files in DataFolder/floorplans/FAMILY/FLOOR, with the family encoded like the database
+names. Their descriptions are stored in the keystore under "Floorplans", as a map from the floor to a
+models.Floorplan, and the coordinates under "LocationCoordinates", as a map from the location to its coordinate.
+
+The coordinates of the locations of a floor are kept when its floorplan is deleted, so they are back when a new
+image of the floor is uploaded.
+*/
+
+import (
+	"bytes"
+	"image"
+	// the formats of the floorplans
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io/ioutil"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mr-tron/base58/base58"
+	"github.com/pkg/errors"
+
+	"github.com/Nimaapr/find3/server/main/src/database"
+	"github.com/Nimaapr/find3/server/main/src/models"
+)
+
+// FloorplanMaxSize is the largest image of a floorplan, in bytes
+var FloorplanMaxSize int64 = 20 << 20
+
+var validFloor = regexp.MustCompile(`^[a-z0-9_-]+$`)
+
+// CleanFloor returns the name of a floor as it is stored, or an error if it is not a valid name
+func CleanFloor(floor string) (string, error) {
+	floor = strings.TrimSpace(strings.ToLower(floor))
+	if !validFloor.MatchString(floor) {
+		return floor, errors.New("floor '" + floor + "' must be letters, digits, '-' or '_'")
+	}
+	return floor, nil
+}
+
+func floorplanPath(family, floor string) string {
+	return path.Join(DataFolder, "floorplans", base58.FastBase58Encoding([]byte(family)), floor)
+}
+
+// GetFloorplans returns the floorplans of a family, sorted by floor, and the coordinates of its locations
+func GetFloorplans(family string) (floorplans []models.Floorplan, coordinates map[string]models.LocationCoordinate, err error) {
+	d, err := database.Open(family, true)
+	if err != nil {
+		return
+	}
+	defer d.Close()
+	floors := getFloorplans(d)
+	floorplans = make([]models.Floorplan, 0, len(floors))
+	for _, f := range floors {
+		floorplans = append(floorplans, f)
+	}
+	sort.Slice(floorplans, func(i, j int) bool { return floorplans[i].Floor < floorplans[j].Floor })
+	coordinates = getLocationCoordinates(d)
+	return
+}
+
+func getFloorplans(d *database.Database) (floorplans map[string]models.Floorplan) {
+	errGet := d.Get("Floorplans", &floorplans)
+	if errGet != nil || floorplans == nil {
+		floorplans = make(map[string]models.Floorplan)
+	}
+	return
+}
+
+func getLocationCoordinates(d *database.Database) (coordinates map[string]models.LocationCoordinate) {
+	errGet := d.Get("LocationCoordinates", &coordinates)
+	if errGet != nil || coordinates == nil {
+		coordinates = make(map[string]models.LocationCoordinate)
+	}
+	return
+}
+
+// SaveFloorplan stores the image of a floor and its scale. Without an image only the
+// scale of an existing floorplan is changed.
+func SaveFloorplan(family, floor string, img []byte, pixelsPerMeter float64) (floorplan models.Floorplan, err error) {
+	floor, err = CleanFloor(floor)
+	if err != nil {
+		return
+	}
+	if pixelsPerMeter < 0 {
+		err = errors.New("pixels_per_meter must be positive")
+		return
+	}
+	if int64(len(img)) > FloorplanMaxSize {
+		err = errors.Errorf("floorplan is larger than %d bytes", FloorplanMaxSize)
+		return
+	}
+	d, err := database.Open(family, true)
+	if err != nil {
+		return
+	}
+	defer d.Close()
+	floorplans := getFloorplans(d)
+	floorplan, ok := floorplans[floor]
+	if len(img) == 0 && !ok {
+		err = errors.New("no floorplan for floor '" + floor + "', upload an image")
+		return
+	}
+	floorplan.Floor = floor
+	floorplan.PixelsPerMeter = pixelsPerMeter
+
+	if len(img) > 0 {
+		config, format, errDecode := image.DecodeConfig(bytes.NewReader(img))
+		if errDecode != nil {
+			err = errors.Wrap(errDecode, "floorplan must be a PNG, JPEG or GIF image")
+			return
+		}
+		fname := floorplanPath(family, floor)
+		err = os.MkdirAll(path.Dir(fname), 0755)
+		if err != nil {
+			return
+		}
+		err = ioutil.WriteFile(fname, img, 0644)
+		if err != nil {
+			return
+		}
+		floorplan.ContentType = "image/" + format
+		floorplan.Width = config.Width
+		floorplan.Height = config.Height
+		floorplan.Uploaded = time.Now().UTC()
+	}
+	floorplans[floor] = floorplan
+	err = d.Set("Floorplans", floorplans)
+	return
+}
+
+// GetFloorplanImage returns the image of a floor and its content type
+func GetFloorplanImage(family, floor string) (img []byte, contentType string, err error) {
+	floor, err = CleanFloor(floor)
+	if err != nil {
+		return
+	}
+	d, err := database.Open(family, true)
+	if err != nil {
+		return
+	}
+	floorplan, ok := getFloorplans(d)[floor]
+	d.Close()
+	if !ok {
+		err = errors.New("no floorplan for floor '" + floor + "'")
+		return
+	}
+	img, err = ioutil.ReadFile(floorplanPath(family, floor))
+	contentType = floorplan.ContentType
+	return
+}
+
+// DeleteFloorplan removes the floorplan of a floor, the coordinates on the floor are kept
+func DeleteFloorplan(family, floor string) (err error) {
+	floor, err = CleanFloor(floor)
+	if err != nil {
+		return
+	}
+	d, err := database.Open(family, true)
+	if err != nil {
+		return
+	}
+	defer d.Close()
+	floorplans := getFloorplans(d)
+	if _, ok := floorplans[floor]; !ok {
+		err = errors.New("no floorplan for floor '" + floor + "'")
+		return
+	}
+	delete(floorplans, floor)
+	err = d.Set("Floorplans", floorplans)
+	if err != nil {
+		return
+	}
+	os.Remove(floorplanPath(family, floor))
+	return
+}
+
+// GetLocationCoordinates returns the coordinates of the locations of a family
+func GetLocationCoordinates(family string) (coordinates map[string]models.LocationCoordinate, err error) {
+	d, err := database.Open(family, true)
+	if err != nil {
+		return
+	}
+	defer d.Close()
+	coordinates = getLocationCoordinates(d)
+	return
+}
+
+// SetLocationCoordinates places locations on floors, replacing their previous coordinates
+func SetLocationCoordinates(family string, changes []models.LocationCoordinate) (coordinates map[string]models.LocationCoordinate, err error) {
+	for i, c := range changes {
+		c.Location = strings.TrimSpace(strings.ToLower(c.Location))
+		if c.Location == "" {
+			err = errors.New("coordinate needs a location")
+			return
+		}
+		c.Floor, err = CleanFloor(c.Floor)
+		if err != nil {
+			return
+		}
+		if c.Unit == "" {
+			c.Unit = models.UnitPixels
+		}
+		if c.Unit != models.UnitPixels && c.Unit != models.UnitMeters {
+			err = errors.New("unit must be 'px' or 'm'")
+			return
+		}
+		if c.X < 0 || c.Y < 0 {
+			err = errors.New("coordinates are from the top left corner and must be positive")
+			return
+		}
+		changes[i] = c
+	}
+
+	d, err := database.Open(family, true)
+	if err != nil {
+		return
+	}
+	defer d.Close()
+	coordinates = getLocationCoordinates(d)
+	for _, c := range changes {
+		coordinates[c.Location] = c
+	}
+	err = d.Set("LocationCoordinates", coordinates)
+	return
+}
+
+// DeleteLocationCoordinate removes the coordinate of a location
+func DeleteLocationCoordinate(family, location string) (err error) {
+	location = strings.TrimSpace(strings.ToLower(location))
+	d, err := database.Open(family, true)
+	if err != nil {
+		return
+	}
+	defer d.Close()
+	coordinates := getLocationCoordinates(d)
+	if _, ok := coordinates[location]; !ok {
+		err = errors.New("no coordinate for '" + location + "'")
+		return
+	}
+	delete(coordinates, location)
+	err = d.Set("LocationCoordinates", coordinates)
+	return
+}
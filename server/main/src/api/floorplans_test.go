@@ -0,0 +1,74 @@
+package api
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/Nimaapr/find3/server/main/src/database"
+	"github.com/Nimaapr/find3/server/main/src/models"
+)
+
+func TestFloorplans(t *testing.T) {
+	defer useTestDatabase(t, "testfloorplans")()
+	defer func(dataFolder string) { DataFolder = dataFolder }(DataFolder)
+	DataFolder = database.DataFolder
+
+	var buf bytes.Buffer
+	assert.Nil(t, png.Encode(&buf, image.NewGray(image.Rect(0, 0, 400, 200))))
+
+	_, err := SaveFloorplan("testfloorplans", "Ground Floor", buf.Bytes(), 0)
+	assert.NotNil(t, err)
+	_, err = SaveFloorplan("testfloorplans", "1", []byte("not an image"), 0)
+	assert.NotNil(t, err)
+	_, err = SaveFloorplan("testfloorplans", "1", nil, 20)
+	assert.NotNil(t, err)
+
+	floorplan, err := SaveFloorplan("testfloorplans", " 1 ", buf.Bytes(), 0)
+	assert.Nil(t, err)
+	assert.Equal(t, "1", floorplan.Floor)
+	assert.Equal(t, "image/png", floorplan.ContentType)
+	assert.Equal(t, 400, floorplan.Width)
+	assert.Equal(t, 200, floorplan.Height)
+
+	// the scale can be changed without the image
+	floorplan, err = SaveFloorplan("testfloorplans", "1", nil, 20)
+	assert.Nil(t, err)
+	assert.Equal(t, 400, floorplan.Width)
+	assert.Equal(t, 20.0, floorplan.PixelsPerMeter)
+
+	img, contentType, err := GetFloorplanImage("testfloorplans", "1")
+	assert.Nil(t, err)
+	assert.Equal(t, "image/png", contentType)
+	assert.Equal(t, buf.Bytes(), img)
+
+	_, err = SetLocationCoordinates("testfloorplans", []models.LocationCoordinate{{Location: "kitchen", Floor: "1", Unit: "ft"}})
+	assert.NotNil(t, err)
+	coordinates, err := SetLocationCoordinates("testfloorplans", []models.LocationCoordinate{
+		{Location: "Kitchen", Floor: "1", X: 100, Y: 50},
+		{Location: "office", Floor: "1", X: 2.5, Y: 5, Unit: models.UnitMeters},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, models.UnitPixels, coordinates["kitchen"].Unit)
+	x, y, ok := coordinates["office"].Pixels(floorplan)
+	assert.True(t, ok)
+	assert.Equal(t, []float64{50, 100}, []float64{x, y})
+	x, y, ok = coordinates["kitchen"].Meters(floorplan)
+	assert.True(t, ok)
+	assert.Equal(t, []float64{5, 2.5}, []float64{x, y})
+
+	// the coordinates stay when the floorplan is deleted
+	assert.Nil(t, DeleteFloorplan("testfloorplans", "1"))
+	_, _, err = GetFloorplanImage("testfloorplans", "1")
+	assert.NotNil(t, err)
+	floorplans, coordinates, err := GetFloorplans("testfloorplans")
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(floorplans))
+	assert.Equal(t, 2, len(coordinates))
+
+	assert.Nil(t, DeleteLocationCoordinate("testfloorplans", "kitchen"))
+	assert.NotNil(t, DeleteLocationCoordinate("testfloorplans", "kitchen"))
+}
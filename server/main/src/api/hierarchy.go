@@ -0,0 +1,217 @@
+package api
+
+/*
+This code keeps the hierarchy of the locations of a family (see models.LocationHierarchy), in the location_hierarchy
+table, and uses it to classify in two stages and to measure the accuracy at each level.
+
+The hierarchy settings are stored in the keystore under "HierarchySettings". With two-stage classification, the
+calibration also fits a naive Bayes model of the floors ("NB1Floors"), learned from the data of all the rooms of
+each floor. A fingerprint is then classified in two stages:
+
+1. the floor is the best guess of the floor model, or, without one, the floor whose rooms have the most of the
+   probability of the guesses,
+2. the room is the best guess among the rooms of that floor, the probabilities of the guesses are scaled to sum to
+   one again.
+
+Locations that are not on a floor are never ruled out. Mistakes between floors, and buildings, are less likely
+because the floor model learns from all the data of a floor at once.
+
+After calibration with cross validation the accuracy of the sites, buildings and floors is stored under
+"AccuracyBreakdownLevels", next to the accuracy of the locations in "AccuracyBreakdown": a guess is right at a level
+when the guessed location is in the same site, building or floor as the true location.
+*/
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/Nimaapr/find3/server/main/src/database"
+	"github.com/Nimaapr/find3/server/main/src/learning/nb1"
+	"github.com/Nimaapr/find3/server/main/src/models"
+)
+
+// FloorModel is the key of the keystore of the naive Bayes model of the floors
+const FloorModel = "NB1Floors"
+
+// GetHierarchy returns the places of the locations in the hierarchy, sorted by location, and the settings
+func GetHierarchy(family string) (hierarchy []models.LocationHierarchy, settings models.HierarchySettings, err error) {
+	d, err := database.Open(family, true)
+	if err != nil {
+		return
+	}
+	defer d.Close()
+	locations, err := d.GetLocationHierarchy()
+	if err != nil {
+		return
+	}
+	hierarchy = make([]models.LocationHierarchy, 0, len(locations))
+	for _, h := range locations {
+		hierarchy = append(hierarchy, h)
+	}
+	sort.Slice(hierarchy, func(i, j int) bool { return hierarchy[i].Location < hierarchy[j].Location })
+	d.Get("HierarchySettings", &settings)
+	return
+}
+
+// SetHierarchy places locations in the hierarchy, and turns two-stage classification on or off
+// when twoStage is given. It takes effect at the next calibration.
+func SetHierarchy(family string, changes []models.LocationHierarchy, twoStage *bool) (err error) {
+	for i, h := range changes {
+		h.Location = strings.TrimSpace(strings.ToLower(h.Location))
+		h.Site = strings.TrimSpace(strings.ToLower(h.Site))
+		h.Building = strings.TrimSpace(strings.ToLower(h.Building))
+		h.Floor = strings.TrimSpace(strings.ToLower(h.Floor))
+		h.Room = strings.TrimSpace(strings.ToLower(h.Room))
+		if h.Location == "" {
+			return errors.New("hierarchy needs a location")
+		}
+		if (h.Floor != "" && h.Building == "") || (h.Building != "" && h.Site == "") {
+			return errors.New("'" + h.Location + "' needs a site for its building and a building for its floor")
+		}
+		for _, name := range []string{h.Site, h.Building, h.Floor} {
+			if strings.Contains(name, "/") {
+				return errors.New("names of sites, buildings and floors cannot have '/'")
+			}
+		}
+		if h.Room == "" {
+			h.Room = h.Location
+		}
+		changes[i] = h
+	}
+
+	d, err := database.Open(family, true)
+	if err != nil {
+		return
+	}
+	defer d.Close()
+	err = d.SetLocationHierarchy(changes)
+	if err != nil || twoStage == nil {
+		return
+	}
+	var settings models.HierarchySettings
+	d.Get("HierarchySettings", &settings)
+	settings.TwoStage = *twoStage
+	return d.Set("HierarchySettings", settings)
+}
+
+// DeleteHierarchy removes a location from the hierarchy
+func DeleteHierarchy(family, location string) (err error) {
+	d, err := database.Open(family, true)
+	if err != nil {
+		return
+	}
+	defer d.Close()
+	return d.DeleteLocationHierarchy(strings.TrimSpace(strings.ToLower(location)))
+}
+
+// fitFloors fits the model of the floors with the data of the locations that are on a floor
+func fitFloors(family string, datas []models.SensorData) (err error) {
+	d, err := database.Open(family, true)
+	if err != nil {
+		return
+	}
+	var settings models.HierarchySettings
+	d.Get("HierarchySettings", &settings)
+	hierarchy, err := d.GetLocationHierarchy()
+	d.Close()
+	if err != nil || !settings.TwoStage {
+		return
+	}
+	floorDatas := []models.SensorData{}
+	floors := make(map[string]struct{})
+	for _, data := range datas {
+		floor := hierarchy[data.Location].Key(models.LevelFloor)
+		if floor == "" {
+			continue
+		}
+		data.Location = floor
+		floorDatas = append(floorDatas, data)
+		floors[floor] = struct{}{}
+	}
+	if len(floors) < 2 {
+		return errors.New("two-stage classification needs locations on two floors or more")
+	}
+	return nb1.NewNamed(FloorModel).Fit(floorDatas)
+}
+
+// classifyFloor returns the guesses of the floor of sensor data, best first
+func classifyFloor(s models.SensorData, guesses []models.LocationPrediction, hierarchy map[string]models.LocationHierarchy) (floors []models.LocationPrediction) {
+	pl, err := nb1.NewNamed(FloorModel).Classify(s)
+	if err == nil {
+		floors = make([]models.LocationPrediction, len(pl))
+		for i := range pl {
+			floors[i] = models.LocationPrediction{Location: pl[i].Key, Probability: float64(int(pl[i].Value*100)) / 100}
+		}
+		return
+	}
+
+	// without the model, the floors get the probability of their rooms
+	probabilities := make(map[string]float64)
+	for _, guess := range guesses {
+		if floor := hierarchy[guess.Location].Key(models.LevelFloor); floor != "" {
+			probabilities[floor] += guess.Probability
+		}
+	}
+	floors = make([]models.LocationPrediction, 0, len(probabilities))
+	for floor, p := range probabilities {
+		floors = append(floors, models.LocationPrediction{Location: floor, Probability: p})
+	}
+	sort.Slice(floors, func(i, j int) bool {
+		if floors[i].Probability == floors[j].Probability {
+			return floors[i].Location < floors[j].Location
+		}
+		return floors[i].Probability > floors[j].Probability
+	})
+	return
+}
+
+// guessesOnFloor keeps the guesses of the rooms of a floor, and of the locations that are not on
+// a floor, with their probabilities scaled to sum to one. The guesses are kept as they are if
+// none is left.
+func guessesOnFloor(guesses []models.LocationPrediction, floor string, hierarchy map[string]models.LocationHierarchy) []models.LocationPrediction {
+	kept := []models.LocationPrediction{}
+	total := 0.0
+	for _, guess := range guesses {
+		if f := hierarchy[guess.Location].Key(models.LevelFloor); f == "" || f == floor {
+			kept = append(kept, guess)
+			total += guess.Probability
+		}
+	}
+	if len(kept) == 0 || total == 0 {
+		return guesses
+	}
+	for i := range kept {
+		kept[i].Probability = float64(int(kept[i].Probability/total*100000)) / 100000
+	}
+	return kept
+}
+
+// levelAccuracy returns the accuracy of the guesses at each level of the hierarchy, by the
+// true site, building or floor
+func levelAccuracy(truths, guesses []string, hierarchy map[string]models.LocationHierarchy) (accuracy map[string]map[string]float64) {
+	accuracy = make(map[string]map[string]float64)
+	for _, level := range models.Levels {
+		correct := make(map[string]float64)
+		total := make(map[string]float64)
+		for i := range truths {
+			truth := hierarchy[truths[i]].Key(level)
+			if truth == "" {
+				continue
+			}
+			total[truth]++
+			if hierarchy[guesses[i]].Key(level) == truth {
+				correct[truth]++
+			}
+		}
+		if len(total) == 0 {
+			continue
+		}
+		accuracy[level] = make(map[string]float64)
+		for name := range total {
+			accuracy[level][name] = correct[name] / total[name]
+		}
+	}
+	return
+}
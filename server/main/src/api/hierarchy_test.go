@@ -0,0 +1,79 @@
+package api
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/Nimaapr/find3/server/main/src/models"
+)
+
+func TestHierarchy(t *testing.T) {
+	defer useTestDatabase(t, "testhierarchy")()
+
+	assert.NotNil(t, SetHierarchy("testhierarchy", []models.LocationHierarchy{{Location: "kitchen", Floor: "1"}}, nil))
+	assert.NotNil(t, SetHierarchy("testhierarchy", []models.LocationHierarchy{{Location: "kitchen", Site: "home", Building: "a/b"}}, nil))
+
+	twoStage := true
+	assert.Nil(t, SetHierarchy("testhierarchy", []models.LocationHierarchy{
+		{Location: " Kitchen ", Site: "Home", Building: "house", Floor: "0"},
+		{Location: "bedroom", Site: "home", Building: "house", Floor: "1", Room: "master"},
+		{Location: "garden", Site: "home"},
+	}, &twoStage))
+	hierarchy, settings, err := GetHierarchy("testhierarchy")
+	assert.Nil(t, err)
+	assert.True(t, settings.TwoStage)
+	assert.Equal(t, 3, len(hierarchy))
+	assert.Equal(t, "bedroom", hierarchy[0].Location)
+	assert.Equal(t, "master", hierarchy[0].Room)
+	assert.Equal(t, "kitchen", hierarchy[2].Location)
+	assert.Equal(t, "kitchen", hierarchy[2].Room)
+	assert.Equal(t, "home/house/0", hierarchy[2].Key(models.LevelFloor))
+	assert.Equal(t, "home/house", hierarchy[2].Key(models.LevelBuilding))
+	assert.Equal(t, "", hierarchy[1].Key(models.LevelFloor))
+	assert.Equal(t, "home", hierarchy[1].Key(models.LevelSite))
+
+	// the settings are kept when two_stage is not given
+	assert.Nil(t, SetHierarchy("testhierarchy", []models.LocationHierarchy{{Location: "garden", Site: "home", Building: "shed"}}, nil))
+	_, settings, _ = GetHierarchy("testhierarchy")
+	assert.True(t, settings.TwoStage)
+
+	assert.Nil(t, DeleteHierarchy("testhierarchy", "garden"))
+	assert.NotNil(t, DeleteHierarchy("testhierarchy", "garden"))
+	hierarchy, _, _ = GetHierarchy("testhierarchy")
+	assert.Equal(t, 2, len(hierarchy))
+}
+
+func TestTwoStage(t *testing.T) {
+	hierarchy := map[string]models.LocationHierarchy{
+		"kitchen": {Location: "kitchen", Site: "home", Building: "house", Floor: "0", Room: "kitchen"},
+		"living":  {Location: "living", Site: "home", Building: "house", Floor: "0", Room: "living"},
+		"bedroom": {Location: "bedroom", Site: "home", Building: "house", Floor: "1", Room: "bedroom"},
+	}
+	guesses := []models.LocationPrediction{
+		{Location: "bedroom", Probability: 0.4},
+		{Location: "kitchen", Probability: 0.35},
+		{Location: "living", Probability: 0.15},
+		{Location: "garden", Probability: 0.1},
+	}
+
+	// without a model of the floors, they get the probability of their rooms
+	floors := classifyFloor(models.SensorData{Family: "testtwostage"}, guesses, hierarchy)
+	assert.Equal(t, 2, len(floors))
+	assert.Equal(t, "home/house/0", floors[0].Location)
+	assert.InDelta(t, 0.5, floors[0].Probability, 0.0001)
+
+	onFloor := guessesOnFloor(guesses, floors[0].Location, hierarchy)
+	assert.Equal(t, 3, len(onFloor))
+	assert.Equal(t, "kitchen", onFloor[0].Location)
+	assert.InDelta(t, 0.35/0.6, onFloor[0].Probability, 0.0001)
+	assert.Equal(t, "garden", onFloor[2].Location)
+	// the guesses are kept when none is on the floor
+	assert.Equal(t, guesses[:1], guessesOnFloor(guesses[:1], "home/house/0", hierarchy))
+
+	accuracy := levelAccuracy([]string{"kitchen", "living", "bedroom", "garden"}, []string{"living", "bedroom", "kitchen", "kitchen"}, hierarchy)
+	assert.Equal(t, 1.0, accuracy[models.LevelSite]["home"])
+	assert.Equal(t, 1.0, accuracy[models.LevelBuilding]["home/house"])
+	assert.Equal(t, 0.5, accuracy[models.LevelFloor]["home/house/0"])
+	assert.Equal(t, 0.0, accuracy[models.LevelFloor]["home/house/1"])
+}
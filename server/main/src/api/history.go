@@ -0,0 +1,63 @@
+package api
+
+/*
+This code builds the location history of a device from the predictions stored in the location_predictions table.
+
+GetHistory reads the predictions of the device between two timestamps and collapses the consecutive predictions with
+the same best guess into visits (see models.Visit), so that a device sitting in the kitchen for an hour is one visit
+rather than hundreds of fingerprints. Predictions without any guess are skipped.
+*/
+
+import (
+	"github.com/pkg/errors"
+
+	"github.com/Nimaapr/find3/server/main/src/database"
+	"github.com/Nimaapr/find3/server/main/src/models"
+)
+
+// GetHistory returns the visits of a device between from and to, in milliseconds
+func GetHistory(family, device string, from, to int64) (visits []models.Visit, err error) {
+	if to < from {
+		err = errors.New("'to' must be after 'from'")
+		return
+	}
+	d, err := database.Open(family, true)
+	if err != nil {
+		return
+	}
+	predictions, err := d.GetPredictionsBetween(device, from, to)
+	d.Close()
+	if err != nil {
+		err = errors.Wrap(err, "problem getting predictions for "+device)
+		return
+	}
+	visits = CollapseVisits(predictions)
+	return
+}
+
+// CollapseVisits turns a time-ordered list of predictions into visits
+func CollapseVisits(predictions []models.Prediction) (visits []models.Visit) {
+	visits = []models.Visit{}
+	for _, p := range predictions {
+		if len(p.Guesses) == 0 {
+			continue
+		}
+		guess := p.Guesses[0]
+		last := len(visits) - 1
+		if last >= 0 && visits[last].Location == guess.Location {
+			// keep a running mean of the probability
+			visits[last].Probability = (visits[last].Probability*float64(visits[last].Count) + guess.Probability) / float64(visits[last].Count+1)
+			visits[last].Count++
+			visits[last].Departure = p.Timestamp
+			continue
+		}
+		visits = append(visits, models.Visit{
+			Location:    guess.Location,
+			Arrival:     p.Timestamp,
+			Departure:   p.Timestamp,
+			Probability: guess.Probability,
+			Count:       1,
+		})
+	}
+	return
+}
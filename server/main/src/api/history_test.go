@@ -0,0 +1,32 @@
+package api
+
+import (
+	"testing"
+
+	"github.com/Nimaapr/find3/server/main/src/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCollapseVisits(t *testing.T) {
+	guess := func(location string, probability float64) []models.LocationPrediction {
+		return []models.LocationPrediction{{Location: location, Probability: probability}}
+	}
+	visits := CollapseVisits([]models.Prediction{
+		{Timestamp: 1, Guesses: guess("kitchen", 0.5)},
+		{Timestamp: 2, Guesses: guess("kitchen", 0.7)},
+		{Timestamp: 3},
+		{Timestamp: 4, Guesses: guess("kitchen", 0.9)},
+		{Timestamp: 5, Guesses: guess("bedroom", 0.6)},
+		{Timestamp: 6, Guesses: guess("kitchen", 0.8)},
+	})
+	assert.Equal(t, 3, len(visits))
+	assert.Equal(t, models.Visit{Location: "kitchen", Arrival: 1, Departure: 4, Probability: 0.7, Count: 3}, roundVisit(visits[0]))
+	assert.Equal(t, models.Visit{Location: "bedroom", Arrival: 5, Departure: 5, Probability: 0.6, Count: 1}, roundVisit(visits[1]))
+	assert.Equal(t, "kitchen", visits[2].Location)
+	assert.Equal(t, 0, len(CollapseVisits(nil)))
+}
+
+func roundVisit(v models.Visit) models.Visit {
+	v.Probability = float64(int(v.Probability*1000+0.5)) / 1000
+	return v
+}
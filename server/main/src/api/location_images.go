@@ -1,112 +1,316 @@
 package api
 
 /*
-The code is a Go program that is part of an API. It defines several functions and variables used to generate and retrieve images.
+This code makes the images of the location analysis page, in Go, when a family is calibrated.
 
-The GetImage function takes a family and a location string as inputs and returns an image in the form of a byte slice and an error. The image is read from a file using ioutil.ReadFile, with the path being constructed using the family and location inputs.
+For every location there is a chart of the signals of the most distinguishing sensors ("wifi-MAC",
+"bluetooth-MAC", ...) in the learning data of the location: the density of their RSSI from -100 to 0 dBm, as a
+translucent area per sensor. The most distinguishing sensors are the ones whose median signal varies the most across
+the locations, at most ImageSensors of them. When the charts of two locations look alike, those locations are likely
+too close to be told apart.
 
-The GenerateImages function takes a family string as input and generates images for that family by sending a POST request to a specified endpoint with a payload containing the URL and data folder for the images.
-The payload is constructed from a struct called Payload and is sent as a JSON object in the body of the request. The response from the endpoint is decoded into a struct called responseStruct, which contains a success flag and a message.
+The heatmap of the family shows the median signal of the HeatmapSensors most distinguishing sensors (the columns) at
+every location (the rows). A sensor that was not seen at a location is grey.
 
-The MainPort variable is a string that represents the main port used in the API.
-
-The FastBase58Encoding function from the base58 package is used to encode the family string before it is used in constructing the image path and data folder.
-
-more explanation below the code.
+The images are PNGs in DataFolder/images/FAMILY (the family encoded like the database names), one LOCATION.png per
+location, and the heatmap in DataFolder/images/FAMILY.heatmap.png. They are replaced at every calibration.
 */
 
 import (
-	"bytes"
-	"encoding/json"
+	"image"
+	"image/color"
+	"image/png"
 	"io/ioutil"
-	"net/http"
+	"math"
+	"os"
 	"path"
+	"sort"
+	"strconv"
 
 	"github.com/mr-tron/base58/base58"
+	"github.com/pkg/errors"
+
+	"github.com/Nimaapr/find3/server/main/src/database"
+	"github.com/Nimaapr/find3/server/main/src/models"
 )
 
 var MainPort string
 
+var (
+	// ImageSensors is the number of sensors in the chart of a location
+	ImageSensors = 10
+	// HeatmapSensors is the number of sensors in the heatmap
+	HeatmapSensors = 30
+)
+
+var (
+	imageBackground = color.RGBA{255, 255, 255, 255}
+	imageAxis       = color.RGBA{80, 80, 80, 255}
+	imageGrid       = color.RGBA{230, 230, 230, 255}
+	imageMissing    = color.RGBA{235, 235, 235, 255}
+)
+
+func imagesFolder(family string) string {
+	return path.Join(DataFolder, "images", base58.FastBase58Encoding([]byte(family)))
+}
+
+// GetImage returns the chart of a location
 func GetImage(family, location string) (img []byte, err error) {
-	imagePath := path.Join(DataFolder, "images", base58.FastBase58Encoding([]byte(family)), location+".png")
+	imagePath := path.Join(imagesFolder(family), location+".png")
 	logger.Log.Debugf("loading %s", imagePath)
 	img, err = ioutil.ReadFile(imagePath)
 	return
 }
 
-func GenerateImages(family string) {
-	logger.Log.Debugf("generating images for %s", family)
-	// Generated by curl-to-Go: https://mholt.github.io/curl-to-go
+// GetHeatmap returns the heatmap of a family
+func GetHeatmap(family string) (img []byte, err error) {
+	return ioutil.ReadFile(imagesFolder(family) + ".heatmap.png")
+}
 
-	type Payload struct {
-		URL        string `json:"url"`
-		DataFolder string `json:"data_folder"`
+// GenerateImages makes the charts of the locations of a family and its heatmap
+func GenerateImages(family string) {
+	logger.Log.Debugf("[%s] generating images", family)
+	err := generateImages(family)
+	if err != nil {
+		logger.Log.Warnf("[%s] problem generating images: %s", family, err.Error())
 	}
+}
 
-	data := Payload{
-		URL:        "http://localhost:" + MainPort + "/api/v1/data/" + family,
-		DataFolder: path.Join(DataFolder, "images", base58.FastBase58Encoding([]byte(family))),
-	}
-	payloadBytes, err := json.Marshal(data)
+func generateImages(family string) (err error) {
+	d, err := database.Open(family, true)
 	if err != nil {
-		logger.Log.Error(err)
 		return
 	}
-	body := bytes.NewReader(payloadBytes)
-
-	req, err := http.NewRequest("POST", "http://localhost:"+AIPort+"/plot", body)
+	datas, err := d.GetAllForClassification()
+	d.Close()
 	if err != nil {
-		logger.Log.Error(err)
 		return
 	}
-	req.Header.Set("Content-Type", "application/json")
+	signals := locationSignals(datas)
+	if len(signals) == 0 {
+		return errors.New("no learning data")
+	}
+	sensors := distinguishingSensors(signals)
 
-	resp, err := http.DefaultClient.Do(req)
+	folder := imagesFolder(family)
+	err = os.MkdirAll(folder, 0755)
 	if err != nil {
-		logger.Log.Error(err)
 		return
 	}
-	defer resp.Body.Close()
+	top := sensors
+	if len(top) > ImageSensors {
+		top = top[:ImageSensors]
+	}
+	for location := range signals {
+		err = savePNG(path.Join(folder, location+".png"), locationChart(location, signals[location], top))
+		if err != nil {
+			return
+		}
+	}
+	if len(sensors) > HeatmapSensors {
+		sensors = sensors[:HeatmapSensors]
+	}
+	return savePNG(folder+".heatmap.png", heatmap(signals, sensors))
+}
 
-	type responseStruct struct {
-		Message string
-		Success bool
+// locationSignals returns the signal strengths of each sensor at each location
+func locationSignals(datas []models.SensorData) (signals map[string]map[string][]float64) {
+	signals = make(map[string]map[string][]float64)
+	for _, data := range datas {
+		if data.Location == "" {
+			continue
+		}
+		if _, ok := signals[data.Location]; !ok {
+			signals[data.Location] = make(map[string][]float64)
+		}
+		for sensorType := range data.Sensors {
+			if models.ContinuousSensors[sensorType] {
+				continue
+			}
+			for mac, value := range data.Sensors[sensorType] {
+				rssi, ok := value.(float64)
+				if !ok {
+					continue
+				}
+				name := sensorType + "-" + mac
+				signals[data.Location][name] = append(signals[data.Location][name], rssi)
+			}
+		}
 	}
-	var target responseStruct
-	err = json.NewDecoder(resp.Body).Decode(&target)
-	if err != nil {
-		logger.Log.Error(err)
-		return
+	return
+}
+
+// distinguishingSensors returns the sensors whose median signal varies across the locations, most first
+func distinguishingSensors(signals map[string]map[string][]float64) (sensors []string) {
+	medians := make(map[string][]float64)
+	for location := range signals {
+		for sensor, values := range signals[location] {
+			medians[sensor] = append(medians[sensor], median(values))
+		}
 	}
-	logger.Log.Debug(target)
+	variances := make(map[string]float64)
+	for sensor, values := range medians {
+		mean := average(values)
+		v := 0.0
+		for _, x := range values {
+			v += (x - mean) * (x - mean)
+		}
+		if v = v / float64(len(values)); v > 0 {
+			variances[sensor] = v
+			sensors = append(sensors, sensor)
+		}
+	}
+	sort.Slice(sensors, func(i, j int) bool {
+		if variances[sensors[i]] == variances[sensors[j]] {
+			return sensors[i] < sensors[j]
+		}
+		return variances[sensors[i]] > variances[sensors[j]]
+	})
+	return
 }
 
-//
-/*
-This code is a Go program that implements an HTTP client that sends a POST request to a REST API. The program is part of a package named api, and it contains several functions, including GetImage, GenerateImages, etc.
+// density is the kernel density of values at x, with a bandwidth of half their deviation
+func density(values []float64, x float64) float64 {
+	mean := average(values)
+	bandwidth := 0.5 * stdDev(values, mean)
+	if !(bandwidth >= 1) {
+		// a single value, or values that are all about the same
+		bandwidth = 1
+	}
+	sum := 0.0
+	for _, v := range values {
+		z := (x - v) / bandwidth
+		sum += math.Exp(-z * z / 2)
+	}
+	return sum / (float64(len(values)) * bandwidth * math.Sqrt(2*math.Pi))
+}
 
-The GetImage function retrieves an image from the local file system using the ioutil.ReadFile method. The path to the image is constructed using the path.Join method and is comprised of the DataFolder and the family and location parameters passed to the function.
-The base58.FastBase58Encoding method is used to encode the family string as a Base58 string.
+// locationChart draws the density of the signals of the sensors at a location
+func locationChart(location string, signals map[string][]float64, sensors []string) *image.RGBA {
+	const width, height = 1000, 400
+	plot := image.Rect(60, 40, width-20, height-40)
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	fillRect(img, img.Bounds(), imageBackground)
 
-The GenerateImages function generates images for the given family by sending a POST request to a REST API running on localhost on the specified AIPort. The request payload contains a JSON object that consists of two fields: URL and DataFolder.
-The URL field contains the URL of the REST API endpoint, and the DataFolder field contains the path to the images folder.
+	shown := []string{}
+	curves := make(map[string][]float64)
+	maxDensity := 0.0
+	for _, sensor := range sensors {
+		values, ok := signals[sensor]
+		if !ok {
+			continue
+		}
+		shown = append(shown, sensor)
+		curves[sensor] = make([]float64, plot.Dx())
+		for i := range curves[sensor] {
+			curves[sensor][i] = density(values, -100+100*float64(i)/float64(plot.Dx()-1))
+			maxDensity = math.Max(maxDensity, curves[sensor][i])
+		}
+	}
 
-The HTTP request is created using the http.NewRequest method, and its header is set to contain the content type of the payload, which is "application/json". The request is sent using the http.DefaultClient.Do method, which returns an HTTP response.
+	// the grid and the axis, every 10 dBm
+	for dbm := -100; dbm <= 0; dbm += 10 {
+		x := plot.Min.X + (dbm+100)*(plot.Dx()-1)/100
+		drawLine(img, x, plot.Min.Y, x, plot.Max.Y, imageGrid)
+		label := strconv.Itoa(dbm)
+		drawText(img, x-textWidth(label, 1)/2, plot.Max.Y+8, label, imageAxis, 1, false)
+	}
+	drawText(img, plot.Min.X+plot.Dx()/2-textWidth("rssi (dbm)", 1)/2, plot.Max.Y+22, "rssi (dbm)", imageAxis, 1, false)
+	drawText(img, plot.Min.X-40, plot.Min.Y+plot.Dy()/2+textWidth("density", 1)/2, "density", imageAxis, 1, true)
 
-The response body is then decoded into a struct responseStruct using the json.NewDecoder method. The struct consists of two fields: Message and Success. The Message field contains the response message, and the Success field is a boolean value indicating whether the request was successful.
-The contents of the response are logged using the logger.Log.Debug method.
-*/
-/*
-Explain more on GetImage function:
+	for _, sensor := range shown {
+		c := colorOf(sensor, 0.25)
+		line := colorOf(sensor, 1)
+		for i, v := range curves[sensor] {
+			top := plot.Max.Y - int(float64(plot.Dy())*v/maxDensity)
+			fillRect(img, image.Rect(plot.Min.X+i, top, plot.Min.X+i+1, plot.Max.Y), c)
+			img.Set(plot.Min.X+i, top, line)
+		}
+	}
+	drawLine(img, plot.Min.X, plot.Max.Y, plot.Max.X, plot.Max.Y, imageAxis)
+	drawLine(img, plot.Min.X, plot.Min.Y, plot.Min.X, plot.Max.Y, imageAxis)
 
-The GetImage function retrieves an image file. It takes two arguments as input: family and location. The image file is constructed using family and location as follows:
+	drawText(img, width/2-textWidth(location, 2)/2, 12, location, imageAxis, 2, false)
 
-imagePath is constructed using the path.Join function. The arguments to path.Join are the data folder, a subfolder named "images", the base58 encoding of family and the location with a ".png" extension.
+	// the legend, in the top right corner
+	legendWidth := 0
+	for _, sensor := range shown {
+		if w := textWidth(sensor, 1); w > legendWidth {
+			legendWidth = w
+		}
+	}
+	for i, sensor := range shown {
+		x, y := plot.Max.X-legendWidth-24, plot.Min.Y+8+14*i
+		fillRect(img, image.Rect(x, y, x+10, y+8), colorOf(sensor, 1))
+		drawText(img, x+16, y, sensor, imageAxis, 1, false)
+	}
+	if len(shown) == 0 {
+		drawText(img, plot.Min.X+plot.Dx()/2-textWidth("no distinguishing sensors", 1)/2, plot.Min.Y+plot.Dy()/2, "no distinguishing sensors", imageAxis, 1, false)
+	}
+	return img
+}
+
+// heatmap draws the median signal of the sensors at every location
+func heatmap(signals map[string]map[string][]float64, sensors []string) *image.RGBA {
+	const cell = 18
+	locations := make([]string, 0, len(signals))
+	labelWidth, sensorWidth := 0, 0
+	for location := range signals {
+		locations = append(locations, location)
+		if w := textWidth(location, 1); w > labelWidth {
+			labelWidth = w
+		}
+	}
+	sort.Strings(locations)
+	for _, sensor := range sensors {
+		if w := textWidth(sensor, 1); w > sensorWidth {
+			sensorWidth = w
+		}
+	}
 
-The image file is then read using ioutil.ReadFile and stored in the img variable.
+	grid := image.Rect(labelWidth+20, sensorWidth+20, labelWidth+20+cell*len(sensors), sensorWidth+20+cell*len(locations))
+	width := grid.Max.X + 20
+	if w := grid.Min.X + 160 + textWidth("-30 dbm", 1); width < w {
+		width = w
+	}
+	img := image.NewRGBA(image.Rect(0, 0, width, grid.Max.Y+50))
+	fillRect(img, img.Bounds(), imageBackground)
 
-Finally, the function returns the img variable and an err variable, which is set to nil if there were no errors.
+	for j, sensor := range sensors {
+		drawText(img, grid.Min.X+cell*j+(cell-7)/2, grid.Min.Y-8, sensor, imageAxis, 1, true)
+	}
+	for i, location := range locations {
+		drawText(img, grid.Min.X-10-textWidth(location, 1), grid.Min.Y+cell*i+(cell-7)/2, location, imageAxis, 1, false)
+		for j, sensor := range sensors {
+			c := imageMissing
+			if values, ok := signals[location][sensor]; ok {
+				c = heatColor((median(values) + 100) / 70)
+			}
+			fillRect(img, image.Rect(grid.Min.X+cell*j, grid.Min.Y+cell*i, grid.Min.X+cell*(j+1)-1, grid.Min.Y+cell*(i+1)-1), c)
+		}
+	}
 
+	// the scale, from -100 to -30 dBm
+	y := grid.Max.Y + 20
+	for x := 0; x < 140; x++ {
+		fillRect(img, image.Rect(grid.Min.X+x, y, grid.Min.X+x+1, y+10), heatColor(float64(x)/139))
+	}
+	drawText(img, grid.Min.X-10-textWidth("-100", 1), y+2, "-100", imageAxis, 1, false)
+	drawText(img, grid.Min.X+150, y+2, "-30 dbm", imageAxis, 1, false)
+	return img
+}
 
-Note that the logger.Log.Debugf function is used to log the process of loading the image file.
-*/
+func savePNG(fname string, img image.Image) (err error) {
+	// write to a new file first, so the page never shows half an image
+	f, err := os.Create(fname + ".tmp")
+	if err != nil {
+		return
+	}
+	err = png.Encode(f, img)
+	f.Close()
+	if err != nil {
+		os.Remove(fname + ".tmp")
+		return
+	}
+	return os.Rename(fname+".tmp", fname)
+}
@@ -0,0 +1,96 @@
+package api
+
+import (
+	"bytes"
+	"image/png"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/Nimaapr/find3/server/main/src/database"
+	"github.com/Nimaapr/find3/server/main/src/models"
+)
+
+func TestGenerateImages(t *testing.T) {
+	defer useTestDatabase(t, "testimages")()
+	defer func(dataFolder string) { DataFolder = dataFolder }(DataFolder)
+	DataFolder = database.DataFolder
+
+	datas := []models.SensorData{}
+	for i := 0; i < 20; i++ {
+		datas = append(datas, models.SensorData{
+			Timestamp: int64(1000 + 2*i),
+			Family:    "testimages",
+			Device:    "phone",
+			Location:  "kitchen",
+			Sensors: map[string]map[string]interface{}{
+				"wifi":      {"aa:aa": float64(-40 - i%5), "bb:bb": float64(-80 - i%3), "cc:cc": -60.0},
+				"bluetooth": {"dd:dd": float64(-70 - i%4)},
+			},
+		}, models.SensorData{
+			Timestamp: int64(1001 + 2*i),
+			Family:    "testimages",
+			Device:    "phone",
+			Location:  "living room",
+			Sensors: map[string]map[string]interface{}{
+				"wifi": {"aa:aa": float64(-75 - i%5), "bb:bb": float64(-50 - i%3), "cc:cc": -60.0},
+			},
+		})
+	}
+	d, err := database.Open("testimages")
+	assert.Nil(t, err)
+	assert.Nil(t, d.AddSensors(datas))
+	d.Close()
+
+	// the sensor that is the same everywhere does not distinguish the locations
+	sensors := distinguishingSensors(locationSignals(datas))
+	assert.Equal(t, []string{"wifi-aa:aa", "wifi-bb:bb"}, sensors)
+
+	GenerateImages("testimages")
+	for _, location := range []string{"kitchen", "living room"} {
+		b, err := GetImage("testimages", location)
+		assert.Nil(t, err)
+		img, err := png.Decode(bytes.NewReader(b))
+		assert.Nil(t, err)
+		assert.Equal(t, 1000, img.Bounds().Dx())
+		assert.Equal(t, 400, img.Bounds().Dy())
+	}
+	b, err := GetHeatmap("testimages")
+	assert.Nil(t, err)
+	_, err = png.Decode(bytes.NewReader(b))
+	assert.Nil(t, err)
+}
+
+func TestCalibrateImagesWithoutAI(t *testing.T) {
+	defer useTestDatabase(t, "testimagesnoai")()
+	defer func(dataFolder, aiPort string) { DataFolder, AIPort = dataFolder, aiPort }(DataFolder, AIPort)
+	DataFolder = database.DataFolder
+	// nothing listens on it
+	AIPort = "1"
+
+	datas := []models.SensorData{}
+	for i := 0; i < 10; i++ {
+		datas = append(datas, models.SensorData{
+			Timestamp: int64(1000 + 2*i), Family: "testimagesnoai", Device: "phone", Location: "kitchen",
+			Sensors: map[string]map[string]interface{}{"wifi": {"aa:aa": float64(-40 - i%5), "bb:bb": -80.0}},
+		}, models.SensorData{
+			Timestamp: int64(1001 + 2*i), Family: "testimagesnoai", Device: "phone", Location: "office",
+			Sensors: map[string]map[string]interface{}{"wifi": {"aa:aa": float64(-75 - i%5), "bb:bb": -50.0}},
+		})
+	}
+	d, err := database.Open("testimagesnoai")
+	assert.Nil(t, err)
+	assert.Nil(t, d.AddSensors(datas))
+	d.Close()
+
+	// the python learning fails, and the images are drawn anyway
+	assert.NotNil(t, Calibrate("testimagesnoai", true))
+	for _, location := range []string{"kitchen", "office"} {
+		b, err := GetImage("testimagesnoai", location)
+		assert.Nil(t, err)
+		_, err = png.Decode(bytes.NewReader(b))
+		assert.Nil(t, err)
+	}
+	_, err = GetHeatmap("testimagesnoai")
+	assert.Nil(t, err)
+}
@@ -0,0 +1,236 @@
+package api
+
+/*
+This code normalizes the signals of the phones and scanners of a family with their RSSI offsets (see
+models.RSSIOffsets), which are stored in the keystore under "RSSIOffsets".
+
+NormalizeSensorData is called with all the data that comes in, before it is saved and classified, so the stored data
+is already normalized. The offsets are kept in memory after they are first read.
+
+The offsets can be set by hand, or estimated against a reference:
+
+- EstimateDeviceOffset compares the learning data of a device with the learning data of a reference device at the
+  same locations (a co-located learning session). The offset is the median difference of the mean signal of each
+  access point at each location.
+- EstimateScannerOffset compares the signals a scanner and a reference scanner reported in the same passive
+  fingerprints, with both scanners placed together at a location while learning.
+
+Because the stored data was normalized with the current offset, the median difference is added to the current
+offset of the device or scanner. The offset of the reference is kept.
+*/
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+
+	"github.com/Nimaapr/find3/server/main/src/database"
+	"github.com/Nimaapr/find3/server/main/src/models"
+)
+
+type RSSIOffsetsMap struct {
+	// Offsets maps family -> offsets
+	Offsets map[string]models.RSSIOffsets
+	sync.Mutex
+}
+
+var globalRSSIOffsets RSSIOffsetsMap
+
+func init() {
+	globalRSSIOffsets.Lock()
+	defer globalRSSIOffsets.Unlock()
+	globalRSSIOffsets.Offsets = make(map[string]models.RSSIOffsets)
+}
+
+// GetRSSIOffsets returns the RSSI offsets of a family
+func GetRSSIOffsets(family string) (offsets models.RSSIOffsets, err error) {
+	globalRSSIOffsets.Lock()
+	defer globalRSSIOffsets.Unlock()
+	offsets, ok := globalRSSIOffsets.Offsets[family]
+	if ok {
+		return
+	}
+	d, err := database.Open(family, true)
+	if err != nil {
+		return
+	}
+	defer d.Close()
+	offsets = getRSSIOffsets(d)
+	globalRSSIOffsets.Offsets[family] = offsets
+	return
+}
+
+func getRSSIOffsets(d *database.Database) (offsets models.RSSIOffsets) {
+	errGet := d.Get("RSSIOffsets", &offsets)
+	if errGet != nil || offsets.Devices == nil {
+		offsets.Devices = make(map[string]float64)
+	}
+	if errGet != nil || offsets.Scanners == nil {
+		offsets.Scanners = make(map[string]float64)
+	}
+	return
+}
+
+// SetRSSIOffsets changes the offsets of the devices and scanners given, an offset of 0 removes it
+func SetRSSIOffsets(family string, changes models.RSSIOffsets) (offsets models.RSSIOffsets, err error) {
+	globalRSSIOffsets.Lock()
+	defer globalRSSIOffsets.Unlock()
+	d, err := database.Open(family, true)
+	if err != nil {
+		return
+	}
+	defer d.Close()
+	offsets = getRSSIOffsets(d)
+	for device, offset := range changes.Devices {
+		setOffset(offsets.Devices, device, offset)
+	}
+	for scanner, offset := range changes.Scanners {
+		setOffset(offsets.Scanners, scanner, offset)
+	}
+	err = d.Set("RSSIOffsets", offsets)
+	if err != nil {
+		return
+	}
+	globalRSSIOffsets.Offsets[family] = offsets
+	return
+}
+
+func setOffset(offsets map[string]float64, name string, offset float64) {
+	name = strings.TrimSpace(name)
+	if offset == 0 {
+		delete(offsets, name)
+	} else {
+		offsets[name] = offset
+	}
+}
+
+// NormalizeSensorData applies the RSSI offsets of its family to sensor data, and makes its
+// pressures relative to the reference barometer (see barometer.go). Data of a family without a
+// database yet is left as it is.
+func NormalizeSensorData(s *models.SensorData) (err error) {
+	if database.Exists(s.Family) != nil {
+		return
+	}
+	offsets, err := GetRSSIOffsets(s.Family)
+	if err != nil {
+		return
+	}
+	offsets.Apply(s)
+	return applyBarometerReference(s)
+}
+
+// EstimateDeviceOffset estimates the offset of a device from the learning data of it and
+// a reference device at the same locations, saves it and returns it with the number of signals compared
+func EstimateDeviceOffset(family, device, reference string) (offset float64, samples int, err error) {
+	if device == reference {
+		err = errors.New("device and reference must differ")
+		return
+	}
+	d, err := database.Open(family, true)
+	if err != nil {
+		return
+	}
+	datas, err := d.GetAllForClassification()
+	d.Close()
+	if err != nil {
+		return
+	}
+
+	// mean signal per location and access point of each device
+	type signal struct {
+		sum float64
+		n   int
+	}
+	means := map[string]map[string]*signal{device: {}, reference: {}}
+	for _, s := range datas {
+		if s.Device != device && s.Device != reference {
+			continue
+		}
+		for sensorType := range s.Sensors {
+			if models.ContinuousSensors[sensorType] {
+				continue
+			}
+			for mac, value := range s.Sensors[sensorType] {
+				v, ok := value.(float64)
+				if !ok {
+					continue
+				}
+				key := s.Location + "\x00" + sensorType + "\x00" + mac
+				if _, ok := means[s.Device][key]; !ok {
+					means[s.Device][key] = &signal{}
+				}
+				means[s.Device][key].sum += v
+				means[s.Device][key].n++
+			}
+		}
+	}
+	diffs := []float64{}
+	for key, ref := range means[reference] {
+		dev, ok := means[device][key]
+		if !ok {
+			continue
+		}
+		diffs = append(diffs, ref.sum/float64(ref.n)-dev.sum/float64(dev.n))
+	}
+	if len(diffs) == 0 {
+		err = fmt.Errorf("no learning data of '%s' and '%s' at the same locations", device, reference)
+		return
+	}
+	samples = len(diffs)
+
+	offsets, err := GetRSSIOffsets(family)
+	if err != nil {
+		return
+	}
+	offset = offsets.Devices[device] + median(diffs)
+	_, err = SetRSSIOffsets(family, models.RSSIOffsets{Devices: map[string]float64{device: offset}})
+	return
+}
+
+// EstimateScannerOffset estimates the offset of a scanner from the passive fingerprints it and a
+// reference scanner reported together at a location, saves it and returns it with the number of signals compared
+func EstimateScannerOffset(family, scanner, reference, location string) (offset float64, samples int, err error) {
+	if scanner == reference {
+		err = errors.New("scanner and reference must differ")
+		return
+	}
+	location = strings.TrimSpace(strings.ToLower(location))
+	d, err := database.Open(family, true)
+	if err != nil {
+		return
+	}
+	datas, err := d.GetAllForClassification()
+	d.Close()
+	if err != nil {
+		return
+	}
+
+	diffs := []float64{}
+	for _, s := range datas {
+		if location != "" && s.Location != location {
+			continue
+		}
+		for sensorType := range s.Sensors {
+			ref, okRef := s.Sensors[sensorType][reference+"-"+sensorType].(float64)
+			v, ok := s.Sensors[sensorType][scanner+"-"+sensorType].(float64)
+			if okRef && ok {
+				diffs = append(diffs, ref-v)
+			}
+		}
+	}
+	if len(diffs) == 0 {
+		err = fmt.Errorf("no passive learning data of '%s' and '%s' together", scanner, reference)
+		return
+	}
+	samples = len(diffs)
+
+	offsets, err := GetRSSIOffsets(family)
+	if err != nil {
+		return
+	}
+	offset = offsets.Scanners[scanner] + median(diffs)
+	_, err = SetRSSIOffsets(family, models.RSSIOffsets{Scanners: map[string]float64{scanner: offset}})
+	return
+}
@@ -0,0 +1,59 @@
+package api
+
+import (
+	"testing"
+
+	"github.com/Nimaapr/find3/server/main/src/database"
+	"github.com/Nimaapr/find3/server/main/src/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRSSIOffsets(t *testing.T) {
+	defer useTestDatabase(t, "testoffsets")()
+
+	// a phone that reads 6 dBm lower than the reference, and two scanners side by side
+	learning := []models.SensorData{
+		{Timestamp: 1, Family: "testoffsets", Device: "reference", Location: "kitchen", Sensors: map[string]map[string]interface{}{"wifi": {"aa": -50.0, "bb": -70.0}}},
+		{Timestamp: 2, Family: "testoffsets", Device: "phone", Location: "kitchen", Sensors: map[string]map[string]interface{}{"wifi": {"aa": -56.0, "bb": -76.0}}},
+		{Timestamp: 3, Family: "testoffsets", Device: "reference", Location: "office", Sensors: map[string]map[string]interface{}{"wifi": {"aa": -80.0}}},
+		{Timestamp: 4, Family: "testoffsets", Device: "phone", Location: "office", Sensors: map[string]map[string]interface{}{"wifi": {"aa": -85.0}}},
+		{Timestamp: 5, Family: "testoffsets", Device: "tag", Location: "desk", Sensors: map[string]map[string]interface{}{"bluetooth": {"pi1-bluetooth": -60.0, "pi2-bluetooth": -63.0}}},
+	}
+	d, err := database.Open("testoffsets")
+	assert.Nil(t, err)
+	assert.Nil(t, d.AddSensors(learning))
+	d.Close()
+
+	offset, samples, err := EstimateDeviceOffset("testoffsets", "phone", "reference")
+	assert.Nil(t, err)
+	assert.Equal(t, 3, samples)
+	assert.Equal(t, 6.0, offset)
+	_, _, err = EstimateDeviceOffset("testoffsets", "phone", "nobody")
+	assert.NotNil(t, err)
+
+	offset, samples, err = EstimateScannerOffset("testoffsets", "pi2", "pi1", "Desk")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, samples)
+	assert.Equal(t, 3.0, offset)
+
+	offsets, err := GetRSSIOffsets("testoffsets")
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]float64{"phone": 6}, offsets.Devices)
+	assert.Equal(t, map[string]float64{"pi2": 3}, offsets.Scanners)
+
+	// the offsets are applied to new data
+	s := models.SensorData{Family: "testoffsets", Device: "phone", Sensors: map[string]map[string]interface{}{"wifi": {"aa": -56.0, "cc": -61}}}
+	assert.Nil(t, NormalizeSensorData(&s))
+	assert.Equal(t, -50.0, s.Sensors["wifi"]["aa"])
+	assert.Equal(t, -55.0, s.Sensors["wifi"]["cc"])
+	s = models.SensorData{Family: "testoffsets", Device: "tag", Sensors: map[string]map[string]interface{}{"bluetooth": {"pi1-bluetooth": -60.0, "pi2-bluetooth": -63.0}}}
+	assert.Nil(t, NormalizeSensorData(&s))
+	assert.Equal(t, -60.0, s.Sensors["bluetooth"]["pi1-bluetooth"])
+	assert.Equal(t, -60.0, s.Sensors["bluetooth"]["pi2-bluetooth"])
+
+	// an offset of 0 removes it
+	offsets, err = SetRSSIOffsets("testoffsets", models.RSSIOffsets{Devices: map[string]float64{"phone": 0}})
+	assert.Nil(t, err)
+	assert.Empty(t, offsets.Devices)
+	assert.Equal(t, 3.0, offsets.Scanners["pi2"])
+}
@@ -0,0 +1,191 @@
+package api
+
+/*
+This code has the drawing used for the location analysis images (see location_images.go), with only the image
+packages of the standard library: lines, rectangles, translucent fills and text in a small 5x7 bitmap font.
+
+The font has the digits, the letters (lowercase letters are drawn as capitals) and the punctuation that is common in
+location names and MAC addresses. Other characters are drawn as a box.
+*/
+
+import (
+	"crypto/sha256"
+	"image"
+	"image/color"
+	"math"
+	"strings"
+)
+
+// glyphs are 5 pixels wide and 7 high, each row is 5 bits with the leftmost pixel in the highest bit
+var glyphs = map[rune][7]uint8{
+	'0':  {0x0e, 0x11, 0x13, 0x15, 0x19, 0x11, 0x0e},
+	'1':  {0x04, 0x0c, 0x04, 0x04, 0x04, 0x04, 0x0e},
+	'2':  {0x0e, 0x11, 0x01, 0x02, 0x04, 0x08, 0x1f},
+	'3':  {0x1f, 0x02, 0x04, 0x02, 0x01, 0x11, 0x0e},
+	'4':  {0x02, 0x06, 0x0a, 0x12, 0x1f, 0x02, 0x02},
+	'5':  {0x1f, 0x10, 0x1e, 0x01, 0x01, 0x11, 0x0e},
+	'6':  {0x06, 0x08, 0x10, 0x1e, 0x11, 0x11, 0x0e},
+	'7':  {0x1f, 0x01, 0x02, 0x04, 0x08, 0x08, 0x08},
+	'8':  {0x0e, 0x11, 0x11, 0x0e, 0x11, 0x11, 0x0e},
+	'9':  {0x0e, 0x11, 0x11, 0x0f, 0x01, 0x02, 0x0c},
+	'A':  {0x0e, 0x11, 0x11, 0x1f, 0x11, 0x11, 0x11},
+	'B':  {0x1e, 0x11, 0x11, 0x1e, 0x11, 0x11, 0x1e},
+	'C':  {0x0e, 0x11, 0x10, 0x10, 0x10, 0x11, 0x0e},
+	'D':  {0x1c, 0x12, 0x11, 0x11, 0x11, 0x12, 0x1c},
+	'E':  {0x1f, 0x10, 0x10, 0x1e, 0x10, 0x10, 0x1f},
+	'F':  {0x1f, 0x10, 0x10, 0x1e, 0x10, 0x10, 0x10},
+	'G':  {0x0e, 0x11, 0x10, 0x17, 0x11, 0x11, 0x0f},
+	'H':  {0x11, 0x11, 0x11, 0x1f, 0x11, 0x11, 0x11},
+	'I':  {0x0e, 0x04, 0x04, 0x04, 0x04, 0x04, 0x0e},
+	'J':  {0x07, 0x02, 0x02, 0x02, 0x02, 0x12, 0x0c},
+	'K':  {0x11, 0x12, 0x14, 0x18, 0x14, 0x12, 0x11},
+	'L':  {0x10, 0x10, 0x10, 0x10, 0x10, 0x10, 0x1f},
+	'M':  {0x11, 0x1b, 0x15, 0x15, 0x11, 0x11, 0x11},
+	'N':  {0x11, 0x11, 0x19, 0x15, 0x13, 0x11, 0x11},
+	'O':  {0x0e, 0x11, 0x11, 0x11, 0x11, 0x11, 0x0e},
+	'P':  {0x1e, 0x11, 0x11, 0x1e, 0x10, 0x10, 0x10},
+	'Q':  {0x0e, 0x11, 0x11, 0x11, 0x15, 0x12, 0x0d},
+	'R':  {0x1e, 0x11, 0x11, 0x1e, 0x14, 0x12, 0x11},
+	'S':  {0x0f, 0x10, 0x10, 0x0e, 0x01, 0x01, 0x1e},
+	'T':  {0x1f, 0x04, 0x04, 0x04, 0x04, 0x04, 0x04},
+	'U':  {0x11, 0x11, 0x11, 0x11, 0x11, 0x11, 0x0e},
+	'V':  {0x11, 0x11, 0x11, 0x11, 0x11, 0x0a, 0x04},
+	'W':  {0x11, 0x11, 0x11, 0x15, 0x15, 0x15, 0x0a},
+	'X':  {0x11, 0x11, 0x0a, 0x04, 0x0a, 0x11, 0x11},
+	'Y':  {0x11, 0x11, 0x11, 0x0a, 0x04, 0x04, 0x04},
+	'Z':  {0x1f, 0x01, 0x02, 0x04, 0x08, 0x10, 0x1f},
+	' ':  {0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00},
+	'-':  {0x00, 0x00, 0x00, 0x1f, 0x00, 0x00, 0x00},
+	'_':  {0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x1f},
+	'.':  {0x00, 0x00, 0x00, 0x00, 0x00, 0x0c, 0x0c},
+	',':  {0x00, 0x00, 0x00, 0x00, 0x0c, 0x04, 0x08},
+	':':  {0x00, 0x0c, 0x0c, 0x00, 0x0c, 0x0c, 0x00},
+	'/':  {0x01, 0x01, 0x02, 0x04, 0x08, 0x10, 0x10},
+	'(':  {0x02, 0x04, 0x08, 0x08, 0x08, 0x04, 0x02},
+	')':  {0x08, 0x04, 0x02, 0x02, 0x02, 0x04, 0x08},
+	'+':  {0x00, 0x04, 0x04, 0x1f, 0x04, 0x04, 0x00},
+	'%':  {0x18, 0x19, 0x02, 0x04, 0x08, 0x13, 0x03},
+	'#':  {0x0a, 0x0a, 0x1f, 0x0a, 0x1f, 0x0a, 0x0a},
+	'\'': {0x04, 0x04, 0x08, 0x00, 0x00, 0x00, 0x00},
+}
+
+var unknownGlyph = [7]uint8{0x1f, 0x11, 0x11, 0x11, 0x11, 0x11, 0x1f}
+
+// textWidth is the width of a text in pixels
+func textWidth(text string, scale int) int {
+	n := len([]rune(text))
+	if n == 0 {
+		return 0
+	}
+	return (6*n - 1) * scale
+}
+
+// drawText draws a text with its top left corner at x, y. When vertical, the
+// text reads upwards with its bottom left corner at x, y.
+func drawText(img *image.RGBA, x, y int, text string, c color.Color, scale int, vertical bool) {
+	for i, r := range []rune(strings.ToUpper(text)) {
+		glyph, ok := glyphs[r]
+		if !ok {
+			glyph = unknownGlyph
+		}
+		for row := 0; row < 7; row++ {
+			for col := 0; col < 5; col++ {
+				if glyph[row]&(1<<uint(4-col)) == 0 {
+					continue
+				}
+				px, py := x+(6*i+col)*scale, y+row*scale
+				if vertical {
+					px, py = x+row*scale, y-(6*i+col)*scale-scale
+				}
+				fillRect(img, image.Rect(px, py, px+scale, py+scale), c)
+			}
+		}
+	}
+}
+
+// fillRect fills a rectangle, blending the color if it is translucent
+func fillRect(img *image.RGBA, r image.Rectangle, c color.Color) {
+	r = r.Intersect(img.Bounds())
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			blend(img, x, y, c)
+		}
+	}
+}
+
+func blend(img *image.RGBA, x, y int, c color.Color) {
+	sr, sg, sb, sa := c.RGBA()
+	if sa == 0xffff {
+		img.Set(x, y, c)
+		return
+	}
+	dr, dg, db, _ := img.At(x, y).RGBA()
+	// the color is premultiplied, so the background is scaled by what the color leaves
+	rest := 0xffff - sa
+	img.SetRGBA(x, y, color.RGBA{
+		R: uint8((sr + dr*rest/0xffff) >> 8),
+		G: uint8((sg + dg*rest/0xffff) >> 8),
+		B: uint8((sb + db*rest/0xffff) >> 8),
+		A: 0xff,
+	})
+}
+
+// drawLine draws a line between two points
+func drawLine(img *image.RGBA, x0, y0, x1, y1 int, c color.Color) {
+	steps := int(math.Max(math.Abs(float64(x1-x0)), math.Abs(float64(y1-y0))))
+	if steps == 0 {
+		img.Set(x0, y0, c)
+		return
+	}
+	for i := 0; i <= steps; i++ {
+		x := x0 + int(math.Round(float64(i*(x1-x0))/float64(steps)))
+		y := y0 + int(math.Round(float64(i*(y1-y0))/float64(steps)))
+		img.Set(x, y, c)
+	}
+}
+
+// colorOf gives every name its own color, the same every time
+func colorOf(name string, alpha float64) color.RGBA {
+	sum := sha256.Sum256([]byte(name))
+	hue := float64(uint16(sum[0])<<8|uint16(sum[1])) / 65536 * 360
+	r, g, b := hsv(hue, 0.65, 0.85)
+	return premultiplied(r, g, b, alpha)
+}
+
+// heatColor maps a value from 0 to 1 to a color from blue to red
+func heatColor(v float64) color.RGBA {
+	v = math.Max(0, math.Min(1, v))
+	r, g, b := hsv(240*(1-v), 0.75, 0.9)
+	return premultiplied(r, g, b, 1)
+}
+
+func premultiplied(r, g, b, alpha float64) color.RGBA {
+	return color.RGBA{
+		R: uint8(255 * r * alpha),
+		G: uint8(255 * g * alpha),
+		B: uint8(255 * b * alpha),
+		A: uint8(255 * alpha),
+	}
+}
+
+// hsv converts a hue in degrees, a saturation and a value to red, green and blue from 0 to 1
+func hsv(h, s, v float64) (r, g, b float64) {
+	c := v * s
+	x := c * (1 - math.Abs(math.Mod(h/60, 2)-1))
+	m := v - c
+	switch {
+	case h < 60:
+		r, g, b = c, x, 0
+	case h < 120:
+		r, g, b = x, c, 0
+	case h < 180:
+		r, g, b = 0, c, x
+	case h < 240:
+		r, g, b = 0, x, c
+	case h < 300:
+		r, g, b = x, 0, c
+	default:
+		r, g, b = c, 0, x
+	}
+	return r + m, g + m, b + m
+}
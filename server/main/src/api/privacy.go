@@ -0,0 +1,149 @@
+package api
+
+/*
+This code keeps the privacy mode of the families (see models.Privacy), stored in the keystore under "Privacy" and
+kept in memory after it is first read.
+
+CurrentPrivacy is used when passive data comes in, to store the devices under their hashes. It replaces the salt
+when it is due for rotation, so there is no need for a timer. The salt is made with crypto/rand and is never returned
+by GetPrivacy.
+*/
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Nimaapr/find3/server/main/src/database"
+	"github.com/Nimaapr/find3/server/main/src/models"
+	"github.com/Nimaapr/find3/server/main/src/utils"
+)
+
+// PrivacySaltLength is the number of characters of the salts
+var PrivacySaltLength = 32
+
+type PrivacyMap struct {
+	// Privacy maps family -> privacy mode
+	Privacy map[string]models.Privacy
+	sync.Mutex
+}
+
+var globalPrivacy PrivacyMap
+
+func init() {
+	globalPrivacy.Lock()
+	defer globalPrivacy.Unlock()
+	globalPrivacy.Privacy = make(map[string]models.Privacy)
+}
+
+// GetPrivacy returns the privacy mode of a family, without its salt
+func GetPrivacy(family string) (privacy models.Privacy, err error) {
+	globalPrivacy.Lock()
+	defer globalPrivacy.Unlock()
+	privacy, err = loadPrivacy(family)
+	privacy.Salt = ""
+	return
+}
+
+// SetPrivacy turns the privacy mode of a family on or off and sets its rotation and allowlist.
+// A new salt is made when the privacy mode is turned on.
+func SetPrivacy(family string, enabled bool, rotateEvery int64, allowlist []string) (privacy models.Privacy, err error) {
+	globalPrivacy.Lock()
+	defer globalPrivacy.Unlock()
+	privacy, err = loadPrivacy(family)
+	if err != nil {
+		return
+	}
+	if enabled && (!privacy.Enabled || privacy.Salt == "") {
+		privacy.Salt = utils.SecureRandomString(PrivacySaltLength)
+		privacy.Rotated = time.Now().UTC()
+	}
+	privacy.Enabled = enabled
+	if rotateEvery < 0 {
+		rotateEvery = 0
+	}
+	privacy.RotateEvery = rotateEvery
+	privacy.Allowlist = []string{}
+	for _, device := range allowlist {
+		if device = strings.ToLower(strings.TrimSpace(device)); device != "" {
+			privacy.Allowlist = append(privacy.Allowlist, device)
+		}
+	}
+	err = savePrivacy(family, privacy)
+	privacy.Salt = ""
+	return
+}
+
+// RotatePrivacySalt replaces the salt of a family right away
+func RotatePrivacySalt(family string) (privacy models.Privacy, err error) {
+	globalPrivacy.Lock()
+	defer globalPrivacy.Unlock()
+	privacy, err = loadPrivacy(family)
+	if err != nil {
+		return
+	}
+	privacy.Salt = utils.SecureRandomString(PrivacySaltLength)
+	privacy.Rotated = time.Now().UTC()
+	err = savePrivacy(family, privacy)
+	privacy.Salt = ""
+	return
+}
+
+// CurrentPrivacy returns the privacy mode of a family with its salt, rotating the salt if it is due
+func CurrentPrivacy(family string, now time.Time) (privacy models.Privacy, err error) {
+	globalPrivacy.Lock()
+	defer globalPrivacy.Unlock()
+	privacy, err = loadPrivacy(family)
+	if err != nil || !privacy.Enabled || privacy.RotateEvery == 0 {
+		return
+	}
+	if now.Sub(privacy.Rotated) >= time.Duration(privacy.RotateEvery)*time.Second {
+		logger.Log.Debugf("[%s] rotating privacy salt", family)
+		privacy.Salt = utils.SecureRandomString(PrivacySaltLength)
+		privacy.Rotated = now
+		err = savePrivacy(family, privacy)
+	}
+	return
+}
+
+// loadPrivacy returns the privacy mode of a family, it must be called with the lock held
+func loadPrivacy(family string) (privacy models.Privacy, err error) {
+	privacy, ok := globalPrivacy.Privacy[family]
+	if ok {
+		return
+	}
+	privacy.Allowlist = []string{}
+	if database.Exists(family) != nil {
+		// a family without data has no privacy mode yet
+		return
+	}
+	d, err := database.Open(family, true)
+	if err != nil {
+		return
+	}
+	defer d.Close()
+	errGet := d.Get("Privacy", &privacy)
+	if errGet != nil {
+		privacy = models.Privacy{}
+	}
+	if privacy.Allowlist == nil {
+		privacy.Allowlist = []string{}
+	}
+	globalPrivacy.Privacy[family] = privacy
+	return
+}
+
+// savePrivacy stores the privacy mode of a family, it must be called with the lock held
+func savePrivacy(family string, privacy models.Privacy) (err error) {
+	d, err := database.Open(family, true)
+	if err != nil {
+		return
+	}
+	defer d.Close()
+	err = d.Set("Privacy", privacy)
+	if err != nil {
+		return
+	}
+	globalPrivacy.Privacy[family] = privacy
+	return
+}
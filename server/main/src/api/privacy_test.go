@@ -0,0 +1,37 @@
+package api
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPrivacy(t *testing.T) {
+	defer useTestDatabase(t, "testprivacy")()
+
+	privacy, err := SetPrivacy("testprivacy", true, 3600, []string{" Badge1 ", ""})
+	assert.Nil(t, err)
+	assert.Equal(t, "", privacy.Salt)
+	assert.Equal(t, []string{"badge1"}, privacy.Allowlist)
+
+	current, err := CurrentPrivacy("testprivacy", time.Now().UTC())
+	assert.Nil(t, err)
+	assert.NotEqual(t, "", current.Salt)
+	id := current.DeviceID("wifi-cc:cc:cc:cc:cc:cc")
+	assert.Equal(t, id, current.DeviceID("wifi-CC:CC:CC:CC:CC:CC"))
+	assert.Equal(t, "wifi-badge1", current.DeviceID("wifi-badge1"))
+
+	// the salt is rotated once it is due
+	rotated, err := CurrentPrivacy("testprivacy", time.Now().UTC().Add(2*time.Hour))
+	assert.Nil(t, err)
+	assert.NotEqual(t, current.Salt, rotated.Salt)
+	assert.NotEqual(t, id, rotated.DeviceID("wifi-cc:cc:cc:cc:cc:cc"))
+
+	// turning it off keeps the names
+	_, err = SetPrivacy("testprivacy", false, 0, nil)
+	assert.Nil(t, err)
+	current, err = CurrentPrivacy("testprivacy", time.Now().UTC())
+	assert.Nil(t, err)
+	assert.Equal(t, "wifi-cc:cc:cc:cc:cc:cc", current.DeviceID("wifi-cc:cc:cc:cc:cc:cc"))
+}
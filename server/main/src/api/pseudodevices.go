@@ -0,0 +1,173 @@
+package api
+
+/*
+This code links the randomized MAC addresses of passive data into pseudo-devices. A phone that randomizes its MAC
+address shows up as a new device every time the address changes, which inflates the number of devices at a location.
+
+The linker is a heuristic. A phone uses one address at a time, so the addresses of the same phone follow each other:
+the next address appears shortly after the previous one stopped (the sequence gap, at most PseudoDeviceMaxGap), and it
+is heard by the same scanners with about the same signals, since the phone did not move much in between (the mean
+difference of the signals at the scanners both heard is at most PseudoDeviceMaxRSSI dBm). The addresses are taken in
+the order they appeared, and each is linked to the best pseudo-device it can continue, or starts a new one. Only the
+pseudo-devices seen within the sequence gap are compared, so that a long range of data is linked in about linear time.
+
+The ID of a pseudo-device is made from its first address, so it is the same for every query that starts from the same
+data. Addresses that are not randomized (see utils.IsMacRandomized) are never linked.
+*/
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/Nimaapr/find3/server/main/src/models"
+	"github.com/Nimaapr/find3/server/main/src/utils"
+)
+
+var (
+	// PseudoDeviceMaxGap is the longest time between the last data of an address and the first data of the next
+	PseudoDeviceMaxGap = 3 * time.Minute
+	// PseudoDeviceMaxRSSI is the largest mean difference of the signals, in dBm, between the last data of an
+	// address and the first data of the next
+	PseudoDeviceMaxRSSI = 6.0
+	// PseudoDeviceMinOverlap is the smallest fraction of the scanners that must have heard both addresses
+	PseudoDeviceMinOverlap = 0.5
+)
+
+type macTrack struct {
+	mac         string
+	first, last int64
+	firstRSSI   map[string]float64
+	lastRSSI    map[string]float64
+}
+
+// LinkRandomized links the randomized addresses of the sensor data into pseudo-devices and
+// returns the pseudo-device of each randomized address
+func LinkRandomized(sensors []models.SensorData) (links map[string]string) {
+	tracks := make(map[string]*macTrack)
+	for _, s := range sensors {
+		if !utils.IsMacRandomized(s.Device) {
+			continue
+		}
+		rssi := signalsOf(s)
+		t, ok := tracks[s.Device]
+		if !ok {
+			t = &macTrack{mac: s.Device, first: s.Timestamp, last: s.Timestamp, firstRSSI: rssi, lastRSSI: rssi}
+			tracks[s.Device] = t
+			continue
+		}
+		if s.Timestamp < t.first {
+			t.first, t.firstRSSI = s.Timestamp, rssi
+		}
+		if s.Timestamp > t.last {
+			t.last, t.lastRSSI = s.Timestamp, rssi
+		}
+	}
+	ordered := make([]*macTrack, 0, len(tracks))
+	for _, t := range tracks {
+		ordered = append(ordered, t)
+	}
+	sort.Slice(ordered, func(i, j int) bool {
+		if ordered[i].first == ordered[j].first {
+			return ordered[i].mac < ordered[j].mac
+		}
+		return ordered[i].first < ordered[j].first
+	})
+
+	maxGap := int64(PseudoDeviceMaxGap / time.Millisecond)
+	type chain struct {
+		id string
+		// n is the order the chain was started in, the older one is taken on a tie
+		n    int
+		last *macTrack
+	}
+	// the chains that can still be continued, ordered by the last time they were seen
+	live := []*chain{}
+	numChains := 0
+	links = make(map[string]string)
+	for _, t := range ordered {
+		// the addresses come in the order they appeared, so a chain that stopped more than the
+		// gap before this one cannot be continued by any of the next ones either
+		dead := 0
+		for dead < len(live) && live[dead].last.last+maxGap < t.first {
+			dead++
+		}
+		live = live[dead:]
+
+		bestIndex := -1
+		bestScore := 0.0
+		for i, c := range live {
+			gap := t.first - c.last.last
+			if gap <= 0 {
+				continue
+			}
+			distance, ok := rssiDistance(c.last.lastRSSI, t.firstRSSI)
+			if !ok || distance > PseudoDeviceMaxRSSI {
+				continue
+			}
+			// a minute of gap weighs as much as a dBm of difference
+			score := distance + float64(gap)/60000
+			if bestIndex < 0 || score < bestScore || (score == bestScore && c.n < live[bestIndex].n) {
+				bestIndex, bestScore = i, score
+			}
+		}
+		var best *chain
+		if bestIndex < 0 {
+			best = &chain{id: pseudoDeviceID(t.mac), n: numChains}
+			numChains++
+		} else {
+			best = live[bestIndex]
+			live = append(live[:bestIndex], live[bestIndex+1:]...)
+		}
+		best.last = t
+		links[t.mac] = best.id
+
+		// put it back in the order of the last time seen
+		i := sort.Search(len(live), func(i int) bool { return live[i].last.last > t.last })
+		live = append(live, nil)
+		copy(live[i+1:], live[i:])
+		live[i] = best
+	}
+	return
+}
+
+// signalsOf returns the signals of sensor data by sensor and scanner
+func signalsOf(s models.SensorData) (rssi map[string]float64) {
+	rssi = make(map[string]float64)
+	for sensorType := range s.Sensors {
+		for scanner, value := range s.Sensors[sensorType] {
+			switch v := value.(type) {
+			case float64:
+				rssi[sensorType+"/"+scanner] = v
+			case int:
+				rssi[sensorType+"/"+scanner] = float64(v)
+			}
+		}
+	}
+	return
+}
+
+// rssiDistance returns the mean difference of the signals at the scanners both heard, if
+// enough of the scanners of each heard both
+func rssiDistance(a, b map[string]float64) (distance float64, ok bool) {
+	common := 0
+	for scanner, va := range a {
+		if vb, found := b[scanner]; found {
+			distance += abs(va - vb)
+			common++
+		}
+	}
+	smallest := len(a)
+	if len(b) < smallest {
+		smallest = len(b)
+	}
+	if common == 0 || float64(common) < PseudoDeviceMinOverlap*float64(smallest) {
+		return
+	}
+	return distance / float64(common), true
+}
+
+func pseudoDeviceID(mac string) string {
+	return fmt.Sprintf("pseudo-%x", sha1.Sum([]byte(mac)))[:17]
+}
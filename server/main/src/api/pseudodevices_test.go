@@ -0,0 +1,63 @@
+package api
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/Nimaapr/find3/server/main/src/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func passiveAt(device string, timestamp int64, pi1, pi2 float64) models.SensorData {
+	return models.SensorData{
+		Timestamp: timestamp,
+		Device:    device,
+		Sensors:   map[string]map[string]interface{}{"wifi": {"pi1-wifi": pi1, "pi2-wifi": pi2}},
+	}
+}
+
+func TestLinkRandomized(t *testing.T) {
+	links := LinkRandomized([]models.SensorData{
+		passiveAt("00:11:22:33:44:55", 0, -50, -60),
+		// a phone near pi1 changing its address twice
+		passiveAt("02:00:00:00:00:01", 0, -50, -70),
+		passiveAt("02:00:00:00:00:01", 90000, -52, -70),
+		passiveAt("06:00:00:00:00:02", 180000, -53, -69),
+		passiveAt("0a:00:00:00:00:03", 270000, -51, -71),
+		// a phone near pi2 whose address changes at the same time
+		passiveAt("12:00:00:00:00:04", 90000, -75, -45),
+		passiveAt("16:00:00:00:00:05", 180000, -74, -46),
+		// an address that shows up too late to continue anything
+		passiveAt("1a:00:00:00:00:06", 900000, -51, -71),
+	})
+	assert.Equal(t, 6, len(links))
+	_, ok := links["00:11:22:33:44:55"]
+	assert.False(t, ok)
+	assert.Equal(t, links["02:00:00:00:00:01"], links["06:00:00:00:00:02"])
+	assert.Equal(t, links["02:00:00:00:00:01"], links["0a:00:00:00:00:03"])
+	assert.Equal(t, links["12:00:00:00:00:04"], links["16:00:00:00:00:05"])
+	assert.NotEqual(t, links["02:00:00:00:00:01"], links["12:00:00:00:00:04"])
+	assert.NotEqual(t, links["02:00:00:00:00:01"], links["1a:00:00:00:00:06"])
+	assert.Equal(t, pseudoDeviceID("02:00:00:00:00:01"), links["02:00:00:00:00:01"])
+}
+
+func TestLinkRandomizedDay(t *testing.T) {
+	// two phones changing their addresses every two minutes for a day
+	sensors := []models.SensorData{}
+	for i := 0; i < 720; i++ {
+		timestamp := int64(i) * 120000
+		sensors = append(sensors,
+			passiveAt(fmt.Sprintf("02:00:00:00:%02x:%02x", i/256, i%256), timestamp, -50, -70),
+			passiveAt(fmt.Sprintf("06:00:00:00:%02x:%02x", i/256, i%256), timestamp, -75, -45))
+	}
+	links := LinkRandomized(sensors)
+	assert.Equal(t, 1440, len(links))
+	pseudoDevices := make(map[string]int)
+	for _, pseudoDevice := range links {
+		pseudoDevices[pseudoDevice]++
+	}
+	assert.Equal(t, map[string]int{
+		pseudoDeviceID("02:00:00:00:00:00"): 720,
+		pseudoDeviceID("06:00:00:00:00:00"): 720,
+	}, pseudoDevices)
+}
@@ -0,0 +1,329 @@
+package api
+
+/*
+This code renames and merges the locations and devices of a family, and keeps the description and tags of the
+locations (see models.LocationMetadata) in the location_metadata table.
+
+A location or device is renamed to a name that is not used yet, or merged into one that is: merging has to be asked
+for, so that a typo does not merge two locations by mistake. The tables are changed in one transaction (see
+database.RenameLocation and database.RenameDevice), and then the keystore entries and the memory that name them:
+
+- for a location, the GPS coordinates ("customGPS" and "autoGPS"), the zones, the coordinates on the floorplans and
+  the locations the devices are learning in passive scanning,
+- for a device, the passive learning settings, the RSSI offsets, the privacy allowlist and the devices the GPS
+  coordinates of the locations were taken from.
+
+When merging, the settings of the location or device merged into are kept where both have one. The models name the
+locations they learned, so the family has to be calibrated again after a rename.
+*/
+
+import (
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/Nimaapr/find3/server/main/src/database"
+	"github.com/Nimaapr/find3/server/main/src/models"
+)
+
+// GetLocationMetadata returns the description and tags of the locations of a family
+func GetLocationMetadata(family string) (metadata map[string]models.LocationMetadata, err error) {
+	d, err := database.Open(family, true)
+	if err != nil {
+		return
+	}
+	defer d.Close()
+	return d.GetLocationMetadata()
+}
+
+// SetLocationMetadata sets the description and tags of a location, empty ones remove them
+func SetLocationMetadata(family string, m models.LocationMetadata) (metadata models.LocationMetadata, err error) {
+	metadata = models.LocationMetadata{
+		Location:    strings.TrimSpace(strings.ToLower(m.Location)),
+		Description: strings.TrimSpace(m.Description),
+		Tags:        []string{},
+	}
+	seen := make(map[string]bool)
+	for _, tag := range m.Tags {
+		tag = strings.TrimSpace(strings.ToLower(tag))
+		if tag != "" && !seen[tag] {
+			metadata.Tags = append(metadata.Tags, tag)
+			seen[tag] = true
+		}
+	}
+	sort.Strings(metadata.Tags)
+
+	d, err := database.Open(family, true)
+	if err != nil {
+		return
+	}
+	defer d.Close()
+	if _, err = d.GetID("locations", metadata.Location); err != nil {
+		err = errors.New("no location '" + metadata.Location + "'")
+		return
+	}
+	err = d.SetLocationMetadata(metadata)
+	return
+}
+
+// cleanRename checks the names of a rename, and whether it is a merge as asked
+func cleanRename(d *database.Database, table, from, to string, merge bool) (string, string, error) {
+	from = strings.TrimSpace(strings.ToLower(from))
+	to = strings.TrimSpace(strings.ToLower(to))
+	if to == "" {
+		return from, to, errors.New("a new name is needed")
+	}
+	if from == to {
+		return from, to, errors.New("'" + from + "' already has that name")
+	}
+	kind := strings.TrimSuffix(table, "s")
+	_, errTo := d.GetID(table, to)
+	if errTo == nil && !merge {
+		return from, to, errors.New("there is a " + kind + " '" + to + "' already, merge into it instead")
+	}
+	if errTo != nil && merge {
+		return from, to, errors.New("no " + kind + " '" + to + "' to merge into")
+	}
+	return from, to, nil
+}
+
+// RenameLocation renames a location, or merges it into the location named to
+func RenameLocation(family, from, to string, merge bool) (rename models.Rename, err error) {
+	family = strings.TrimSpace(strings.ToLower(family))
+	d, err := database.Open(family, true)
+	if err != nil {
+		return
+	}
+	defer d.Close()
+	from, to, err = cleanRename(d, "locations", from, to, merge)
+	if err != nil {
+		return
+	}
+	rename = models.Rename{From: from, To: to}
+	rename.Counts, rename.Merged, err = d.RenameLocation(from, to)
+	if err != nil {
+		return
+	}
+
+	for _, key := range []string{"customGPS", "autoGPS"} {
+		var gpsData map[string]models.SensorData
+		if errGet := d.Get(key, &gpsData); errGet != nil {
+			continue
+		}
+		s, ok := gpsData[from]
+		if !ok {
+			continue
+		}
+		if _, kept := gpsData[to]; !kept {
+			s.Location = to
+			gpsData[to] = s
+		}
+		delete(gpsData, from)
+		if err = d.Set(key, gpsData); err != nil {
+			return
+		}
+		rename.Counts[key] = 1
+	}
+
+	var zones map[string]models.Zone
+	if errGet := d.Get("Zones", &zones); errGet == nil {
+		changed := int64(0)
+		for name, z := range zones {
+			if !z.Contains(from) {
+				continue
+			}
+			locations := []string{}
+			for _, location := range z.Locations {
+				if location == from {
+					location = to
+				}
+				if location != to || !containsString(locations, to) {
+					locations = append(locations, location)
+				}
+			}
+			z.Locations = locations
+			zones[name] = z
+			changed++
+		}
+		if changed > 0 {
+			if err = d.Set("Zones", zones); err != nil {
+				return
+			}
+			rename.Counts["Zones"] = changed
+		}
+	}
+
+	coordinates := getLocationCoordinates(d)
+	if c, ok := coordinates[from]; ok {
+		if _, kept := coordinates[to]; !kept {
+			c.Location = to
+			coordinates[to] = c
+		}
+		delete(coordinates, from)
+		if err = d.Set("LocationCoordinates", coordinates); err != nil {
+			return
+		}
+		rename.Counts["LocationCoordinates"] = 1
+	}
+
+	var rollingData models.ReverseRollingData
+	if errGet := d.Get("ReverseRollingData", &rollingData); errGet == nil {
+		changed := int64(0)
+		for device, location := range rollingData.DeviceLocation {
+			if location == from {
+				rollingData.DeviceLocation[device] = to
+				changed++
+			}
+		}
+		if changed > 0 {
+			if err = d.Set("ReverseRollingData", rollingData); err != nil {
+				return
+			}
+			rename.Counts["ReverseRollingData"] = changed
+		}
+	}
+
+	globalLastLocation.Lock()
+	for device, location := range globalLastLocation.Location[family] {
+		if location == from {
+			globalLastLocation.Location[family][device] = to
+		}
+	}
+	globalLastLocation.Unlock()
+
+	// the chart of the location is made again with the calibration
+	os.Remove(path.Join(imagesFolder(family), from+".png"))
+	return
+}
+
+// RenameDevice renames a device, or merges it into the device named to
+func RenameDevice(family, from, to string, merge bool) (rename models.Rename, err error) {
+	family = strings.TrimSpace(strings.ToLower(family))
+	d, err := database.Open(family, true)
+	if err != nil {
+		return
+	}
+	from, to, err = cleanRename(d, "devices", from, to, merge)
+	if err != nil {
+		d.Close()
+		return
+	}
+	rename = models.Rename{From: from, To: to}
+	rename.Counts, rename.Merged, err = d.RenameDevice(from, to)
+	if err != nil {
+		d.Close()
+		return
+	}
+
+	var rollingData models.ReverseRollingData
+	if errGet := d.Get("ReverseRollingData", &rollingData); errGet == nil {
+		location, learning := rollingData.DeviceLocation[from]
+		gps, hasGPS := rollingData.DeviceGPS[from]
+		if learning || hasGPS {
+			if _, kept := rollingData.DeviceLocation[to]; learning && !kept {
+				rollingData.DeviceLocation[to] = location
+			}
+			if _, kept := rollingData.DeviceGPS[to]; hasGPS && !kept {
+				rollingData.DeviceGPS[to] = gps
+			}
+			delete(rollingData.DeviceLocation, from)
+			delete(rollingData.DeviceGPS, from)
+			if err = d.Set("ReverseRollingData", rollingData); err != nil {
+				d.Close()
+				return
+			}
+			rename.Counts["ReverseRollingData"] = 1
+		}
+	}
+
+	for _, key := range []string{"customGPS", "autoGPS"} {
+		var gpsData map[string]models.SensorData
+		if errGet := d.Get(key, &gpsData); errGet != nil {
+			continue
+		}
+		changed := int64(0)
+		for location, s := range gpsData {
+			if s.Device == from {
+				s.Device = to
+				gpsData[location] = s
+				changed++
+			}
+		}
+		if changed > 0 {
+			if err = d.Set(key, gpsData); err != nil {
+				d.Close()
+				return
+			}
+			rename.Counts[key] = changed
+		}
+	}
+	d.Close()
+
+	// the settings that are kept in memory too
+	offsets, err := GetRSSIOffsets(family)
+	if err != nil {
+		return
+	}
+	if offset, ok := offsets.Devices[from]; ok {
+		changes := models.RSSIOffsets{Devices: map[string]float64{from: 0}}
+		if _, kept := offsets.Devices[to]; !kept {
+			changes.Devices[to] = offset
+		}
+		if _, err = SetRSSIOffsets(family, changes); err != nil {
+			return
+		}
+		rename.Counts["RSSIOffsets"] = 1
+	}
+
+	globalPrivacy.Lock()
+	privacy, err := loadPrivacy(family)
+	if err == nil && containsString(privacy.Allowlist, from) {
+		allowlist := []string{}
+		for _, allowed := range privacy.Allowlist {
+			if allowed == from {
+				allowed = to
+			}
+			if !containsString(allowlist, allowed) {
+				allowlist = append(allowlist, allowed)
+			}
+		}
+		privacy.Allowlist = allowlist
+		err = savePrivacy(family, privacy)
+		rename.Counts["Privacy"] = 1
+	}
+	globalPrivacy.Unlock()
+	if err != nil {
+		return
+	}
+
+	globalLastLocation.Lock()
+	if location, ok := globalLastLocation.Location[family][from]; ok {
+		if _, kept := globalLastLocation.Location[family][to]; !kept {
+			globalLastLocation.Location[family][to] = location
+		}
+		delete(globalLastLocation.Location[family], from)
+	}
+	globalLastLocation.Unlock()
+	globalZoneState.Lock()
+	if visits, ok := globalZoneState.Visits[family][from]; ok {
+		if _, kept := globalZoneState.Visits[family][to]; !kept {
+			globalZoneState.Visits[family][to] = visits
+		}
+		delete(globalZoneState.Visits[family], from)
+	}
+	globalZoneState.Unlock()
+	forgetTracker(family, from, to)
+	return
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
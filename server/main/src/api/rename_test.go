@@ -0,0 +1,136 @@
+package api
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/Nimaapr/find3/server/main/src/database"
+	"github.com/Nimaapr/find3/server/main/src/models"
+)
+
+func TestRenameLocation(t *testing.T) {
+	defer useTestDatabase(t, "testrename")()
+
+	d, err := database.Open("testrename")
+	assert.Nil(t, err)
+	datas := []models.SensorData{
+		{Timestamp: 1000, Family: "testrename", Device: "phone", Location: "kitchn", GPS: models.GPS{Latitude: 1, Longitude: 2},
+			Sensors: map[string]map[string]interface{}{"wifi": {"aa": -50}}},
+		{Timestamp: 2000, Family: "testrename", Device: "phone", Location: "kitchen",
+			Sensors: map[string]map[string]interface{}{"wifi": {"aa": -55}}},
+		{Timestamp: 3000, Family: "testrename", Device: "phone", Location: "office",
+			Sensors: map[string]map[string]interface{}{"wifi": {"aa": -70}}},
+	}
+	assert.Nil(t, d.AddSensors(datas))
+	assert.Nil(t, d.SetGPS(datas[0]))
+	assert.Nil(t, d.AddPrediction(3000, []models.LocationPrediction{{Location: "office", Probability: 0.45}, {Location: "kitchn", Probability: 0.35}, {Location: "kitchen", Probability: 0.2}}))
+	_, err = d.AddEvent(models.Event{Type: models.EventLocationChange, Device: "phone", Location: "office", PreviousLocation: "kitchn", Timestamp: 3000})
+	assert.Nil(t, err)
+	assert.Nil(t, d.Set("customGPS", map[string]models.SensorData{"kitchn": {Location: "kitchn", GPS: models.GPS{Latitude: 1}}}))
+	d.Close()
+	_, err = SetZone("testrename", models.Zone{Name: "downstairs", Locations: []string{"kitchn", "kitchen", "office"}})
+	assert.Nil(t, err)
+	_, err = SetLocationMetadata("testrename", models.LocationMetadata{Location: "kitchn", Description: " The kitchen ", Tags: []string{"Food", "food", " "}})
+	assert.Nil(t, err)
+	_, err = SetLocationMetadata("testrename", models.LocationMetadata{Location: "attic"})
+	assert.NotNil(t, err)
+
+	// merging has to be asked for
+	_, err = RenameLocation("testrename", "kitchn", "kitchen", false)
+	assert.NotNil(t, err)
+	_, err = RenameLocation("testrename", "kitchn", "cellar", true)
+	assert.NotNil(t, err)
+	_, err = RenameLocation("testrename", "kitchn", "kitchn", false)
+	assert.NotNil(t, err)
+
+	rename, err := RenameLocation("testrename", "Kitchn", "Kitchen", true)
+	assert.Nil(t, err)
+	assert.True(t, rename.Merged)
+	assert.Equal(t, "kitchen", rename.To)
+	assert.Equal(t, int64(1), rename.Counts["sensors"])
+	assert.Equal(t, int64(1), rename.Counts["location_predictions"])
+	assert.Equal(t, int64(1), rename.Counts["events"])
+
+	d, err = database.Open("testrename", true)
+	assert.Nil(t, err)
+	locations, err := d.GetLocations()
+	assert.Nil(t, err)
+	assert.ElementsMatch(t, []string{"kitchen", "office"}, locations)
+	guesses, err := d.GetPrediction(3000)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(guesses))
+	assert.Equal(t, "kitchen", guesses[0].Location)
+	assert.InDelta(t, 0.55, guesses[0].Probability, 0.0001)
+	events, err := d.GetEvents(database.EventFilter{Device: "phone"})
+	assert.Nil(t, err)
+	assert.Equal(t, "kitchen", events[0].PreviousLocation)
+	var customGPS map[string]models.SensorData
+	assert.Nil(t, d.Get("customGPS", &customGPS))
+	assert.Equal(t, "kitchen", customGPS["kitchen"].Location)
+	d.Close()
+
+	zones, err := GetZones("testrename")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"kitchen", "office"}, zones["downstairs"].Locations)
+	metadata, err := GetLocationMetadata("testrename")
+	assert.Nil(t, err)
+	assert.Equal(t, models.LocationMetadata{Location: "kitchen", Description: "The kitchen", Tags: []string{"food"}}, metadata["kitchen"])
+
+	// a plain rename
+	rename, err = RenameLocation("testrename", "office", "study", false)
+	assert.Nil(t, err)
+	assert.False(t, rename.Merged)
+	assert.Equal(t, int64(1), rename.Counts["locations"])
+}
+
+func TestRenameDevice(t *testing.T) {
+	defer useTestDatabase(t, "testrenamedevice")()
+
+	d, err := database.Open("testrenamedevice")
+	assert.Nil(t, err)
+	assert.Nil(t, d.AddSensors([]models.SensorData{
+		{Timestamp: 1000, Family: "testrenamedevice", Device: "phone", Location: "kitchen",
+			Sensors: map[string]map[string]interface{}{"wifi": {"aa": -50}}},
+		{Timestamp: 2000, Family: "testrenamedevice", Device: "phne", Location: "kitchen",
+			Sensors: map[string]map[string]interface{}{"wifi": {"aa": -55}}},
+	}))
+	_, err = d.AddEvent(models.Event{Type: models.EventLocationChange, Device: "phne", Location: "kitchen", Timestamp: 2000})
+	assert.Nil(t, err)
+	assert.Nil(t, d.Set("ReverseRollingData", models.ReverseRollingData{
+		Family:         "testrenamedevice",
+		DeviceLocation: map[string]string{"phne": "kitchen"},
+		DeviceGPS:      map[string]models.GPS{},
+	}))
+	d.Close()
+	_, err = SetRSSIOffsets("testrenamedevice", models.RSSIOffsets{Devices: map[string]float64{"phne": 3}})
+	assert.Nil(t, err)
+	_, err = SetPrivacy("testrenamedevice", true, 0, []string{"phne", "phone"})
+	assert.Nil(t, err)
+
+	rename, err := RenameDevice("testrenamedevice", "phne", "phone", true)
+	assert.Nil(t, err)
+	assert.True(t, rename.Merged)
+	assert.Equal(t, int64(1), rename.Counts["sensors"])
+	assert.Equal(t, int64(1), rename.Counts["events"])
+
+	d, err = database.Open("testrenamedevice", true)
+	assert.Nil(t, err)
+	devices, err := d.GetDevices()
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"phone"}, devices)
+	sensors, err := d.GetDeviceSensors("phone")
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(sensors))
+	var rollingData models.ReverseRollingData
+	assert.Nil(t, d.Get("ReverseRollingData", &rollingData))
+	assert.Equal(t, map[string]string{"phone": "kitchen"}, rollingData.DeviceLocation)
+	d.Close()
+
+	offsets, err := GetRSSIOffsets("testrenamedevice")
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]float64{"phone": 3}, offsets.Devices)
+	privacy, err := GetPrivacy("testrenamedevice")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"phone"}, privacy.Allowlist)
+}
@@ -0,0 +1,329 @@
+package api
+
+/*
+This code keeps the registry of the passive scanners of a family (see models.Scanner) and watches their health.
+
+The registry is stored in the keystore under "Scanners", as a map from the scanner name to a models.Scanner. A
+scanner is added the first time it sends passive data, and its placement can be set with SetScanner.
+
+RecordScan is called with every passive fingerprint. It only updates statistics in memory: the number of signals in
+each of the last minutes, and the last ScannerRSSISamples signals. CheckScanners is called every minute. It saves the
+statistics to the registry and returns the events for the scanners whose health changed:
+
+- scanner_silent when a scanner has not sent anything for ScannerSilentAfter, and scanner_online when it is back,
+- scanner_rssi_shift when the median signal of a scanner is ScannerRSSIShift dBm or more away from its baseline, the
+  median of its first ScannerRSSISamples signals (or of the signals after the baseline is reset). The event is sent
+  again only after the median has come back within half of the shift.
+
+The statistics of the scanners are lost when the server restarts. LoadScanners is called when the server starts: it
+reads the registries of all the families, so that a scanner that stays silent after a restart is still noticed, from
+the last time it was seen before the restart. The baseline and the median are kept in the registry, and the median is
+only taken again once there are ScannerRSSIMinSamples signals since the restart, so that a scanner is not flagged or
+cleared on a handful of them.
+*/
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/Nimaapr/find3/server/main/src/database"
+	"github.com/Nimaapr/find3/server/main/src/models"
+)
+
+var (
+	// ScannerSilentAfter is how long a scanner can go without sending data before it is silent
+	ScannerSilentAfter = 5 * time.Minute
+	// ScannerRateWindow is the number of minutes the rate of a scanner is averaged over
+	ScannerRateWindow = 5
+	// ScannerRSSISamples is the number of signals the median of a scanner is taken over
+	ScannerRSSISamples = 500
+	// ScannerRSSIMinSamples is the smallest number of signals the median of a scanner is taken over,
+	// the median from before is kept until then
+	ScannerRSSIMinSamples = 100
+	// ScannerRSSIShift is the change of the median signal, in dBm, that is an alert
+	ScannerRSSIShift = 8.0
+)
+
+type scannerStats struct {
+	// minutes are the number of signals in each minute, the last ScannerRateWindow minutes
+	minutes map[int64]int
+	rssi    []float64
+	next    int
+	// signals, first and last seen since the statistics were saved
+	signals   int64
+	firstSeen time.Time
+	lastSeen  time.Time
+}
+
+type ScannerStatsMap struct {
+	// Stats maps family -> scanner -> statistics
+	Stats map[string]map[string]*scannerStats
+	sync.Mutex
+}
+
+var globalScannerStats ScannerStatsMap
+
+func init() {
+	globalScannerStats.Lock()
+	defer globalScannerStats.Unlock()
+	globalScannerStats.Stats = make(map[string]map[string]*scannerStats)
+}
+
+// RecordScan counts the signals a scanner sent in a passive fingerprint
+func RecordScan(family, scanner string, rssi []float64, t time.Time) {
+	globalScannerStats.Lock()
+	defer globalScannerStats.Unlock()
+	if _, ok := globalScannerStats.Stats[family]; !ok {
+		globalScannerStats.Stats[family] = make(map[string]*scannerStats)
+	}
+	s, ok := globalScannerStats.Stats[family][scanner]
+	if !ok {
+		s = &scannerStats{minutes: make(map[int64]int)}
+		globalScannerStats.Stats[family][scanner] = s
+	}
+	if s.firstSeen.IsZero() {
+		s.firstSeen = t
+	}
+	s.lastSeen = t
+	s.signals += int64(len(rssi))
+	s.minutes[t.Unix()/60] += len(rssi)
+	for _, r := range rssi {
+		if len(s.rssi) < ScannerRSSISamples {
+			s.rssi = append(s.rssi, r)
+		} else {
+			s.rssi[s.next] = r
+			s.next = (s.next + 1) % ScannerRSSISamples
+		}
+	}
+}
+
+// GetScanners returns the registry of the scanners of a family, sorted by name
+func GetScanners(family string) (scanners []models.Scanner, err error) {
+	d, err := database.Open(family, true)
+	if err != nil {
+		return
+	}
+	defer d.Close()
+	registry := getScanners(d)
+	scanners = make([]models.Scanner, 0, len(registry))
+	for _, s := range registry {
+		scanners = append(scanners, s)
+	}
+	sort.Slice(scanners, func(i, j int) bool { return scanners[i].Name < scanners[j].Name })
+	return
+}
+
+func getScanners(d *database.Database) (scanners map[string]models.Scanner) {
+	errGet := d.Get("Scanners", &scanners)
+	if errGet != nil || scanners == nil {
+		scanners = make(map[string]models.Scanner)
+	}
+	return
+}
+
+// SetScanner registers a scanner or changes its placement, and can reset its baseline signal
+func SetScanner(family string, s models.Scanner, resetBaseline bool) (scanner models.Scanner, err error) {
+	s.Name = strings.TrimSpace(s.Name)
+	if s.Name == "" {
+		err = errors.New("scanner needs a name")
+		return
+	}
+	d, err := database.Open(family, true)
+	if err != nil {
+		return
+	}
+	defer d.Close()
+	scanners := getScanners(d)
+	scanner, ok := scanners[s.Name]
+	if !ok {
+		scanner = models.Scanner{Name: s.Name}
+	}
+	scanner.Location = strings.TrimSpace(strings.ToLower(s.Location))
+	if resetBaseline {
+		scanner.BaselineRSSI = 0
+		scanner.Shifted = false
+		globalScannerStats.Lock()
+		if stats, ok := globalScannerStats.Stats[family][s.Name]; ok {
+			stats.rssi = stats.rssi[:0]
+			stats.next = 0
+		}
+		globalScannerStats.Unlock()
+	}
+	scanners[s.Name] = scanner
+	err = d.Set("Scanners", scanners)
+	return
+}
+
+// DeleteScanner removes a scanner from the registry, it is added again if it sends data
+func DeleteScanner(family, name string) (err error) {
+	d, err := database.Open(family, true)
+	if err != nil {
+		return
+	}
+	defer d.Close()
+	scanners := getScanners(d)
+	if _, ok := scanners[name]; !ok {
+		err = errors.New("no scanner '" + name + "'")
+		return
+	}
+	delete(scanners, name)
+	err = d.Set("Scanners", scanners)
+	if err != nil {
+		return
+	}
+	globalScannerStats.Lock()
+	delete(globalScannerStats.Stats[family], name)
+	globalScannerStats.Unlock()
+	return
+}
+
+// LoadScanners starts watching the scanners in the registries of all the families, from the
+// last time they were seen, and returns the families that have scanners
+func LoadScanners() (families []string) {
+	for _, family := range database.GetFamilies() {
+		d, err := database.Open(family, true)
+		if err != nil {
+			logger.Log.Warnf("[%s] could not load scanners: %s", family, err.Error())
+			continue
+		}
+		scanners := getScanners(d)
+		d.Close()
+		if len(scanners) == 0 {
+			continue
+		}
+		globalScannerStats.Lock()
+		if _, ok := globalScannerStats.Stats[family]; !ok {
+			globalScannerStats.Stats[family] = make(map[string]*scannerStats)
+		}
+		for name, scanner := range scanners {
+			if _, ok := globalScannerStats.Stats[family][name]; ok {
+				continue
+			}
+			globalScannerStats.Stats[family][name] = &scannerStats{
+				minutes:   make(map[int64]int),
+				firstSeen: scanner.FirstSeen,
+				lastSeen:  scanner.LastSeen,
+			}
+		}
+		globalScannerStats.Unlock()
+		families = append(families, family)
+	}
+	return
+}
+
+// ScannerFamilies returns the families whose scanners are watched, the ones loaded when the
+// server started and the ones that sent passive data since
+func ScannerFamilies() (families []string) {
+	globalScannerStats.Lock()
+	defer globalScannerStats.Unlock()
+	for family := range globalScannerStats.Stats {
+		families = append(families, family)
+	}
+	sort.Strings(families)
+	return
+}
+
+// CheckScanners saves the statistics of the scanners of a family to the registry and
+// returns the events of the scanners whose health changed
+func CheckScanners(family string, now time.Time) (events []models.Event, err error) {
+	d, err := database.Open(family, true)
+	if err != nil {
+		return
+	}
+	defer d.Close()
+	scanners := getScanners(d)
+
+	globalScannerStats.Lock()
+	for name, stats := range globalScannerStats.Stats[family] {
+		scanner, ok := scanners[name]
+		if !ok {
+			scanner = models.Scanner{Name: name, FirstSeen: stats.firstSeen}
+		}
+		if stats.lastSeen.After(scanner.LastSeen) {
+			scanner.LastSeen = stats.lastSeen
+		}
+		scanner.Fingerprints += stats.signals
+		stats.signals = 0
+
+		// the rate over the last full minutes
+		total := 0
+		for minute, n := range stats.minutes {
+			if minute < now.Unix()/60-int64(ScannerRateWindow) {
+				delete(stats.minutes, minute)
+			} else if minute < now.Unix()/60 {
+				total += n
+			}
+		}
+		scanner.Rate = float64(total) / float64(ScannerRateWindow)
+
+		if len(stats.rssi) >= ScannerRSSIMinSamples {
+			scanner.MedianRSSI = median(stats.rssi)
+		}
+		if scanner.BaselineRSSI == 0 && len(stats.rssi) >= ScannerRSSISamples {
+			scanner.BaselineRSSI = scanner.MedianRSSI
+		}
+		scanners[name] = scanner
+	}
+	globalScannerStats.Unlock()
+
+	for name, scanner := range scanners {
+		status := models.ScannerOnline
+		if scanner.LastSeen.IsZero() || now.Sub(scanner.LastSeen) > ScannerSilentAfter {
+			status = models.ScannerSilent
+		}
+		if scanner.Status != "" && status != scanner.Status && !scanner.LastSeen.IsZero() {
+			eventType := models.EventScannerOnline
+			if status == models.ScannerSilent {
+				eventType = models.EventScannerSilent
+			}
+			events = append(events, scannerEvent(family, eventType, scanner, now))
+		}
+		scanner.Status = status
+
+		if scanner.BaselineRSSI != 0 && status == models.ScannerOnline {
+			shift := abs(scanner.MedianRSSI - scanner.BaselineRSSI)
+			if !scanner.Shifted && shift >= ScannerRSSIShift {
+				scanner.Shifted = true
+				events = append(events, scannerEvent(family, models.EventScannerRSSIShift, scanner, now))
+			} else if scanner.Shifted && shift < ScannerRSSIShift/2 {
+				scanner.Shifted = false
+			}
+		}
+		scanners[name] = scanner
+	}
+	sort.Slice(events, func(i, j int) bool { return events[i].Device < events[j].Device })
+
+	err = d.Set("Scanners", scanners)
+	return
+}
+
+func scannerEvent(family, eventType string, scanner models.Scanner, now time.Time) models.Event {
+	return models.Event{
+		Type:      eventType,
+		Family:    family,
+		Device:    scanner.Name,
+		Location:  scanner.Location,
+		RSSI:      scanner.MedianRSSI,
+		Timestamp: now.UnixNano() / int64(time.Millisecond),
+	}
+}
+
+func median(values []float64) float64 {
+	sorted := append([]float64{}, values...)
+	sort.Float64s(sorted)
+	if len(sorted)%2 == 1 {
+		return sorted[len(sorted)/2]
+	}
+	return (sorted[len(sorted)/2-1] + sorted[len(sorted)/2]) / 2
+}
+
+func abs(x float64) float64 {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
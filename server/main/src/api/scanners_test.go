@@ -0,0 +1,150 @@
+package api
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Nimaapr/find3/server/main/src/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScanners(t *testing.T) {
+	defer useTestDatabase(t, "testscanners")()
+	defer func(samples, minSamples int) { ScannerRSSISamples, ScannerRSSIMinSamples = samples, minSamples }(ScannerRSSISamples, ScannerRSSIMinSamples)
+	ScannerRSSISamples, ScannerRSSIMinSamples = 10, 5
+
+	start := time.Date(2018, 3, 7, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < 10; i++ {
+		RecordScan("testscanners", "pi1", []float64{-60, -62, -64}, start.Add(time.Duration(i)*time.Second))
+	}
+	assert.Equal(t, []string{"testscanners"}, ScannerFamilies())
+	_, err := SetScanner("testscanners", models.Scanner{Name: "pi1", Location: "Kitchen"}, false)
+	assert.Nil(t, err)
+
+	// the first check takes the baseline and sends no events
+	events, err := CheckScanners("testscanners", start.Add(time.Minute))
+	assert.Nil(t, err)
+	assert.Empty(t, events)
+	scanners, err := GetScanners("testscanners")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(scanners))
+	assert.Equal(t, "kitchen", scanners[0].Location)
+	assert.Equal(t, models.ScannerOnline, scanners[0].Status)
+	assert.Equal(t, int64(30), scanners[0].Fingerprints)
+	assert.Equal(t, 6.0, scanners[0].Rate)
+	assert.Equal(t, -62.0, scanners[0].MedianRSSI)
+	assert.Equal(t, -62.0, scanners[0].BaselineRSSI)
+
+	// a weaker signal shifts the median once
+	for i := 0; i < 10; i++ {
+		RecordScan("testscanners", "pi1", []float64{-75}, start.Add(time.Minute+time.Duration(i)*time.Second))
+	}
+	events, err = CheckScanners("testscanners", start.Add(2*time.Minute))
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(events))
+	assert.Equal(t, models.EventScannerRSSIShift, events[0].Type)
+	assert.Equal(t, "pi1", events[0].Device)
+	assert.Equal(t, "kitchen", events[0].Location)
+	assert.Equal(t, -75.0, events[0].RSSI)
+	events, err = CheckScanners("testscanners", start.Add(3*time.Minute))
+	assert.Nil(t, err)
+	assert.Empty(t, events)
+
+	// going silent and coming back
+	events, err = CheckScanners("testscanners", start.Add(10*time.Minute))
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(events))
+	assert.Equal(t, models.EventScannerSilent, events[0].Type)
+	RecordScan("testscanners", "pi1", []float64{-75}, start.Add(11*time.Minute))
+	events, err = CheckScanners("testscanners", start.Add(11*time.Minute))
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(events))
+	assert.Equal(t, models.EventScannerOnline, events[0].Type)
+
+	// resetting the baseline takes it again from the next signals
+	_, err = SetScanner("testscanners", models.Scanner{Name: "pi1", Location: "kitchen"}, true)
+	assert.Nil(t, err)
+	for i := 0; i < 10; i++ {
+		RecordScan("testscanners", "pi1", []float64{-80}, start.Add(11*time.Minute+time.Duration(i)*time.Second))
+	}
+	_, err = CheckScanners("testscanners", start.Add(12*time.Minute))
+	assert.Nil(t, err)
+	scanners, err = GetScanners("testscanners")
+	assert.Nil(t, err)
+	assert.Equal(t, -80.0, scanners[0].BaselineRSSI)
+	assert.False(t, scanners[0].Shifted)
+
+	assert.Nil(t, DeleteScanner("testscanners", "pi1"))
+	assert.NotNil(t, DeleteScanner("testscanners", "pi1"))
+	scanners, err = GetScanners("testscanners")
+	assert.Nil(t, err)
+	assert.Empty(t, scanners)
+}
+
+func TestLoadScanners(t *testing.T) {
+	defer useTestDatabase(t, "testloadscanners")()
+	defer func() {
+		globalScannerStats.Lock()
+		delete(globalScannerStats.Stats, "testloadscanners")
+		globalScannerStats.Unlock()
+	}()
+
+	start := time.Date(2018, 3, 7, 12, 0, 0, 0, time.UTC)
+	RecordScan("testloadscanners", "pi1", []float64{-60}, start)
+	events, err := CheckScanners("testloadscanners", start.Add(time.Minute))
+	assert.Nil(t, err)
+	assert.Empty(t, events)
+
+	// the server restarts, and the scanner does not send anything after
+	globalScannerStats.Lock()
+	delete(globalScannerStats.Stats, "testloadscanners")
+	globalScannerStats.Unlock()
+	assert.NotContains(t, ScannerFamilies(), "testloadscanners")
+	assert.Equal(t, []string{"testloadscanners"}, LoadScanners())
+	assert.Contains(t, ScannerFamilies(), "testloadscanners")
+
+	events, err = CheckScanners("testloadscanners", start.Add(10*time.Minute))
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(events))
+	assert.Equal(t, models.EventScannerSilent, events[0].Type)
+	assert.Equal(t, "pi1", events[0].Device)
+}
+
+func TestScannersRestart(t *testing.T) {
+	defer useTestDatabase(t, "testrestartscanners")()
+	defer func() {
+		globalScannerStats.Lock()
+		delete(globalScannerStats.Stats, "testrestartscanners")
+		globalScannerStats.Unlock()
+	}()
+	defer func(samples, minSamples int) { ScannerRSSISamples, ScannerRSSIMinSamples = samples, minSamples }(ScannerRSSISamples, ScannerRSSIMinSamples)
+	ScannerRSSISamples, ScannerRSSIMinSamples = 10, 5
+
+	start := time.Date(2018, 3, 7, 12, 0, 0, 0, time.UTC)
+	scan := func(n int, rssi float64, minute int) []models.Event {
+		for i := 0; i < n; i++ {
+			RecordScan("testrestartscanners", "pi1", []float64{rssi}, start.Add(time.Duration(minute)*time.Minute+time.Duration(i)*time.Second))
+		}
+		events, err := CheckScanners("testrestartscanners", start.Add(time.Duration(minute+1)*time.Minute))
+		assert.Nil(t, err)
+		return events
+	}
+	assert.Empty(t, scan(10, -60, 0))
+
+	// the server restarts, a few weaker signals do not move the median from before
+	globalScannerStats.Lock()
+	delete(globalScannerStats.Stats, "testrestartscanners")
+	globalScannerStats.Unlock()
+	LoadScanners()
+	assert.Empty(t, scan(2, -80, 1))
+	scanners, err := GetScanners("testrestartscanners")
+	assert.Nil(t, err)
+	assert.Equal(t, -60.0, scanners[0].MedianRSSI)
+	assert.Equal(t, -60.0, scanners[0].BaselineRSSI)
+
+	// enough of them do
+	events := scan(3, -80, 2)
+	assert.Equal(t, 1, len(events))
+	assert.Equal(t, models.EventScannerRSSIShift, events[0].Type)
+	assert.Equal(t, -80.0, events[0].RSSI)
+}
@@ -0,0 +1,417 @@
+package api
+
+/*
+This code tracks the devices on the floorplans with a particle filter each, which fuses their successive guesses with
+a motion model, so that the position moves smoothly between the locations instead of jumping from one to the next.
+
+The walkable areas of the floors are stored in the keystore under "WalkableAreas", as a map from the floor to a
+models.WalkableArea. The particles can only be in them, a floor without walkable areas is walkable everywhere.
+
+Each guess of a device moves the particles of its filter:
+
+1. the particles walk in a random direction, at most as far as a person walks (TrackerSpeed) since the last guess,
+   and stay where they are when the step leaves the walkable areas,
+2. each particle is weighted by how well it agrees with the guess: the guessed locations with a coordinate, weighted
+   by their probability, each a Gaussian of TrackerMeasurementNoise around it, and the trilateration position when
+   there is one (see trilateration.go),
+3. the particles are resampled when too few of them carry the weight.
+
+The filter starts again around the guesses when the device changes floor, or was not seen for TrackerTimeout. The
+position is the weighted mean of the particles and its uncertainty their standard deviation around it, both in
+meters. The positions are stored in the tracked_positions table for the history of the device.
+*/
+
+import (
+	"math"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/Nimaapr/find3/server/main/src/database"
+	"github.com/Nimaapr/find3/server/main/src/models"
+)
+
+var (
+	// TrackerParticles is the number of particles of each device
+	TrackerParticles = 300
+	// TrackerSpeed is the fastest a device moves, in meters per second
+	TrackerSpeed = 1.5
+	// TrackerMeasurementNoise is how far from its location a guess can be, in meters
+	TrackerMeasurementNoise = 3.0
+	// TrackerTimeout is how long a device is tracked without a guess
+	TrackerTimeout = 5 * time.Minute
+	// trackerMaxStep is the largest step of a particle, in meters, and trackerMinStep the smallest
+	trackerMaxStep = 15.0
+	trackerMinStep = 0.5
+)
+
+type particle struct {
+	x, y, w float64
+}
+
+type tracker struct {
+	floor     string
+	particles []particle
+	last      int64
+	random    *rand.Rand
+}
+
+// trackerMeasurement is what a guess says of the position of a device on a floor
+type trackerMeasurement struct {
+	floor    string
+	points   []weightedPoint
+	position *models.Position
+}
+
+type weightedPoint struct {
+	x, y, p float64
+}
+
+type TrackerMap struct {
+	// Trackers maps family -> device -> tracker
+	Trackers map[string]map[string]*tracker
+	sync.Mutex
+}
+
+var globalTrackers TrackerMap
+
+func init() {
+	globalTrackers.Lock()
+	defer globalTrackers.Unlock()
+	globalTrackers.Trackers = make(map[string]map[string]*tracker)
+}
+
+// GetWalkableAreas returns the walkable areas of the floors of a family
+func GetWalkableAreas(family string) (areas map[string]models.WalkableArea, err error) {
+	d, err := database.Open(family, true)
+	if err != nil {
+		return
+	}
+	defer d.Close()
+	areas = getWalkableAreas(d)
+	return
+}
+
+func getWalkableAreas(d *database.Database) (areas map[string]models.WalkableArea) {
+	errGet := d.Get("WalkableAreas", &areas)
+	if errGet != nil || areas == nil {
+		areas = make(map[string]models.WalkableArea)
+	}
+	return
+}
+
+// SetWalkableArea replaces the walkable areas of a floor
+func SetWalkableArea(family string, area models.WalkableArea) (saved models.WalkableArea, err error) {
+	area.Floor, err = CleanFloor(area.Floor)
+	if err != nil {
+		return
+	}
+	if area.Unit == "" {
+		area.Unit = models.UnitPixels
+	}
+	if area.Unit != models.UnitPixels && area.Unit != models.UnitMeters {
+		err = errors.New("unit must be 'px' or 'm'")
+		return
+	}
+	if len(area.Polygons) == 0 {
+		err = errors.New("walkable area needs a polygon")
+		return
+	}
+	for _, polygon := range area.Polygons {
+		if len(polygon) < 3 {
+			err = errors.New("polygons need three points or more")
+			return
+		}
+		for _, p := range polygon {
+			if p.X < 0 || p.Y < 0 {
+				err = errors.New("coordinates are from the top left corner and must be positive")
+				return
+			}
+		}
+	}
+
+	d, err := database.Open(family, true)
+	if err != nil {
+		return
+	}
+	defer d.Close()
+	areas := getWalkableAreas(d)
+	areas[area.Floor] = area
+	err = d.Set("WalkableAreas", areas)
+	saved = area
+	return
+}
+
+// DeleteWalkableArea removes the walkable areas of a floor, it is then walkable everywhere
+func DeleteWalkableArea(family, floor string) (err error) {
+	floor = strings.TrimSpace(strings.ToLower(floor))
+	d, err := database.Open(family, true)
+	if err != nil {
+		return
+	}
+	defer d.Close()
+	areas := getWalkableAreas(d)
+	if _, ok := areas[floor]; !ok {
+		err = errors.New("no walkable areas for floor '" + floor + "'")
+		return
+	}
+	delete(areas, floor)
+	err = d.Set("WalkableAreas", areas)
+	return
+}
+
+// Track moves the particle filter of a device with its latest analysis, and stores and returns
+// its position. The position is nil when the family has no locations on its floorplans.
+func Track(family, device string, timestamp int64, analysis models.LocationAnalysis) (position *models.TrackedPosition, err error) {
+	d, err := database.Open(family, true)
+	if err != nil {
+		return
+	}
+	defer d.Close()
+	coordinates := getLocationCoordinates(d)
+	if len(coordinates) == 0 {
+		return
+	}
+	floorplans := getFloorplans(d)
+	m := measure(analysis, floorplans, coordinates)
+	if m.floor == "" {
+		return
+	}
+	var polygons [][]models.Point
+	if area, ok := getWalkableAreas(d)[m.floor]; ok {
+		polygons, _ = area.Meters(floorplans[m.floor])
+	}
+
+	globalTrackers.Lock()
+	if globalTrackers.Trackers[family] == nil {
+		globalTrackers.Trackers[family] = make(map[string]*tracker)
+	}
+	t := globalTrackers.Trackers[family][device]
+	if t == nil || t.floor != m.floor || timestamp < t.last || time.Duration(timestamp-t.last)*time.Millisecond > TrackerTimeout {
+		t = newTracker(m, polygons, timestamp)
+		globalTrackers.Trackers[family][device] = t
+	} else {
+		t.update(m, polygons, timestamp)
+	}
+	x, y, uncertainty := t.estimate()
+	globalTrackers.Unlock()
+
+	position = &models.TrackedPosition{
+		Timestamp:   timestamp,
+		Floor:       m.floor,
+		X:           math.Round(x*100) / 100,
+		Y:           math.Round(y*100) / 100,
+		Uncertainty: math.Round(uncertainty*100) / 100,
+	}
+	position.Location, _ = nearestLocation(m.floor, x, y, floorplans, coordinates)
+	err = d.AddTrackedPosition(device, *position)
+	return
+}
+
+// GetTrackedPositions returns the tracked positions of a device between from and to, in milliseconds
+func GetTrackedPositions(family, device string, from, to int64) (positions []models.TrackedPosition, err error) {
+	d, err := database.Open(family, true)
+	if err != nil {
+		return
+	}
+	defer d.Close()
+	return d.GetTrackedPositions(device, from, to)
+}
+
+// forgetTracker stops tracking a device, and moves its tracker to another name when given
+func forgetTracker(family, device, renamed string) {
+	globalTrackers.Lock()
+	defer globalTrackers.Unlock()
+	t, ok := globalTrackers.Trackers[family][device]
+	if !ok {
+		return
+	}
+	delete(globalTrackers.Trackers[family], device)
+	if _, kept := globalTrackers.Trackers[family][renamed]; renamed != "" && !kept {
+		globalTrackers.Trackers[family][renamed] = t
+	}
+}
+
+// measure finds the floor of an analysis, the floor with the most probability of the guessed
+// locations with a coordinate, and the places the analysis points to on it
+func measure(analysis models.LocationAnalysis, floorplans map[string]models.Floorplan, coordinates map[string]models.LocationCoordinate) (m trackerMeasurement) {
+	points := make(map[string][]weightedPoint)
+	total := make(map[string]float64)
+	for _, guess := range analysis.Guesses {
+		c, ok := coordinates[guess.Location]
+		if !ok || guess.Probability <= 0 {
+			continue
+		}
+		x, y, ok := c.Meters(floorplans[c.Floor])
+		if !ok {
+			continue
+		}
+		points[c.Floor] = append(points[c.Floor], weightedPoint{x: x, y: y, p: guess.Probability})
+		total[c.Floor] += guess.Probability
+	}
+	for floor, p := range total {
+		if m.floor == "" || p > total[m.floor] || (p == total[m.floor] && floor < m.floor) {
+			m.floor = floor
+		}
+	}
+	if analysis.Position != nil && (m.floor == "" || analysis.Position.Floor == m.floor) {
+		m.floor = analysis.Position.Floor
+		m.position = analysis.Position
+	}
+	m.points = points[m.floor]
+	for i := range m.points {
+		m.points[i].p /= total[m.floor]
+	}
+	return
+}
+
+// likelihood is how well a place agrees with a measurement
+func (m trackerMeasurement) likelihood(x, y float64) (l float64) {
+	l = 1
+	if len(m.points) > 0 {
+		l = 0
+		for _, p := range m.points {
+			d2 := (x-p.x)*(x-p.x) + (y-p.y)*(y-p.y)
+			l += p.p * math.Exp(-d2/(2*TrackerMeasurementNoise*TrackerMeasurementNoise))
+		}
+	}
+	if m.position != nil {
+		sigma := math.Max(m.position.Error, 1)
+		d2 := (x-m.position.X)*(x-m.position.X) + (y-m.position.Y)*(y-m.position.Y)
+		l *= math.Exp(-d2 / (2 * sigma * sigma))
+	}
+	// no particle is ever impossible, so that the filter can recover
+	return l + 1e-12
+}
+
+// sample draws a place from a measurement, in the walkable areas when it can
+func (m trackerMeasurement) sample(random *rand.Rand, polygons [][]models.Point) (x, y float64) {
+	cx, cy, sigma := 0.0, 0.0, TrackerMeasurementNoise
+	if len(m.points) > 0 {
+		r := random.Float64()
+		for _, p := range m.points {
+			cx, cy = p.x, p.y
+			if r -= p.p; r <= 0 {
+				break
+			}
+		}
+	} else if m.position != nil {
+		cx, cy, sigma = m.position.X, m.position.Y, math.Max(m.position.Error, 1)
+	}
+	for try := 0; try < 20; try++ {
+		x, y = cx+random.NormFloat64()*sigma, cy+random.NormFloat64()*sigma
+		if x >= 0 && y >= 0 && (polygons == nil || models.Inside(polygons, x, y)) {
+			return
+		}
+	}
+	return cx, cy
+}
+
+func newTracker(m trackerMeasurement, polygons [][]models.Point, timestamp int64) (t *tracker) {
+	t = &tracker{
+		floor:     m.floor,
+		particles: make([]particle, TrackerParticles),
+		last:      timestamp,
+		random:    rand.New(rand.NewSource(timestamp)),
+	}
+	t.spread(m, polygons)
+	return
+}
+
+// spread places the particles around a measurement
+func (t *tracker) spread(m trackerMeasurement, polygons [][]models.Point) {
+	for i := range t.particles {
+		x, y := m.sample(t.random, polygons)
+		t.particles[i] = particle{x: x, y: y, w: 1 / float64(len(t.particles))}
+	}
+}
+
+func (t *tracker) update(m trackerMeasurement, polygons [][]models.Point, timestamp int64) {
+	// the particles walk
+	step := TrackerSpeed * float64(timestamp-t.last) / 1000
+	step = math.Min(math.Max(step, trackerMinStep), trackerMaxStep)
+	t.last = timestamp
+	for i, p := range t.particles {
+		for try := 0; try < 10; try++ {
+			x, y := p.x+t.random.NormFloat64()*step, p.y+t.random.NormFloat64()*step
+			if polygons == nil || models.Inside(polygons, x, y) {
+				t.particles[i].x, t.particles[i].y = x, y
+				break
+			}
+		}
+	}
+
+	// and are weighted by the measurement
+	total := 0.0
+	for i, p := range t.particles {
+		t.particles[i].w = p.w * m.likelihood(p.x, p.y)
+		total += t.particles[i].w
+	}
+	if !(total > 0) {
+		t.spread(m, polygons)
+		return
+	}
+	squares := 0.0
+	for i := range t.particles {
+		t.particles[i].w /= total
+		squares += t.particles[i].w * t.particles[i].w
+	}
+	if 1/squares < float64(len(t.particles))/2 {
+		t.resample()
+	}
+}
+
+// resample draws the particles again by their weight, with systematic resampling
+func (t *tracker) resample() {
+	n := len(t.particles)
+	resampled := make([]particle, n)
+	u := t.random.Float64() / float64(n)
+	cumulative := t.particles[0].w
+	j := 0
+	for i := 0; i < n; i++ {
+		for u > cumulative && j < n-1 {
+			j++
+			cumulative += t.particles[j].w
+		}
+		resampled[i] = particle{x: t.particles[j].x, y: t.particles[j].y, w: 1 / float64(n)}
+		u += 1 / float64(n)
+	}
+	t.particles = resampled
+}
+
+// estimate returns the weighted mean of the particles and their standard deviation around it
+func (t *tracker) estimate() (x, y, uncertainty float64) {
+	total := 0.0
+	for _, p := range t.particles {
+		x += p.w * p.x
+		y += p.w * p.y
+		total += p.w
+	}
+	x, y = x/total, y/total
+	for _, p := range t.particles {
+		uncertainty += p.w * ((p.x-x)*(p.x-x) + (p.y-y)*(p.y-y))
+	}
+	uncertainty = math.Sqrt(uncertainty / total)
+	return
+}
+
+// nearestLocation returns the location with a coordinate on a floor that is nearest to a place
+func nearestLocation(floor string, x, y float64, floorplans map[string]models.Floorplan, coordinates map[string]models.LocationCoordinate) (location string, distance float64) {
+	distance = math.Inf(1)
+	for l, c := range coordinates {
+		if c.Floor != floor {
+			continue
+		}
+		cx, cy, ok := c.Meters(floorplans[floor])
+		if !ok {
+			continue
+		}
+		if d := math.Hypot(cx-x, cy-y); d < distance || (d == distance && l < location) {
+			location, distance = l, d
+		}
+	}
+	return
+}
@@ -0,0 +1,110 @@
+package api
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/Nimaapr/find3/server/main/src/models"
+)
+
+func TestInside(t *testing.T) {
+	// an L made of a corridor and a room
+	polygons := [][]models.Point{
+		{{X: 0, Y: 0}, {X: 20, Y: 0}, {X: 20, Y: 4}, {X: 0, Y: 4}},
+		{{X: 8, Y: 4}, {X: 12, Y: 4}, {X: 12, Y: 10}, {X: 8, Y: 10}},
+	}
+	assert.True(t, models.Inside(polygons, 1, 1))
+	assert.True(t, models.Inside(polygons, 10, 8))
+	assert.False(t, models.Inside(polygons, 2, 8))
+	assert.False(t, models.Inside(polygons, 21, 1))
+	assert.False(t, models.Inside(nil, 1, 1))
+}
+
+func TestTrack(t *testing.T) {
+	defer useTestDatabase(t, "testtrack")()
+
+	_, err := SetWalkableArea("testtrack", models.WalkableArea{Floor: "1", Polygons: [][]models.Point{{{X: 0, Y: 0}, {X: 1, Y: 1}}}})
+	assert.NotNil(t, err)
+	_, err = SetWalkableArea("testtrack", models.WalkableArea{Floor: "1", Unit: "ft", Polygons: [][]models.Point{{{X: 0, Y: 0}, {X: 1, Y: 0}, {X: 1, Y: 1}}}})
+	assert.NotNil(t, err)
+	area, err := SetWalkableArea("testtrack", models.WalkableArea{Floor: " 1 ", Unit: models.UnitMeters, Polygons: [][]models.Point{
+		{{X: 0, Y: 0}, {X: 20, Y: 0}, {X: 20, Y: 4}, {X: 0, Y: 4}},
+		{{X: 8, Y: 4}, {X: 12, Y: 4}, {X: 12, Y: 10}, {X: 8, Y: 10}},
+	}})
+	assert.Nil(t, err)
+	assert.Equal(t, "1", area.Floor)
+	_, err = SetWalkableArea("testtrack", models.WalkableArea{Floor: "2", Unit: models.UnitMeters, Polygons: [][]models.Point{{{X: 0, Y: 0}, {X: 1, Y: 0}, {X: 1, Y: 1}}}})
+	assert.Nil(t, err)
+	assert.Nil(t, DeleteWalkableArea("testtrack", "2"))
+	assert.NotNil(t, DeleteWalkableArea("testtrack", "2"))
+	areas, err := GetWalkableAreas("testtrack")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(areas))
+
+	// without coordinates there is nothing to track
+	guess := func(location string) models.LocationAnalysis {
+		return models.LocationAnalysis{Guesses: []models.LocationPrediction{{Location: location, Probability: 0.9}, {Location: "room", Probability: 0.1}}}
+	}
+	position, err := Track("testtrack", "phone", 1000, guess("hall"))
+	assert.Nil(t, err)
+	assert.Nil(t, position)
+
+	_, err = SetLocationCoordinates("testtrack", []models.LocationCoordinate{
+		{Location: "hall", Floor: "1", X: 2, Y: 2, Unit: models.UnitMeters},
+		{Location: "end", Floor: "1", X: 18, Y: 2, Unit: models.UnitMeters},
+		{Location: "room", Floor: "1", X: 10, Y: 8, Unit: models.UnitMeters},
+		{Location: "upstairs", Floor: "2", X: 5, Y: 5, Unit: models.UnitMeters},
+	})
+	assert.Nil(t, err)
+
+	position, err = Track("testtrack", "phone", 1000, guess("hall"))
+	assert.Nil(t, err)
+	assert.NotNil(t, position)
+	assert.Equal(t, "1", position.Floor)
+	assert.Equal(t, "hall", position.Location)
+	assert.True(t, position.Uncertainty < 4)
+
+	// the position walks down the corridor instead of jumping to its end
+	position, err = Track("testtrack", "phone", 3000, guess("end"))
+	assert.Nil(t, err)
+	assert.True(t, position.X < 12)
+	timestamp := int64(3000)
+	for i := 0; i < 10; i++ {
+		timestamp += 2000
+		position, err = Track("testtrack", "phone", timestamp, guess("end"))
+		assert.Nil(t, err)
+	}
+	assert.Equal(t, "end", position.Location)
+	assert.True(t, math.Hypot(position.X-18, position.Y-2) < 2)
+
+	// and the particles stay in the walkable areas
+	polygons, _ := area.Meters(models.Floorplan{})
+	globalTrackers.Lock()
+	for _, p := range globalTrackers.Trackers["testtrack"]["phone"].particles {
+		assert.True(t, models.Inside(polygons, p.x, p.y))
+	}
+	globalTrackers.Unlock()
+
+	// a new floor starts again
+	timestamp += 2000
+	position, err = Track("testtrack", "phone", timestamp, guess("upstairs"))
+	assert.Nil(t, err)
+	assert.Equal(t, "2", position.Floor)
+	assert.Equal(t, "upstairs", position.Location)
+
+	positions, err := GetTrackedPositions("testtrack", "phone", 0, timestamp)
+	assert.Nil(t, err)
+	assert.Equal(t, 13, len(positions))
+	assert.Equal(t, "1", positions[0].Floor)
+	assert.Equal(t, "hall", positions[0].Location)
+	assert.Equal(t, "2", positions[12].Floor)
+	assert.Equal(t, "upstairs", positions[12].Location)
+
+	forgetTracker("testtrack", "phone", "tablet")
+	globalTrackers.Lock()
+	assert.Nil(t, globalTrackers.Trackers["testtrack"]["phone"])
+	assert.NotNil(t, globalTrackers.Trackers["testtrack"]["tablet"])
+	globalTrackers.Unlock()
+}
@@ -0,0 +1,275 @@
+package api
+
+/*
+This code estimates the position of a fingerprint from the signals of access points at known places, next to the
+guesses of the fingerprint classification. It is meant for large open areas, where learning every location is not
+practical.
+
+The path loss model and the access points of a family are stored in the keystore under "Trilateration" (see
+models.Trilateration). Their places are on the floors of the floorplans, in meters, or in pixels of a floorplan with a
+scale. The beacons of the registry (see beacons.go) are access points too.
+
+The floor of a fingerprint is the floor with the most access points heard, the strongest signal breaking ties. The
+position on it is the weighted least squares fit of the distances to its access points, found with Gauss-Newton from
+their weighted centroid: each access point is weighted by the inverse of the square of its distance, as the distances
+of weak signals are the least certain. At least three access points of the floor are needed. The position is snapped
+to the nearest location with a coordinate on the floor, for the clients that only know locations.
+*/
+
+import (
+	"math"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/Nimaapr/find3/server/main/src/database"
+	"github.com/Nimaapr/find3/server/main/src/models"
+)
+
+var (
+	// TrilaterationMinimumAccessPoints is the number of access points of a floor needed for a position
+	TrilaterationMinimumAccessPoints = 3
+	// trilaterationIterations is the most steps of Gauss-Newton
+	trilaterationIterations = 50
+)
+
+// GetTrilateration returns the path loss model and the access points of a family
+func GetTrilateration(family string) (trilateration models.Trilateration, err error) {
+	d, err := database.Open(family, true)
+	if err != nil {
+		return
+	}
+	defer d.Close()
+	trilateration = getTrilateration(d)
+	return
+}
+
+func getTrilateration(d *database.Database) (trilateration models.Trilateration) {
+	errGet := d.Get("Trilateration", &trilateration)
+	if errGet != nil {
+		trilateration = models.Trilateration{}
+	}
+	if trilateration.TxPower == 0 {
+		trilateration.TxPower = models.DefaultTxPower
+	}
+	if trilateration.PathLossExponent <= 0 {
+		trilateration.PathLossExponent = models.DefaultPathLossExponent
+	}
+	if trilateration.AccessPoints == nil {
+		trilateration.AccessPoints = make(map[string]models.AccessPoint)
+	}
+	return
+}
+
+// SetTrilateration changes the path loss model of a family, when given, and places access points,
+// replacing their previous places
+func SetTrilateration(family string, txPower, pathLossExponent *float64, accessPoints []models.AccessPoint) (trilateration models.Trilateration, err error) {
+	if txPower != nil && *txPower >= 0 {
+		err = errors.New("tx_power is the signal at one meter, in dBm, and must be negative")
+		return
+	}
+	if pathLossExponent != nil && *pathLossExponent <= 0 {
+		err = errors.New("path_loss_exponent must be positive")
+		return
+	}
+	for i, a := range accessPoints {
+		a.Mac = strings.TrimSpace(strings.ToLower(a.Mac))
+		if a.Mac == "" {
+			err = errors.New("access point needs a mac")
+			return
+		}
+		a.Sensor = strings.TrimSpace(strings.ToLower(a.Sensor))
+		if a.Sensor == "" {
+			a.Sensor = "wifi"
+		}
+		a.Floor, err = CleanFloor(a.Floor)
+		if err != nil {
+			return
+		}
+		if a.Unit == "" {
+			a.Unit = models.UnitPixels
+		}
+		if a.Unit != models.UnitPixels && a.Unit != models.UnitMeters {
+			err = errors.New("unit must be 'px' or 'm'")
+			return
+		}
+		if a.X < 0 || a.Y < 0 {
+			err = errors.New("coordinates are from the top left corner and must be positive")
+			return
+		}
+		if a.TxPower > 0 || a.PathLossExponent < 0 {
+			err = errors.New("the path loss model of '" + a.Mac + "' is not valid")
+			return
+		}
+		accessPoints[i] = a
+	}
+
+	d, err := database.Open(family, true)
+	if err != nil {
+		return
+	}
+	defer d.Close()
+	trilateration = getTrilateration(d)
+	if txPower != nil {
+		trilateration.TxPower = *txPower
+	}
+	if pathLossExponent != nil {
+		trilateration.PathLossExponent = *pathLossExponent
+	}
+	for _, a := range accessPoints {
+		trilateration.AccessPoints[a.Mac] = a
+	}
+	err = d.Set("Trilateration", trilateration)
+	return
+}
+
+// DeleteAccessPoint removes an access point of a family
+func DeleteAccessPoint(family, mac string) (err error) {
+	mac = strings.TrimSpace(strings.ToLower(mac))
+	d, err := database.Open(family, true)
+	if err != nil {
+		return
+	}
+	defer d.Close()
+	trilateration := getTrilateration(d)
+	if _, ok := trilateration.AccessPoints[mac]; !ok {
+		err = errors.New("no access point '" + mac + "'")
+		return
+	}
+	delete(trilateration.AccessPoints, mac)
+	err = d.Set("Trilateration", trilateration)
+	return
+}
+
+type trilaterationSignal struct {
+	x, y     float64
+	rssi     float64
+	distance float64
+}
+
+// trilaterate estimates the position of sensor data from the signals of the access points of its
+// family, it is nil when there are not enough access points heard on a floor
+func trilaterate(s models.SensorData, trilateration models.Trilateration, floorplans map[string]models.Floorplan, coordinates map[string]models.LocationCoordinate) (position *models.Position) {
+	floors := make(map[string][]trilaterationSignal)
+	for _, a := range trilateration.AccessPoints {
+		value, ok := s.Sensors[a.Sensor][a.Mac]
+		if !ok {
+			continue
+		}
+		rssi, ok := signalOf(value)
+		if !ok {
+			continue
+		}
+		x, y, ok := a.Meters(floorplans[a.Floor])
+		if !ok {
+			continue
+		}
+		floors[a.Floor] = append(floors[a.Floor], trilaterationSignal{x: x, y: y, rssi: rssi, distance: a.Distance(rssi, trilateration)})
+	}
+
+	floor := ""
+	for f, signals := range floors {
+		if floor == "" || len(signals) > len(floors[floor]) || (len(signals) == len(floors[floor]) && strongest(signals) > strongest(floors[floor])) {
+			floor = f
+		}
+	}
+	signals := floors[floor]
+	if len(signals) < TrilaterationMinimumAccessPoints {
+		return
+	}
+	x, y, rms := leastSquares(signals)
+	position = &models.Position{
+		Floor:        floor,
+		X:            math.Round(x*100) / 100,
+		Y:            math.Round(y*100) / 100,
+		Error:        math.Round(rms*100) / 100,
+		AccessPoints: len(signals),
+	}
+
+	// the nearest location of the floor
+	location, distance := nearestLocation(floor, x, y, floorplans, coordinates)
+	if location != "" {
+		position.Location = location
+		position.Distance = math.Round(distance*100) / 100
+	}
+	return
+}
+
+func signalOf(value interface{}) (rssi float64, ok bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case float32:
+		return float64(v), true
+	case int:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	}
+	return
+}
+
+func strongest(signals []trilaterationSignal) (rssi float64) {
+	rssi = math.Inf(-1)
+	for _, s := range signals {
+		rssi = math.Max(rssi, s.rssi)
+	}
+	return
+}
+
+// leastSquares returns the place whose distances to the access points fit the estimated ones
+// best, weighted by the inverse square of the estimates, and the weighted root mean square of
+// the differences
+func leastSquares(signals []trilaterationSignal) (x, y, rms float64) {
+	weights := make([]float64, len(signals))
+	total := 0.0
+	for i, s := range signals {
+		d := math.Max(s.distance, 0.1)
+		weights[i] = 1 / (d * d)
+		// the centroid is weighted by the inverse of the distance, to start near the strongest
+		x += s.x / d
+		y += s.y / d
+		total += 1 / d
+	}
+	x, y = x/total, y/total
+
+	for iteration := 0; iteration < trilaterationIterations; iteration++ {
+		// the normal equations (J'WJ) step = J'W residuals
+		var a, b, c, rx, ry float64
+		for i, s := range signals {
+			dx, dy := x-s.x, y-s.y
+			r := math.Hypot(dx, dy)
+			if r < 1e-9 {
+				continue
+			}
+			jx, jy := dx/r, dy/r
+			residual := s.distance - r
+			a += weights[i] * jx * jx
+			b += weights[i] * jx * jy
+			c += weights[i] * jy * jy
+			rx += weights[i] * jx * residual
+			ry += weights[i] * jy * residual
+		}
+		det := a*c - b*b
+		if math.Abs(det) < 1e-12 {
+			// the access points are in a line
+			break
+		}
+		stepX := (c*rx - b*ry) / det
+		stepY := (a*ry - b*rx) / det
+		x += stepX
+		y += stepY
+		if math.Hypot(stepX, stepY) < 1e-4 {
+			break
+		}
+	}
+
+	sum, totalWeight := 0.0, 0.0
+	for i, s := range signals {
+		residual := s.distance - math.Hypot(x-s.x, y-s.y)
+		sum += weights[i] * residual * residual
+		totalWeight += weights[i]
+	}
+	rms = math.Sqrt(sum / totalWeight)
+	return
+}
@@ -0,0 +1,73 @@
+package api
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/Nimaapr/find3/server/main/src/models"
+)
+
+func TestTrilateration(t *testing.T) {
+	defer useTestDatabase(t, "testtrilateration")()
+
+	exponent := 0.0
+	_, err := SetTrilateration("testtrilateration", nil, &exponent, nil)
+	assert.NotNil(t, err)
+	_, err = SetTrilateration("testtrilateration", nil, nil, []models.AccessPoint{{Mac: "aa", Floor: "1", Unit: "ft"}})
+	assert.NotNil(t, err)
+
+	txPower := -45.0
+	trilateration, err := SetTrilateration("testtrilateration", &txPower, nil, []models.AccessPoint{
+		{Mac: "AA", Floor: "1", X: 0, Y: 0, Unit: models.UnitMeters},
+		{Mac: "bb", Floor: "1", X: 10, Y: 0, Unit: models.UnitMeters},
+		{Mac: "cc", Floor: "1", X: 0, Y: 200, PathLossExponent: 2},
+		{Mac: "dd", Floor: "1", X: 200, Y: 200},
+		{Mac: "ee", Floor: "2", X: 0, Y: 0, Unit: models.UnitMeters, Sensor: "bluetooth"},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, -45.0, trilateration.TxPower)
+	assert.Equal(t, float64(models.DefaultPathLossExponent), trilateration.PathLossExponent)
+	assert.Equal(t, "wifi", trilateration.AccessPoints["aa"].Sensor)
+	assert.Equal(t, models.UnitPixels, trilateration.AccessPoints["cc"].Unit)
+
+	assert.Nil(t, DeleteAccessPoint("testtrilateration", "ee"))
+	assert.NotNil(t, DeleteAccessPoint("testtrilateration", "ee"))
+	trilateration, err = GetTrilateration("testtrilateration")
+	assert.Nil(t, err)
+	assert.Equal(t, 4, len(trilateration.AccessPoints))
+
+	// the signals of a device at (3, 4) meters, the pixels are 20 to the meter
+	floorplans := map[string]models.Floorplan{"1": {Floor: "1", PixelsPerMeter: 20}}
+	rssi := func(a models.AccessPoint) float64 {
+		x, y, _ := a.Meters(floorplans["1"])
+		exponent := a.PathLossExponent
+		if exponent == 0 {
+			exponent = trilateration.PathLossExponent
+		}
+		return trilateration.TxPower - 10*exponent*math.Log10(math.Hypot(x-3, y-4))
+	}
+	s := models.SensorData{Sensors: map[string]map[string]interface{}{"wifi": {}}}
+	for mac, a := range trilateration.AccessPoints {
+		s.Sensors["wifi"][mac] = rssi(a)
+	}
+	coordinates := map[string]models.LocationCoordinate{
+		"desk":    {Location: "desk", Floor: "1", X: 60, Y: 100},
+		"window":  {Location: "window", Floor: "1", X: 9, Y: 9, Unit: models.UnitMeters},
+		"upstair": {Location: "upstair", Floor: "2", X: 3, Y: 4, Unit: models.UnitMeters},
+	}
+	position := trilaterate(s, trilateration, floorplans, coordinates)
+	assert.NotNil(t, position)
+	assert.Equal(t, "1", position.Floor)
+	assert.InDelta(t, 3, position.X, 0.05)
+	assert.InDelta(t, 4, position.Y, 0.05)
+	assert.InDelta(t, 0, position.Error, 0.05)
+	assert.Equal(t, 4, position.AccessPoints)
+	assert.Equal(t, "desk", position.Location)
+	assert.InDelta(t, 1, position.Distance, 0.05)
+
+	// without the scale the access points in pixels are left out, and two are not enough
+	position = trilaterate(s, trilateration, nil, coordinates)
+	assert.Nil(t, position)
+}
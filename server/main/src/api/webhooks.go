@@ -0,0 +1,255 @@
+package api
+
+/*
+This code implements webhooks, which post the events of a family (see models.Event) to external HTTP endpoints.
+
+The webhooks of a family are stored in the keystore under "Webhooks", as a map from the webhook ID to a models.Webhook.
+
+FireWebhooks sends an event to every webhook of its family whose filters match. Each delivery is a POST of a JSON body
+
+	{"delivery": "<delivery id>", "event": {...}}
+
+with the headers X-FIND-Event, X-FIND-Delivery and X-FIND-Signature. The signature is "sha256=" followed by the hex
+encoded HMAC-SHA256 of the body, keyed with the secret of the webhook, so that receivers can check where it came from.
+
+A delivery that fails (a network error or a non-2xx status) is retried up to WebhookMaxAttempts times, waiting
+WebhookRetryDelay before the first retry and doubling the wait after each attempt. Every attempt is recorded in the
+webhook_deliveries table, which can be read back with GetWebhookDeliveries, and the newest WebhookDeliveriesKept of
+each webhook are kept.
+*/
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/Nimaapr/find3/server/main/src/database"
+	"github.com/Nimaapr/find3/server/main/src/models"
+	"github.com/Nimaapr/find3/server/main/src/utils"
+)
+
+var (
+	// WebhookMaxAttempts is the number of times a delivery is tried
+	WebhookMaxAttempts = 5
+	// WebhookRetryDelay is the wait before the first retry, it doubles after every attempt
+	WebhookRetryDelay = 2 * time.Second
+	// WebhookTimeout is the time allowed for the receiver to respond, it is read at each attempt
+	WebhookTimeout = 10 * time.Second
+	// WebhookDeliveriesKept is the number of delivery attempts kept for each webhook
+	WebhookDeliveriesKept = 1000
+)
+
+// webhookClient has no timeout of its own, each attempt gets WebhookTimeout
+var webhookClient = &http.Client{}
+
+// GetWebhooks returns the webhooks of a family
+func GetWebhooks(family string) (webhooks map[string]models.Webhook, err error) {
+	d, err := database.Open(family, true)
+	if err != nil {
+		return
+	}
+	defer d.Close()
+	errGet := d.Get("Webhooks", &webhooks)
+	if errGet != nil || webhooks == nil {
+		webhooks = make(map[string]models.Webhook)
+	}
+	return
+}
+
+// AddWebhook validates and stores a webhook for a family. A new ID is
+// generated, and a secret too when none is given.
+func AddWebhook(family string, w models.Webhook) (webhook models.Webhook, err error) {
+	u, err := url.Parse(w.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		err = errors.New("webhook needs an http(s) url")
+		return
+	}
+	for _, eventType := range w.Events {
+		if !models.IsEventType(eventType) {
+			err = fmt.Errorf("unknown event type '%s', must be one of %s", eventType, strings.Join(models.EventTypes, ", "))
+			return
+		}
+	}
+	for i := range w.Devices {
+		w.Devices[i] = strings.TrimSpace(strings.ToLower(w.Devices[i]))
+	}
+	for i := range w.Locations {
+		w.Locations[i] = strings.TrimSpace(strings.ToLower(w.Locations[i]))
+	}
+	w.ID = utils.RandomString(10)
+	if w.Secret == "" {
+		w.Secret = utils.SecureRandomString(32)
+	}
+	w.Created = time.Now().UTC()
+
+	d, err := database.Open(family, true)
+	if err != nil {
+		return
+	}
+	defer d.Close()
+	var webhooks map[string]models.Webhook
+	errGet := d.Get("Webhooks", &webhooks)
+	if errGet != nil || webhooks == nil {
+		webhooks = make(map[string]models.Webhook)
+	}
+	webhooks[w.ID] = w
+	err = d.Set("Webhooks", webhooks)
+	if err != nil {
+		return
+	}
+	webhook = w
+	logger.Log.Debugf("[%s] added webhook %s for %s", family, w.ID, w.URL)
+	return
+}
+
+// DeleteWebhook removes a webhook and its delivery log
+func DeleteWebhook(family, id string) (err error) {
+	d, err := database.Open(family, true)
+	if err != nil {
+		return
+	}
+	defer d.Close()
+	var webhooks map[string]models.Webhook
+	err = d.Get("Webhooks", &webhooks)
+	if err != nil {
+		err = errors.New("no webhooks for " + family)
+		return
+	}
+	if _, ok := webhooks[id]; !ok {
+		err = errors.New("no webhook '" + id + "'")
+		return
+	}
+	delete(webhooks, id)
+	err = d.Set("Webhooks", webhooks)
+	if err != nil {
+		return
+	}
+	err = d.DeleteWebhookDeliveries(id)
+	return
+}
+
+// GetWebhookDeliveries returns the latest attempts at delivering to a webhook
+func GetWebhookDeliveries(family, id string, limit int) (deliveries []models.WebhookDelivery, err error) {
+	d, err := database.Open(family, true)
+	if err != nil {
+		return
+	}
+	defer d.Close()
+	deliveries, err = d.GetWebhookDeliveries(id, limit)
+	return
+}
+
+// FireWebhooks delivers an event to the matching webhooks of its family.
+// Each delivery runs in its own goroutine so retries do not hold up the others.
+func FireWebhooks(e models.Event) {
+	webhooks, err := GetWebhooks(e.Family)
+	if err != nil {
+		return
+	}
+	for _, w := range webhooks {
+		if !w.Matches(e) {
+			continue
+		}
+		go deliverWebhook(e.Family, w, e)
+	}
+}
+
+func deliverWebhook(family string, w models.Webhook, e models.Event) (err error) {
+	delivery := utils.RandomString(12)
+	body, err := json.Marshal(struct {
+		Delivery string       `json:"delivery"`
+		Event    models.Event `json:"event"`
+	}{delivery, e})
+	if err != nil {
+		return
+	}
+
+	delay := WebhookRetryDelay
+	for attempt := 1; attempt <= WebhookMaxAttempts; attempt++ {
+		statusCode, errPost := postWebhook(w, e.Type, delivery, body)
+		if errPost == nil && (statusCode < 200 || statusCode > 299) {
+			errPost = fmt.Errorf("receiver responded with %d", statusCode)
+		}
+		logWebhookDelivery(family, models.WebhookDelivery{
+			WebhookID:  w.ID,
+			Delivery:   delivery,
+			Event:      e.Type,
+			Timestamp:  time.Now().UTC().UnixNano() / int64(time.Millisecond),
+			Attempt:    attempt,
+			StatusCode: statusCode,
+			Success:    errPost == nil,
+			Error:      errorString(errPost),
+			Payload:    string(body),
+		})
+		if errPost == nil {
+			logger.Log.Debugf("[%s] delivered %s to webhook %s", family, e.Type, w.ID)
+			return
+		}
+		err = errPost
+		logger.Log.Debugf("[%s] webhook %s attempt %d failed: %s", family, w.ID, attempt, err.Error())
+		if attempt < WebhookMaxAttempts {
+			time.Sleep(delay)
+			delay *= 2
+		}
+	}
+	logger.Log.Warnf("[%s] giving up on webhook %s after %d attempts: %s", family, w.ID, WebhookMaxAttempts, err.Error())
+	return
+}
+
+func postWebhook(w models.Webhook, eventType, delivery string, body []byte) (statusCode int, err error) {
+	req, err := http.NewRequest("POST", w.URL, bytes.NewBuffer(body))
+	if err != nil {
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "FIND3-Webhook")
+	req.Header.Set("X-FIND-Event", eventType)
+	req.Header.Set("X-FIND-Delivery", delivery)
+	req.Header.Set("X-FIND-Signature", SignWebhook(w.Secret, body))
+	ctx, cancel := context.WithTimeout(context.Background(), WebhookTimeout)
+	defer cancel()
+	resp, err := webhookClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return
+	}
+	resp.Body.Close()
+	statusCode = resp.StatusCode
+	return
+}
+
+// SignWebhook returns the value of the X-FIND-Signature header for a body
+func SignWebhook(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func logWebhookDelivery(family string, w models.WebhookDelivery) {
+	d, err := database.Open(family, true)
+	if err != nil {
+		logger.Log.Warn(err)
+		return
+	}
+	defer d.Close()
+	err = d.AddWebhookDelivery(w, WebhookDeliveriesKept)
+	if err != nil {
+		logger.Log.Warn(err)
+	}
+}
+
+func errorString(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
@@ -0,0 +1,147 @@
+package api
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Nimaapr/find3/server/main/src/database"
+	"github.com/Nimaapr/find3/server/main/src/models"
+	"github.com/stretchr/testify/assert"
+)
+
+// useTestDatabase points the database at a temporary folder with a new family
+func useTestDatabase(t *testing.T, family string) func() {
+	folder, err := ioutil.TempDir("", "find3")
+	assert.Nil(t, err)
+	previous := database.DataFolder
+	database.DataFolder = folder
+	d, err := database.Open(family)
+	assert.Nil(t, err)
+	d.Close()
+	return func() {
+		database.DataFolder = previous
+		os.RemoveAll(folder)
+	}
+}
+
+func TestWebhooks(t *testing.T) {
+	defer useTestDatabase(t, "testwebhooks")()
+	WebhookRetryDelay = 10 * time.Millisecond
+
+	var mutex sync.Mutex
+	attempts := 0
+	received := make(chan models.Event, 1)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		attempts++
+		attempt := attempts
+		mutex.Unlock()
+		if attempt == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		assert.Equal(t, SignWebhook("shh", body), r.Header.Get("X-FIND-Signature"))
+		assert.Equal(t, models.EventLocationChange, r.Header.Get("X-FIND-Event"))
+		var payload struct {
+			Event models.Event `json:"event"`
+		}
+		assert.Nil(t, json.Unmarshal(body, &payload))
+		received <- payload.Event
+	}))
+	defer receiver.Close()
+
+	_, err := AddWebhook("testwebhooks", models.Webhook{URL: "ftp://example.com"})
+	assert.NotNil(t, err)
+	_, err = AddWebhook("testwebhooks", models.Webhook{URL: receiver.URL, Events: []string{"teleported"}})
+	assert.NotNil(t, err)
+	w, err := AddWebhook("testwebhooks", models.Webhook{URL: receiver.URL, Secret: "shh", Devices: []string{"Phone"}})
+	assert.Nil(t, err)
+
+	// filtered out by device
+	FireWebhooks(models.Event{Type: models.EventLocationChange, Family: "testwebhooks", Device: "laptop", Location: "kitchen"})
+	FireWebhooks(models.Event{Type: models.EventLocationChange, Family: "testwebhooks", Device: "phone", Location: "kitchen", PreviousLocation: "bedroom"})
+	select {
+	case e := <-received:
+		assert.Equal(t, "phone", e.Device)
+		assert.Equal(t, "bedroom", e.PreviousLocation)
+	case <-time.After(5 * time.Second):
+		t.Fatal("webhook was not delivered")
+	}
+
+	// the failed attempt and the successful retry are both logged
+	var deliveries []models.WebhookDelivery
+	for i := 0; i < 100; i++ {
+		deliveries, err = GetWebhookDeliveries("testwebhooks", w.ID, 10)
+		assert.Nil(t, err)
+		if len(deliveries) == 2 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, 2, len(deliveries))
+	assert.True(t, deliveries[0].Success)
+	assert.Equal(t, 2, deliveries[0].Attempt)
+	assert.False(t, deliveries[1].Success)
+	assert.Equal(t, 500, deliveries[1].StatusCode)
+
+	assert.Nil(t, DeleteWebhook("testwebhooks", w.ID))
+	webhooks, err := GetWebhooks("testwebhooks")
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(webhooks))
+}
+
+func TestWebhookTimeout(t *testing.T) {
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(100 * time.Millisecond)
+	}))
+	defer receiver.Close()
+	defer func(timeout time.Duration) { WebhookTimeout = timeout }(WebhookTimeout)
+
+	// the timeout is the one set at the time of the attempt
+	WebhookTimeout = 10 * time.Millisecond
+	_, err := postWebhook(models.Webhook{URL: receiver.URL}, models.EventLocationChange, "1", []byte("{}"))
+	assert.NotNil(t, err)
+	WebhookTimeout = time.Second
+	statusCode, err := postWebhook(models.Webhook{URL: receiver.URL}, models.EventLocationChange, "2", []byte("{}"))
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, statusCode)
+}
+
+func TestWebhookDeliveriesKept(t *testing.T) {
+	defer useTestDatabase(t, "testdeliveries")()
+	defer func(kept int) { WebhookDeliveriesKept = kept }(WebhookDeliveriesKept)
+	WebhookDeliveriesKept = 3
+
+	for i := 1; i <= 5; i++ {
+		logWebhookDelivery("testdeliveries", models.WebhookDelivery{WebhookID: "a", Attempt: i, Timestamp: int64(i)})
+		logWebhookDelivery("testdeliveries", models.WebhookDelivery{WebhookID: "b", Attempt: i, Timestamp: int64(i)})
+	}
+	for _, id := range []string{"a", "b"} {
+		deliveries, err := GetWebhookDeliveries("testdeliveries", id, 10)
+		assert.Nil(t, err)
+		attempts := []int{}
+		for _, d := range deliveries {
+			attempts = append(attempts, d.Attempt)
+		}
+		assert.Equal(t, []int{5, 4, 3}, attempts)
+	}
+}
+
+func TestDetectLocationChange(t *testing.T) {
+	defer useTestDatabase(t, "testchanges")()
+	previous, changed := DetectLocationChange("testchanges", "phone", "kitchen", 1)
+	assert.True(t, changed)
+	assert.Equal(t, "", previous)
+	_, changed = DetectLocationChange("testchanges", "phone", "kitchen", 2)
+	assert.False(t, changed)
+	previous, changed = DetectLocationChange("testchanges", "phone", "bedroom", 3)
+	assert.True(t, changed)
+	assert.Equal(t, "kitchen", previous)
+}
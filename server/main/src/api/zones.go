@@ -0,0 +1,228 @@
+package api
+
+/*
+This code implements geofence zones, named groups of locations (see models.Zone), and the events of devices
+entering, leaving and dwelling in them.
+
+The zones of a family are stored in the keystore under "Zones", as a map from the zone name to a models.Zone.
+
+ProcessZones is called with each new best guess of a device. It keeps in memory which zones each device is in and
+since when, and returns the zone_enter, zone_exit and zone_dwell events caused by the guess. When the server has just
+started, the zones of the previous location of the device are taken as the ones it is in, so that a restart does not
+look like every device entered its zones. A zone_dwell event is emitted once per visit, at the first guess after the
+device has been in the zone for longer than the dwell time of the zone.
+
+The events are stored in the events table with RecordEvent and can be queried with GetEvents.
+*/
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/Nimaapr/find3/server/main/src/database"
+	"github.com/Nimaapr/find3/server/main/src/models"
+)
+
+type zoneVisit struct {
+	Entered int64
+	Dwelled bool
+}
+
+type ZoneStateMap struct {
+	// Visits maps family -> device -> zone -> visit
+	Visits map[string]map[string]map[string]*zoneVisit
+	sync.Mutex
+}
+
+var globalZoneState ZoneStateMap
+
+func init() {
+	globalZoneState.Lock()
+	defer globalZoneState.Unlock()
+	globalZoneState.Visits = make(map[string]map[string]map[string]*zoneVisit)
+}
+
+// GetZones returns the zones of a family
+func GetZones(family string) (zones map[string]models.Zone, err error) {
+	d, err := database.Open(family, true)
+	if err != nil {
+		return
+	}
+	defer d.Close()
+	errGet := d.Get("Zones", &zones)
+	if errGet != nil || zones == nil {
+		zones = make(map[string]models.Zone)
+	}
+	return
+}
+
+// SetZone adds a zone to a family, or replaces the zone with the same name
+func SetZone(family string, z models.Zone) (zone models.Zone, err error) {
+	z.Name = strings.TrimSpace(strings.ToLower(z.Name))
+	if z.Name == "" {
+		err = errors.New("zone needs a name")
+		return
+	}
+	locations := []string{}
+	for _, location := range z.Locations {
+		location = strings.TrimSpace(strings.ToLower(location))
+		if location != "" {
+			locations = append(locations, location)
+		}
+	}
+	if len(locations) == 0 {
+		err = errors.New("zone needs at least one location")
+		return
+	}
+	z.Locations = locations
+	if z.DwellTime < 0 {
+		err = errors.New("dwell time must be positive")
+		return
+	}
+
+	d, err := database.Open(family, true)
+	if err != nil {
+		return
+	}
+	defer d.Close()
+	var zones map[string]models.Zone
+	errGet := d.Get("Zones", &zones)
+	if errGet != nil || zones == nil {
+		zones = make(map[string]models.Zone)
+	}
+	if existing, ok := zones[z.Name]; ok {
+		z.Created = existing.Created
+	} else {
+		z.Created = time.Now().UTC()
+	}
+	zones[z.Name] = z
+	err = d.Set("Zones", zones)
+	if err != nil {
+		return
+	}
+	zone = z
+	logger.Log.Debugf("[%s] set zone %s: %s", family, z.Name, strings.Join(z.Locations, ", "))
+	return
+}
+
+// DeleteZone removes a zone from a family
+func DeleteZone(family, name string) (err error) {
+	name = strings.TrimSpace(strings.ToLower(name))
+	d, err := database.Open(family, true)
+	if err != nil {
+		return
+	}
+	defer d.Close()
+	var zones map[string]models.Zone
+	err = d.Get("Zones", &zones)
+	if err != nil {
+		err = errors.New("no zones for " + family)
+		return
+	}
+	if _, ok := zones[name]; !ok {
+		err = errors.New("no zone '" + name + "'")
+		return
+	}
+	delete(zones, name)
+	err = d.Set("Zones", zones)
+	if err != nil {
+		return
+	}
+
+	globalZoneState.Lock()
+	for _, visits := range globalZoneState.Visits[family] {
+		delete(visits, name)
+	}
+	globalZoneState.Unlock()
+	return
+}
+
+// ProcessZones records the location of a device and returns the zone events it causes.
+// The previous location is the one returned by DetectLocationChange.
+func ProcessZones(family, device, location, previous string, probability float64, timestamp int64) (events []models.Event) {
+	events = []models.Event{}
+	zones, err := GetZones(family)
+	if err != nil || len(zones) == 0 {
+		return
+	}
+	names := make([]string, 0, len(zones))
+	for name := range zones {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	globalZoneState.Lock()
+	defer globalZoneState.Unlock()
+	if _, ok := globalZoneState.Visits[family]; !ok {
+		globalZoneState.Visits[family] = make(map[string]map[string]*zoneVisit)
+	}
+	visits, ok := globalZoneState.Visits[family][device]
+	if !ok {
+		visits = make(map[string]*zoneVisit)
+		for _, name := range names {
+			if previous != "" && zones[name].Contains(previous) {
+				visits[name] = &zoneVisit{Entered: timestamp}
+			}
+		}
+		globalZoneState.Visits[family][device] = visits
+	}
+
+	for _, name := range names {
+		z := zones[name]
+		e := models.Event{
+			Family:           family,
+			Device:           device,
+			Location:         location,
+			PreviousLocation: previous,
+			Zone:             name,
+			Probability:      probability,
+			Timestamp:        timestamp,
+		}
+		visit, inZone := visits[name]
+		if z.Contains(location) {
+			if !inZone {
+				visits[name] = &zoneVisit{Entered: timestamp}
+				e.Type = models.EventZoneEnter
+				events = append(events, e)
+			} else if z.DwellTime > 0 && !visit.Dwelled && timestamp-visit.Entered >= z.DwellTime*1000 {
+				visit.Dwelled = true
+				e.Type = models.EventZoneDwell
+				e.Duration = timestamp - visit.Entered
+				events = append(events, e)
+			}
+		} else if inZone {
+			delete(visits, name)
+			e.Type = models.EventZoneExit
+			e.Duration = timestamp - visit.Entered
+			events = append(events, e)
+		}
+	}
+	return
+}
+
+// RecordEvent stores an event of a family and returns it with its ID
+func RecordEvent(e models.Event) (event models.Event, err error) {
+	event = e
+	d, err := database.Open(e.Family, true)
+	if err != nil {
+		return
+	}
+	defer d.Close()
+	event.ID, err = d.AddEvent(e)
+	return
+}
+
+// GetEvents returns the stored events of a family, newest first
+func GetEvents(family string, filter database.EventFilter) (events []models.Event, err error) {
+	d, err := database.Open(family, true)
+	if err != nil {
+		return
+	}
+	defer d.Close()
+	events, err = d.GetEvents(filter)
+	return
+}
@@ -0,0 +1,60 @@
+package api
+
+import (
+	"testing"
+
+	"github.com/Nimaapr/find3/server/main/src/database"
+	"github.com/Nimaapr/find3/server/main/src/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func eventTypes(events []models.Event) (types []string) {
+	types = []string{}
+	for _, e := range events {
+		types = append(types, e.Type+" "+e.Zone)
+	}
+	return
+}
+
+func TestZones(t *testing.T) {
+	defer useTestDatabase(t, "testzones")()
+	_, err := SetZone("testzones", models.Zone{Name: "Lab Wing"})
+	assert.NotNil(t, err)
+	_, err = SetZone("testzones", models.Zone{Name: "Lab Wing", Locations: []string{"Lab1", "lab2", "corridor3"}, DwellTime: 60})
+	assert.Nil(t, err)
+	_, err = SetZone("testzones", models.Zone{Name: "corridors", Locations: []string{"corridor3"}})
+	assert.Nil(t, err)
+	zones, err := GetZones("testzones")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"lab1", "lab2", "corridor3"}, zones["lab wing"].Locations)
+
+	assert.Equal(t, []string{"zone_enter lab wing"}, eventTypes(ProcessZones("testzones", "phone", "lab1", "", 0.9, 1000)))
+	assert.Equal(t, []string{}, eventTypes(ProcessZones("testzones", "phone", "lab2", "lab1", 0.9, 30000)))
+	assert.Equal(t, []string{"zone_enter corridors"}, eventTypes(ProcessZones("testzones", "phone", "corridor3", "lab2", 0.9, 40000)))
+	events := ProcessZones("testzones", "phone", "corridor3", "corridor3", 0.9, 61000)
+	assert.Equal(t, []string{"zone_dwell lab wing"}, eventTypes(events))
+	assert.Equal(t, int64(60000), events[0].Duration)
+	// the dwell event is only sent once per visit
+	assert.Equal(t, []string{}, eventTypes(ProcessZones("testzones", "phone", "corridor3", "corridor3", 0.9, 62000)))
+	events = ProcessZones("testzones", "phone", "kitchen", "corridor3", 0.9, 70000)
+	assert.Equal(t, []string{"zone_exit corridors", "zone_exit lab wing"}, eventTypes(events))
+	assert.Equal(t, int64(69000), events[1].Duration)
+
+	// a device seen before a restart is already in the zones of its previous location
+	assert.Equal(t, []string{}, eventTypes(ProcessZones("testzones", "laptop", "lab1", "lab2", 0.9, 1000)))
+
+	for _, e := range events {
+		_, err = RecordEvent(e)
+		assert.Nil(t, err)
+	}
+	stored, err := GetEvents("testzones", database.EventFilter{Zone: "lab wing"})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(stored))
+	assert.Equal(t, models.EventZoneExit, stored[0].Type)
+	assert.Equal(t, "testzones", stored[0].Family)
+
+	assert.Nil(t, DeleteZone("testzones", "Lab Wing"))
+	zones, err = GetZones("testzones")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(zones))
+}
@@ -149,3 +149,23 @@ func BenchmarkGetLatest(b *testing.B) {
 		db.Close()
 	}
 }
+
+func TestAddSensors(t *testing.T) {
+	db, err := Open("testingbulk")
+	assert.Nil(t, err)
+	defer db.Delete()
+	defer db.Close()
+	sensors := []models.SensorData{
+		{Timestamp: 1, Family: "testingbulk", Device: "wifi-aa", Location: "kitchen", Sensors: map[string]map[string]interface{}{"wifi": {"scanner1-wifi": -50.0}}},
+		{Timestamp: 2, Family: "testingbulk", Device: "bluetooth-bb", Sensors: map[string]map[string]interface{}{"bluetooth": {"scanner1-bluetooth": -60.0}}},
+		{Timestamp: 3, Family: "testingbulk", Device: "wifi-aa", Sensors: map[string]map[string]interface{}{"wifi": {"scanner2-wifi": -70.0}, "bluetooth": {"scanner2-bluetooth": -40.0}}},
+	}
+	assert.Nil(t, db.AddSensors(sensors))
+	for _, s := range sensors {
+		got, err := db.GetSensorFromTime(s.Timestamp)
+		assert.Nil(t, err)
+		assert.Equal(t, s.Device, got.Device)
+		assert.Equal(t, s.Location, got.Location)
+		assert.Equal(t, s.Sensors, got.Sensors)
+	}
+}
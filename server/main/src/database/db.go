@@ -258,9 +258,122 @@ func (d *Database) GetPrediction(timestamp int64) (aidata []models.LocationPredi
 	return
 }
 
+// GetLatestPredictionBefore returns the last prediction stored for a device before the timestamp
+func (d *Database) GetLatestPredictionBefore(device string, timestamp int64) (predictionTimestamp int64, aidata []models.LocationPrediction, err error) {
+	deviceID, err := d.GetID("devices", device)
+	if err != nil {
+		return
+	}
+	stmt, err := d.db.Prepare("SELECT location_predictions.timestamp, location_predictions.prediction FROM location_predictions INNER JOIN sensors ON sensors.timestamp = location_predictions.timestamp WHERE sensors.deviceid = ? AND location_predictions.timestamp < ? ORDER BY location_predictions.timestamp DESC LIMIT 1")
+	if err != nil {
+		err = errors.Wrap(err, "problem preparing SQL")
+		return
+	}
+	defer stmt.Close()
+	var result string
+	err = stmt.QueryRow(deviceID, timestamp).Scan(&predictionTimestamp, &result)
+	if err != nil {
+		err = errors.Wrap(err, "problem getting prediction")
+		return
+	}
+	err = json.Unmarshal([]byte(result), &aidata)
+	return
+}
+
+// GetPredictionsBetween returns the predictions stored for a device with a
+// timestamp between from and to (inclusive), oldest first
+func (d *Database) GetPredictionsBetween(device string, from, to int64) (predictions []models.Prediction, err error) {
+	deviceID, err := d.GetID("devices", device)
+	if err != nil {
+		return
+	}
+	query := "SELECT location_predictions.timestamp, location_predictions.prediction FROM location_predictions INNER JOIN sensors ON sensors.timestamp = location_predictions.timestamp WHERE sensors.deviceid = ? AND location_predictions.timestamp >= ? AND location_predictions.timestamp <= ? ORDER BY location_predictions.timestamp"
+	stmt, err := d.db.Prepare(query)
+	if err != nil {
+		err = errors.Wrap(err, query)
+		return
+	}
+	defer stmt.Close()
+	rows, err := stmt.Query(deviceID, from, to)
+	if err != nil {
+		err = errors.Wrap(err, query)
+		return
+	}
+	defer rows.Close()
+
+	predictions = []models.Prediction{}
+	for rows.Next() {
+		var p models.Prediction
+		var result string
+		err = rows.Scan(&p.Timestamp, &result)
+		if err != nil {
+			err = errors.Wrap(err, "scanning")
+			return
+		}
+		err = json.Unmarshal([]byte(result), &p.Guesses)
+		if err != nil {
+			err = errors.Wrap(err, "problem parsing prediction")
+			return
+		}
+		predictions = append(predictions, p)
+	}
+	err = rows.Err()
+	if err != nil {
+		err = errors.Wrap(err, "rows")
+	}
+	return
+}
+
+// GetAllPredictionsBetween returns the predictions stored for every device with a
+// timestamp between from and to (inclusive), oldest first
+func (d *Database) GetAllPredictionsBetween(from, to int64) (predictions []models.Prediction, err error) {
+	query := "SELECT devices.name, location_predictions.timestamp, location_predictions.prediction FROM location_predictions INNER JOIN sensors ON sensors.timestamp = location_predictions.timestamp INNER JOIN devices ON devices.id = sensors.deviceid WHERE location_predictions.timestamp >= ? AND location_predictions.timestamp <= ? ORDER BY location_predictions.timestamp"
+	stmt, err := d.db.Prepare(query)
+	if err != nil {
+		err = errors.Wrap(err, query)
+		return
+	}
+	defer stmt.Close()
+	rows, err := stmt.Query(from, to)
+	if err != nil {
+		err = errors.Wrap(err, query)
+		return
+	}
+	defer rows.Close()
+
+	predictions = []models.Prediction{}
+	for rows.Next() {
+		var p models.Prediction
+		var result string
+		err = rows.Scan(&p.Device, &p.Timestamp, &result)
+		if err != nil {
+			err = errors.Wrap(err, "scanning")
+			return
+		}
+		err = json.Unmarshal([]byte(result), &p.Guesses)
+		if err != nil {
+			err = errors.Wrap(err, "problem parsing prediction")
+			return
+		}
+		predictions = append(predictions, p)
+	}
+	err = rows.Err()
+	if err != nil {
+		err = errors.Wrap(err, "rows")
+	}
+	return
+}
+
 // AddSensor will insert a sensor data into the database
-// TODO: AddSensor should be special case of AddSensors
 func (d *Database) AddSensor(s models.SensorData) (err error) {
+	return d.AddSensors([]models.SensorData{s})
+}
+
+// AddSensors will insert several sensor data into the database, in one transaction
+func (d *Database) AddSensors(sensors []models.SensorData) (err error) {
+	if len(sensors) == 0 {
+		return
+	}
 	startTime := time.Now()
 	// determine the current table coluss
 	oldColumns := make(map[string]struct{})
@@ -284,85 +397,88 @@ func (d *Database) AddSensor(s models.SensorData) (err error) {
 	}
 	previousCurrent := sensorDataSS.Current
 
-	// setup the database
-	tx, err := d.db.Begin()
-	if err != nil {
-		return errors.Wrap(err, "AddSensor")
+	// add the names first, they are added in their own transactions
+	deviceIDs := make([]string, len(sensors))
+	locationIDs := make([]string, len(sensors))
+	for i, s := range sensors {
+		deviceIDs[i], err = d.AddName("devices", s.Device)
+		if err != nil {
+			return errors.Wrap(err, "problem getting device ID")
+		}
+		if len(s.Location) > 0 {
+			locationIDs[i], err = d.AddName("locations", s.Location)
+			if err != nil {
+				return errors.Wrap(err, "problem getting location ID")
+			}
+		}
 	}
 
-	// first add new columns in the sensor data
-	deviceID, err := d.AddName("devices", s.Device)
+	// setup the database
+	tx, err := d.db.Begin()
 	if err != nil {
-		return errors.Wrap(err, "problem getting device ID")
+		return errors.Wrap(err, "AddSensors")
 	}
-	locationID := ""
-	if len(s.Location) > 0 {
-		locationID, err = d.AddName("locations", s.Location)
+	defer func() {
 		if err != nil {
-			return errors.Wrap(err, "problem getting location ID")
+			tx.Rollback()
 		}
-	}
-	args := make([]interface{}, 3)
-	args[0] = s.Timestamp
-	args[1] = deviceID
-	args[2] = locationID
-	argsQ := []string{"?", "?", "?"}
-	for sensor := range s.Sensors {
-		if _, ok := oldColumns[sensor]; !ok {
-			stmt, err := tx.Prepare("alter table sensors add column " + sensor + " text")
-			if err != nil {
-				return errors.Wrap(err, "AddSensor, adding column")
+	}()
+
+	// first add new columns in the sensor data
+	for _, s := range sensors {
+		for sensor := range s.Sensors {
+			if _, ok := oldColumns[sensor]; ok {
+				continue
 			}
-			_, err = stmt.Exec()
+			_, err = tx.Exec("alter table sensors add column " + sensor + " text")
 			if err != nil {
-				return errors.Wrap(err, "AddSensor, adding column")
+				return errors.Wrap(err, "AddSensors, adding column")
 			}
 			logger.Log.Debugf("adding column %s", sensor)
+			oldColumns[sensor] = struct{}{}
 			columnList = append(columnList, sensor)
-			stmt.Close()
 		}
 	}
 
-	// organize arguments in the correct order
-	for _, sensor := range columnList {
-		if _, ok := s.Sensors[sensor]; !ok {
-			continue
+	statements := make(map[string]*sql.Stmt)
+	defer func() {
+		for _, stmt := range statements {
+			stmt.Close()
 		}
-		argsQ = append(argsQ, "?")
-		args = append(args, sensorDataSS.ShrinkMapToString(s.Sensors[sensor]))
-	}
-
-	// only use the columns that are in the payload
-	newColumnList := make([]string, len(columnList))
-	j := 0
-	for i, c := range columnList {
-		if i >= 3 {
-			if _, ok := s.Sensors[c]; !ok {
+	}()
+	for i, s := range sensors {
+		args := []interface{}{s.Timestamp, deviceIDs[i], locationIDs[i]}
+		argsQ := []string{"?", "?", "?"}
+
+		// organize arguments in the correct order, only using the columns that are in the payload
+		newColumnList := append([]string{}, columnList[:3]...)
+		for _, sensor := range columnList[3:] {
+			if _, ok := s.Sensors[sensor]; !ok {
 				continue
 			}
+			newColumnList = append(newColumnList, sensor)
+			argsQ = append(argsQ, "?")
+			args = append(args, sensorDataSS.ShrinkMapToString(s.Sensors[sensor]))
 		}
-		newColumnList[j] = c
-		j++
-	}
-	newColumnList = newColumnList[:j]
-
-	sqlStatement := "insert or replace into sensors(" + strings.Join(newColumnList, ",") + ") values (" + strings.Join(argsQ, ",") + ")"
-	stmt, err := tx.Prepare(sqlStatement)
-	// logger.Log.Debug("columns", columnList)
-	// logger.Log.Debug("args", args)
-	if err != nil {
-		return errors.Wrap(err, "AddSensor, prepare "+sqlStatement)
-	}
-	defer stmt.Close()
 
-	_, err = stmt.Exec(args...)
-	if err != nil {
-		return errors.Wrap(err, "AddSensor, execute")
+		sqlStatement := "insert or replace into sensors(" + strings.Join(newColumnList, ",") + ") values (" + strings.Join(argsQ, ",") + ")"
+		stmt, ok := statements[sqlStatement]
+		if !ok {
+			stmt, err = tx.Prepare(sqlStatement)
+			if err != nil {
+				return errors.Wrap(err, "AddSensors, prepare "+sqlStatement)
+			}
+			statements[sqlStatement] = stmt
+		}
+		_, err = stmt.Exec(args...)
+		if err != nil {
+			return errors.Wrap(err, "AddSensors, execute")
+		}
 	}
 
 	err = tx.Commit()
 	if err != nil {
-		return errors.Wrap(err, "AddSensor")
+		return errors.Wrap(err, "AddSensors")
 	}
 
 	// update the map key slimmer
@@ -373,9 +489,8 @@ func (d *Database) AddSensor(s models.SensorData) (err error) {
 		}
 	}
 
-	logger.Log.Debugf("[%s] inserted sensor data, %s", s.Family, time.Since(startTime))
+	logger.Log.Debugf("[%s] inserted %d sensor data, %s", sensors[0].Family, len(sensors), time.Since(startTime))
 	return
-
 }
 
 // GetSensorFromTime will return a sensor data for a given timestamp
@@ -431,6 +546,11 @@ func (d *Database) GetSensorFromGreaterTime(timeBlockInMilliseconds int64) (sens
 	return
 }
 
+// GetSensorsBetween returns all the sensor data between the timestamps from and to, in order
+func (d *Database) GetSensorsBetween(from, to int64) (sensors []models.SensorData, err error) {
+	return d.GetAllFromPreparedQuery("SELECT * FROM sensors WHERE timestamp >= ? AND timestamp <= ? ORDER BY timestamp", from, to)
+}
+
 func (d *Database) NumDevices() (num int, err error) {
 	stmt, err := d.db.Prepare("select count(id) from devices")
 	if err != nil {
@@ -873,9 +993,17 @@ func (d *Database) AddName(table string, name string) (deviceID string, err erro
 		return
 	}
 
-	// transform the device name into an ID with the current count
+	// transform the device name into an ID with the current count, skipping the IDs that are
+	// still in use after names were removed (see DeleteDevice)
 	currentCount++
 	deviceID = stringsizer.Transform(currentCount)
+	for {
+		if _, errName := d.GetName(table, deviceID); errName != nil {
+			break
+		}
+		currentCount++
+		deviceID = stringsizer.Transform(currentCount)
+	}
 	// logger.Log.Debugf("transformed (%d) %s -> %s", currentCount, name, deviceID)
 
 	// add the device name and ID
@@ -894,7 +1022,9 @@ func (d *Database) AddName(table string, name string) (deviceID string, err erro
 	defer stmt.Close()
 	_, err = stmt.Exec(deviceID, name)
 	if err != nil {
+		tx.Rollback()
 		err = errors.Wrap(err, "AddName")
+		return
 	}
 	err = tx.Commit()
 	if err != nil {
@@ -975,6 +1105,8 @@ func Open(family string, readOnly ...bool) (d *Database, err error) {
 		logger.Log.Debug("made tables")
 	}
 
+	// create the tables added since the database was made
+	err = d.Migrate(newDatabase)
 	return
 }
 
@@ -0,0 +1,175 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+
+	"github.com/pkg/errors"
+
+	"github.com/Nimaapr/find3/server/main/src/models"
+)
+
+// GetDeviceSensors returns all the sensor data of a device, oldest first
+func (d *Database) GetDeviceSensors(device string) (s []models.SensorData, err error) {
+	deviceID, err := d.GetID("devices", device)
+	if err != nil {
+		return
+	}
+	return d.GetAllFromPreparedQuery("SELECT * FROM sensors WHERE deviceid = ? ORDER BY timestamp", deviceID)
+}
+
+// GetDeviceGPS returns the GPS coordinates recorded with the sensor data of a device
+func (d *Database) GetDeviceGPS(device string) (gps []models.DeviceGPS, err error) {
+	deviceID, err := d.GetID("devices", device)
+	if err != nil {
+		return
+	}
+	query := "SELECT timestamp, mac, loc, lat, lon, alt FROM gps WHERE timestamp IN (SELECT timestamp FROM sensors WHERE deviceid = ?) ORDER BY timestamp"
+	stmt, err := d.db.Prepare(query)
+	if err != nil {
+		err = errors.Wrap(err, query)
+		return
+	}
+	defer stmt.Close()
+	rows, err := stmt.Query(deviceID)
+	if err != nil {
+		err = errors.Wrap(err, query)
+		return
+	}
+	defer rows.Close()
+
+	gps = []models.DeviceGPS{}
+	for rows.Next() {
+		var g models.DeviceGPS
+		var location sql.NullString
+		err = rows.Scan(&g.Timestamp, &g.Mac, &location, &g.GPS.Latitude, &g.GPS.Longitude, &g.GPS.Altitude)
+		if err != nil {
+			err = errors.Wrap(err, "scanning")
+			return
+		}
+		g.Location = location.String
+		gps = append(gps, g)
+	}
+	err = rows.Err()
+	if err != nil {
+		err = errors.Wrap(err, "rows")
+	}
+	return
+}
+
+// DeleteDevice removes a device and all of its rows in one transaction: its sensor data, the predictions
+// and GPS coordinates made from it, its events, tracked positions and updates of the stream, and the
+// webhook deliveries of its events. It returns the number of rows removed from each table, and "learning" is the number of its
+// sensor data that had a location.
+func (d *Database) DeleteDevice(device string) (counts map[string]int64, err error) {
+	deviceID, err := d.GetID("devices", device)
+	if err != nil {
+		err = errors.Wrap(err, "no device '"+device+"'")
+		return
+	}
+	deviceJSON, _ := json.Marshal(device)
+
+	tx, err := d.db.Begin()
+	if err != nil {
+		return nil, errors.Wrap(err, "begin DeleteDevice")
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+			counts = nil
+		}
+	}()
+
+	counts = make(map[string]int64)
+	var learning int64
+	err = tx.QueryRow("SELECT COUNT(*) FROM sensors WHERE deviceid = ? AND locationid != ''", deviceID).Scan(&learning)
+	if err != nil {
+		return counts, errors.Wrap(err, "DeleteDevice")
+	}
+	counts["learning"] = learning
+
+	statements := []struct {
+		table string
+		query string
+		args  []interface{}
+	}{
+		{"location_predictions", "DELETE FROM location_predictions WHERE timestamp IN (SELECT timestamp FROM sensors WHERE deviceid = ?)", []interface{}{deviceID}},
+		{"gps", "DELETE FROM gps WHERE timestamp IN (SELECT timestamp FROM sensors WHERE deviceid = ?)", []interface{}{deviceID}},
+		{"sensors", "DELETE FROM sensors WHERE deviceid = ?", []interface{}{deviceID}},
+		{"devices", "DELETE FROM devices WHERE id = ?", []interface{}{deviceID}},
+		{"events", "DELETE FROM events WHERE device = ?", []interface{}{device}},
+		{"tracked_positions", "DELETE FROM tracked_positions WHERE device = ?", []interface{}{device}},
+		{"stream_events", "DELETE FROM stream_events WHERE device = ?", []interface{}{device}},
+		{"webhook_deliveries", "DELETE FROM webhook_deliveries WHERE instr(payload, ?) > 0", []interface{}{`"device":` + string(deviceJSON)}},
+	}
+	for _, s := range statements {
+		var res sql.Result
+		res, err = tx.Exec(s.query, s.args...)
+		if err != nil {
+			return counts, errors.Wrap(err, "DeleteDevice "+s.table)
+		}
+		counts[s.table], _ = res.RowsAffected()
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return counts, errors.Wrap(err, "commit DeleteDevice")
+	}
+	return
+}
+
+// DeleteKey removes an entry of the keystore and returns whether there was one
+func (d *Database) DeleteKey(key string) (deleted bool, err error) {
+	res, err := d.db.Exec("DELETE FROM keystore WHERE key = ?", key)
+	if err != nil {
+		err = errors.Wrap(err, "DeleteKey")
+		return
+	}
+	n, err := res.RowsAffected()
+	deleted = n > 0
+	return
+}
+
+// AddAuditEntry stores an entry of the audit log and returns its ID
+func (d *Database) AddAuditEntry(e models.AuditEntry) (id int64, err error) {
+	counts, err := json.Marshal(e.Counts)
+	if err != nil {
+		return
+	}
+	res, err := d.db.Exec("insert into audit_log (timestamp, action, device, actor, counts) values (?, ?, ?, ?, ?)", e.Timestamp, e.Action, e.Device, e.Actor, string(counts))
+	if err != nil {
+		err = errors.Wrap(err, "AddAuditEntry")
+		return
+	}
+	id, err = res.LastInsertId()
+	return
+}
+
+// GetAuditEntries returns the latest entries of the audit log, newest first
+func (d *Database) GetAuditEntries(limit int) (entries []models.AuditEntry, err error) {
+	query := "SELECT id, timestamp, action, device, actor, counts FROM audit_log ORDER BY timestamp DESC, id DESC LIMIT ?"
+	rows, err := d.db.Query(query, limit)
+	if err != nil {
+		err = errors.Wrap(err, query)
+		return
+	}
+	defer rows.Close()
+
+	entries = []models.AuditEntry{}
+	for rows.Next() {
+		var e models.AuditEntry
+		var counts string
+		err = rows.Scan(&e.ID, &e.Timestamp, &e.Action, &e.Device, &e.Actor, &counts)
+		if err != nil {
+			err = errors.Wrap(err, "scanning")
+			return
+		}
+		json.Unmarshal([]byte(counts), &e.Counts)
+		entries = append(entries, e)
+	}
+	err = rows.Err()
+	if err != nil {
+		err = errors.Wrap(err, "rows")
+	}
+	return
+}
@@ -0,0 +1,101 @@
+package database
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/Nimaapr/find3/server/main/src/models"
+)
+
+// EventFilter selects events in GetEvents, the empty fields are ignored
+type EventFilter struct {
+	Device string
+	Zone   string
+	Type   string
+	From   int64
+	To     int64
+	Limit  int
+}
+
+// AddEvent will store an event and return its ID
+func (d *Database) AddEvent(e models.Event) (id int64, err error) {
+	stmt, err := d.db.Prepare("insert into events (type, device, location, previous_location, zone, duration, probability, rssi, timestamp) values (?, ?, ?, ?, ?, ?, ?, ?, ?)")
+	if err != nil {
+		err = errors.Wrap(err, "stmt AddEvent")
+		return
+	}
+	defer stmt.Close()
+
+	res, err := stmt.Exec(e.Type, e.Device, e.Location, e.PreviousLocation, e.Zone, e.Duration, e.Probability, e.RSSI, e.Timestamp)
+	if err != nil {
+		err = errors.Wrap(err, "exec AddEvent")
+		return
+	}
+	id, err = res.LastInsertId()
+	return
+}
+
+// GetEvents returns the events that pass the filter, newest first
+func (d *Database) GetEvents(filter EventFilter) (events []models.Event, err error) {
+	conditions := []string{}
+	args := []interface{}{}
+	if filter.Device != "" {
+		conditions = append(conditions, "device = ?")
+		args = append(args, filter.Device)
+	}
+	if filter.Zone != "" {
+		conditions = append(conditions, "zone = ?")
+		args = append(args, filter.Zone)
+	}
+	if filter.Type != "" {
+		conditions = append(conditions, "type = ?")
+		args = append(args, filter.Type)
+	}
+	if filter.From > 0 {
+		conditions = append(conditions, "timestamp >= ?")
+		args = append(args, filter.From)
+	}
+	if filter.To > 0 {
+		conditions = append(conditions, "timestamp <= ?")
+		args = append(args, filter.To)
+	}
+	query := "SELECT id, type, device, location, previous_location, zone, duration, probability, rssi, timestamp FROM events"
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	query += " ORDER BY timestamp DESC, id DESC"
+	if filter.Limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, filter.Limit)
+	}
+
+	stmt, err := d.db.Prepare(query)
+	if err != nil {
+		err = errors.Wrap(err, query)
+		return
+	}
+	defer stmt.Close()
+	rows, err := stmt.Query(args...)
+	if err != nil {
+		err = errors.Wrap(err, query)
+		return
+	}
+	defer rows.Close()
+
+	events = []models.Event{}
+	for rows.Next() {
+		e := models.Event{Family: d.family}
+		err = rows.Scan(&e.ID, &e.Type, &e.Device, &e.Location, &e.PreviousLocation, &e.Zone, &e.Duration, &e.Probability, &e.RSSI, &e.Timestamp)
+		if err != nil {
+			err = errors.Wrap(err, "scanning")
+			return
+		}
+		events = append(events, e)
+	}
+	err = rows.Err()
+	if err != nil {
+		err = errors.Wrap(err, "rows")
+	}
+	return
+}
@@ -0,0 +1,72 @@
+package database
+
+import (
+	"github.com/pkg/errors"
+
+	"github.com/Nimaapr/find3/server/main/src/models"
+)
+
+// GetLocationHierarchy returns the place in the hierarchy of the locations that have one
+func (d *Database) GetLocationHierarchy() (hierarchy map[string]models.LocationHierarchy, err error) {
+	query := "SELECT location, site, building, floor, room FROM location_hierarchy"
+	rows, err := d.db.Query(query)
+	if err != nil {
+		err = errors.Wrap(err, query)
+		return
+	}
+	defer rows.Close()
+
+	hierarchy = make(map[string]models.LocationHierarchy)
+	for rows.Next() {
+		var h models.LocationHierarchy
+		err = rows.Scan(&h.Location, &h.Site, &h.Building, &h.Floor, &h.Room)
+		if err != nil {
+			err = errors.Wrap(err, "scanning")
+			return
+		}
+		hierarchy[h.Location] = h
+	}
+	err = rows.Err()
+	if err != nil {
+		err = errors.Wrap(err, "rows")
+	}
+	return
+}
+
+// SetLocationHierarchy places locations in the hierarchy, replacing their previous place
+func (d *Database) SetLocationHierarchy(hierarchy []models.LocationHierarchy) (err error) {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return errors.Wrap(err, "SetLocationHierarchy")
+	}
+	stmt, err := tx.Prepare("insert or replace into location_hierarchy (location, site, building, floor, room) values (?, ?, ?, ?, ?)")
+	if err != nil {
+		tx.Rollback()
+		return errors.Wrap(err, "SetLocationHierarchy")
+	}
+	defer stmt.Close()
+	for _, h := range hierarchy {
+		_, err = stmt.Exec(h.Location, h.Site, h.Building, h.Floor, h.Room)
+		if err != nil {
+			tx.Rollback()
+			return errors.Wrap(err, "SetLocationHierarchy")
+		}
+	}
+	err = tx.Commit()
+	if err != nil {
+		err = errors.Wrap(err, "SetLocationHierarchy")
+	}
+	return
+}
+
+// DeleteLocationHierarchy removes a location from the hierarchy
+func (d *Database) DeleteLocationHierarchy(location string) (err error) {
+	res, err := d.db.Exec("DELETE FROM location_hierarchy WHERE location = ?", location)
+	if err != nil {
+		return errors.Wrap(err, "DeleteLocationHierarchy")
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		err = errors.New("no hierarchy for '" + location + "'")
+	}
+	return
+}
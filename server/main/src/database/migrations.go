@@ -0,0 +1,59 @@
+package database
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// migrations create the tables that were added after MakeTables, so that
+// databases made by older versions get them too. Every statement must be
+// safe to run more than once (adding a column that exists is ignored).
+var migrations = []string{
+	`CREATE TABLE IF NOT EXISTS webhook_deliveries (id INTEGER PRIMARY KEY, webhook_id TEXT, delivery TEXT, event TEXT, timestamp INTEGER, attempt INTEGER, status_code INTEGER, success INTEGER, error TEXT, payload TEXT);`,
+	`CREATE INDEX IF NOT EXISTS webhook_deliveries_webhook ON webhook_deliveries (webhook_id, timestamp);`,
+	`CREATE TABLE IF NOT EXISTS events (id INTEGER PRIMARY KEY, type TEXT, device TEXT, location TEXT, previous_location TEXT, zone TEXT, duration INTEGER, probability REAL, timestamp INTEGER);`,
+	`CREATE INDEX IF NOT EXISTS events_timestamp ON events (timestamp);`,
+	`CREATE TABLE IF NOT EXISTS occupancy_intervals (interval INTEGER, start INTEGER, devices INTEGER, randomized_devices INTEGER, PRIMARY KEY (interval, start));`,
+	`CREATE TABLE IF NOT EXISTS occupancy_rollups (interval INTEGER, start INTEGER, location TEXT, devices INTEGER, randomized_devices INTEGER, PRIMARY KEY (interval, start, location));`,
+	`ALTER TABLE events ADD COLUMN rssi REAL DEFAULT 0;`,
+	`ALTER TABLE occupancy_intervals ADD COLUMN pseudo_devices INTEGER DEFAULT 0;`,
+	`ALTER TABLE occupancy_intervals ADD COLUMN pseudo_device_ids TEXT DEFAULT '';`,
+	`ALTER TABLE occupancy_rollups ADD COLUMN pseudo_devices INTEGER DEFAULT 0;`,
+	`CREATE TABLE IF NOT EXISTS audit_log (id INTEGER PRIMARY KEY, timestamp INTEGER, action TEXT, device TEXT, actor TEXT, counts TEXT);`,
+	`CREATE TABLE IF NOT EXISTS location_hierarchy (location TEXT PRIMARY KEY, site TEXT, building TEXT, floor TEXT, room TEXT);`,
+	`CREATE TABLE IF NOT EXISTS location_metadata (location TEXT PRIMARY KEY, description TEXT, tags TEXT);`,
+	`CREATE TABLE IF NOT EXISTS tracked_positions (device TEXT, timestamp INTEGER, floor TEXT, x REAL, y REAL, uncertainty REAL, PRIMARY KEY (device, timestamp));`,
+	`CREATE TABLE IF NOT EXISTS stream_events (id INTEGER PRIMARY KEY AUTOINCREMENT, timestamp INTEGER, device TEXT, location TEXT);`,
+	`ALTER TABLE tracked_positions ADD COLUMN location TEXT DEFAULT '';`,
+}
+
+type migratedDatabases struct {
+	names map[string]bool
+	sync.Mutex
+}
+
+var migrated = migratedDatabases{names: make(map[string]bool)}
+
+// Migrate runs the migrations, once per database file for the lifetime of the program
+func (d *Database) Migrate(force ...bool) (err error) {
+	migrated.Lock()
+	defer migrated.Unlock()
+	if migrated.names[d.name] && !(len(force) > 0 && force[0]) {
+		return
+	}
+	for _, sqlStmt := range migrations {
+		_, err = d.db.Exec(sqlStmt)
+		if err != nil && strings.Contains(err.Error(), "duplicate column name") {
+			err = nil
+		}
+		if err != nil {
+			err = errors.Wrap(err, "Migrate")
+			logger.Log.Error(err)
+			return
+		}
+	}
+	migrated.names[d.name] = true
+	return
+}
@@ -0,0 +1,112 @@
+package database
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/Nimaapr/find3/server/main/src/models"
+)
+
+// AddOccupancyRollups will store the rollups, replacing the ones of the same intervals
+func (d *Database) AddOccupancyRollups(rollups []models.OccupancyRollup) (err error) {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return errors.Wrap(err, "begin AddOccupancyRollups")
+	}
+	stmtInterval, err := tx.Prepare("insert or replace into occupancy_intervals (interval, start, devices, randomized_devices, pseudo_devices, pseudo_device_ids) values (?, ?, ?, ?, ?, ?)")
+	if err != nil {
+		tx.Rollback()
+		return errors.Wrap(err, "stmt AddOccupancyRollups")
+	}
+	defer stmtInterval.Close()
+	stmtDelete, err := tx.Prepare("delete from occupancy_rollups where interval = ? and start = ?")
+	if err != nil {
+		tx.Rollback()
+		return errors.Wrap(err, "stmt AddOccupancyRollups")
+	}
+	defer stmtDelete.Close()
+	stmtLocation, err := tx.Prepare("insert into occupancy_rollups (interval, start, location, devices, randomized_devices, pseudo_devices) values (?, ?, ?, ?, ?, ?)")
+	if err != nil {
+		tx.Rollback()
+		return errors.Wrap(err, "stmt AddOccupancyRollups")
+	}
+	defer stmtLocation.Close()
+
+	for _, r := range rollups {
+		_, err = stmtInterval.Exec(r.Interval, r.Start, r.Devices, r.RandomizedDevices, r.PseudoDevices, strings.Join(r.PseudoDeviceIDs, ","))
+		if err == nil {
+			_, err = stmtDelete.Exec(r.Interval, r.Start)
+		}
+		for location, count := range r.Locations {
+			if err != nil {
+				break
+			}
+			_, err = stmtLocation.Exec(r.Interval, r.Start, location, count.Devices, count.RandomizedDevices, count.PseudoDevices)
+		}
+		if err != nil {
+			tx.Rollback()
+			return errors.Wrap(err, "exec AddOccupancyRollups")
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return errors.Wrap(err, "commit AddOccupancyRollups")
+	}
+	return
+}
+
+// GetOccupancyRollups returns the stored rollups of the intervals that start between from and to, in order
+func (d *Database) GetOccupancyRollups(interval, from, to int64) (rollups []models.OccupancyRollup, err error) {
+	query := "SELECT occupancy_intervals.start, occupancy_intervals.devices, occupancy_intervals.randomized_devices, occupancy_intervals.pseudo_devices, occupancy_intervals.pseudo_device_ids, occupancy_rollups.location, occupancy_rollups.devices, occupancy_rollups.randomized_devices, occupancy_rollups.pseudo_devices FROM occupancy_intervals LEFT JOIN occupancy_rollups ON occupancy_rollups.interval = occupancy_intervals.interval AND occupancy_rollups.start = occupancy_intervals.start WHERE occupancy_intervals.interval = ? AND occupancy_intervals.start >= ? AND occupancy_intervals.start <= ? ORDER BY occupancy_intervals.start"
+	stmt, err := d.db.Prepare(query)
+	if err != nil {
+		err = errors.Wrap(err, query)
+		return
+	}
+	defer stmt.Close()
+	rows, err := stmt.Query(interval, from, to)
+	if err != nil {
+		err = errors.Wrap(err, query)
+		return
+	}
+	defer rows.Close()
+
+	rollups = []models.OccupancyRollup{}
+	for rows.Next() {
+		var r models.OccupancyRollup
+		var location *string
+		var devices, randomizedDevices, pseudoDevices *int
+		var intervalPseudoDevices *int
+		var pseudoDeviceIDs *string
+		err = rows.Scan(&r.Start, &r.Devices, &r.RandomizedDevices, &intervalPseudoDevices, &pseudoDeviceIDs, &location, &devices, &randomizedDevices, &pseudoDevices)
+		if err != nil {
+			err = errors.Wrap(err, "scanning")
+			return
+		}
+		if len(rollups) == 0 || rollups[len(rollups)-1].Start != r.Start {
+			if intervalPseudoDevices != nil {
+				r.PseudoDevices = *intervalPseudoDevices
+			}
+			if pseudoDeviceIDs != nil && *pseudoDeviceIDs != "" {
+				r.PseudoDeviceIDs = strings.Split(*pseudoDeviceIDs, ",")
+			}
+			r.Interval = interval
+			r.Locations = make(map[string]models.OccupancyCount)
+			rollups = append(rollups, r)
+		}
+		if location != nil {
+			count := models.OccupancyCount{Devices: *devices, RandomizedDevices: *randomizedDevices}
+			if pseudoDevices != nil {
+				count.PseudoDevices = *pseudoDevices
+			}
+			rollups[len(rollups)-1].Locations[*location] = count
+		}
+	}
+	err = rows.Err()
+	if err != nil {
+		err = errors.Wrap(err, "rows")
+	}
+	return
+}
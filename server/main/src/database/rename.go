@@ -0,0 +1,276 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"sort"
+
+	"github.com/pkg/errors"
+
+	"github.com/Nimaapr/find3/server/main/src/models"
+)
+
+// GetLocationMetadata returns the description and tags of the locations that have them
+func (d *Database) GetLocationMetadata() (metadata map[string]models.LocationMetadata, err error) {
+	query := "SELECT location, description, tags FROM location_metadata"
+	rows, err := d.db.Query(query)
+	if err != nil {
+		err = errors.Wrap(err, query)
+		return
+	}
+	defer rows.Close()
+
+	metadata = make(map[string]models.LocationMetadata)
+	for rows.Next() {
+		var m models.LocationMetadata
+		var tags string
+		err = rows.Scan(&m.Location, &m.Description, &tags)
+		if err != nil {
+			err = errors.Wrap(err, "scanning")
+			return
+		}
+		if err = json.Unmarshal([]byte(tags), &m.Tags); err != nil {
+			err = errors.Wrap(err, "tags of "+m.Location)
+			return
+		}
+		metadata[m.Location] = m
+	}
+	err = rows.Err()
+	if err != nil {
+		err = errors.Wrap(err, "rows")
+	}
+	return
+}
+
+// SetLocationMetadata stores the description and tags of a location, the metadata is removed
+// when both are empty
+func (d *Database) SetLocationMetadata(m models.LocationMetadata) (err error) {
+	if m.Description == "" && len(m.Tags) == 0 {
+		_, err = d.db.Exec("DELETE FROM location_metadata WHERE location = ?", m.Location)
+	} else {
+		var tags []byte
+		tags, err = json.Marshal(m.Tags)
+		if err != nil {
+			return
+		}
+		_, err = d.db.Exec("INSERT OR REPLACE INTO location_metadata (location, description, tags) VALUES (?, ?, ?)", m.Location, m.Description, string(tags))
+	}
+	if err != nil {
+		err = errors.Wrap(err, "SetLocationMetadata")
+	}
+	return
+}
+
+type renameStatement struct {
+	table string
+	query string
+	args  []interface{}
+}
+
+// RenameLocation renames a location in the tables, or merges it into the location named to
+// when there is one. The learning data, GPS coordinates, events, predictions, tracked positions,
+// place in the hierarchy, metadata and occupancy of the location are moved to the new name. When merging,
+// the place in the hierarchy and metadata of the location merged into are kept, and the
+// occupancy of the intervals where both locations were occupied is computed again.
+func (d *Database) RenameLocation(from, to string) (counts map[string]int64, merged bool, err error) {
+	fromID, err := d.GetID("locations", from)
+	if err != nil {
+		err = errors.Wrap(err, "no location '"+from+"'")
+		return
+	}
+	toID, errTo := d.GetID("locations", to)
+	merged = errTo == nil
+
+	statements := []renameStatement{}
+	if merged {
+		statements = append(statements,
+			renameStatement{"sensors", "UPDATE sensors SET locationid = ? WHERE locationid = ?", []interface{}{toID, fromID}},
+			renameStatement{"locations", "DELETE FROM locations WHERE id = ?", []interface{}{fromID}},
+		)
+	} else {
+		statements = append(statements,
+			renameStatement{"locations", "UPDATE locations SET name = ? WHERE id = ?", []interface{}{to, fromID}},
+		)
+	}
+	statements = append(statements,
+		renameStatement{"gps", "UPDATE gps SET loc = ? WHERE loc = ?", []interface{}{to, from}},
+		renameStatement{"events", "UPDATE events SET location = ? WHERE location = ?", []interface{}{to, from}},
+		renameStatement{"events", "UPDATE events SET previous_location = ? WHERE previous_location = ?", []interface{}{to, from}},
+		renameStatement{"stream_events", "UPDATE stream_events SET location = ? WHERE location = ?", []interface{}{to, from}},
+		renameStatement{"tracked_positions", "UPDATE tracked_positions SET location = ? WHERE location = ?", []interface{}{to, from}},
+		renameStatement{"location_hierarchy", "UPDATE OR IGNORE location_hierarchy SET location = ? WHERE location = ?", []interface{}{to, from}},
+		renameStatement{"location_hierarchy", "DELETE FROM location_hierarchy WHERE location = ?", []interface{}{from}},
+		renameStatement{"location_metadata", "UPDATE OR IGNORE location_metadata SET location = ? WHERE location = ?", []interface{}{to, from}},
+		renameStatement{"location_metadata", "DELETE FROM location_metadata WHERE location = ?", []interface{}{from}},
+		renameStatement{"occupancy_rollups", "UPDATE OR IGNORE occupancy_rollups SET location = ? WHERE location = ?", []interface{}{to, from}},
+		// the rollups left have both locations, their intervals are computed again
+		renameStatement{"occupancy_intervals", "DELETE FROM occupancy_intervals WHERE EXISTS (SELECT 1 FROM occupancy_rollups WHERE occupancy_rollups.location = ? AND occupancy_rollups.interval = occupancy_intervals.interval AND occupancy_rollups.start = occupancy_intervals.start)", []interface{}{from}},
+		renameStatement{"occupancy_rollups", "DELETE FROM occupancy_rollups WHERE (interval, start) IN (SELECT interval, start FROM occupancy_rollups WHERE location = ?)", []interface{}{from}},
+	)
+
+	tx, err := d.db.Begin()
+	if err != nil {
+		err = errors.Wrap(err, "begin RenameLocation")
+		return
+	}
+	counts, err = execRename(tx, statements)
+	if err != nil {
+		tx.Rollback()
+		err = errors.Wrap(err, "RenameLocation")
+		return
+	}
+	counts["location_predictions"], err = renamePredictions(tx, from, to)
+	if err != nil {
+		tx.Rollback()
+		err = errors.Wrap(err, "RenameLocation")
+		return
+	}
+	err = tx.Commit()
+	if err != nil {
+		err = errors.Wrap(err, "commit RenameLocation")
+	}
+	return
+}
+
+// RenameDevice renames a device in the tables, or merges it into the device named to when there
+// is one. The sensor data, events and tracked positions of the device are moved to the new name.
+// When merging, the occupancy of the time the device was seen is computed again.
+func (d *Database) RenameDevice(from, to string) (counts map[string]int64, merged bool, err error) {
+	fromID, err := d.GetID("devices", from)
+	if err != nil {
+		err = errors.Wrap(err, "no device '"+from+"'")
+		return
+	}
+	toID, errTo := d.GetID("devices", to)
+	merged = errTo == nil
+
+	statements := []renameStatement{}
+	if merged {
+		var first, last sql.NullInt64
+		err = d.db.QueryRow("SELECT MIN(timestamp), MAX(timestamp) FROM sensors WHERE deviceid = ?", fromID).Scan(&first, &last)
+		if err != nil {
+			err = errors.Wrap(err, "RenameDevice")
+			return
+		}
+		statements = append(statements,
+			renameStatement{"sensors", "UPDATE sensors SET deviceid = ? WHERE deviceid = ?", []interface{}{toID, fromID}},
+			renameStatement{"devices", "DELETE FROM devices WHERE id = ?", []interface{}{fromID}},
+		)
+		if first.Valid {
+			statements = append(statements,
+				renameStatement{"occupancy_rollups", "DELETE FROM occupancy_rollups WHERE start + interval > ? AND start <= ?", []interface{}{first.Int64, last.Int64}},
+				renameStatement{"occupancy_intervals", "DELETE FROM occupancy_intervals WHERE start + interval > ? AND start <= ?", []interface{}{first.Int64, last.Int64}},
+			)
+		}
+	} else {
+		statements = append(statements,
+			renameStatement{"devices", "UPDATE devices SET name = ? WHERE id = ?", []interface{}{to, fromID}},
+		)
+	}
+	statements = append(statements,
+		renameStatement{"events", "UPDATE events SET device = ? WHERE device = ?", []interface{}{to, from}},
+		renameStatement{"stream_events", "UPDATE stream_events SET device = ? WHERE device = ?", []interface{}{to, from}},
+		renameStatement{"tracked_positions", "UPDATE OR IGNORE tracked_positions SET device = ? WHERE device = ?", []interface{}{to, from}},
+		renameStatement{"tracked_positions", "DELETE FROM tracked_positions WHERE device = ?", []interface{}{from}},
+	)
+
+	tx, err := d.db.Begin()
+	if err != nil {
+		err = errors.Wrap(err, "begin RenameDevice")
+		return
+	}
+	counts, err = execRename(tx, statements)
+	if err != nil {
+		tx.Rollback()
+		err = errors.Wrap(err, "RenameDevice")
+		return
+	}
+	err = tx.Commit()
+	if err != nil {
+		err = errors.Wrap(err, "commit RenameDevice")
+	}
+	return
+}
+
+// execRename runs the statements of a rename, adding up the rows changed in each table
+func execRename(tx *sql.Tx, statements []renameStatement) (counts map[string]int64, err error) {
+	counts = make(map[string]int64)
+	for _, s := range statements {
+		var res sql.Result
+		res, err = tx.Exec(s.query, s.args...)
+		if err != nil {
+			err = errors.Wrap(err, s.table)
+			return
+		}
+		n, _ := res.RowsAffected()
+		counts[s.table] += n
+	}
+	return
+}
+
+// renamePredictions renames a location in the stored predictions, adding up its probability
+// with the location it is merged into
+func renamePredictions(tx *sql.Tx, from, to string) (n int64, err error) {
+	fromJSON, _ := json.Marshal(from)
+	rows, err := tx.Query("SELECT timestamp, prediction FROM location_predictions WHERE instr(prediction, ?) > 0", `"location":`+string(fromJSON))
+	if err != nil {
+		err = errors.Wrap(err, "location_predictions")
+		return
+	}
+	predictions := make(map[int64][]models.LocationPrediction)
+	for rows.Next() {
+		var timestamp int64
+		var prediction string
+		if err = rows.Scan(&timestamp, &prediction); err != nil {
+			rows.Close()
+			err = errors.Wrap(err, "scanning")
+			return
+		}
+		var guesses []models.LocationPrediction
+		if json.Unmarshal([]byte(prediction), &guesses) == nil {
+			predictions[timestamp] = guesses
+		}
+	}
+	err = rows.Err()
+	rows.Close()
+	if err != nil {
+		err = errors.Wrap(err, "rows")
+		return
+	}
+
+	stmt, err := tx.Prepare("UPDATE location_predictions SET prediction = ? WHERE timestamp = ?")
+	if err != nil {
+		err = errors.Wrap(err, "location_predictions")
+		return
+	}
+	defer stmt.Close()
+	for timestamp, guesses := range predictions {
+		b, _ := json.Marshal(RenameGuesses(guesses, from, to))
+		if _, err = stmt.Exec(string(b), timestamp); err != nil {
+			err = errors.Wrap(err, "location_predictions")
+			return
+		}
+		n++
+	}
+	return
+}
+
+// RenameGuesses renames a location in guesses, adding up the probabilities of the guesses that
+// end up with the same location, best first
+func RenameGuesses(guesses []models.LocationPrediction, from, to string) []models.LocationPrediction {
+	renamed := make([]models.LocationPrediction, 0, len(guesses))
+	index := make(map[string]int)
+	for _, guess := range guesses {
+		if guess.Location == from {
+			guess.Location = to
+		}
+		if i, ok := index[guess.Location]; ok {
+			renamed[i].Probability += guess.Probability
+			continue
+		}
+		index[guess.Location] = len(renamed)
+		renamed = append(renamed, guess)
+	}
+	sort.SliceStable(renamed, func(i, j int) bool { return renamed[i].Probability > renamed[j].Probability })
+	return renamed
+}
@@ -0,0 +1,88 @@
+package database
+
+import (
+	"database/sql"
+
+	"github.com/pkg/errors"
+)
+
+// StreamEvent is a location update that was sent over the stream. Its ID is given by the server
+// and only grows, unlike the timestamps of the fingerprints, which passive data dates back.
+type StreamEvent struct {
+	ID        int64
+	Timestamp int64
+	Device    string
+	Location  string
+}
+
+// AddStreamEvent stores a location update of the stream and returns its ID. Only the updates of the
+// newest keep IDs are kept, the older ones are removed, unless keep is 0.
+func (d *Database) AddStreamEvent(timestamp int64, device, location string, keep int) (id int64, err error) {
+	res, err := d.db.Exec("INSERT INTO stream_events (timestamp, device, location) VALUES (?, ?, ?)", timestamp, device, location)
+	if err != nil {
+		err = errors.Wrap(err, "AddStreamEvent")
+		return
+	}
+	id, err = res.LastInsertId()
+	if err != nil || keep <= 0 {
+		return
+	}
+	_, err = d.db.Exec("DELETE FROM stream_events WHERE id <= ?", id-int64(keep))
+	if err != nil {
+		err = errors.Wrap(err, "AddStreamEvent")
+	}
+	return
+}
+
+// GetLastStreamEventID returns the last ID given to a location update of the stream, 0 when there was none.
+// It stays the same when the update is removed.
+func (d *Database) GetLastStreamEventID() (id int64, err error) {
+	err = d.db.QueryRow("SELECT seq FROM sqlite_sequence WHERE name = 'stream_events'").Scan(&id)
+	if err == sql.ErrNoRows {
+		err = nil
+	} else if err != nil {
+		err = errors.Wrap(err, "GetLastStreamEventID")
+	}
+	return
+}
+
+// GetStreamEventsAfter returns the newest limit location updates of the stream after the ID given, oldest
+// first, of the device and at the location given when they are not empty. missed is the number of older
+// ones after the ID that were left out.
+func (d *Database) GetStreamEventsAfter(id int64, device, location string, limit int) (events []StreamEvent, missed int, err error) {
+	where := " WHERE id > ? AND (? = '' OR device = ?) AND (? = '' OR location = ?)"
+	args := []interface{}{id, device, device, location, location}
+	var total int
+	err = d.db.QueryRow("SELECT COUNT(*) FROM stream_events"+where, args...).Scan(&total)
+	if err != nil {
+		err = errors.Wrap(err, "GetStreamEventsAfter")
+		return
+	}
+	rows, err := d.db.Query("SELECT id, timestamp, device, location FROM stream_events"+where+" ORDER BY id DESC LIMIT ?", append(args, limit)...)
+	if err != nil {
+		err = errors.Wrap(err, "GetStreamEventsAfter")
+		return
+	}
+	defer rows.Close()
+
+	events = []StreamEvent{}
+	for rows.Next() {
+		var e StreamEvent
+		err = rows.Scan(&e.ID, &e.Timestamp, &e.Device, &e.Location)
+		if err != nil {
+			err = errors.Wrap(err, "scanning")
+			return
+		}
+		events = append(events, e)
+	}
+	err = rows.Err()
+	if err != nil {
+		err = errors.Wrap(err, "rows")
+		return
+	}
+	for i, j := 0, len(events)-1; i < j; i, j = i+1, j-1 {
+		events[i], events[j] = events[j], events[i]
+	}
+	missed = total - len(events)
+	return
+}
@@ -0,0 +1,43 @@
+package database
+
+import (
+	"github.com/pkg/errors"
+
+	"github.com/Nimaapr/find3/server/main/src/models"
+)
+
+// AddTrackedPosition stores the position of a device estimated by its particle filter
+func (d *Database) AddTrackedPosition(device string, p models.TrackedPosition) (err error) {
+	_, err = d.db.Exec("INSERT OR REPLACE INTO tracked_positions (timestamp, device, floor, x, y, uncertainty, location) VALUES (?, ?, ?, ?, ?, ?, ?)", p.Timestamp, device, p.Floor, p.X, p.Y, p.Uncertainty, p.Location)
+	if err != nil {
+		err = errors.Wrap(err, "AddTrackedPosition")
+	}
+	return
+}
+
+// GetTrackedPositions returns the tracked positions of a device between from and to, in order
+func (d *Database) GetTrackedPositions(device string, from, to int64) (positions []models.TrackedPosition, err error) {
+	query := "SELECT timestamp, floor, x, y, uncertainty, location FROM tracked_positions WHERE device = ? AND timestamp >= ? AND timestamp <= ? ORDER BY timestamp"
+	rows, err := d.db.Query(query, device, from, to)
+	if err != nil {
+		err = errors.Wrap(err, query)
+		return
+	}
+	defer rows.Close()
+
+	positions = []models.TrackedPosition{}
+	for rows.Next() {
+		var p models.TrackedPosition
+		err = rows.Scan(&p.Timestamp, &p.Floor, &p.X, &p.Y, &p.Uncertainty, &p.Location)
+		if err != nil {
+			err = errors.Wrap(err, "scanning")
+			return
+		}
+		positions = append(positions, p)
+	}
+	err = rows.Err()
+	if err != nil {
+		err = errors.Wrap(err, "rows")
+	}
+	return
+}
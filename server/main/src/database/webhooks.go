@@ -0,0 +1,85 @@
+package database
+
+import (
+	"github.com/pkg/errors"
+
+	"github.com/Nimaapr/find3/server/main/src/models"
+)
+
+// AddWebhookDelivery will log an attempt at delivering a webhook. Only the newest keep attempts of
+// the webhook are kept, the older ones are removed, unless keep is 0.
+func (d *Database) AddWebhookDelivery(w models.WebhookDelivery, keep int) (err error) {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return errors.Wrap(err, "begin AddWebhookDelivery")
+	}
+	stmt, err := tx.Prepare("insert into webhook_deliveries (webhook_id, delivery, event, timestamp, attempt, status_code, success, error, payload) values (?, ?, ?, ?, ?, ?, ?, ?, ?)")
+	if err != nil {
+		return errors.Wrap(err, "stmt AddWebhookDelivery")
+	}
+	defer stmt.Close()
+
+	_, err = stmt.Exec(w.WebhookID, w.Delivery, w.Event, w.Timestamp, w.Attempt, w.StatusCode, w.Success, w.Error, w.Payload)
+	if err != nil {
+		tx.Rollback()
+		return errors.Wrap(err, "exec AddWebhookDelivery")
+	}
+	if keep > 0 {
+		_, err = tx.Exec("DELETE FROM webhook_deliveries WHERE webhook_id = ? AND id NOT IN (SELECT id FROM webhook_deliveries WHERE webhook_id = ? ORDER BY id DESC LIMIT ?)", w.WebhookID, w.WebhookID, keep)
+		if err != nil {
+			tx.Rollback()
+			return errors.Wrap(err, "trim AddWebhookDelivery")
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return errors.Wrap(err, "commit AddWebhookDelivery")
+	}
+	return
+}
+
+// GetWebhookDeliveries returns the latest delivery attempts of a webhook, newest first
+func (d *Database) GetWebhookDeliveries(webhookID string, limit int) (deliveries []models.WebhookDelivery, err error) {
+	query := "SELECT id, webhook_id, delivery, event, timestamp, attempt, status_code, success, error, payload FROM webhook_deliveries WHERE webhook_id = ? ORDER BY timestamp DESC, id DESC LIMIT ?"
+	stmt, err := d.db.Prepare(query)
+	if err != nil {
+		err = errors.Wrap(err, query)
+		return
+	}
+	defer stmt.Close()
+	rows, err := stmt.Query(webhookID, limit)
+	if err != nil {
+		err = errors.Wrap(err, query)
+		return
+	}
+	defer rows.Close()
+
+	deliveries = []models.WebhookDelivery{}
+	for rows.Next() {
+		var w models.WebhookDelivery
+		err = rows.Scan(&w.ID, &w.WebhookID, &w.Delivery, &w.Event, &w.Timestamp, &w.Attempt, &w.StatusCode, &w.Success, &w.Error, &w.Payload)
+		if err != nil {
+			err = errors.Wrap(err, "scanning")
+			return
+		}
+		deliveries = append(deliveries, w)
+	}
+	err = rows.Err()
+	if err != nil {
+		err = errors.Wrap(err, "rows")
+	}
+	return
+}
+
+// DeleteWebhookDeliveries removes the delivery log of a webhook
+func (d *Database) DeleteWebhookDeliveries(webhookID string) (err error) {
+	stmt, err := d.db.Prepare("DELETE FROM webhook_deliveries WHERE webhook_id = ?")
+	if err != nil {
+		err = errors.Wrap(err, "problem preparing SQL")
+		return
+	}
+	defer stmt.Close()
+	_, err = stmt.Exec(webhookID)
+	return
+}
@@ -1,5 +1,20 @@
 package nb1
 
+/*
+This code is a naive Bayes classifier of the locations. The signal strengths are learned as histograms of their whole
+dBm, smoothed with a Gaussian when classifying.
+
+The other values of the fingerprints (see models.SensorValue) are learned next to them, and stored under the key of
+the model followed by "Features":
+
+- the continuous measurements, like the air pressure or the magnitude of the magnetic field, as the mean and variance
+  at each location, so that a barometer tells the floors apart (the pressures are made relative to a barometer that
+  stays in the building before they are saved, see api/barometer.go, as the weather moves them by more than a floor),
+- the categorical values as the count of each value at each location.
+
+A model learned before the features were has none, and classifies with the signal strengths only.
+*/
+
 import (
 	"errors"
 	"math"
@@ -9,17 +24,65 @@ import (
 	"github.com/Nimaapr/find3/server/main/src/models"
 )
 
+// MinimumDeviation is the smallest standard deviation of a continuous feature at a location, as
+// a fraction of its standard deviation over all the locations
+var MinimumDeviation = 0.1
+
 // Algorithm defines the basic structure
 type Algorithm struct {
 	Data     map[string]map[string]map[int]int
+	Features Features
 	isLoaded bool
+	key      string
+}
+
+// Features are the continuous and categorical features learned at each location
+type Features struct {
+	// Continuous maps location -> feature -> its moments
+	Continuous map[string]map[string]Moments `json:"continuous"`
+	// Categories maps location -> feature -> value -> count
+	Categories map[string]map[string]map[string]int `json:"categories"`
+}
+
+// Moments are the number, sum and sum of squares of the values of a continuous feature
+type Moments struct {
+	N          float64 `json:"n"`
+	Sum        float64 `json:"sum"`
+	SumSquares float64 `json:"sum_squares"`
+}
+
+func (m *Moments) add(o Moments) {
+	m.N += o.N
+	m.Sum += o.Sum
+	m.SumSquares += o.SumSquares
+}
+
+// meanDeviation returns the mean and the standard deviation of the values
+func (m Moments) meanDeviation() (mean, deviation float64) {
+	mean = m.Sum / m.N
+	return mean, math.Sqrt(math.Max(m.SumSquares/m.N-mean*mean, 0))
+}
+
+func newFeatures() Features {
+	return Features{
+		Continuous: make(map[string]map[string]Moments),
+		Categories: make(map[string]map[string]map[string]int),
+	}
 }
 
 // New returns new algorithm
 func New() *Algorithm {
+	return NewNamed("NB1")
+}
+
+// NewNamed returns a new algorithm that is stored under another key of the keystore,
+// to learn other labels than the locations
+func NewNamed(key string) *Algorithm {
 	n := new(Algorithm)
 	n.Data = make(map[string]map[string]map[int]int)
+	n.Features = newFeatures()
 	n.isLoaded = false
+	n.key = key
 	return n
 }
 
@@ -30,14 +93,34 @@ func (a *Algorithm) Fit(datas []models.SensorData) (err error) {
 		return
 	}
 	a.Data = make(map[string]map[string]map[int]int)
+	a.Features = newFeatures()
 	for _, data := range datas {
 		if _, ok := a.Data[data.Location]; !ok {
 			a.Data[data.Location] = make(map[string]map[int]int)
+			a.Features.Continuous[data.Location] = make(map[string]Moments)
+			a.Features.Categories[data.Location] = make(map[string]map[string]int)
 		}
 		for sensorType := range data.Sensors {
 			for sensor := range data.Sensors[sensorType] {
 				mac := sensorType + "-" + sensor
-				val := int(data.Sensors[sensorType][sensor].(float64))
+				value, errValue := models.ParseSensorValue(data.Sensors[sensorType][sensor])
+				if errValue != nil {
+					continue
+				}
+				if value.Continuous(sensorType) {
+					m := a.Features.Continuous[data.Location][mac]
+					m.add(Moments{N: 1, Sum: value.Scalar, SumSquares: value.Scalar * value.Scalar})
+					a.Features.Continuous[data.Location][mac] = m
+					continue
+				}
+				if value.Kind == models.ValueCategorical {
+					if _, ok := a.Features.Categories[data.Location][mac]; !ok {
+						a.Features.Categories[data.Location][mac] = make(map[string]int)
+					}
+					a.Features.Categories[data.Location][mac][value.Category]++
+					continue
+				}
+				val := int(value.Scalar)
 				if _, ok := a.Data[data.Location][mac]; !ok {
 					a.Data[data.Location][mac] = make(map[int]int)
 				}
@@ -53,7 +136,11 @@ func (a *Algorithm) Fit(datas []models.SensorData) (err error) {
 		return
 	}
 	defer db.Close()
-	err = db.Set("NB1", a.Data)
+	err = db.Set(a.key, a.Data)
+	if err != nil {
+		return
+	}
+	err = db.Set(a.key+"Features", a.Features)
 	return
 }
 
@@ -66,7 +153,11 @@ func (a *Algorithm) Classify(data models.SensorData) (pl PairList, err error) {
 			err = err2
 			return
 		}
-		err = db.Get("NB1", &a.Data)
+		err = db.Get(a.key, &a.Data)
+		if err == nil && db.Get(a.key+"Features", &a.Features) != nil {
+			// learned before the features were
+			a.Features = newFeatures()
+		}
 		db.Close()
 		if err != nil {
 			return
@@ -88,12 +179,34 @@ func (a *Algorithm) Classify(data models.SensorData) (pl PairList, err error) {
 	for sensorType := range data.Sensors {
 		for name := range data.Sensors[sensorType] {
 			mac := sensorType + "-" + name
-			val := int(data.Sensors[sensorType][name].(float64))
+			value, errValue := models.ParseSensorValue(data.Sensors[sensorType][name])
+			if errValue != nil {
+				continue
+			}
 			for location := range Ps {
-				PA := a.probMacGivenLocation(mac, val, location, true)
-				PnotA := a.probMacGivenLocation(mac, val, location, false)
+				var PA, PnotA float64
+				switch {
+				case value.Continuous(sensorType):
+					PA = a.probContinuousGivenLocation(mac, value.Scalar, location, true)
+					PnotA = a.probContinuousGivenLocation(mac, value.Scalar, location, false)
+				case value.Kind == models.ValueCategorical:
+					PA = a.probCategoryGivenLocation(mac, value.Category, location, true)
+					PnotA = a.probCategoryGivenLocation(mac, value.Category, location, false)
+				default:
+					val := int(value.Scalar)
+					PA = a.probMacGivenLocation(mac, val, location, true)
+					PnotA = a.probMacGivenLocation(mac, val, location, false)
+					P := PA * NA / (PA*NA + PnotA*NnotA)
+					Ps[location] = append(Ps[location], math.Log(P))
+					continue
+				}
 				P := PA * NA / (PA*NA + PnotA*NnotA)
-				Ps[location] = append(Ps[location], math.Log(P))
+				if math.IsNaN(P) {
+					// the feature was not learned
+					continue
+				}
+				// a measurement far from the ones learned does not rule a location out on its own
+				Ps[location] = append(Ps[location], math.Log(math.Max(P, 1e-9)))
 			}
 		}
 	}
@@ -199,6 +312,57 @@ func (a *Algorithm) probMacGivenLocation(mac string, val int, loc string, positi
 	return
 }
 
+// probContinuousGivenLocation is the density of a continuous feature at a location, or at the
+// other locations when not positive, it is 0 for a feature that was not learned
+func (a *Algorithm) probContinuousGivenLocation(feature string, x float64, loc string, positive bool) (P float64) {
+	var m, all Moments
+	for locX := range a.Features.Continuous {
+		moments, ok := a.Features.Continuous[locX][feature]
+		if !ok {
+			continue
+		}
+		all.add(moments)
+		if (locX == loc) == positive {
+			m.add(moments)
+		}
+	}
+	if all.N == 0 {
+		return 0
+	}
+	if m.N == 0 {
+		// the locations that did not measure it are told nothing by it
+		m = all
+	}
+	mean, deviation := m.meanDeviation()
+	_, deviationAll := all.meanDeviation()
+	deviation = math.Max(deviation, math.Max(MinimumDeviation*deviationAll, 1e-9))
+	return normPDF(mean, x, deviation)
+}
+
+// probCategoryGivenLocation is the probability of a value of a categorical feature at a
+// location, or at the other locations when not positive, with add-one smoothing. It is 0 for a
+// feature that was not learned.
+func (a *Algorithm) probCategoryGivenLocation(feature, category string, loc string, positive bool) (P float64) {
+	values := make(map[string]struct{})
+	count, total := 0, 0
+	for locX := range a.Features.Categories {
+		for value, n := range a.Features.Categories[locX][feature] {
+			values[value] = struct{}{}
+			if (locX == loc) != positive {
+				continue
+			}
+			total += n
+			if value == category {
+				count += n
+			}
+		}
+	}
+	if len(values) == 0 {
+		return 0
+	}
+	return float64(count+1) / float64(total+len(values)+1)
+}
+
 func normPDF(mean, x, sd float64) float64 {
 	m := sd * math.Sqrt(2*math.Pi)
 	e := math.Exp(-math.Pow(x-mean, 2) / (2 * math.Pow(sd, 2)))
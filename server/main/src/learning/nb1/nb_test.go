@@ -2,10 +2,14 @@ package nb1
 
 import (
 	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"os"
 	"path/filepath"
 	"testing"
 
 	"github.com/Nimaapr/find3/server/main/src/database"
+	"github.com/Nimaapr/find3/server/main/src/models"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -32,3 +36,51 @@ func TestBasic(t *testing.T) {
 	fmt.Println(datas[1].Location)
 	fmt.Println(pl)
 }
+
+func TestContinuous(t *testing.T) {
+	folder, err := ioutil.TempDir("", "find3")
+	assert.Nil(t, err)
+	defer os.RemoveAll(folder)
+	previous := database.DataFolder
+	database.DataFolder = folder
+	defer func() { database.DataFolder = previous }()
+
+	// the same wifi on both floors, which only the air pressure tells apart
+	random := rand.New(rand.NewSource(1))
+	fingerprint := func(floor string, pressure float64) models.SensorData {
+		return models.SensorData{Family: "testcontinuous", Device: "phone", Location: floor, Sensors: map[string]map[string]interface{}{
+			"wifi":         {"aa": float64(-60 + random.Intn(5))},
+			"barometer":    {"pressure": pressure + random.NormFloat64()*0.05},
+			"magnetometer": {"field": []interface{}{random.NormFloat64(), 30.0, 40.0}},
+			"cell":         {"tower": "12"},
+		}}
+	}
+	datas := []models.SensorData{}
+	for i := 0; i < 40; i++ {
+		datas = append(datas, fingerprint("ground", 1013.0), fingerprint("first", 1012.6))
+	}
+	nb := New()
+	assert.Nil(t, nb.Fit(datas))
+	assert.Equal(t, 40.0, nb.Features.Continuous["first"]["barometer-pressure"].N)
+	assert.Equal(t, 40, nb.Features.Categories["ground"]["cell-tower"]["12"])
+
+	nb = New()
+	pl, err := nb.Classify(fingerprint("", 1012.62))
+	assert.Nil(t, err)
+	assert.Equal(t, "first", pl[0].Key)
+	assert.True(t, pl[0].Value > 0.9)
+	pl, err = nb.Classify(fingerprint("", 1012.98))
+	assert.Nil(t, err)
+	assert.Equal(t, "ground", pl[0].Key)
+
+	// a model learned before the features classifies with the signals
+	d, err := database.Open("testcontinuous")
+	assert.Nil(t, err)
+	_, err = d.DeleteKey("NB1Features")
+	assert.Nil(t, err)
+	d.Close()
+	nb = New()
+	pl, err = nb.Classify(fingerprint("", 1012.62))
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(pl))
+}
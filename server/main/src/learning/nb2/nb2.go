@@ -39,6 +39,9 @@ func (a *Algorithm) Fit(datas []models.SensorData) (err error) {
 		locationTotals[data.Location]++
 		for sensorType := range data.Sensors {
 			for sensor := range data.Sensors[sensorType] {
+				if !isScalar(data.Sensors[sensorType][sensor]) {
+					continue
+				}
 				mac := sensorType + "-" + sensor
 				if _, ok := a.Data[data.Location][mac]; !ok {
 					a.Data[data.Location][mac] = float64(0)
@@ -92,8 +95,12 @@ func (a *Algorithm) Classify(data models.SensorData) (pl PairList, err error) {
 	}
 	for sensorType := range data.Sensors {
 		for name := range data.Sensors[sensorType] {
+			value, errValue := models.ParseSensorValue(data.Sensors[sensorType][name])
+			if errValue != nil || value.Kind != models.ValueScalar {
+				continue
+			}
 			mac := sensorType + "-" + name
-			val := int(data.Sensors[sensorType][name].(float64))
+			val := int(value.Scalar)
 			for location := range Ps {
 				PA := a.probMacGivenLocation(mac, val, location, true)
 				PnotA := a.probMacGivenLocation(mac, val, location, false)
@@ -137,6 +144,13 @@ func (p PairList) Len() int           { return len(p) }
 func (p PairList) Less(i, j int) bool { return p[i].Value < p[j].Value }
 func (p PairList) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
 
+// isScalar is whether a value is a signal strength or another number, the vectors and the
+// categories of the other sensors are not learned by NB2
+func isScalar(value interface{}) bool {
+	v, err := models.ParseSensorValue(value)
+	return err == nil && v.Kind == models.ValueScalar
+}
+
 func (a *Algorithm) probMacGivenLocation(mac string, val int, loc string, positive bool) (P float64) {
 	P = 0.005
 
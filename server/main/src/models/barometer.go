@@ -0,0 +1,69 @@
+package models
+
+/*
+This code defines the BarometerReference structure, the barometer that stays in the building of a family. The air
+pressure tells the floors apart, about 0.4 hPa each, but it drifts with the weather by several hPa, so the pressures
+measured by the other devices are made relative to the pressure of the reference before they are learned.
+
+Device: the reference device, whose own data keeps the absolute pressure.
+Pressures: the latest pressure of the reference, by the name of the value ("pressure").
+Timestamp: when the reference last measured it, in milliseconds.
+*/
+
+import (
+	"math"
+)
+
+// RelativeSuffix ends the name of a pressure that was made relative to the reference
+const RelativeSuffix = "-relative"
+
+// BarometerReference is the barometer the pressures of a family are relative to
+type BarometerReference struct {
+	Device    string             `json:"device"`
+	Pressures map[string]float64 `json:"pressures,omitempty"`
+	Timestamp int64              `json:"timestamp,omitempty"`
+}
+
+// Read keeps the pressures of the reference in its sensor data, and returns whether there were any
+// newer than the ones it has
+func (r *BarometerReference) Read(s SensorData) bool {
+	if s.Device != r.Device || s.Timestamp < r.Timestamp {
+		return false
+	}
+	pressures := make(map[string]float64)
+	for name, value := range s.Sensors["barometer"] {
+		if v, err := ParseSensorValue(value); err == nil && v.Kind == ValueScalar {
+			pressures[name] = v.Scalar
+		}
+	}
+	if len(pressures) == 0 {
+		return false
+	}
+	r.Pressures = pressures
+	r.Timestamp = s.Timestamp
+	return true
+}
+
+// Apply replaces the pressures of the sensor data with their difference to the pressures of the
+// reference, under the name followed by RelativeSuffix, rounded to 0.01 hPa. The pressures are
+// left as they are when the reference was not measured within maxAge milliseconds of the data.
+func (r BarometerReference) Apply(s *SensorData, maxAge int64) {
+	if r.Device == "" || s.Device == r.Device || len(r.Pressures) == 0 {
+		return
+	}
+	if age := s.Timestamp - r.Timestamp; age > maxAge || age < -maxAge {
+		return
+	}
+	for name, value := range s.Sensors["barometer"] {
+		reference, ok := r.Pressures[name]
+		if !ok {
+			continue
+		}
+		v, err := ParseSensorValue(value)
+		if err != nil || v.Kind != ValueScalar {
+			continue
+		}
+		delete(s.Sensors["barometer"], name)
+		s.Sensors["barometer"][name+RelativeSuffix] = math.Round((v.Scalar-reference)*100) / 100
+	}
+}
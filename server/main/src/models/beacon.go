@@ -0,0 +1,137 @@
+package models
+
+/*
+This code defines the BLE beacons of a family. Beacons change their MAC address, so their signals are sent under the
+"beacon" sensor type, keyed by the identity the beacon advertises instead:
+
+	ibeacon:UUID:MAJOR:MINOR            ibeacon:f7826da6-4fa2-4e98-8024-bc5b71e0893e:100:2
+	eddystone:NAMESPACE:INSTANCE        eddystone:edd1ebeac04e5defa017:0123456789ab
+
+The advertisement itself can be sent instead, in hex: the manufacturer data of an iBeacon (4c000215 followed by the
+UUID, major, minor and measured power) or the service data of an Eddystone-UID frame (aafe00 followed by the measured
+power, namespace and instance). Validate rewrites the keys into identities, and drops the ones that are not beacons.
+
+A Beacon places an identity on a floor, like an access point of trilateration (see trilateration.go). Its TxPower is the
+measured power it advertises, the signal at one meter.
+*/
+
+import (
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"strings"
+)
+
+const (
+	// SensorBeacon is the sensor type of the BLE beacons, keyed by their identity
+	SensorBeacon = "beacon"
+	// BeaconIBeacon and BeaconEddystone are the prefixes of the identities
+	BeaconIBeacon   = "ibeacon"
+	BeaconEddystone = "eddystone"
+)
+
+// Beacon is a BLE beacon of a family at a known place
+type Beacon struct {
+	Identity         string  `json:"identity"`
+	Name             string  `json:"name,omitempty"`
+	Floor            string  `json:"floor"`
+	X                float64 `json:"x"`
+	Y                float64 `json:"y"`
+	Unit             string  `json:"unit"`
+	TxPower          float64 `json:"tx_power,omitempty"`
+	PathLossExponent float64 `json:"path_loss_exponent,omitempty"`
+}
+
+// AccessPoint returns the beacon as an access point of trilateration
+func (b Beacon) AccessPoint() AccessPoint {
+	return AccessPoint{
+		Sensor:           SensorBeacon,
+		Mac:              b.Identity,
+		Floor:            b.Floor,
+		X:                b.X,
+		Y:                b.Y,
+		Unit:             b.Unit,
+		TxPower:          b.TxPower,
+		PathLossExponent: b.PathLossExponent,
+	}
+}
+
+// ParseBeacon returns the identity of a beacon, from an identity written in any case or from its
+// advertisement in hex
+func ParseBeacon(key string) (identity string, err error) {
+	key = strings.TrimSpace(strings.ToLower(key))
+	parts := strings.Split(key, ":")
+	switch {
+	case parts[0] == BeaconIBeacon && len(parts) == 4:
+		return iBeaconIdentity(strings.Replace(parts[1], "-", "", -1), parts[2], parts[3])
+	case parts[0] == BeaconEddystone && len(parts) == 3:
+		return eddystoneIdentity(parts[1], parts[2])
+	case len(parts) == 1:
+		return parseAdvertisement(strings.TrimPrefix(key, "0x"))
+	}
+	return "", errors.New("'" + key + "' is not a beacon")
+}
+
+// parseAdvertisement reads the identity from the manufacturer data of an iBeacon or the service
+// data of an Eddystone-UID frame
+func parseAdvertisement(data string) (identity string, err error) {
+	b, err := hex.DecodeString(data)
+	if err != nil {
+		return "", errors.New("'" + data + "' is not a beacon")
+	}
+	// the company of Apple and the type and length of an iBeacon
+	if len(b) == 25 && b[0] == 0x4c && b[1] == 0x00 && b[2] == 0x02 && b[3] == 0x15 {
+		return iBeaconIdentity(hex.EncodeToString(b[4:20]),
+			strconv.Itoa(int(b[20])<<8|int(b[21])), strconv.Itoa(int(b[22])<<8|int(b[23])))
+	}
+	// the service of Eddystone and the frame type of UID, the two bytes at the end are reserved
+	if (len(b) == 20 || len(b) == 22) && b[0] == 0xaa && b[1] == 0xfe && b[2] == 0x00 {
+		return eddystoneIdentity(hex.EncodeToString(b[4:14]), hex.EncodeToString(b[14:20]))
+	}
+	return "", errors.New("'" + data + "' is not a beacon")
+}
+
+func iBeaconIdentity(uuid, major, minor string) (identity string, err error) {
+	if _, errHex := hex.DecodeString(uuid); errHex != nil || len(uuid) != 32 {
+		return "", errors.New("'" + uuid + "' is not an iBeacon UUID")
+	}
+	numbers := []string{major, minor}
+	for i, s := range numbers {
+		n, errNumber := strconv.ParseUint(s, 10, 16)
+		if errNumber != nil {
+			return "", errors.New("'" + s + "' is not an iBeacon major or minor")
+		}
+		numbers[i] = strconv.FormatUint(n, 10)
+	}
+	uuid = uuid[0:8] + "-" + uuid[8:12] + "-" + uuid[12:16] + "-" + uuid[16:20] + "-" + uuid[20:32]
+	return BeaconIBeacon + ":" + uuid + ":" + numbers[0] + ":" + numbers[1], nil
+}
+
+func eddystoneIdentity(namespace, instance string) (identity string, err error) {
+	if _, errHex := hex.DecodeString(namespace); errHex != nil || len(namespace) != 20 {
+		return "", errors.New("'" + namespace + "' is not an Eddystone namespace")
+	}
+	if _, errHex := hex.DecodeString(instance); errHex != nil || len(instance) != 12 {
+		return "", errors.New("'" + instance + "' is not an Eddystone instance")
+	}
+	return BeaconEddystone + ":" + namespace + ":" + instance, nil
+}
+
+// normalizeBeacons rewrites the keys of beacon signals into identities, keeping the strongest
+// signal of an identity sent twice, and drops the keys that are not beacons
+func normalizeBeacons(signals map[string]interface{}) map[string]interface{} {
+	normalized := make(map[string]interface{})
+	for key, value := range signals {
+		identity, err := ParseBeacon(key)
+		if err != nil {
+			continue
+		}
+		if previous, ok := normalized[identity].(float64); ok {
+			if rssi, isFloat := value.(float64); !isFloat || rssi < previous {
+				continue
+			}
+		}
+		normalized[identity] = value
+	}
+	return normalized
+}
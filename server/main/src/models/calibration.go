@@ -0,0 +1,13 @@
+package models
+
+// CalibrationSummary is the accuracy of a family after calibration, as measured
+// on the data that was held out of the learning
+type CalibrationSummary struct {
+	Family    string             `json:"family"`
+	Timestamp int64              `json:"timestamp"`
+	Samples   int                `json:"samples"`
+	Accuracy  float64            `json:"accuracy"`
+	Locations map[string]float64 `json:"locations"`
+	// Levels is the accuracy of the sites, buildings and floors (see LocationHierarchy)
+	Levels map[string]map[string]float64 `json:"levels,omitempty"`
+}
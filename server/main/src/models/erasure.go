@@ -0,0 +1,61 @@
+package models
+
+/*
+This code defines the structures for the requests of data subjects: the export of everything stored about a device,
+and the audit log of the exports and erasures.
+
+DeviceExport is the data of one device of a family: its sensor data with the predictions made from it, its events,
+the GPS coordinates recorded with its data, its tracked positions, and its settings (the location it is learning in
+passive scanning, its RSSI offset and whether it is on the privacy allowlist).
+
+AuditEntry records an export or an erasure: when it was done, by whom (the address of the API client, or "cli"), and
+for an erasure the number of rows or entries removed from each table and keystore entry.
+*/
+
+import "time"
+
+const (
+	// AuditExport is the action of an export
+	AuditExport = "export"
+	// AuditErase is the action of an erasure
+	AuditErase = "erase"
+)
+
+// DeviceGPS is a GPS coordinate recorded with the sensor data of a device
+type DeviceGPS struct {
+	Timestamp int64  `json:"timestamp"`
+	Mac       string `json:"mac"`
+	Location  string `json:"location"`
+	GPS       GPS    `json:"gps"`
+}
+
+// DeviceSettings are the settings that name a device
+type DeviceSettings struct {
+	LearningLocation string  `json:"learning_location,omitempty"`
+	LearningGPS      GPS     `json:"learning_gps,omitempty"`
+	RSSIOffset       float64 `json:"rssi_offset,omitempty"`
+	Allowlisted      bool    `json:"allowlisted,omitempty"`
+}
+
+// DeviceExport is everything stored about a device
+type DeviceExport struct {
+	Family      string            `json:"family"`
+	Device      string            `json:"device"`
+	Exported    time.Time         `json:"exported"`
+	Sensors     []SensorData      `json:"sensors"`
+	Predictions []Prediction      `json:"predictions"`
+	Events      []Event           `json:"events"`
+	GPS         []DeviceGPS       `json:"gps"`
+	Positions   []TrackedPosition `json:"positions"`
+	Settings    DeviceSettings    `json:"settings"`
+}
+
+// AuditEntry is a record of an export or an erasure of a device
+type AuditEntry struct {
+	ID        int64            `json:"id"`
+	Timestamp int64            `json:"timestamp"`
+	Action    string           `json:"action"`
+	Device    string           `json:"device"`
+	Actor     string           `json:"actor"`
+	Counts    map[string]int64 `json:"counts,omitempty"`
+}
@@ -0,0 +1,64 @@
+package models
+
+/*
+This code defines the Event structure, which describes something that happened to a device, such as the best
+guess of its location changing. Events are pushed to the subscribers of a family (for example webhooks).
+
+Event contains the following fields:
+
+ID: the identifier of the event, when it is stored.
+Type: the kind of event, one of the Event* constants.
+Family, Device: who the event is about (the scanner, for the scanner events).
+Location: the current location of the device.
+PreviousLocation: the location of the device before the event, if it changed.
+Zone: the zone the event is about, for the zone events.
+Duration: the time the device has spent in the zone, in milliseconds, for the exit and dwell events.
+Probability: the probability of the current location.
+RSSI: the median signal of the scanner, for the scanner events.
+Timestamp: the time of the fingerprint that caused the event, in milliseconds.
+*/
+
+const (
+	// EventLocationChange is emitted when the best guess of a device changes location
+	EventLocationChange = "location_change"
+	// EventZoneEnter is emitted when a device moves to a location of a zone it was not in
+	EventZoneEnter = "zone_enter"
+	// EventZoneExit is emitted when a device moves to a location outside of a zone it was in
+	EventZoneExit = "zone_exit"
+	// EventZoneDwell is emitted once per visit, when a device stays in a zone longer than its dwell time
+	EventZoneDwell = "zone_dwell"
+	// EventScannerSilent is emitted when a passive scanner stops sending data
+	EventScannerSilent = "scanner_silent"
+	// EventScannerOnline is emitted when a silent passive scanner sends data again
+	EventScannerOnline = "scanner_online"
+	// EventScannerRSSIShift is emitted when the median signal of a passive scanner moves away from its baseline
+	EventScannerRSSIShift = "scanner_rssi_shift"
+)
+
+// EventTypes lists all the types of events
+var EventTypes = []string{EventLocationChange, EventZoneEnter, EventZoneExit, EventZoneDwell, EventScannerSilent, EventScannerOnline, EventScannerRSSIShift}
+
+// Event is something that happened to a device
+type Event struct {
+	ID               int64   `json:"id,omitempty"`
+	Type             string  `json:"type"`
+	Family           string  `json:"family"`
+	Device           string  `json:"device"`
+	Location         string  `json:"location,omitempty"`
+	PreviousLocation string  `json:"previous_location,omitempty"`
+	Zone             string  `json:"zone,omitempty"`
+	Duration         int64   `json:"duration,omitempty"`
+	Probability      float64 `json:"probability,omitempty"`
+	RSSI             float64 `json:"rssi,omitempty"`
+	Timestamp        int64   `json:"timestamp"`
+}
+
+// IsEventType returns whether the type is a known type of event
+func IsEventType(eventType string) bool {
+	for _, t := range EventTypes {
+		if t == eventType {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,63 @@
+package models
+
+/*
+This code defines the floorplans of a family and the coordinates of its locations on them.
+
+A Floorplan is an image of one floor. Its size is read from the image when it is uploaded. PixelsPerMeter is its
+scale: with a scale, the coordinates of the locations on the floor can be given in meters from the top left corner
+of the image, instead of in pixels.
+
+A LocationCoordinate places a location on a floor, at X and Y in pixels ("px", the default) or in meters ("m").
+*/
+
+import "time"
+
+const (
+	// UnitPixels is the unit of coordinates in pixels of the floorplan image
+	UnitPixels = "px"
+	// UnitMeters is the unit of coordinates in meters, which needs the scale of the floorplan
+	UnitMeters = "m"
+)
+
+// Floorplan is the image of a floor
+type Floorplan struct {
+	Floor          string    `json:"floor"`
+	ContentType    string    `json:"content_type"`
+	Width          int       `json:"width"`
+	Height         int       `json:"height"`
+	PixelsPerMeter float64   `json:"pixels_per_meter,omitempty"`
+	Uploaded       time.Time `json:"uploaded"`
+}
+
+// LocationCoordinate is the place of a location on a floor
+type LocationCoordinate struct {
+	Location string  `json:"location"`
+	Floor    string  `json:"floor"`
+	X        float64 `json:"x"`
+	Y        float64 `json:"y"`
+	Unit     string  `json:"unit"`
+}
+
+// Pixels returns the coordinate in pixels of the floorplan, ok is false when it is
+// in meters and the floorplan has no scale
+func (c LocationCoordinate) Pixels(f Floorplan) (x, y float64, ok bool) {
+	if c.Unit != UnitMeters {
+		return c.X, c.Y, true
+	}
+	if f.PixelsPerMeter <= 0 {
+		return
+	}
+	return c.X * f.PixelsPerMeter, c.Y * f.PixelsPerMeter, true
+}
+
+// Meters returns the coordinate in meters, ok is false when it is in pixels and
+// the floorplan has no scale
+func (c LocationCoordinate) Meters(f Floorplan) (x, y float64, ok bool) {
+	if c.Unit == UnitMeters {
+		return c.X, c.Y, true
+	}
+	if f.PixelsPerMeter <= 0 {
+		return
+	}
+	return c.X / f.PixelsPerMeter, c.Y / f.PixelsPerMeter, true
+}
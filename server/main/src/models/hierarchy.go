@@ -0,0 +1,54 @@
+package models
+
+/*
+This code defines the hierarchy of the locations of a family: every location can be a room of a floor, in a
+building, on a site. The names of each level only need to be unique within the level above, so the floors of
+different buildings are told apart by their key, "SITE/BUILDING/FLOOR".
+
+With two-stage classification (HierarchySettings.TwoStage), the floor of a fingerprint is classified first, and then
+the room among the rooms of that floor.
+*/
+
+import "strings"
+
+const (
+	// LevelSite is the level of the sites
+	LevelSite = "site"
+	// LevelBuilding is the level of the buildings of a site
+	LevelBuilding = "building"
+	// LevelFloor is the level of the floors of a building
+	LevelFloor = "floor"
+)
+
+// Levels are the levels above the rooms, from the top
+var Levels = []string{LevelSite, LevelBuilding, LevelFloor}
+
+// LocationHierarchy places a location in the hierarchy, Room is the name of the location within its floor
+type LocationHierarchy struct {
+	Location string `json:"location"`
+	Site     string `json:"site"`
+	Building string `json:"building"`
+	Floor    string `json:"floor"`
+	Room     string `json:"room"`
+}
+
+// HierarchySettings are the settings of the classification with the hierarchy
+type HierarchySettings struct {
+	TwoStage bool `json:"two_stage"`
+}
+
+// Key returns the name of the site, building or floor of the location, made unique with
+// the levels above it. It is empty when the location is not placed at that level.
+func (h LocationHierarchy) Key(level string) string {
+	names := []string{h.Site, h.Building, h.Floor}
+	for i, l := range Levels {
+		if l != level {
+			continue
+		}
+		if names[i] == "" {
+			return ""
+		}
+		return strings.Join(names[:i+1], "/")
+	}
+	return ""
+}
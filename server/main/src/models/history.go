@@ -0,0 +1,27 @@
+package models
+
+/*
+This code defines the structures for the location history of a device.
+
+Prediction is the stored guess of the location of a Device at the Timestamp (in milliseconds) of a fingerprint.
+
+Visit is a run of consecutive predictions with the same best guess. Arrival and Departure are the timestamps of the
+first and the last prediction of the run, Probability is the mean probability of the best guess over the run and
+Count is the number of predictions in it.
+*/
+
+// Prediction is the guess of the location of a device at a time
+type Prediction struct {
+	Device    string               `json:"device,omitempty"`
+	Timestamp int64                `json:"timestamp"`
+	Guesses   []LocationPrediction `json:"guesses"`
+}
+
+// Visit is a stay of a device at a location
+type Visit struct {
+	Location    string  `json:"location"`
+	Arrival     int64   `json:"arrival"`
+	Departure   int64   `json:"departure"`
+	Probability float64 `json:"probability"`
+	Count       int     `json:"count"`
+}
@@ -0,0 +1,15 @@
+package models
+
+// HomeAssistant is whether a family is announced to Home Assistant with MQTT discovery,
+// and the discovery prefix Home Assistant listens to
+type HomeAssistant struct {
+	Enabled bool   `json:"enabled"`
+	Prefix  string `json:"prefix"`
+}
+
+// Presence is the retained message on FAMILY/presence/LOCATION, the devices whose best guess is the location
+type Presence struct {
+	Location string   `json:"location"`
+	Count    int      `json:"count"`
+	Devices  []string `json:"devices"`
+}
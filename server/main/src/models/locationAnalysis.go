@@ -33,6 +33,12 @@ type LocationAnalysis struct {
 	LocationNames map[string]string     `json:"location_names"`
 	Predictions   []AlgorithmPrediction `json:"predictions"`
 	Guesses       []LocationPrediction  `json:"guesses,omitempty"`
+	// Floors are the guesses of the floor with two-stage classification, the guesses
+	// of the locations are then only the ones on the best floor
+	Floors []LocationPrediction `json:"floors,omitempty"`
+	// Position is the place estimated by trilateration, when the family has access points
+	// at known places (see Trilateration)
+	Position *Position `json:"position,omitempty"`
 }
 
 type AlgorithmPrediction struct {
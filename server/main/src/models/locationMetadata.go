@@ -0,0 +1,25 @@
+package models
+
+/*
+This code defines the metadata of a location, a description and tags, and the result of renaming or merging a
+location or a device.
+
+A location or device is renamed when no other has the new name, and merged into the other one when it has (see
+Rename). Merging keeps the settings of the one merged into, where both have one.
+*/
+
+// LocationMetadata describes a location
+type LocationMetadata struct {
+	Location    string   `json:"location"`
+	Description string   `json:"description"`
+	Tags        []string `json:"tags"`
+}
+
+// Rename is a rename or merge of a location or device, Counts is the number of rows or
+// entries changed in each table and keystore entry
+type Rename struct {
+	From   string           `json:"from"`
+	To     string           `json:"to"`
+	Merged bool             `json:"merged"`
+	Counts map[string]int64 `json:"counts"`
+}
@@ -0,0 +1,48 @@
+package models
+
+/*
+This code defines the structures for the movement reports of a family.
+
+LocationDwell sums up the visits to a location: the number of Visits and the Total, Mean and Max time spent per
+visit, in milliseconds.
+
+TransitionMatrix counts the moves between locations. Counts[i][j] is the number of times a prediction at
+Locations[i] was followed by a prediction at Locations[j] for the same device, so the diagonal counts the times a
+device stayed put. The rows normalized by Probabilities are the chances of going from one location to the next,
+which can be used as the transition model for smoothing predictions.
+*/
+
+// LocationDwell is the time devices spend at a location
+type LocationDwell struct {
+	Location string `json:"location"`
+	Visits   int    `json:"visits"`
+	Total    int64  `json:"total"`
+	Mean     int64  `json:"mean"`
+	Max      int64  `json:"max"`
+}
+
+// TransitionMatrix counts the moves from one location to another
+type TransitionMatrix struct {
+	Locations []string `json:"locations"`
+	Counts    [][]int  `json:"counts"`
+}
+
+// Probabilities returns the matrix with each row normalized to sum to 1.
+// Rows without any transition are left as zeros.
+func (m TransitionMatrix) Probabilities() (p [][]float64) {
+	p = make([][]float64, len(m.Counts))
+	for i, row := range m.Counts {
+		p[i] = make([]float64, len(row))
+		total := 0
+		for _, count := range row {
+			total += count
+		}
+		if total == 0 {
+			continue
+		}
+		for j, count := range row {
+			p[i][j] = float64(count) / float64(total)
+		}
+	}
+	return
+}
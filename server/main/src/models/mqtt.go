@@ -0,0 +1,44 @@
+package models
+
+/*
+This code defines the MQTTCredential structure, a username and password that can connect to the MQTT broker for a
+family.
+
+Each family has a "default" credential, whose username is the family name, and can have more named credentials (for
+example one per scanner) whose username is "NAME@FAMILY". Only the salted hash of the password is stored, in the
+format of the mosquitto password file.
+
+Topics are the topic filters the credential may use, all within FAMILY/#, and Access is one of "readwrite", "read"
+(subscribe only) or "write" (publish only).
+*/
+
+import "time"
+
+// MQTTCredential is a login to the MQTT broker for a family
+type MQTTCredential struct {
+	Name     string    `json:"name"`
+	Family   string    `json:"family"`
+	Username string    `json:"username"`
+	Hash     string    `json:"hash,omitempty"`
+	Topics   []string  `json:"topics"`
+	Access   string    `json:"access"`
+	Created  time.Time `json:"created"`
+	Rotated  time.Time `json:"rotated"`
+}
+
+// CanRead returns whether the credential may subscribe
+func (c MQTTCredential) CanRead() bool {
+	return c.Access == "readwrite" || c.Access == "read"
+}
+
+// CanWrite returns whether the credential may publish
+func (c MQTTCredential) CanWrite() bool {
+	return c.Access == "readwrite" || c.Access == "write"
+}
+
+// DeviceStatus is the retained message on FAMILY/status/device/DEVICE
+type DeviceStatus struct {
+	Device    string `json:"device"`
+	Status    string `json:"status"`
+	Timestamp int64  `json:"timestamp"`
+}
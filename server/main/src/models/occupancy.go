@@ -0,0 +1,41 @@
+package models
+
+/*
+This code defines the structures for occupancy analytics, the number of unique devices seen at each location over
+intervals of time.
+
+OccupancyRollup is the stored count for one interval: the interval starts at Start and lasts Interval (both in
+milliseconds). Devices and RandomizedDevices count the unique devices seen anywhere during the interval, with a
+normal or a randomized MAC address, and Locations holds the same counts for each location. PseudoDevices counts the
+pseudo-devices the randomized addresses were linked to (see api.LinkRandomized), which is closer to the number of
+phones, and PseudoDeviceIDs are their IDs.
+
+Occupancy is what the API returns for an interval, where the randomized devices are already included (as
+pseudo-devices) or left out.
+*/
+
+// OccupancyCount is the number of unique devices
+type OccupancyCount struct {
+	Devices           int `json:"devices"`
+	RandomizedDevices int `json:"randomized_devices"`
+	PseudoDevices     int `json:"pseudo_devices"`
+}
+
+// OccupancyRollup is the number of unique devices at each location during an interval
+type OccupancyRollup struct {
+	Start           int64                     `json:"start"`
+	Interval        int64                     `json:"interval"`
+	Locations       map[string]OccupancyCount `json:"locations"`
+	PseudoDeviceIDs []string                  `json:"pseudo_device_ids,omitempty"`
+	OccupancyCount
+}
+
+// Occupancy is the number of unique devices at each location during an interval
+type Occupancy struct {
+	Start     int64          `json:"start"`
+	End       int64          `json:"end"`
+	Total     int            `json:"total"`
+	Locations map[string]int `json:"locations"`
+	// PseudoDevices are the IDs of the pseudo-devices, when the randomized devices are included
+	PseudoDevices []string `json:"pseudo_devices,omitempty"`
+}
@@ -0,0 +1,49 @@
+package models
+
+/*
+This code defines the RSSIOffsets structure, the corrections that make the signals of different phones and scanner
+hardware comparable. Phones and scanners report systematically different RSSI for the same signal, and the nb1
+classifier keys its model on the exact integer RSSI, so the data of a family is normalized with these offsets
+before it is saved and classified.
+
+Devices: the offset in dBm of each device, added to every signal in its data.
+Scanners: the offset in dBm of each passive scanner, added to the signals it reported (the "SCANNER-SENSOR" keys of
+passive data).
+*/
+
+import (
+	"math"
+	"strings"
+)
+
+// RSSIOffsets are the offsets of the devices and scanners of a family
+type RSSIOffsets struct {
+	Devices  map[string]float64 `json:"devices"`
+	Scanners map[string]float64 `json:"scanners"`
+}
+
+// Apply adds the offsets to the numeric signals of the sensor data, rounding them to whole dBm
+func (o RSSIOffsets) Apply(s *SensorData) {
+	deviceOffset := o.Devices[s.Device]
+	if deviceOffset == 0 && len(o.Scanners) == 0 {
+		return
+	}
+	for sensorType := range s.Sensors {
+		// the continuous measurements are not signal strengths
+		if ContinuousSensors[sensorType] {
+			continue
+		}
+		for mac, value := range s.Sensors[sensorType] {
+			offset := deviceOffset + o.Scanners[strings.TrimSuffix(mac, "-"+sensorType)]
+			if offset == 0 {
+				continue
+			}
+			switch v := value.(type) {
+			case float64:
+				s.Sensors[sensorType][mac] = math.Round(v + offset)
+			case int:
+				s.Sensors[sensorType][mac] = math.Round(float64(v) + offset)
+			}
+		}
+	}
+}
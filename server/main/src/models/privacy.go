@@ -0,0 +1,67 @@
+package models
+
+/*
+This code defines the Privacy structure, the privacy mode of a family. In privacy mode the devices seen by passive
+scanning, whose names are the MAC addresses of the neighbours ("wifi-60:57:18:3d:b8:14"), are stored under a keyed
+hash of the name instead, so the addresses themselves are never saved.
+
+Enabled: whether the privacy mode is on.
+Salt: the secret key of the hashes. It is never shown by the API.
+RotateEvery: the number of seconds after which the salt is replaced, 0 to keep it. After a rotation the same device
+gets a new hash, so it cannot be followed from one period to the next.
+Rotated: when the salt was last replaced.
+Allowlist: the devices that stay in clear text, for example staff badges, as the full name or only the address.
+*/
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"time"
+
+	"github.com/Nimaapr/find3/server/main/src/utils"
+)
+
+// Privacy is the privacy mode of a family
+type Privacy struct {
+	Enabled     bool      `json:"enabled"`
+	Salt        string    `json:"salt,omitempty"`
+	RotateEvery int64     `json:"rotate_every"`
+	Rotated     time.Time `json:"rotated"`
+	Allowlist   []string  `json:"allowlist"`
+}
+
+// Allowed returns whether a device stays in clear text
+func (p Privacy) Allowed(device string) bool {
+	device = strings.ToLower(device)
+	address := device[strings.Index(device, "-")+1:]
+	for _, allowed := range p.Allowlist {
+		allowed = strings.ToLower(strings.TrimSpace(allowed))
+		if allowed == device || allowed == address {
+			return true
+		}
+	}
+	return false
+}
+
+// DeviceID returns the name a device is stored under: "SENSOR-~HASH" in privacy mode,
+// or "SENSOR-~rHASH" when its address is randomized, unless the device is allowed,
+// and the name itself otherwise
+func (p Privacy) DeviceID(device string) string {
+	if !p.Enabled || p.Salt == "" || p.Allowed(device) {
+		return device
+	}
+	sensor := ""
+	if i := strings.Index(device, "-"); i >= 0 {
+		sensor = device[:i+1]
+	}
+	mac := hmac.New(sha256.New, []byte(p.Salt))
+	mac.Write([]byte(strings.ToLower(device)))
+	// keep the randomized address bit, for the by-location and occupancy counts
+	// and the linking of the randomized addresses
+	if utils.IsMacRandomized(device) {
+		return sensor + "~r" + hex.EncodeToString(mac.Sum(nil))[:20]
+	}
+	return sensor + "~" + hex.EncodeToString(mac.Sum(nil))[:20]
+}
@@ -1,12 +1,10 @@
 package models
 
 /*
-The code defines a Go data structure named "ReverseRollingData". This structure contains several fields:
+The code defines a Go data structure named "ReverseRollingData", the settings of the passive scanning of a family
+(the data itself is aggregated in memory, see server/passive.go). This structure contains several fields:
 
-HasData: a boolean field indicating whether the structure has data.
 Family: a string field that holds a string value representing the family name.
-Datas: an array of SensorData structures, representing the data.
-Timestamp: a time.Time field that holds the timestamp for the data.
 TimeBlock: a time.Duration field representing the duration of a time block.
 MinimumPassive: an integer field representing the minimum passive value.
 DeviceLocation: a map field that maps device names to location names.
@@ -19,10 +17,7 @@ import (
 )
 
 type ReverseRollingData struct {
-	HasData        bool
 	Family         string
-	Datas          []SensorData
-	Timestamp      time.Time
 	TimeBlock      time.Duration
 	MinimumPassive int
 	DeviceLocation map[string]string // Device -> Location for learning
@@ -0,0 +1,39 @@
+package models
+
+/*
+This code defines the Scanner structure, a computer that does passive scanning for a family and sends the signals
+of the devices around it to /passive.
+
+Name: the name of the scanner, the device of the passive data it sends.
+Location: where the scanner is placed, set by hand.
+FirstSeen, LastSeen: the times of its first and last passive data (LastSeen is its heartbeat).
+Fingerprints: the number of signals it has sent.
+Rate: the number of signals per minute it has sent recently.
+MedianRSSI: the median signal of the devices it has seen recently.
+BaselineRSSI: the median signal when the scanner was set up, to notice when it is moved or its antenna changes.
+Status: ScannerOnline, or ScannerSilent when it has not sent anything for a while.
+Shifted: whether the median signal has moved away from the baseline.
+*/
+
+import "time"
+
+const (
+	// ScannerOnline is the status of a scanner that is sending data
+	ScannerOnline = "online"
+	// ScannerSilent is the status of a scanner that stopped sending data
+	ScannerSilent = "silent"
+)
+
+// Scanner is a computer that does passive scanning
+type Scanner struct {
+	Name         string    `json:"name"`
+	Location     string    `json:"location,omitempty"`
+	FirstSeen    time.Time `json:"first_seen"`
+	LastSeen     time.Time `json:"last_seen"`
+	Fingerprints int64     `json:"fingerprints"`
+	Rate         float64   `json:"rate"`
+	MedianRSSI   float64   `json:"median_rssi"`
+	BaselineRSSI float64   `json:"baseline_rssi"`
+	Status       string    `json:"status"`
+	Shifted      bool      `json:"shifted"`
+}
@@ -33,6 +33,7 @@ Rssi (an int)
 
 The SensorData struct has a method named Validate that validates that the fingerprint is okay. It checks if the Family, Device, and Timestamp fields are not empty, if the Timestamp is valid, and if the Sensors data is not empty.
 If the Timestamp is equal to 0, the method sets it to the current time in UTC in milliseconds.
+The signals of the "beacon" sensor type are keyed by the identities of the beacons (see beacon.go), and the values have to be numbers, lists of numbers or texts (see sensorValue.go).
 
 The FINDFingerprint struct has a method named Convert that converts it into a SensorData struct.
 */
@@ -81,9 +82,17 @@ func (d *SensorData) Validate() (err error) {
 	if d.Timestamp == 0 {
 		d.Timestamp = time.Now().UTC().UnixNano() / int64(time.Millisecond)
 	}
+	if beacons, ok := d.Sensors[SensorBeacon]; ok {
+		d.Sensors[SensorBeacon] = normalizeBeacons(beacons)
+	}
 	numFingerprints := 0
 	for sensorType := range d.Sensors {
 		numFingerprints += len(d.Sensors[sensorType])
+		for name, value := range d.Sensors[sensorType] {
+			if _, errValue := ParseSensorValue(value); errValue != nil {
+				err = errors.New("value of " + sensorType + "-" + name + " is not valid: " + errValue.Error())
+			}
+		}
 	}
 	if numFingerprints == 0 {
 		err = errors.New("sensor data cannot be empty")
@@ -131,3 +131,86 @@ func TestBackwards(t *testing.T) {
 	assert.Equal(t, p, d)
 	fmt.Println(d)
 }
+
+func TestBeacons(t *testing.T) {
+	j := `{
+  "d": "device1",
+  "f": "daimler",
+  "t": 1520424248897,
+  "s": {
+    "beacon": {
+      "iBeacon:F7826DA64FA24E988024BC5B71E0893E:100:02": -70,
+      "4c000215f7826da64fa24e988024bc5b71e0893e00640002c5": -62,
+      "aafe00ebedd1ebeac04e5defa0170123456789ab0000": -80,
+      "eddystone:edd1ebeac04e5defa017:00000000000a": -85,
+      "20:25:64:b7:91:42": -60,
+      "ibeacon:f7826da6:1:2": -60
+    }
+  }
+}
+`
+	var p SensorData
+	assert.Nil(t, json.Unmarshal([]byte(j), &p))
+	assert.Nil(t, p.Validate())
+	assert.Equal(t, map[string]interface{}{
+		"ibeacon:f7826da6-4fa2-4e98-8024-bc5b71e0893e:100:2": -62.0,
+		"eddystone:edd1ebeac04e5defa017:0123456789ab":        -80.0,
+		"eddystone:edd1ebeac04e5defa017:00000000000a":        -85.0,
+	}, p.Sensors["beacon"])
+
+	// validating again changes nothing
+	assert.Nil(t, p.Validate())
+	assert.Equal(t, 3, len(p.Sensors["beacon"]))
+
+	_, err := ParseBeacon("ibeacon:f7826da6-4fa2-4e98-8024-bc5b71e0893e:70000:1")
+	assert.NotNil(t, err)
+	_, err = ParseBeacon("eddystone:edd1ebeac04e5defa017:0123")
+	assert.NotNil(t, err)
+
+	p.Sensors["beacon"] = map[string]interface{}{"not a beacon": -50}
+	assert.NotNil(t, p.Validate())
+}
+
+func TestSensorValues(t *testing.T) {
+	j := `{
+  "d": "device1",
+  "f": "daimler",
+  "s": {
+    "wifi": {"20:25:64:b7:91:40": -73},
+    "barometer": {"pressure": 1013.25},
+    "magnetometer": {"field": [3, 4, 12]},
+    "cell": {"tower": " Tower-12 ", "roaming": false}
+  }
+}
+`
+	var p SensorData
+	assert.Nil(t, json.Unmarshal([]byte(j), &p))
+	assert.Nil(t, p.Validate())
+
+	v, err := ParseSensorValue(p.Sensors["wifi"]["20:25:64:b7:91:40"])
+	assert.Nil(t, err)
+	assert.Equal(t, ValueScalar, v.Kind)
+	assert.False(t, v.Continuous("wifi"))
+	v, err = ParseSensorValue(p.Sensors["barometer"]["pressure"])
+	assert.Nil(t, err)
+	assert.True(t, v.Continuous("barometer"))
+	v, err = ParseSensorValue(p.Sensors["magnetometer"]["field"])
+	assert.Nil(t, err)
+	assert.Equal(t, ValueVector, v.Kind)
+	assert.Equal(t, 13.0, v.Scalar)
+	assert.True(t, v.Continuous("magnetometer"))
+	v, err = ParseSensorValue(p.Sensors["cell"]["tower"])
+	assert.Nil(t, err)
+	assert.Equal(t, SensorValue{Kind: ValueCategorical, Category: "tower-12"}, v)
+
+	n := p.Numeric()
+	assert.Equal(t, 13.0, n.Sensors["magnetometer"]["field"])
+	assert.Equal(t, 1.0, n.Sensors["cell"]["tower=tower-12"])
+	assert.Equal(t, 1.0, n.Sensors["cell"]["roaming=false"])
+	assert.Equal(t, []interface{}{3.0, 4.0, 12.0}, p.Sensors["magnetometer"]["field"])
+
+	p.Sensors["magnetometer"]["field"] = []interface{}{3.0, "north"}
+	assert.NotNil(t, p.Validate())
+	p.Sensors["magnetometer"]["field"] = map[string]interface{}{"x": 3.0}
+	assert.NotNil(t, p.Validate())
+}
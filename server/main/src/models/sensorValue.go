@@ -0,0 +1,117 @@
+package models
+
+/*
+This code reads the values of sensor data into typed values. The values of most sensor types are signal strengths
+(RSSI), but a fingerprint can carry other measurements:
+
+- a scalar is a number, the signal strength of a transmitter or a continuous measurement like the air pressure,
+- a vector is a list of numbers, like the magnetic field, and is learned by its magnitude, which does not depend on how
+  the phone is held,
+- a categorical value is a text (or true or false), like the name of the cell tower a phone is on.
+
+The scalars of the sensor types in ContinuousSensors are continuous measurements, the ones of the other sensor types are
+signal strengths. Validate rejects the values that are none of these.
+*/
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+)
+
+const (
+	// ValueScalar is a number
+	ValueScalar = "scalar"
+	// ValueVector is a list of numbers
+	ValueVector = "vector"
+	// ValueCategorical is a text
+	ValueCategorical = "categorical"
+)
+
+// ContinuousSensors are the sensor types whose scalars are continuous measurements, and not
+// signal strengths
+var ContinuousSensors = map[string]bool{
+	"barometer":    true,
+	"magnetometer": true,
+	"temperature":  true,
+	"humidity":     true,
+	"light":        true,
+}
+
+// SensorValue is a typed value of sensor data. Scalar is the number of a scalar, or the
+// magnitude of a vector.
+type SensorValue struct {
+	Kind     string
+	Scalar   float64
+	Vector   []float64
+	Category string
+}
+
+// ParseSensorValue returns the typed value of a value of sensor data
+func ParseSensorValue(value interface{}) (v SensorValue, err error) {
+	switch x := value.(type) {
+	case float64:
+		return SensorValue{Kind: ValueScalar, Scalar: x}, nil
+	case float32:
+		return SensorValue{Kind: ValueScalar, Scalar: float64(x)}, nil
+	case int:
+		return SensorValue{Kind: ValueScalar, Scalar: float64(x)}, nil
+	case int64:
+		return SensorValue{Kind: ValueScalar, Scalar: float64(x)}, nil
+	case string:
+		return SensorValue{Kind: ValueCategorical, Category: strings.TrimSpace(strings.ToLower(x))}, nil
+	case bool:
+		return SensorValue{Kind: ValueCategorical, Category: fmt.Sprint(x)}, nil
+	case []float64:
+		return vectorValue(x), nil
+	case []interface{}:
+		vector := make([]float64, len(x))
+		for i, component := range x {
+			c, errComponent := ParseSensorValue(component)
+			if errComponent != nil || c.Kind != ValueScalar {
+				return v, errors.New("vectors can only have numbers")
+			}
+			vector[i] = c.Scalar
+		}
+		return vectorValue(vector), nil
+	}
+	return v, fmt.Errorf("%v is not a number, a list of numbers or a text", value)
+}
+
+func vectorValue(vector []float64) (v SensorValue) {
+	v = SensorValue{Kind: ValueVector, Vector: vector}
+	for _, component := range vector {
+		v.Scalar += component * component
+	}
+	v.Scalar = math.Sqrt(v.Scalar)
+	return
+}
+
+// Continuous returns whether the value of a sensor type is a continuous measurement
+func (v SensorValue) Continuous(sensorType string) bool {
+	return v.Kind == ValueVector || (v.Kind == ValueScalar && ContinuousSensors[sensorType])
+}
+
+// Numeric returns a copy of the sensor data with only numbers, for the learning that only takes
+// numbers: a vector is its magnitude, and a categorical value is the number 1 under the name of
+// the sensor followed by "=" and the value.
+func (d SensorData) Numeric() (n SensorData) {
+	n = d
+	n.Sensors = make(map[string]map[string]interface{}, len(d.Sensors))
+	for sensorType := range d.Sensors {
+		n.Sensors[sensorType] = make(map[string]interface{}, len(d.Sensors[sensorType]))
+		for name, value := range d.Sensors[sensorType] {
+			v, err := ParseSensorValue(value)
+			if err != nil {
+				continue
+			}
+			if v.Kind == ValueCategorical {
+				n.Sensors[sensorType][name+"="+v.Category] = 1.0
+			} else {
+				n.Sensors[sensorType][name] = v.Scalar
+			}
+		}
+	}
+	return
+}
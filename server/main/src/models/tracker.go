@@ -0,0 +1,68 @@
+package models
+
+/*
+This code defines the structures of the tracking of devices on the floorplans: the walkable areas of the floors, and
+the positions of the devices estimated by their particle filters (see api.Track).
+
+The walkable areas of a floor are polygons, in pixels of its floorplan or in meters like the coordinates of the
+locations. The devices can only move inside them, so that a track does not go through walls. A floor without
+walkable areas is walkable everywhere.
+*/
+
+// Point is a corner of a walkable area
+type Point struct {
+	X float64 `json:"x"`
+	Y float64 `json:"y"`
+}
+
+// WalkableArea is the walkable part of a floor, made of polygons
+type WalkableArea struct {
+	Floor    string    `json:"floor"`
+	Unit     string    `json:"unit"`
+	Polygons [][]Point `json:"polygons"`
+}
+
+// TrackedPosition is the position of a device estimated by its particle filter, in meters on a
+// floor. Uncertainty is the standard deviation of the particles around it, in meters, and
+// Location is the nearest location on the floor.
+type TrackedPosition struct {
+	Timestamp   int64   `json:"timestamp,omitempty"`
+	Floor       string  `json:"floor"`
+	X           float64 `json:"x"`
+	Y           float64 `json:"y"`
+	Uncertainty float64 `json:"uncertainty"`
+	Location    string  `json:"location,omitempty"`
+}
+
+// Meters returns the polygons of the area in meters, ok is false when they are in pixels and the
+// floorplan has no scale
+func (w WalkableArea) Meters(f Floorplan) (polygons [][]Point, ok bool) {
+	polygons = make([][]Point, len(w.Polygons))
+	for i, polygon := range w.Polygons {
+		polygons[i] = make([]Point, len(polygon))
+		for j, p := range polygon {
+			polygons[i][j].X, polygons[i][j].Y, ok = LocationCoordinate{X: p.X, Y: p.Y, Unit: w.Unit}.Meters(f)
+			if !ok {
+				return nil, false
+			}
+		}
+	}
+	return polygons, true
+}
+
+// Inside returns whether a point is inside one of the polygons
+func Inside(polygons [][]Point, x, y float64) bool {
+	for _, polygon := range polygons {
+		inside := false
+		for i, j := 0, len(polygon)-1; i < len(polygon); j, i = i, i+1 {
+			a, b := polygon[i], polygon[j]
+			if (a.Y > y) != (b.Y > y) && x < (b.X-a.X)*(y-a.Y)/(b.Y-a.Y)+a.X {
+				inside = !inside
+			}
+		}
+		if inside {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,74 @@
+package models
+
+/*
+This code defines the structures of trilateration: the access points (or beacons) of a family at known places on its
+floors, the path loss model of their signals, and the position estimated from the signals of a fingerprint.
+
+The distance to an access point is estimated from its signal with the log-distance path loss model,
+
+	rssi = TxPower - 10 * PathLossExponent * log10(distance)
+
+where TxPower is the signal at one meter. Each access point can have its own TxPower and PathLossExponent, or use the
+ones of the family. Positions are in meters from the top left corner of the floorplan of their floor.
+*/
+
+import "math"
+
+const (
+	// DefaultTxPower is the signal at one meter of an access point, in dBm
+	DefaultTxPower = -40
+	// DefaultPathLossExponent is the path loss exponent indoors, 2 is free space
+	DefaultPathLossExponent = 3
+)
+
+// AccessPoint is a transmitter at a known place, its signals are the ones of Sensor ("wifi" or
+// "bluetooth") with the name Mac
+type AccessPoint struct {
+	Sensor           string  `json:"sensor"`
+	Mac              string  `json:"mac"`
+	Floor            string  `json:"floor"`
+	X                float64 `json:"x"`
+	Y                float64 `json:"y"`
+	Unit             string  `json:"unit"`
+	TxPower          float64 `json:"tx_power,omitempty"`
+	PathLossExponent float64 `json:"path_loss_exponent,omitempty"`
+}
+
+// Trilateration is the path loss model and the access points of a family
+type Trilateration struct {
+	TxPower          float64                `json:"tx_power"`
+	PathLossExponent float64                `json:"path_loss_exponent"`
+	AccessPoints     map[string]AccessPoint `json:"access_points"`
+}
+
+// Position is a place estimated by trilateration, in meters on a floor, with the root mean
+// square of the differences of the distances to the access points it was estimated from.
+// Location is the nearest location on the floor, Distance is how far it is.
+type Position struct {
+	Floor        string  `json:"floor"`
+	X            float64 `json:"x"`
+	Y            float64 `json:"y"`
+	Error        float64 `json:"error"`
+	AccessPoints int     `json:"access_points"`
+	Location     string  `json:"location,omitempty"`
+	Distance     float64 `json:"distance,omitempty"`
+}
+
+// Meters returns the place of the access point in meters, ok is false when it is in pixels
+// and the floorplan has no scale
+func (a AccessPoint) Meters(f Floorplan) (x, y float64, ok bool) {
+	return LocationCoordinate{Floor: a.Floor, X: a.X, Y: a.Y, Unit: a.Unit}.Meters(f)
+}
+
+// Distance returns the distance in meters to the access point estimated from its signal, with
+// the path loss model of the family where the access point has none
+func (a AccessPoint) Distance(rssi float64, t Trilateration) float64 {
+	txPower, exponent := a.TxPower, a.PathLossExponent
+	if txPower == 0 {
+		txPower = t.TxPower
+	}
+	if exponent <= 0 {
+		exponent = t.PathLossExponent
+	}
+	return math.Pow(10, (txPower-rssi)/(10*exponent))
+}
@@ -0,0 +1,56 @@
+package models
+
+/*
+This code defines the structures for webhooks, which push events of a family to an external HTTP endpoint.
+
+Webhook is a subscription of a family. Events are posted as JSON to the URL, signed with HMAC-SHA256 using the Secret.
+Events, Devices and Locations are optional filters, an empty filter matches everything.
+
+WebhookDelivery is a single attempt at posting an event to a webhook, as it is stored in the delivery log.
+*/
+
+import "time"
+
+// Webhook is a subscription to the events of a family
+type Webhook struct {
+	ID        string    `json:"id"`
+	URL       string    `json:"url"`
+	Secret    string    `json:"secret,omitempty"`
+	Events    []string  `json:"events,omitempty"`
+	Devices   []string  `json:"devices,omitempty"`
+	Locations []string  `json:"locations,omitempty"`
+	Created   time.Time `json:"created"`
+}
+
+// WebhookDelivery is an attempt at delivering an event to a webhook
+type WebhookDelivery struct {
+	ID         int64  `json:"id"`
+	WebhookID  string `json:"webhook_id"`
+	Delivery   string `json:"delivery"`
+	Event      string `json:"event"`
+	Timestamp  int64  `json:"timestamp"`
+	Attempt    int    `json:"attempt"`
+	StatusCode int    `json:"status_code"`
+	Success    bool   `json:"success"`
+	Error      string `json:"error,omitempty"`
+	Payload    string `json:"payload"`
+}
+
+// Matches returns whether the event passes the filters of the webhook
+func (w Webhook) Matches(e Event) bool {
+	return matchesFilter(w.Events, e.Type) &&
+		matchesFilter(w.Devices, e.Device) &&
+		(matchesFilter(w.Locations, e.Location) || (e.PreviousLocation != "" && matchesFilter(w.Locations, e.PreviousLocation)))
+}
+
+func matchesFilter(filter []string, value string) bool {
+	if len(filter) == 0 {
+		return true
+	}
+	for _, f := range filter {
+		if f == value {
+			return true
+		}
+	}
+	return false
+}
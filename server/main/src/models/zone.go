@@ -0,0 +1,35 @@
+package models
+
+/*
+This code defines the Zone structure, which groups locations of a family under a name (for example "lab wing" for
+lab1, lab2 and corridor3) so that devices can be followed at a coarser level than single locations.
+
+A device is in a zone when its best guess is one of the Locations of the zone. Moving into or out of a zone emits the
+zone_enter and zone_exit events, and staying longer than DwellTime emits a zone_dwell event (see Event).
+
+Zone contains the following fields:
+
+Name: the name of the zone, unique within the family.
+Locations: the locations that make up the zone. A location can be in more than one zone.
+DwellTime: the number of seconds a device may stay in the zone before a zone_dwell event, 0 disables it.
+*/
+
+import "time"
+
+// Zone is a named group of locations
+type Zone struct {
+	Name      string    `json:"name"`
+	Locations []string  `json:"locations"`
+	DwellTime int64     `json:"dwell_time,omitempty"`
+	Created   time.Time `json:"created"`
+}
+
+// Contains returns whether the location is part of the zone
+func (z Zone) Contains(location string) bool {
+	for _, l := range z.Locations {
+		if l == location {
+			return true
+		}
+	}
+	return false
+}
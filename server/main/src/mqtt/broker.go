@@ -0,0 +1,480 @@
+package mqtt
+
+/*
+This code implements a small MQTT 3.1.1 broker that runs inside the server, so that FIND can be used over MQTT without
+installing and managing mosquitto. It is used instead of mosquitto when Embedded is set.
+
+Clients are authenticated with the same credentials that are written to the mosquitto password file: the admin user
+and password, and the credentials of the families in the "mosquitto" database (see credentials.go). The database is
+read on every connection, so new credentials can log in right away without reloading anything.
+
+Each user gets the topic filters it may read and write (its ACL), the same as in the mosquitto acl file: "#" for the
+admin, and the topics of the credential otherwise. Publishing to a topic outside of the ACL is silently dropped
+(MQTT 3.1.1 has no way to refuse a publish), and subscribing to a filter that is not covered by the ACL is refused in
+the SUBACK.
+
+The broker supports QoS 0 and 1. Messages published with QoS 2 are acknowledged as QoS 2 but delivered with at most
+QoS 1, and subscriptions are granted at most QoS 1. Messages are not queued for clients that are offline. Retained
+messages and last wills are supported.
+*/
+
+import (
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+	"github.com/pkg/errors"
+)
+
+// Embedded sets whether to run the MQTT broker in the server instead of using mosquitto
+var Embedded = false
+
+// ACL is the topic filters a user may subscribe to (Read) and publish to (Write)
+type ACL struct {
+	Read  []string
+	Write []string
+}
+
+// Authenticator returns the ACL of a user, and whether the password is correct
+type Authenticator func(username, password string) (acl ACL, ok bool)
+
+// Broker is an MQTT broker
+type Broker struct {
+	Authenticate Authenticator
+	listener     net.Listener
+	clients      map[string]*brokerClient
+	retained     map[string]*packets.PublishPacket
+	closed       bool
+	sync.Mutex
+}
+
+type brokerClient struct {
+	id            string
+	username      string
+	acl           ACL
+	conn          net.Conn
+	subscriptions map[string]byte
+	will          *packets.PublishPacket
+	nextID        uint16
+	sync.Mutex
+}
+
+var broker *Broker
+
+// NewBroker returns a broker that checks the credentials of clients with the authenticator
+func NewBroker(authenticate Authenticator) *Broker {
+	return &Broker{
+		Authenticate: authenticate,
+		clients:      make(map[string]*brokerClient),
+		retained:     make(map[string]*packets.PublishPacket),
+	}
+}
+
+// Listen starts accepting clients on the address
+func (b *Broker) Listen(address string) (err error) {
+	b.listener, err = net.Listen("tcp", address)
+	if err != nil {
+		err = errors.Wrap(err, "could not listen for mqtt")
+		return
+	}
+	go b.serve()
+	return
+}
+
+// Addr returns the address the broker listens on
+func (b *Broker) Addr() net.Addr {
+	return b.listener.Addr()
+}
+
+// Close stops the broker and disconnects all the clients
+func (b *Broker) Close() (err error) {
+	b.Lock()
+	b.closed = true
+	for _, c := range b.clients {
+		c.conn.Close()
+	}
+	b.Unlock()
+	if b.listener != nil {
+		err = b.listener.Close()
+	}
+	return
+}
+
+// Disconnect closes the connections of a user, whose clients then have to log in again
+func (b *Broker) Disconnect(username string) {
+	b.Lock()
+	defer b.Unlock()
+	for _, c := range b.clients {
+		if c.username == username {
+			c.conn.Close()
+		}
+	}
+}
+
+func (b *Broker) serve() {
+	for {
+		conn, err := b.listener.Accept()
+		if err != nil {
+			b.Lock()
+			closed := b.closed
+			b.Unlock()
+			if closed {
+				return
+			}
+			logger.Log.Warnf("mqtt broker could not accept: %s", err.Error())
+			time.Sleep(100 * time.Millisecond)
+			continue
+		}
+		go b.handle(conn)
+	}
+}
+
+// handle runs a connection from the CONNECT to the end
+func (b *Broker) handle(conn net.Conn) {
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	cp, err := packets.ReadPacket(conn)
+	if err != nil {
+		return
+	}
+	connect, ok := cp.(*packets.ConnectPacket)
+	if !ok {
+		return
+	}
+	c, returnCode := b.connect(connect, conn)
+	connack := packets.NewControlPacket(packets.Connack).(*packets.ConnackPacket)
+	connack.ReturnCode = returnCode
+	err = connack.Write(conn)
+	if err != nil || returnCode != packets.Accepted {
+		if returnCode != packets.Accepted {
+			logger.Log.Debugf("refused mqtt client '%s' (%s): %s", connect.ClientIdentifier, connect.Username, packets.ConnackReturnCodes[returnCode])
+		}
+		return
+	}
+	logger.Log.Debugf("mqtt client '%s' connected as %s", c.id, c.username)
+
+	cleanDisconnect := false
+	for {
+		if connect.Keepalive > 0 {
+			conn.SetReadDeadline(time.Now().Add(time.Duration(connect.Keepalive) * time.Second * 3 / 2))
+		} else {
+			conn.SetReadDeadline(time.Time{})
+		}
+		cp, err = packets.ReadPacket(conn)
+		if err != nil {
+			break
+		}
+		if _, ok := cp.(*packets.DisconnectPacket); ok {
+			cleanDisconnect = true
+			break
+		}
+		err = b.process(c, cp)
+		if err != nil {
+			logger.Log.Debugf("mqtt client '%s': %s", c.id, err.Error())
+			break
+		}
+	}
+
+	b.Lock()
+	if b.clients[c.id] == c {
+		delete(b.clients, c.id)
+	}
+	b.Unlock()
+	if !cleanDisconnect && c.will != nil {
+		b.publish(c.will)
+	}
+	logger.Log.Debugf("mqtt client '%s' disconnected", c.id)
+}
+
+// connect checks the credentials of a client and registers it
+func (b *Broker) connect(connect *packets.ConnectPacket, conn net.Conn) (c *brokerClient, returnCode byte) {
+	returnCode = connect.Validate()
+	if returnCode != packets.Accepted {
+		return
+	}
+	acl, ok := b.Authenticate(connect.Username, string(connect.Password))
+	if !ok {
+		returnCode = packets.ErrRefusedBadUsernameOrPassword
+		return
+	}
+	c = &brokerClient{
+		id:            connect.ClientIdentifier,
+		username:      connect.Username,
+		acl:           acl,
+		conn:          conn,
+		subscriptions: make(map[string]byte),
+	}
+	if c.id == "" {
+		c.id = "auto-" + conn.RemoteAddr().String()
+	}
+	if connect.WillFlag {
+		if !aclAllows(acl.Write, connect.WillTopic) {
+			returnCode = packets.ErrRefusedNotAuthorised
+			return
+		}
+		c.will = packets.NewControlPacket(packets.Publish).(*packets.PublishPacket)
+		c.will.TopicName = connect.WillTopic
+		c.will.Payload = connect.WillMessage
+		c.will.Qos = connect.WillQos
+		c.will.Retain = connect.WillRetain
+	}
+
+	// a client with the same id takes over the session
+	b.Lock()
+	if existing, ok := b.clients[c.id]; ok {
+		existing.conn.Close()
+	}
+	b.clients[c.id] = c
+	b.Unlock()
+	return
+}
+
+func (b *Broker) process(c *brokerClient, cp packets.ControlPacket) (err error) {
+	switch p := cp.(type) {
+	case *packets.PublishPacket:
+		switch p.Qos {
+		case 1:
+			puback := packets.NewControlPacket(packets.Puback).(*packets.PubackPacket)
+			puback.MessageID = p.MessageID
+			err = c.write(puback)
+		case 2:
+			pubrec := packets.NewControlPacket(packets.Pubrec).(*packets.PubrecPacket)
+			pubrec.MessageID = p.MessageID
+			err = c.write(pubrec)
+		}
+		if strings.ContainsAny(p.TopicName, "+#") {
+			err = errors.New("wildcards in topic " + p.TopicName)
+			return
+		}
+		if !aclAllows(c.acl.Write, p.TopicName) {
+			logger.Log.Debugf("mqtt client '%s' (%s) is not allowed to publish to %s", c.id, c.username, p.TopicName)
+			return
+		}
+		b.publish(p)
+	case *packets.PubrelPacket:
+		pubcomp := packets.NewControlPacket(packets.Pubcomp).(*packets.PubcompPacket)
+		pubcomp.MessageID = p.MessageID
+		err = c.write(pubcomp)
+	case *packets.SubscribePacket:
+		suback := packets.NewControlPacket(packets.Suback).(*packets.SubackPacket)
+		suback.MessageID = p.MessageID
+		allowed := []string{}
+		for i, filter := range p.Topics {
+			if !validFilter(filter) || !aclCovers(c.acl.Read, filter) {
+				logger.Log.Debugf("mqtt client '%s' (%s) is not allowed to subscribe to %s", c.id, c.username, filter)
+				suback.ReturnCodes = append(suback.ReturnCodes, 0x80)
+				continue
+			}
+			qos := p.Qoss[i]
+			if qos > 1 {
+				qos = 1
+			}
+			c.Lock()
+			c.subscriptions[filter] = qos
+			c.Unlock()
+			suback.ReturnCodes = append(suback.ReturnCodes, qos)
+			allowed = append(allowed, filter)
+		}
+		err = c.write(suback)
+		if err == nil {
+			b.sendRetained(c, allowed)
+		}
+	case *packets.UnsubscribePacket:
+		c.Lock()
+		for _, filter := range p.Topics {
+			delete(c.subscriptions, filter)
+		}
+		c.Unlock()
+		unsuback := packets.NewControlPacket(packets.Unsuback).(*packets.UnsubackPacket)
+		unsuback.MessageID = p.MessageID
+		err = c.write(unsuback)
+	case *packets.PingreqPacket:
+		err = c.write(packets.NewControlPacket(packets.Pingresp))
+	case *packets.PubackPacket, *packets.PubrecPacket, *packets.PubcompPacket:
+		// messages are not resent, so there is nothing to acknowledge
+	default:
+		err = errors.New("unexpected packet " + cp.String())
+	}
+	return
+}
+
+// publish sends a message to every client subscribed to its topic, and keeps it if it is retained
+func (b *Broker) publish(p *packets.PublishPacket) {
+	b.Lock()
+	if p.Retain {
+		if len(p.Payload) == 0 {
+			delete(b.retained, p.TopicName)
+		} else {
+			b.retained[p.TopicName] = p.Copy()
+			b.retained[p.TopicName].Qos = p.Qos
+		}
+	}
+	clients := make([]*brokerClient, 0, len(b.clients))
+	for _, c := range b.clients {
+		clients = append(clients, c)
+	}
+	b.Unlock()
+
+	for _, c := range clients {
+		qos, subscribed := c.subscribedQos(p.TopicName)
+		if !subscribed {
+			continue
+		}
+		err := c.send(p, qos, false)
+		if err != nil {
+			logger.Log.Debugf("could not send to mqtt client '%s': %s", c.id, err.Error())
+		}
+	}
+}
+
+// sendRetained sends the retained messages that match new subscriptions
+func (b *Broker) sendRetained(c *brokerClient, filters []string) {
+	b.Lock()
+	retained := []*packets.PublishPacket{}
+	for topic, p := range b.retained {
+		for _, filter := range filters {
+			if topicMatches(filter, topic) {
+				retained = append(retained, p)
+				break
+			}
+		}
+	}
+	b.Unlock()
+	for _, p := range retained {
+		qos, _ := c.subscribedQos(p.TopicName)
+		c.send(p, qos, true)
+	}
+}
+
+// subscribedQos returns the highest QoS of the subscriptions of the client that match the topic
+func (c *brokerClient) subscribedQos(topic string) (qos byte, subscribed bool) {
+	c.Lock()
+	defer c.Unlock()
+	for filter, filterQos := range c.subscriptions {
+		if topicMatches(filter, topic) {
+			subscribed = true
+			if filterQos > qos {
+				qos = filterQos
+			}
+		}
+	}
+	return
+}
+
+func (c *brokerClient) send(p *packets.PublishPacket, qos byte, retain bool) error {
+	out := p.Copy()
+	out.Qos = p.Qos
+	if qos < out.Qos {
+		out.Qos = qos
+	}
+	if out.Qos > 1 {
+		out.Qos = 1
+	}
+	out.Retain = retain
+	if out.Qos > 0 {
+		c.Lock()
+		c.nextID++
+		if c.nextID == 0 {
+			c.nextID = 1
+		}
+		out.MessageID = c.nextID
+		c.Unlock()
+	}
+	return c.write(out)
+}
+
+func (c *brokerClient) write(cp packets.ControlPacket) (err error) {
+	c.Lock()
+	defer c.Unlock()
+	c.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	err = cp.Write(c.conn)
+	return
+}
+
+// topicMatches returns whether a topic matches a filter with the + and # wildcards
+func topicMatches(filter, topic string) bool {
+	// wildcards do not match the topics that start with $ at the first level
+	if strings.HasPrefix(topic, "$") && !strings.HasPrefix(filter, "$") {
+		return false
+	}
+	filterLevels := strings.Split(filter, "/")
+	topicLevels := strings.Split(topic, "/")
+	for i, level := range filterLevels {
+		if level == "#" {
+			return true
+		}
+		if i >= len(topicLevels) {
+			return false
+		}
+		if level != "+" && level != topicLevels[i] {
+			return false
+		}
+	}
+	return len(filterLevels) == len(topicLevels)
+}
+
+// validFilter returns whether the wildcards of a filter are used correctly
+func validFilter(filter string) bool {
+	if filter == "" {
+		return false
+	}
+	levels := strings.Split(filter, "/")
+	for i, level := range levels {
+		if strings.Contains(level, "#") && (level != "#" || i != len(levels)-1) {
+			return false
+		}
+		if strings.Contains(level, "+") && level != "+" {
+			return false
+		}
+	}
+	return true
+}
+
+// aclAllows returns whether a topic is in the ACL
+func aclAllows(acl []string, topic string) bool {
+	for _, pattern := range acl {
+		if topicMatches(pattern, topic) {
+			return true
+		}
+	}
+	return false
+}
+
+// aclCovers returns whether every topic matched by the filter is in the ACL
+func aclCovers(acl []string, filter string) bool {
+	filterLevels := strings.Split(filter, "/")
+	for _, pattern := range acl {
+		patternLevels := strings.Split(pattern, "/")
+		covered := len(patternLevels) == len(filterLevels)
+		for i, level := range patternLevels {
+			if level == "#" {
+				covered = true
+				break
+			}
+			if i >= len(filterLevels) || filterLevels[i] == "#" || (filterLevels[i] == "+" && level != "+") {
+				covered = false
+				break
+			}
+			if level != "+" && level != filterLevels[i] {
+				covered = false
+				break
+			}
+		}
+		if covered {
+			return true
+		}
+	}
+	return false
+}
+
+func startBroker() (err error) {
+	broker = NewBroker(authenticateCredential)
+	err = broker.Listen(Server)
+	if err != nil {
+		return
+	}
+	logger.Log.Infof("running embedded mqtt broker on %s", broker.Addr().String())
+	return
+}
@@ -0,0 +1,184 @@
+package mqtt
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	MQTT "github.com/eclipse/paho.mqtt.golang"
+	"github.com/eclipse/paho.mqtt.golang/packets"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/Nimaapr/find3/server/main/src/logging"
+)
+
+func testBroker(t *testing.T) *Broker {
+	if logger == nil {
+		logger, _ = logging.New()
+	}
+	b := NewBroker(func(username, password string) (ACL, bool) {
+		if username == "admin" && password == "1234" {
+			return ACL{Read: []string{"#"}, Write: []string{"#"}}, true
+		}
+		if username == "labs" && password == "secret" {
+			return ACL{Read: []string{"labs/#"}, Write: []string{"labs/#"}}, true
+		}
+		if username == "display@labs" && password == "secret" {
+			return ACL{Read: []string{"labs/location/#"}}, true
+		}
+		return ACL{}, false
+	})
+	assert.Nil(t, b.Listen("127.0.0.1:0"))
+	return b
+}
+
+func testClient(t *testing.T, b *Broker, id, username, password string) (MQTT.Client, error) {
+	opts := MQTT.NewClientOptions().AddBroker("tcp://" + b.Addr().String()).SetClientID(id).SetUsername(username).SetPassword(password)
+	c := MQTT.NewClient(opts)
+	token := c.Connect()
+	token.Wait()
+	return c, token.Error()
+}
+
+func receiver() (chan MQTT.Message, MQTT.MessageHandler) {
+	messages := make(chan MQTT.Message, 10)
+	return messages, func(c MQTT.Client, m MQTT.Message) { messages <- m }
+}
+
+func expectMessage(t *testing.T, messages chan MQTT.Message, topic, payload string) {
+	select {
+	case m := <-messages:
+		assert.Equal(t, topic, m.Topic())
+		assert.Equal(t, payload, string(m.Payload()))
+	case <-time.After(2 * time.Second):
+		t.Errorf("did not get %s on %s", payload, topic)
+	}
+}
+
+func expectNoMessage(t *testing.T, messages chan MQTT.Message) {
+	select {
+	case m := <-messages:
+		t.Errorf("unexpected message on %s", m.Topic())
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestBroker(t *testing.T) {
+	b := testBroker(t)
+	defer b.Close()
+
+	_, err := testClient(t, b, "bad", "labs", "wrong")
+	assert.NotNil(t, err)
+
+	admin, err := testClient(t, b, "admin", "admin", "1234")
+	assert.Nil(t, err)
+	adminMessages, adminHandler := receiver()
+	token := admin.Subscribe("#", 1, adminHandler)
+	token.Wait()
+	assert.Nil(t, token.Error())
+
+	labs, err := testClient(t, b, "labs", "labs", "secret")
+	assert.Nil(t, err)
+	labsMessages, labsHandler := receiver()
+	token = labs.Subscribe("other/#", 1, labsHandler)
+	token.Wait()
+	assert.Equal(t, byte(0x80), token.(*MQTT.SubscribeToken).Result()["other/#"])
+	token = labs.Subscribe("labs/location/+", 1, labsHandler)
+	token.Wait()
+	assert.Equal(t, byte(1), token.(*MQTT.SubscribeToken).Result()["labs/location/+"])
+
+	admin.Publish("labs/location/phone", 1, false, "kitchen").Wait()
+	expectMessage(t, labsMessages, "labs/location/phone", "kitchen")
+	expectMessage(t, adminMessages, "labs/location/phone", "kitchen")
+
+	// publishing outside of the family is dropped
+	labs.Publish("other/track/phone", 1, false, "nope").Wait()
+	expectNoMessage(t, adminMessages)
+	labs.Publish("labs/track/phone", 0, false, "yes").Wait()
+	expectMessage(t, adminMessages, "labs/track/phone", "yes")
+
+	// retained messages are sent to new subscriptions
+	admin.Publish("labs/location/laptop", 1, true, "office").Wait()
+	expectMessage(t, labsMessages, "labs/location/laptop", "office")
+	expectMessage(t, adminMessages, "labs/location/laptop", "office")
+	other, err := testClient(t, b, "other", "labs", "secret")
+	assert.Nil(t, err)
+	otherMessages, otherHandler := receiver()
+	other.Subscribe("labs/location/#", 0, otherHandler).Wait()
+	expectMessage(t, otherMessages, "labs/location/laptop", "office")
+	other.Disconnect(10)
+
+	// the will is published when a client goes away without disconnecting
+	conn, err := net.Dial("tcp", b.Addr().String())
+	assert.Nil(t, err)
+	connect := packets.NewControlPacket(packets.Connect).(*packets.ConnectPacket)
+	connect.ProtocolName = "MQTT"
+	connect.ProtocolVersion = 4
+	connect.CleanSession = true
+	connect.ClientIdentifier = "scanner"
+	connect.UsernameFlag = true
+	connect.Username = "labs"
+	connect.PasswordFlag = true
+	connect.Password = []byte("secret")
+	connect.WillFlag = true
+	connect.WillTopic = "labs/status/scanner"
+	connect.WillMessage = []byte("offline")
+	assert.Nil(t, connect.Write(conn))
+	cp, err := packets.ReadPacket(conn)
+	assert.Nil(t, err)
+	assert.Equal(t, byte(packets.Accepted), cp.(*packets.ConnackPacket).ReturnCode)
+	conn.Close()
+	expectMessage(t, adminMessages, "labs/status/scanner", "offline")
+
+	// disconnecting a user closes its connections
+	conn, err = net.Dial("tcp", b.Addr().String())
+	assert.Nil(t, err)
+	connect.WillFlag = false
+	connect.Username = "display@labs"
+	assert.Nil(t, connect.Write(conn))
+	_, err = packets.ReadPacket(conn)
+	assert.Nil(t, err)
+	b.Disconnect("display@labs")
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, err = packets.ReadPacket(conn)
+	assert.NotNil(t, err)
+	assert.False(t, strings.Contains(err.Error(), "timeout"))
+
+	// a read only user can subscribe but not publish
+	display, err := testClient(t, b, "display", "display@labs", "secret")
+	assert.Nil(t, err)
+	displayMessages, displayHandler := receiver()
+	token = display.Subscribe("labs/track/#", 1, displayHandler)
+	token.Wait()
+	assert.Equal(t, byte(0x80), token.(*MQTT.SubscribeToken).Result()["labs/track/#"])
+	display.Subscribe("labs/location/+", 1, displayHandler).Wait()
+	expectMessage(t, displayMessages, "labs/location/laptop", "office")
+	display.Publish("labs/location/laptop", 1, false, "nope").Wait()
+	expectNoMessage(t, adminMessages)
+
+	display.Disconnect(10)
+
+	labs.Disconnect(10)
+	admin.Disconnect(10)
+}
+
+func TestTopics(t *testing.T) {
+	assert.True(t, topicMatches("labs/#", "labs/location/phone"))
+	assert.True(t, topicMatches("labs/#", "labs"))
+	assert.True(t, topicMatches("+/location/+", "labs/location/phone"))
+	assert.False(t, topicMatches("+/location/+", "labs/location/phone/x"))
+	assert.False(t, topicMatches("#", "$SYS/broker"))
+	assert.False(t, topicMatches("labs/#", "labsx/location"))
+
+	assert.True(t, aclCovers([]string{"labs/#"}, "labs/+/phone"))
+	assert.True(t, aclCovers([]string{"labs/#"}, "labs/#"))
+	assert.False(t, aclCovers([]string{"labs/#"}, "#"))
+	assert.False(t, aclCovers([]string{"labs/#"}, "+/location"))
+	assert.False(t, aclCovers([]string{"labs/location/+"}, "labs/location/#"))
+	assert.True(t, aclCovers([]string{"labs/location/+"}, "labs/location/phone"))
+
+	assert.False(t, validFilter("labs/#/x"))
+	assert.False(t, validFilter("labs/a+"))
+	assert.True(t, validFilter("labs/+/x"))
+}
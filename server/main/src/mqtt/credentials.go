@@ -0,0 +1,338 @@
+package mqtt
+
+/*
+This code manages the credentials that can connect to the MQTT broker (see models.MQTTCredential).
+
+The credentials are stored in the "credentials" entry of the "mosquitto" database, as a map from the username to the
+credential. Passwords are generated with crypto/rand and only their hash is kept, in the "$6$" format of the mosquitto
+password file: a random 12 byte salt and the SHA-512 of the password followed by the salt, both base64 encoded. The
+same hashes are written to the passwd file for mosquitto and checked by the embedded broker, so mosquitto_passwd is
+not needed.
+
+The plain text passwords of older versions (the "passes" entry) are turned into default credentials the first time
+the credentials are read.
+
+After a change, mosquitto is given the new configuration. The embedded broker reads the credentials on every
+connection, and disconnects the clients of a credential that is rotated or revoked.
+*/
+
+import (
+	"crypto/rand"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/Nimaapr/find3/server/main/src/database"
+	"github.com/Nimaapr/find3/server/main/src/models"
+	"github.com/Nimaapr/find3/server/main/src/utils"
+)
+
+// PasswordLength is the number of characters of generated passwords
+var PasswordLength = 24
+
+// DefaultCredential is the name of the credential whose username is the family
+const DefaultCredential = "default"
+
+var validCredentialName = regexp.MustCompile(`^[a-z0-9_\-.]+$`)
+
+// GetCredentials returns the credentials of a family, without their hashes
+func GetCredentials(family string) (credentials []models.MQTTCredential, err error) {
+	db, err := database.Open("mosquitto", false, true)
+	if err != nil {
+		return
+	}
+	defer db.Close()
+	all, err := loadCredentials(db)
+	if err != nil {
+		return
+	}
+	credentials = []models.MQTTCredential{}
+	for _, c := range all {
+		if c.Family == family {
+			c.Hash = ""
+			credentials = append(credentials, c)
+		}
+	}
+	sort.Slice(credentials, func(i, j int) bool { return credentials[i].Name < credentials[j].Name })
+	return
+}
+
+// AddCredential makes a new credential for a family and returns its password. The topics must
+// be within FAMILY/# and default to all of them, the access is "readwrite" (the default), "read" or "write".
+func AddCredential(family, name string, topics []string, access string) (credential models.MQTTCredential, password string, err error) {
+	name = strings.TrimSpace(strings.ToLower(name))
+	if name == DefaultCredential {
+		err = errors.New("the default credential can only be rotated")
+		return
+	}
+	if !validCredentialName.MatchString(name) {
+		err = errors.New("credential name must be letters, digits, '.', '_' or '-'")
+		return
+	}
+	credential, err = newCredential(family, name, topics, access)
+	if err != nil {
+		return
+	}
+
+	db, err := database.Open("mosquitto", false, true)
+	if err != nil {
+		return
+	}
+	defer db.Close()
+	credentials, err := loadCredentials(db)
+	if err != nil {
+		return
+	}
+	if _, exists := credentials[credential.Username]; exists {
+		err = fmt.Errorf("credential '%s' already exists", name)
+		return
+	}
+	password = utils.SecureRandomString(PasswordLength)
+	credential.Hash = hashPassword(password)
+	credentials[credential.Username] = credential
+	err = db.Set("credentials", credentials)
+	db.Close()
+	if err != nil {
+		return
+	}
+	credential.Hash = ""
+	logger.Log.Debugf("[%s] added mqtt credential %s", family, credential.Username)
+	err = credentialsChanged()
+	return
+}
+
+// RotateCredential gives a credential a new password and returns it. Rotating the
+// default credential of a family that has none makes it.
+func RotateCredential(family, name string) (password string, err error) {
+	name = strings.TrimSpace(strings.ToLower(name))
+	db, err := database.Open("mosquitto", false, true)
+	if err != nil {
+		return
+	}
+	defer db.Close()
+	credentials, err := loadCredentials(db)
+	if err != nil {
+		return
+	}
+	username := credentialUsername(family, name)
+	credential, exists := credentials[username]
+	if !exists {
+		if name != DefaultCredential {
+			err = fmt.Errorf("no credential '%s'", name)
+			return
+		}
+		credential, err = newCredential(family, name, nil, "")
+		if err != nil {
+			return
+		}
+	}
+	password = utils.SecureRandomString(PasswordLength)
+	credential.Hash = hashPassword(password)
+	credential.Rotated = time.Now().UTC()
+	credentials[username] = credential
+	err = db.Set("credentials", credentials)
+	db.Close()
+	if err != nil {
+		return
+	}
+	logger.Log.Debugf("[%s] rotated mqtt credential %s", family, username)
+	err = credentialsChanged(username)
+	return
+}
+
+// RevokeCredential removes a credential, so it can no longer connect
+func RevokeCredential(family, name string) (err error) {
+	name = strings.TrimSpace(strings.ToLower(name))
+	db, err := database.Open("mosquitto", false, true)
+	if err != nil {
+		return
+	}
+	defer db.Close()
+	credentials, err := loadCredentials(db)
+	if err != nil {
+		return
+	}
+	username := credentialUsername(family, name)
+	if _, exists := credentials[username]; !exists {
+		err = fmt.Errorf("no credential '%s'", name)
+		return
+	}
+	delete(credentials, username)
+	err = db.Set("credentials", credentials)
+	db.Close()
+	if err != nil {
+		return
+	}
+	logger.Log.Debugf("[%s] revoked mqtt credential %s", family, username)
+	err = credentialsChanged(username)
+	return
+}
+
+func newCredential(family, name string, topics []string, access string) (c models.MQTTCredential, err error) {
+	family = strings.TrimSpace(strings.ToLower(family))
+	if family == "" || strings.ContainsAny(family, "@:/+#") {
+		err = errors.New("invalid family")
+		return
+	}
+	if len(topics) == 0 {
+		topics = []string{family + "/#"}
+	}
+	for i, topic := range topics {
+		topics[i] = strings.TrimSpace(topic)
+		if !validFilter(topics[i]) || !aclCovers([]string{family + "/#"}, topics[i]) {
+			err = fmt.Errorf("topic '%s' must be within %s/#", topic, family)
+			return
+		}
+	}
+	if access == "" {
+		access = "readwrite"
+	}
+	if access != "readwrite" && access != "read" && access != "write" {
+		err = errors.New("access must be readwrite, read or write")
+		return
+	}
+	c = models.MQTTCredential{
+		Name:     name,
+		Family:   family,
+		Username: credentialUsername(family, name),
+		Topics:   topics,
+		Access:   access,
+		Created:  time.Now().UTC(),
+	}
+	c.Rotated = c.Created
+	return
+}
+
+func credentialUsername(family, name string) string {
+	if name == DefaultCredential {
+		return family
+	}
+	return name + "@" + family
+}
+
+// loadCredentials reads the credentials, turning the plain text passwords of older versions into hashes
+func loadCredentials(db *database.Database) (credentials map[string]models.MQTTCredential, err error) {
+	errGet := db.Get("credentials", &credentials)
+	if errGet != nil || credentials == nil {
+		credentials = make(map[string]models.MQTTCredential)
+	}
+	var passes map[string]string
+	errGet = db.Get("passes", &passes)
+	if errGet != nil || len(passes) == 0 {
+		return
+	}
+	for family, pass := range passes {
+		if _, exists := credentials[family]; exists {
+			continue
+		}
+		var c models.MQTTCredential
+		c, err = newCredential(family, DefaultCredential, nil, "")
+		if err != nil {
+			logger.Log.Warnf("could not migrate mqtt password of '%s': %s", family, err.Error())
+			err = nil
+			continue
+		}
+		c.Hash = hashPassword(pass)
+		credentials[c.Username] = c
+	}
+	err = db.Set("credentials", credentials)
+	if err != nil {
+		return
+	}
+	err = db.Set("passes", map[string]string{})
+	logger.Log.Debugf("hashed %d mqtt passwords", len(passes))
+	return
+}
+
+// credentialsChanged applies the credentials to the broker, disconnecting the clients of the usernames given
+func credentialsChanged(usernames ...string) (err error) {
+	if Embedded {
+		if broker != nil {
+			for _, username := range usernames {
+				broker.Disconnect(username)
+			}
+		}
+		return
+	}
+	err = updateMosquittoConfig()
+	return
+}
+
+// authenticateCredential checks the credentials against the admin and the
+// stored credentials of the families
+func authenticateCredential(username, password string) (acl ACL, ok bool) {
+	if username == AdminUser && subtle.ConstantTimeCompare([]byte(password), []byte(AdminPassword)) == 1 {
+		return ACL{Read: []string{"#"}, Write: []string{"#"}}, true
+	}
+	db, err := database.Open("mosquitto", false, true)
+	if err != nil {
+		logger.Log.Warn(err)
+		return
+	}
+	defer db.Close()
+	credentials, err := loadCredentials(db)
+	if err != nil {
+		logger.Log.Warn(err)
+		return
+	}
+	c, exists := credentials[username]
+	if !exists || !checkPassword(c.Hash, password) {
+		return
+	}
+	if c.CanRead() {
+		acl.Read = append([]string{StatusTopic}, c.Topics...)
+	}
+	if c.CanWrite() {
+		acl.Write = c.Topics
+	}
+	return acl, true
+}
+
+// hashPassword returns the hash of a password in the "$6$" format of mosquitto
+func hashPassword(password string) string {
+	salt := make([]byte, 12)
+	if _, err := rand.Read(salt); err != nil {
+		panic(err)
+	}
+	return saltedHash(password, salt)
+}
+
+func saltedHash(password string, salt []byte) string {
+	h := sha512.New()
+	h.Write([]byte(password))
+	h.Write(salt)
+	return "$6$" + base64.StdEncoding.EncodeToString(salt) + "$" + base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+// checkPassword returns whether a password matches a "$6$" hash
+func checkPassword(hash, password string) bool {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 4 || parts[1] != "6" {
+		return false
+	}
+	salt, err := base64.StdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(saltedHash(password, salt)), []byte(hash)) == 1
+}
+
+// hashPasswdLines hashes the plain text passwords of a mosquitto passwd file
+func hashPasswdLines(passwd string) string {
+	lines := strings.Split(passwd, "\n")
+	for i, line := range lines {
+		fields := strings.SplitN(line, ":", 2)
+		if len(fields) != 2 || strings.HasPrefix(fields[1], "$6$") || strings.HasPrefix(fields[1], "$7$") {
+			continue
+		}
+		lines[i] = fields[0] + ":" + hashPassword(fields[1])
+	}
+	return strings.Join(lines, "\n")
+}
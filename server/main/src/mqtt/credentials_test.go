@@ -0,0 +1,88 @@
+package mqtt
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/Nimaapr/find3/server/main/src/database"
+	"github.com/Nimaapr/find3/server/main/src/logging"
+)
+
+func TestPasswordHash(t *testing.T) {
+	hash := hashPassword("secret")
+	assert.True(t, checkPassword(hash, "secret"))
+	assert.False(t, checkPassword(hash, "secreT"))
+	assert.NotEqual(t, hash, hashPassword("secret"))
+	assert.False(t, checkPassword("secret", "secret"))
+
+	passwd := hashPasswdLines("admin:1234\nlabs:" + hash + "\n")
+	assert.NotContains(t, passwd, "1234")
+	assert.Contains(t, passwd, "labs:"+hash+"\n")
+}
+
+func TestCredentials(t *testing.T) {
+	if logger == nil {
+		logger, _ = logging.New()
+	}
+	folder, err := ioutil.TempDir("", "find3")
+	assert.Nil(t, err)
+	defer os.RemoveAll(folder)
+	defer func(dataFolder string) { database.DataFolder = dataFolder }(database.DataFolder)
+	database.DataFolder = folder
+	Embedded = true
+	defer func() { Embedded = false }()
+
+	// plain text passwords of older versions are hashed
+	db, err := database.Open("mosquitto", false, true)
+	assert.Nil(t, err)
+	assert.Nil(t, db.Set("passes", map[string]string{"labs": "abcde"}))
+	db.Close()
+	_, ok := authenticateCredential("labs", "abcde")
+	assert.True(t, ok)
+	credentials, err := GetCredentials("labs")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(credentials))
+	assert.Equal(t, DefaultCredential, credentials[0].Name)
+	assert.Equal(t, "", credentials[0].Hash)
+
+	password, err := AddFamily("labs")
+	assert.Nil(t, err)
+	assert.Equal(t, PasswordLength, len(password))
+	_, ok = authenticateCredential("labs", "abcde")
+	assert.False(t, ok)
+	acl, ok := authenticateCredential("labs", password)
+	assert.True(t, ok)
+	assert.Equal(t, []string{"labs/#"}, acl.Write)
+
+	_, _, err = AddCredential("labs", "scanner", []string{"other/#"}, "")
+	assert.NotNil(t, err)
+	_, _, err = AddCredential("labs", "scanner", nil, "everything")
+	assert.NotNil(t, err)
+	c, scannerPassword, err := AddCredential("labs", "Scanner", []string{"labs/passive/+"}, "write")
+	assert.Nil(t, err)
+	assert.Equal(t, "scanner@labs", c.Username)
+	_, _, err = AddCredential("labs", "scanner", nil, "")
+	assert.NotNil(t, err)
+	acl, ok = authenticateCredential("scanner@labs", scannerPassword)
+	assert.True(t, ok)
+	assert.Equal(t, 0, len(acl.Read))
+	assert.Equal(t, []string{"labs/passive/+"}, acl.Write)
+
+	newPassword, err := RotateCredential("labs", "scanner")
+	assert.Nil(t, err)
+	_, ok = authenticateCredential("scanner@labs", scannerPassword)
+	assert.False(t, ok)
+	_, ok = authenticateCredential("scanner@labs", newPassword)
+	assert.True(t, ok)
+
+	assert.Nil(t, RevokeCredential("labs", "scanner"))
+	assert.NotNil(t, RevokeCredential("labs", "scanner"))
+	_, ok = authenticateCredential("scanner@labs", newPassword)
+	assert.False(t, ok)
+	credentials, err = GetCredentials("labs")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(credentials))
+}
@@ -0,0 +1,298 @@
+package mqtt
+
+/*
+This code announces the devices and locations of a family to Home Assistant with MQTT discovery
+(https://www.home-assistant.io/integrations/mqtt/#mqtt-discovery), when it is enabled for the family.
+
+The settings are stored in the "HomeAssistant" entry of the keystore of the family (see models.HomeAssistant). The
+discovery prefix defaults to FAMILY/homeassistant, so that the family login can read it; set discovery_prefix in
+Home Assistant to the same.
+
+Each device is a device_tracker whose state is the best guess of the location payloads on FAMILY/location/DEVICE, and
+which is available while the device and the server are online (see status.go). Each location is an occupancy
+binary_sensor whose state comes from FAMILY/presence/LOCATION, the devices whose best guess is the location. The
+configs are retained messages on PREFIX/COMPONENT/OBJECT_ID/config, and are removed by publishing an empty retained
+message to the same topic, when a location is deleted or the integration is disabled.
+*/
+
+import (
+	"encoding/json"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+
+	"github.com/Nimaapr/find3/server/main/src/database"
+	"github.com/Nimaapr/find3/server/main/src/models"
+)
+
+var homeAssistant = struct {
+	// settings of the families, read from the database the first time they are needed
+	settings map[string]models.HomeAssistant
+	// announced config topics, by family
+	announced map[string]map[string]bool
+	// best guess of the online devices, by family and device
+	locations map[string]map[string]string
+	sync.Mutex
+}{
+	settings:  make(map[string]models.HomeAssistant),
+	announced: make(map[string]map[string]bool),
+	locations: make(map[string]map[string]string),
+}
+
+var invalidObjectID = regexp.MustCompile(`[^a-z0-9_]+`)
+
+// GetHomeAssistant returns the Home Assistant settings of a family
+func GetHomeAssistant(family string) (settings models.HomeAssistant, err error) {
+	homeAssistant.Lock()
+	defer homeAssistant.Unlock()
+	return homeAssistantSettings(family)
+}
+
+// homeAssistantSettings must be called with the lock held
+func homeAssistantSettings(family string) (settings models.HomeAssistant, err error) {
+	settings, ok := homeAssistant.settings[family]
+	if ok {
+		return
+	}
+	db, err := database.Open(family, true)
+	if err != nil {
+		// not asked again, SetHomeAssistant updates it
+		homeAssistant.settings[family] = models.HomeAssistant{Prefix: family + "/homeassistant"}
+		return
+	}
+	defer db.Close()
+	errGet := db.Get("HomeAssistant", &settings)
+	if errGet != nil {
+		settings = models.HomeAssistant{}
+	}
+	if settings.Prefix == "" {
+		settings.Prefix = family + "/homeassistant"
+	}
+	homeAssistant.settings[family] = settings
+	return
+}
+
+// SetHomeAssistant turns the Home Assistant discovery of a family on or off. Turning it on
+// announces the devices and locations that are in the database, turning it off removes them.
+func SetHomeAssistant(family string, settings models.HomeAssistant) (err error) {
+	settings.Prefix = strings.Trim(strings.TrimSpace(settings.Prefix), "/")
+	if settings.Prefix == "" {
+		settings.Prefix = family + "/homeassistant"
+	}
+	if !validFilter(settings.Prefix) || strings.ContainsAny(settings.Prefix, "+#") {
+		err = errors.New("invalid discovery prefix")
+		return
+	}
+
+	db, err := database.Open(family, true)
+	if err != nil {
+		return
+	}
+	devices, err := db.GetDevices()
+	if err != nil {
+		db.Close()
+		return
+	}
+	locations, err := db.GetLocations()
+	if err != nil {
+		db.Close()
+		return
+	}
+	err = db.Set("HomeAssistant", settings)
+	db.Close()
+	if err != nil {
+		return
+	}
+
+	homeAssistant.Lock()
+	previous, _ := homeAssistantSettings(family)
+	homeAssistant.settings[family] = settings
+	homeAssistant.Unlock()
+
+	if previous.Enabled && (!settings.Enabled || previous.Prefix != settings.Prefix) {
+		for _, device := range devices {
+			removeConfig(family, previous, "device_tracker", device)
+		}
+		for _, location := range locations {
+			removeConfig(family, previous, "binary_sensor", location)
+		}
+	}
+	if settings.Enabled {
+		for _, device := range devices {
+			announceDevice(family, settings, device)
+		}
+		for _, location := range locations {
+			announceLocation(family, settings, location)
+		}
+	}
+	return
+}
+
+// RemoveLocation removes the Home Assistant config of a location that was deleted
+func RemoveLocation(family, location string) {
+	homeAssistant.Lock()
+	settings, err := homeAssistantSettings(family)
+	for device, l := range homeAssistant.locations[family] {
+		if l == location {
+			delete(homeAssistant.locations[family], device)
+		}
+	}
+	homeAssistant.Unlock()
+	if err != nil || !settings.Enabled {
+		return
+	}
+	removeConfig(family, settings, "binary_sensor", location)
+	if err = publishRetained(family+"/presence/"+location, []byte{}); err != nil {
+		logger.Log.Warn(err)
+	}
+}
+
+// homeAssistantSeen announces a device and its location, if they are new, and updates
+// the presence of the locations
+func homeAssistantSeen(family, device, location string) {
+	homeAssistant.Lock()
+	settings, err := homeAssistantSettings(family)
+	if err != nil || !settings.Enabled {
+		homeAssistant.Unlock()
+		return
+	}
+	if _, ok := homeAssistant.locations[family]; !ok {
+		homeAssistant.locations[family] = make(map[string]string)
+	}
+	previous, online := homeAssistant.locations[family][device]
+	if location == "" {
+		delete(homeAssistant.locations[family], device)
+	} else {
+		homeAssistant.locations[family][device] = location
+	}
+	changed := []string{}
+	if previous != location {
+		if online {
+			changed = append(changed, previous)
+		}
+		if location != "" {
+			changed = append(changed, location)
+		}
+	}
+	presences := make([]models.Presence, len(changed))
+	for i, l := range changed {
+		presences[i] = presence(family, l)
+	}
+	homeAssistant.Unlock()
+
+	if location != "" {
+		announceDevice(family, settings, device)
+		announceLocation(family, settings, location)
+	}
+	for _, p := range presences {
+		b, _ := json.Marshal(p)
+		if err = publishRetained(family+"/presence/"+p.Location, b); err != nil {
+			logger.Log.Warn(err)
+		}
+	}
+}
+
+// presence must be called with the lock held
+func presence(family, location string) (p models.Presence) {
+	p = models.Presence{Location: location, Devices: []string{}}
+	for device, l := range homeAssistant.locations[family] {
+		if l == location {
+			p.Devices = append(p.Devices, device)
+		}
+	}
+	sort.Strings(p.Devices)
+	p.Count = len(p.Devices)
+	return
+}
+
+func objectID(family, name string) string {
+	return invalidObjectID.ReplaceAllString(strings.ToLower("find3_"+family+"_"+name), "_")
+}
+
+func configTopic(settings models.HomeAssistant, component, id string) string {
+	return settings.Prefix + "/" + component + "/" + id + "/config"
+}
+
+func announceDevice(family string, settings models.HomeAssistant, device string) {
+	id := objectID(family, device)
+	announce(family, configTopic(settings, "device_tracker", id), map[string]interface{}{
+		"name":                     device,
+		"unique_id":                id,
+		"object_id":                id,
+		"state_topic":              family + "/location/" + device,
+		"value_template":           "{{ value_json.location }}",
+		"json_attributes_topic":    family + "/location/" + device,
+		"json_attributes_template": "{{ {'probability': value_json.guesses[0].probability, 'guesses': value_json.guesses} | tojson }}",
+		"source_type":              "router",
+		"availability": []map[string]string{
+			{"topic": StatusTopic},
+			{"topic": family + "/status/device/" + device, "value_template": "{{ value_json.status }}"},
+		},
+		"availability_mode": "all",
+		"device": map[string]interface{}{
+			"identifiers":  []string{id},
+			"name":         device,
+			"manufacturer": "FIND3",
+			"model":        "Tracked device",
+		},
+	})
+}
+
+func announceLocation(family string, settings models.HomeAssistant, location string) {
+	id := objectID(family, location)
+	announce(family, configTopic(settings, "binary_sensor", id), map[string]interface{}{
+		"name":                  location,
+		"unique_id":             id,
+		"object_id":             id,
+		"device_class":          "occupancy",
+		"state_topic":           family + "/presence/" + location,
+		"value_template":        "{{ 'ON' if value_json.count > 0 else 'OFF' }}",
+		"json_attributes_topic": family + "/presence/" + location,
+		"availability_topic":    StatusTopic,
+		"device": map[string]interface{}{
+			"identifiers":  []string{"find3_" + invalidObjectID.ReplaceAllString(family, "_")},
+			"name":         "FIND3 " + family,
+			"manufacturer": "FIND3",
+			"model":        "Family",
+		},
+	})
+}
+
+// announce publishes a config, once
+func announce(family, topic string, config map[string]interface{}) {
+	homeAssistant.Lock()
+	if homeAssistant.announced[family] == nil {
+		homeAssistant.announced[family] = make(map[string]bool)
+	}
+	done := homeAssistant.announced[family][topic]
+	homeAssistant.announced[family][topic] = true
+	homeAssistant.Unlock()
+	if done {
+		return
+	}
+	b, err := json.Marshal(config)
+	if err == nil {
+		err = publishRetained(topic, b)
+	}
+	if err != nil {
+		logger.Log.Warn(err)
+		homeAssistant.Lock()
+		delete(homeAssistant.announced[family], topic)
+		homeAssistant.Unlock()
+		return
+	}
+	logger.Log.Debugf("[%s] announced %s to home assistant", family, topic)
+}
+
+func removeConfig(family string, settings models.HomeAssistant, component, name string) {
+	topic := configTopic(settings, component, objectID(family, name))
+	homeAssistant.Lock()
+	delete(homeAssistant.announced[family], topic)
+	homeAssistant.Unlock()
+	if err := publishRetained(topic, []byte{}); err != nil {
+		logger.Log.Warn(err)
+	}
+}
@@ -0,0 +1,96 @@
+package mqtt
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/Nimaapr/find3/server/main/src/database"
+	"github.com/Nimaapr/find3/server/main/src/models"
+)
+
+func TestHomeAssistant(t *testing.T) {
+	b := testBroker(t)
+	defer b.Close()
+	client, err := testClient(t, b, "server", "admin", "1234")
+	assert.Nil(t, err)
+	defer client.Disconnect(10)
+	adminClient, IsSetup = client, true
+	defer func() { IsSetup = false }()
+
+	folder, err := ioutil.TempDir("", "find3")
+	assert.Nil(t, err)
+	defer os.RemoveAll(folder)
+	defer func(dataFolder string) { database.DataFolder = dataFolder }(database.DataFolder)
+	database.DataFolder = folder
+	db, err := database.Open("labs")
+	assert.Nil(t, err)
+	assert.Nil(t, db.AddSensor(models.SensorData{Timestamp: 1, Family: "labs", Device: "phone", Location: "kitchen", Sensors: map[string]map[string]interface{}{"wifi": {"aa:bb": -50}}}))
+	db.Close()
+
+	labs, err := testClient(t, b, "labs", "labs", "secret")
+	assert.Nil(t, err)
+	defer labs.Disconnect(10)
+	messages, handler := receiver()
+	labs.Subscribe("labs/homeassistant/#", 1, handler).Wait()
+
+	settings, err := GetHomeAssistant("labs")
+	assert.Nil(t, err)
+	assert.False(t, settings.Enabled)
+	assert.Equal(t, "labs/homeassistant", settings.Prefix)
+	assert.NotNil(t, SetHomeAssistant("labs", models.HomeAssistant{Enabled: true, Prefix: "ha/#"}))
+	assert.Nil(t, SetHomeAssistant("labs", models.HomeAssistant{Enabled: true}))
+
+	configs := make(map[string]map[string]interface{})
+	for i := 0; i < 2; i++ {
+		select {
+		case m := <-messages:
+			var config map[string]interface{}
+			assert.Nil(t, json.Unmarshal(m.Payload(), &config))
+			configs[m.Topic()] = config
+		case <-time.After(2 * time.Second):
+			t.Fatal("configs were not announced")
+		}
+	}
+	assert.Equal(t, "labs/location/phone", configs["labs/homeassistant/device_tracker/find3_labs_phone/config"]["state_topic"])
+	assert.Equal(t, "labs/presence/kitchen", configs["labs/homeassistant/binary_sensor/find3_labs_kitchen/config"]["state_topic"])
+
+	// a new device and location are announced with their first location
+	labs.Subscribe("labs/presence/#", 1, handler).Wait()
+	assert.Nil(t, Publish("labs", "laptop", `{"location":"office","guesses":[{"location":"office","probability":1}]}`))
+	got := make(map[string][]byte)
+	for i := 0; i < 3; i++ {
+		select {
+		case m := <-messages:
+			got[m.Topic()] = m.Payload()
+		case <-time.After(2 * time.Second):
+			t.Fatal("laptop was not announced")
+		}
+	}
+	assert.Contains(t, got, "labs/homeassistant/device_tracker/find3_labs_laptop/config")
+	assert.Contains(t, got, "labs/homeassistant/binary_sensor/find3_labs_office/config")
+	var p models.Presence
+	assert.Nil(t, json.Unmarshal(got["labs/presence/office"], &p))
+	assert.Equal(t, []string{"laptop"}, p.Devices)
+
+	// deleting a location removes its config
+	RemoveLocation("labs", "office")
+	for i := 0; i < 2; i++ {
+		select {
+		case m := <-messages:
+			assert.Equal(t, 0, len(m.Payload()))
+		case <-time.After(2 * time.Second):
+			t.Fatal("office was not removed")
+		}
+	}
+	devices.Lock()
+	delete(devices.lastSeen, "labs/laptop")
+	devices.Unlock()
+	homeAssistant.Lock()
+	delete(homeAssistant.settings, "labs")
+	homeAssistant.Unlock()
+}
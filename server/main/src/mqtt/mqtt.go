@@ -7,6 +7,7 @@ import (
 	"os"
 	"os/exec"
 	"path"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -37,6 +38,15 @@ var (
 	adminClient MQTT.Client
 )
 
+// DataHandler and PassiveHandler process the sensor data sent as JSON to
+// FAMILY/data/DEVICE and FAMILY/passive/SCANNER. They are set by the server,
+// so that the data goes through the same steps as the data posted to /data
+// and /passive.
+var (
+	DataHandler    func(d models.SensorData) (message string, err error)
+	PassiveHandler func(d models.SensorData) (message string, err error)
+)
+
 func Setup() (err error) {
 	logger, _ = logging.New()
 	if Debug {
@@ -52,6 +62,13 @@ func Setup() (err error) {
 	if Existing {
 		logger.Log.Debug("using existing setup")
 		opts.AddBroker(server).SetClientID(utils.RandomString(5)).SetCleanSession(true)
+	} else if Embedded {
+		logger.Log.Debug("using embedded broker")
+		err = startBroker()
+		if err != nil {
+			return
+		}
+		opts.AddBroker(server).SetClientID(utils.RandomString(5)).SetUsername(AdminUser).SetPassword(AdminPassword).SetCleanSession(true)
 	} else {
 		logger.Log.Debug("using current setup")
 		err = updateMosquittoConfig()
@@ -62,12 +79,15 @@ func Setup() (err error) {
 		time.Sleep(3 * time.Second)
 		opts.AddBroker(server).SetClientID(utils.RandomString(5)).SetUsername(AdminUser).SetPassword(AdminPassword).SetCleanSession(true)
 	}
+	// subscribers learn that the device statuses are stale when the server goes away
+	opts.SetWill(StatusTopic, "offline", 1, true)
 	// subscribe
 	opts.OnConnect = func(c MQTT.Client) {
 		if token := c.Subscribe("#", 1, messageReceived); token.Wait() && token.Error() != nil {
 			err = errors.Wrap(token.Error(), "could not subscribe")
 			return
 		}
+		c.Publish(StatusTopic, 1, true, "online")
 	}
 
 	adminClient = MQTT.NewClient(opts)
@@ -77,6 +97,7 @@ func Setup() (err error) {
 	}
 	logger.Log.Debug("finished setup")
 	IsSetup = true
+	startDeviceStatus()
 	return
 }
 
@@ -109,14 +130,27 @@ func updateMosquittoConfig() (err error) {
 		conf = fmt.Sprintf("allow_anonymous false\n\nacl_file %s/acl\n\npassword_file %s/passwd\n\npid_file %s/pid", MosquittoConfigDirectory, MosquittoConfigDirectory, MosquittoConfigDirectory)
 	}
 
-	var passes map[string]string
-	errGet = db.Get("passes", &passes)
-	if errGet == nil {
-		for user := range passes {
-			acl = acl + fmt.Sprintf("user %s\ntopic readwrite %s/#\n\n", user, user)
-			passwd = passwd + fmt.Sprintf("%s:%s\n", user, passes[user])
+	credentials, err := loadCredentials(db)
+	if err != nil {
+		return
+	}
+	acl = acl + fmt.Sprintf("pattern read %s\n\n", StatusTopic)
+	usernames := make([]string, 0, len(credentials))
+	for username := range credentials {
+		usernames = append(usernames, username)
+	}
+	sort.Strings(usernames)
+	for _, username := range usernames {
+		c := credentials[username]
+		acl = acl + fmt.Sprintf("user %s\n", username)
+		for _, topic := range c.Topics {
+			acl = acl + fmt.Sprintf("topic %s %s\n", c.Access, topic)
 		}
+		acl = acl + "\n"
+		passwd = passwd + fmt.Sprintf("%s:%s\n", username, c.Hash)
 	}
+	// mosquitto only reads hashed passwords
+	passwd = hashPasswdLines(passwd)
 
 	os.MkdirAll(MosquittoConfigDirectory, 0755)
 	err = ioutil.WriteFile(path.Join(MosquittoConfigDirectory, "acl"), []byte(acl), 0644)
@@ -135,16 +169,9 @@ func updateMosquittoConfig() (err error) {
 		return
 	}
 
-	// generate passwords
-	cmd := "mosquitto_passwd"
-	args := []string{"-U", path.Join(MosquittoConfigDirectory, "passwd")}
-	err = exec.Command(cmd, args...).Run()
-	if err != nil {
-		err = errors.Wrap(err, "problem writing new passwd")
-		return
-	}
-
 	// regenerate mosquitto
+	var cmd string
+	var args []string
 	bPID, errPID := ioutil.ReadFile(path.Join(MosquittoConfigDirectory, "pid"))
 	if errPID != nil {
 		logger.Log.Debug("could not get PID, running")
@@ -167,40 +194,38 @@ func updateMosquittoConfig() (err error) {
 	return
 }
 
-// add pushes a new family into the database
-func add(family string) (password string, err error) {
-	// open the database that stores the basic parameters
-	db, err := database.Open("mosquitto", false, true)
-	if err != nil {
-		return
-	}
-	defer db.Close()
+// AddFamily gives the default credential of a family a new password
+func AddFamily(family string) (password string, err error) {
+	return RotateCredential(family, DefaultCredential)
+}
 
-	var passes map[string]string
-	errGet := db.Get("passes", &passes)
-	if errGet != nil {
-		passes = make(map[string]string)
+func Publish(family, device, message string) (err error) {
+	if !IsSetup {
+		return errors.New("mqtt not setup")
 	}
-	password = utils.RandomString(5)
-	passes[family] = password
-	err = db.Set("passes", passes)
-	return
-}
+	pubTopic := strings.Join([]string{family, "/location/", device}, "")
 
-func AddFamily(family string) (password string, err error) {
-	password, err = add(family)
-	if err != nil {
+	// retained, so new subscribers get the last location right away
+	if token := adminClient.Publish(pubTopic, 1, true, message); token.Wait() && token.Error() != nil {
+		err = fmt.Errorf("Failed to send message")
 		return
 	}
-	err = updateMosquittoConfig()
+	deviceSeen(family, device)
+	var payload struct {
+		Location string `json:"location"`
+	}
+	if json.Unmarshal([]byte(message), &payload) == nil && payload.Location != "" {
+		homeAssistantSeen(family, device, payload.Location)
+	}
 	return
 }
 
-func Publish(family, device, message string) (err error) {
+// PublishEvent sends an event (see models.Event) to the topic FAMILY/events/DEVICE
+func PublishEvent(family, device, message string) (err error) {
 	if !IsSetup {
 		return errors.New("mqtt not setup")
 	}
-	pubTopic := strings.Join([]string{family, "/location/", device}, "")
+	pubTopic := strings.Join([]string{family, "/events/", device}, "")
 
 	if token := adminClient.Publish(pubTopic, 1, false, message); token.Wait() && token.Error() != nil {
 		err = fmt.Errorf("Failed to send message")
@@ -209,6 +234,26 @@ func Publish(family, device, message string) (err error) {
 }
 
 func messageReceived(client MQTT.Client, msg MQTT.Message) {
+	if msg.Retained() && restoreDeviceStatus(msg.Topic(), msg.Payload()) {
+		return
+	}
+	if d, route, err := mqttBuildSensorData(msg.Topic(), msg.Payload()); err == nil {
+		handler := DataHandler
+		if route == "passive" {
+			handler = PassiveHandler
+		}
+		if handler == nil {
+			logger.Log.Warnf("no handler for mqtt %s data", route)
+			return
+		}
+		logger.Log.Debugf("[%s] got mqtt %s data for %s", d.Family, route, d.Device)
+		_, err = handler(d)
+		if err != nil {
+			logger.Log.Warnf("[%s] problem with mqtt %s data for %s: %s", d.Family, route, d.Device, err.Error())
+		}
+		return
+	}
+
 	jsonFingerprint, route, err := mqttBuildFingerprint(msg.Topic(), msg.Payload())
 	if err != nil {
 		return
@@ -218,6 +263,11 @@ func messageReceived(client MQTT.Client, msg MQTT.Message) {
 		jsonFingerprint.Location = ""
 	}
 	d := jsonFingerprint.Convert()
+	err = api.NormalizeSensorData(&d)
+	if err != nil {
+		logger.Log.Error(err)
+		return
+	}
 	err = api.SaveSensorData(d)
 	if err != nil {
 		logger.Log.Error(err)
@@ -249,6 +299,26 @@ func sendOutData(p models.SensorData) (analysis models.LocationAnalysis, err err
 	return
 }
 
+// mqttBuildSensorData reads the JSON sensor data (models.SensorData) sent to
+// FAMILY/data/DEVICE or FAMILY/passive/SCANNER. The family and the device are
+// taken from the topic, since the topic is what the ACL of the sender allows.
+func mqttBuildSensorData(topic string, message []byte) (d models.SensorData, route string, err error) {
+	topics := strings.Split(strings.ToLower(topic), "/")
+	if len(topics) != 3 || (topics[1] != "data" && topics[1] != "passive") {
+		err = fmt.Errorf("not a data topic")
+		return
+	}
+	route = topics[1]
+	err = json.Unmarshal(message, &d)
+	if err != nil {
+		err = errors.Wrap(err, "problem parsing sensor data")
+		return
+	}
+	d.Family = topics[0]
+	d.Device = topics[2]
+	return
+}
+
 // backwards compatible with FIND
 func mqttBuildFingerprint(topic string, message []byte) (jsonFingerprint models.FINDFingerprint, route string, err error) {
 	err = nil
@@ -17,3 +17,22 @@ func TestMQTT(t *testing.T) {
 	assert.Nil(t, err)
 	fmt.Println(password)
 }
+
+func TestBuildSensorData(t *testing.T) {
+	d, route, err := mqttBuildSensorData("Labs/data/Phone", []byte(`{"t":1520424248897,"f":"other","d":"laptop","s":{"bluetooth":{"aa:bb:cc:dd:ee:ff":-50}}}`))
+	assert.Nil(t, err)
+	assert.Equal(t, "data", route)
+	assert.Equal(t, "labs", d.Family)
+	assert.Equal(t, "phone", d.Device)
+	assert.Equal(t, int64(1520424248897), d.Timestamp)
+	assert.Equal(t, float64(-50), d.Sensors["bluetooth"]["aa:bb:cc:dd:ee:ff"])
+
+	_, route, err = mqttBuildSensorData("labs/passive/scanner1", []byte(`{"s":{"wifi":{"aa:bb:cc:dd:ee:ff":-70}}}`))
+	assert.Nil(t, err)
+	assert.Equal(t, "passive", route)
+
+	_, _, err = mqttBuildSensorData("labs/track/phone", []byte(`{}`))
+	assert.NotNil(t, err)
+	_, _, err = mqttBuildSensorData("labs/data/phone", []byte(`aabbccddeeff50`))
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,167 @@
+package mqtt
+
+/*
+This code publishes the state of the families as retained messages, so a client that subscribes gets the current
+state right away instead of waiting for the next fingerprint:
+
+	FAMILY/location/DEVICE        the last location payload of the device (see Publish)
+	FAMILY/status/device/DEVICE   {"device":"DEVICE","status":"online","timestamp":...}
+	FAMILY/status/calibration     the accuracy after the last calibration (see models.CalibrationSummary)
+	find3/status                  "online" while the server is connected, "offline" (its last will) otherwise
+
+A device is online from its first location until no location has been published for DeviceTimeout. The statuses
+are kept in memory; after a restart they are read back from the retained messages on the broker, so devices that
+went away while the server was down are still marked offline. The device statuses are only current while
+find3/status is "online".
+*/
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/Nimaapr/find3/server/main/src/models"
+)
+
+// StatusTopic is where the server publishes whether it is online, it is readable by every user
+const StatusTopic = "find3/status"
+
+var (
+	// DeviceTimeout is how long a device stays online after its last location
+	DeviceTimeout = 5 * time.Minute
+	// DeviceStatusInterval is how often devices are checked for the timeout
+	DeviceStatusInterval = 30 * time.Second
+)
+
+var devices = struct {
+	// lastSeen is the time of the last location of the online devices, by "FAMILY/DEVICE"
+	lastSeen map[string]time.Time
+	sync.Mutex
+}{lastSeen: make(map[string]time.Time)}
+
+var startStatusOnce sync.Once
+
+// PublishCalibration sends the accuracy of the last calibration to FAMILY/status/calibration
+func PublishCalibration(summary models.CalibrationSummary) (err error) {
+	b, err := json.Marshal(summary)
+	if err != nil {
+		return
+	}
+	return publishRetained(summary.Family+"/status/calibration", b)
+}
+
+func publishRetained(topic string, payload []byte) (err error) {
+	if !IsSetup {
+		return errors.New("mqtt not setup")
+	}
+	if token := adminClient.Publish(topic, 1, true, payload); token.Wait() && token.Error() != nil {
+		err = fmt.Errorf("Failed to send message")
+	}
+	return
+}
+
+func publishDeviceStatus(family, device, status string, t time.Time) (err error) {
+	b, err := json.Marshal(models.DeviceStatus{
+		Device:    device,
+		Status:    status,
+		Timestamp: t.UnixNano() / int64(time.Millisecond),
+	})
+	if err != nil {
+		return
+	}
+	logger.Log.Debugf("[%s] %s is %s", family, device, status)
+	return publishRetained(family+"/status/device/"+device, b)
+}
+
+// deviceSeen marks a device online, publishing its status if it was not
+func deviceSeen(family, device string) {
+	now := time.Now().UTC()
+	key := family + "/" + device
+	devices.Lock()
+	_, online := devices.lastSeen[key]
+	devices.lastSeen[key] = now
+	devices.Unlock()
+	if !online {
+		if err := publishDeviceStatus(family, device, "online", now); err != nil {
+			logger.Log.Warn(err)
+		}
+	}
+}
+
+// expireDevices marks offline the devices that were not seen for DeviceTimeout
+func expireDevices(now time.Time) {
+	var expired []string
+	devices.Lock()
+	for key, seen := range devices.lastSeen {
+		if now.Sub(seen) > DeviceTimeout {
+			expired = append(expired, key)
+			delete(devices.lastSeen, key)
+		}
+	}
+	devices.Unlock()
+	for _, key := range expired {
+		parts := strings.SplitN(key, "/", 2)
+		if err := publishDeviceStatus(parts[0], parts[1], "offline", now); err != nil {
+			logger.Log.Warn(err)
+		}
+		homeAssistantSeen(parts[0], parts[1], "")
+	}
+}
+
+// RemoveDevice clears the retained messages of a device that was erased, and its Home Assistant config
+func RemoveDevice(family, device string) (err error) {
+	if !IsSetup {
+		return errors.New("mqtt not setup")
+	}
+	devices.Lock()
+	delete(devices.lastSeen, family+"/"+device)
+	devices.Unlock()
+	homeAssistantSeen(family, device, "")
+
+	homeAssistant.Lock()
+	settings, errSettings := homeAssistantSettings(family)
+	homeAssistant.Unlock()
+	if errSettings == nil && settings.Enabled {
+		removeConfig(family, settings, "device_tracker", device)
+	}
+	for _, topic := range []string{family + "/location/" + device, family + "/status/device/" + device} {
+		if err = publishRetained(topic, []byte{}); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// restoreDeviceStatus reads back a retained device status, returning whether the topic was one
+func restoreDeviceStatus(topic string, payload []byte) bool {
+	parts := strings.Split(topic, "/")
+	if len(parts) != 4 || parts[1] != "status" || parts[2] != "device" {
+		return false
+	}
+	var status models.DeviceStatus
+	if err := json.Unmarshal(payload, &status); err != nil || status.Status != "online" {
+		return true
+	}
+	key := parts[0] + "/" + parts[3]
+	devices.Lock()
+	if _, ok := devices.lastSeen[key]; !ok {
+		devices.lastSeen[key] = time.Unix(0, status.Timestamp*int64(time.Millisecond)).UTC()
+	}
+	devices.Unlock()
+	return true
+}
+
+func startDeviceStatus() {
+	startStatusOnce.Do(func() {
+		go func() {
+			for {
+				time.Sleep(DeviceStatusInterval)
+				expireDevices(time.Now().UTC())
+			}
+		}()
+	})
+}
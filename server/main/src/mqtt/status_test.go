@@ -0,0 +1,70 @@
+package mqtt
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/Nimaapr/find3/server/main/src/logging"
+	"github.com/Nimaapr/find3/server/main/src/models"
+)
+
+func TestDeviceStatus(t *testing.T) {
+	if logger == nil {
+		logger, _ = logging.New()
+	}
+	b := testBroker(t)
+	defer b.Close()
+	client, err := testClient(t, b, "server", "admin", "1234")
+	assert.Nil(t, err)
+	defer client.Disconnect(10)
+	adminClient, IsSetup = client, true
+	defer func() { IsSetup = false }()
+
+	assert.Nil(t, Publish("labs", "phone", `{"guesses":[]}`))
+	assert.Nil(t, PublishCalibration(models.CalibrationSummary{Family: "labs", Accuracy: 0.9}))
+
+	// a new subscriber gets the current state
+	labs, err := testClient(t, b, "labs", "labs", "secret")
+	assert.Nil(t, err)
+	messages, handler := receiver()
+	labs.Subscribe("labs/location/#", 1, handler).Wait()
+	expectMessage(t, messages, "labs/location/phone", `{"guesses":[]}`)
+	labs.Subscribe("labs/status/#", 1, handler).Wait()
+	got := make(map[string][]byte)
+	for i := 0; i < 2; i++ {
+		select {
+		case m := <-messages:
+			got[m.Topic()] = m.Payload()
+		case <-time.After(2 * time.Second):
+			t.Fatal("did not get the statuses")
+		}
+	}
+	var status models.DeviceStatus
+	assert.Nil(t, json.Unmarshal(got["labs/status/device/phone"], &status))
+	assert.Equal(t, "online", status.Status)
+	var summary models.CalibrationSummary
+	assert.Nil(t, json.Unmarshal(got["labs/status/calibration"], &summary))
+	assert.Equal(t, 0.9, summary.Accuracy)
+
+	// the device goes offline after the timeout
+	expireDevices(time.Now().Add(DeviceTimeout + time.Second))
+	select {
+	case m := <-messages:
+		assert.Nil(t, json.Unmarshal(m.Payload(), &status))
+		assert.Equal(t, "offline", status.Status)
+	case <-time.After(2 * time.Second):
+		t.Fatal("device did not go offline")
+	}
+
+	// statuses are read back after a restart
+	assert.True(t, restoreDeviceStatus("labs/status/device/laptop", []byte(`{"device":"laptop","status":"online","timestamp":1000}`)))
+	assert.False(t, restoreDeviceStatus("labs/status/calibration", []byte(`{}`)))
+	devices.Lock()
+	assert.Equal(t, int64(1000), devices.lastSeen["labs/laptop"].UnixNano()/int64(time.Millisecond))
+	delete(devices.lastSeen, "labs/laptop")
+	devices.Unlock()
+	labs.Disconnect(10)
+}
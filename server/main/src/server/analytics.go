@@ -0,0 +1,115 @@
+package server
+
+import (
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+
+	"github.com/Nimaapr/find3/server/main/src/analytics"
+	"github.com/Nimaapr/find3/server/main/src/models"
+)
+
+// handlerOccupancy returns the unique devices per location for each interval, by default hourly over the last day
+func handlerOccupancy(c *gin.Context) {
+	occupancy, err := func(c *gin.Context) (occupancy []models.Occupancy, err error) {
+		family := strings.ToLower(strings.TrimSpace(c.Param("family")))
+		interval, err := time.ParseDuration(c.DefaultQuery("interval", "1h"))
+		if err != nil {
+			err = errors.Wrap(err, "bad interval")
+			return
+		}
+		from, to, err := parseTimeRange(c, 24*time.Hour)
+		if err != nil {
+			return
+		}
+		showRandomized := c.DefaultQuery("randomized", "0") == "1"
+		occupancy, err = analytics.Occupancy(family, interval, from, to, showRandomized)
+		return
+	}(c)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"message": err.Error(), "success": false})
+	} else {
+		c.JSON(http.StatusOK, gin.H{"message": "got occupancy", "success": true, "occupancy": occupancy})
+	}
+}
+
+// handlerDwell returns the time spent at each location, by default over the last week.
+// With ?format=csv the result is a CSV file with the times in seconds.
+func handlerDwell(c *gin.Context) {
+	family := strings.ToLower(strings.TrimSpace(c.Param("family")))
+	dwell, err := func(c *gin.Context) (dwell []models.LocationDwell, err error) {
+		from, to, err := parseTimeRange(c, 7*24*time.Hour)
+		if err != nil {
+			return
+		}
+		dwell, err = analytics.Dwell(family, from, to, c.DefaultQuery("randomized", "0") == "1")
+		return
+	}(c)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"message": err.Error(), "success": false})
+		return
+	}
+	if c.Query("format") != "csv" {
+		c.JSON(http.StatusOK, gin.H{"message": "got dwell times", "success": true, "dwell": dwell})
+		return
+	}
+	records := [][]string{{"location", "visits", "total_seconds", "mean_seconds", "max_seconds"}}
+	for _, d := range dwell {
+		records = append(records, []string{
+			d.Location,
+			strconv.Itoa(d.Visits),
+			strconv.FormatFloat(float64(d.Total)/1000, 'f', 1, 64),
+			strconv.FormatFloat(float64(d.Mean)/1000, 'f', 1, 64),
+			strconv.FormatFloat(float64(d.Max)/1000, 'f', 1, 64),
+		})
+	}
+	writeCSV(c, family+"-dwell.csv", records)
+}
+
+// handlerTransitions returns the counts of moves between locations, by default over the last week.
+// With ?format=csv the result is a CSV file with a row for each location moved from.
+func handlerTransitions(c *gin.Context) {
+	family := strings.ToLower(strings.TrimSpace(c.Param("family")))
+	matrix, err := func(c *gin.Context) (matrix models.TransitionMatrix, err error) {
+		from, to, err := parseTimeRange(c, 7*24*time.Hour)
+		if err != nil {
+			return
+		}
+		matrix, err = analytics.Transitions(family, from, to, c.DefaultQuery("randomized", "0") == "1")
+		return
+	}(c)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"message": err.Error(), "success": false})
+		return
+	}
+	if c.Query("format") != "csv" {
+		c.JSON(http.StatusOK, gin.H{"message": "got transitions", "success": true, "transitions": matrix})
+		return
+	}
+	records := [][]string{append([]string{"from"}, matrix.Locations...)}
+	for i, row := range matrix.Counts {
+		record := []string{matrix.Locations[i]}
+		for _, count := range row {
+			record = append(record, strconv.Itoa(count))
+		}
+		records = append(records, record)
+	}
+	writeCSV(c, family+"-transitions.csv", records)
+}
+
+func writeCSV(c *gin.Context, filename string, records [][]string) {
+	c.Header("Content-Type", "text/csv")
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	c.Status(http.StatusOK)
+	w := csv.NewWriter(c.Writer)
+	err := w.WriteAll(records)
+	if err != nil {
+		logger.Log.Warn(err)
+	}
+}
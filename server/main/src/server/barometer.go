@@ -0,0 +1,44 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+
+	"github.com/Nimaapr/find3/server/main/src/api"
+	"github.com/Nimaapr/find3/server/main/src/models"
+)
+
+func handlerBarometerReference(c *gin.Context) {
+	family := strings.ToLower(strings.TrimSpace(c.Param("family")))
+	reference, err := api.GetBarometerReference(family)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"message": err.Error(), "success": false})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "got barometer reference", "success": true, "reference": reference})
+}
+
+// handlerSetBarometerReference makes the device in the request the reference barometer
+func handlerSetBarometerReference(c *gin.Context) {
+	reference, err := func(c *gin.Context) (reference models.BarometerReference, err error) {
+		family := strings.ToLower(strings.TrimSpace(c.Param("family")))
+		var request struct {
+			Device string `json:"device"`
+		}
+		err = c.BindJSON(&request)
+		if err != nil {
+			err = errors.Wrap(err, "problem binding data")
+			return
+		}
+		reference, err = api.SetBarometerReference(family, request.Device)
+		return
+	}(c)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"message": err.Error(), "success": false})
+	} else {
+		c.JSON(http.StatusOK, gin.H{"message": "set barometer reference", "success": true, "reference": reference})
+	}
+}
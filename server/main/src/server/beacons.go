@@ -0,0 +1,54 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+
+	"github.com/Nimaapr/find3/server/main/src/api"
+	"github.com/Nimaapr/find3/server/main/src/models"
+)
+
+func handlerBeacons(c *gin.Context) {
+	family := strings.ToLower(strings.TrimSpace(c.Param("family")))
+	beacons, err := api.GetBeacons(family)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"message": err.Error(), "success": false})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "got beacons", "success": true, "beacons": beacons})
+}
+
+// handlerSetBeacons places the beacons of the request in the registry
+func handlerSetBeacons(c *gin.Context) {
+	beacons, err := func(c *gin.Context) (beacons map[string]models.Beacon, err error) {
+		family := strings.ToLower(strings.TrimSpace(c.Param("family")))
+		var request struct {
+			Beacons []models.Beacon `json:"beacons"`
+		}
+		err = c.BindJSON(&request)
+		if err != nil {
+			err = errors.Wrap(err, "problem binding data")
+			return
+		}
+		beacons, err = api.SetBeacons(family, request.Beacons)
+		return
+	}(c)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"message": err.Error(), "success": false})
+	} else {
+		c.JSON(http.StatusOK, gin.H{"message": "set beacons", "success": true, "beacons": beacons})
+	}
+}
+
+func handlerDeleteBeacon(c *gin.Context) {
+	family := strings.ToLower(strings.TrimSpace(c.Param("family")))
+	err := api.DeleteBeacon(family, c.Param("beacon"))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"message": err.Error(), "success": false})
+	} else {
+		c.JSON(http.StatusOK, gin.H{"message": "deleted beacon " + c.Param("beacon"), "success": true})
+	}
+}
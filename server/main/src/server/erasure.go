@@ -0,0 +1,66 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/Nimaapr/find3/server/main/src/api"
+	"github.com/Nimaapr/find3/server/main/src/models"
+	"github.com/Nimaapr/find3/server/main/src/mqtt"
+)
+
+// handlerExportDevice returns everything stored about a device
+func handlerExportDevice(c *gin.Context) {
+	family := strings.ToLower(strings.TrimSpace(c.Param("family")))
+	export, err := api.ExportDevice(family, c.Param("device"), c.ClientIP())
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"message": err.Error(), "success": false})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "exported " + export.Device, "success": true, "export": export})
+}
+
+// handlerEraseDevice removes everything stored about a device
+func handlerEraseDevice(c *gin.Context) {
+	family := strings.ToLower(strings.TrimSpace(c.Param("family")))
+	entry, err := eraseDevice(family, c.Param("device"), c.ClientIP())
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"message": err.Error(), "success": false})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "erased " + entry.Device, "success": true, "audit": entry})
+}
+
+func handlerAudit(c *gin.Context) {
+	family := strings.ToLower(strings.TrimSpace(c.Param("family")))
+	entries, err := api.GetAudit(family)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"message": err.Error(), "success": false})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "got audit log", "success": true, "audit": entries})
+}
+
+// eraseDevice erases a device and forgets it on MQTT and in the models. It is forgotten in the passive
+// windows and settings by forgetPassive, which api.EraseDevice calls.
+func eraseDevice(family, device, actor string) (entry models.AuditEntry, err error) {
+	entry, err = api.EraseDevice(family, device, actor)
+	if err != nil {
+		return
+	}
+	if UseMQTT {
+		if errMQTT := mqtt.RemoveDevice(family, entry.Device); errMQTT != nil {
+			logger.Log.Warn(errMQTT)
+		}
+	}
+	if entry.Counts["learning"] > 0 {
+		go func() {
+			if errCalibrate := api.Calibrate(family, true); errCalibrate != nil {
+				logger.Log.Warnf("[%s] problem calibrating after erasing %s: %s", family, entry.Device, errCalibrate.Error())
+			}
+		}()
+	}
+	return
+}
@@ -0,0 +1,81 @@
+package server
+
+/*
+This code sends out the events of devices (see models.Event), such as a change of location or entering a zone.
+
+Each event is stored in the events table, and then sent
+
+- over the websockets of the family that connect with device=events, as {"event": {...}},
+- over MQTT to the topic FAMILY/events/DEVICE, when MQTT is enabled,
+- to the matching webhooks of the family (see api/webhooks.go).
+*/
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+
+	"github.com/Nimaapr/find3/server/main/src/api"
+	"github.com/Nimaapr/find3/server/main/src/database"
+	"github.com/Nimaapr/find3/server/main/src/models"
+	"github.com/Nimaapr/find3/server/main/src/mqtt"
+)
+
+func sendOutEvent(e models.Event) {
+	e, err := api.RecordEvent(e)
+	if err != nil {
+		logger.Log.Warnf("[%s] problem recording %s event: %s", e.Family, e.Type, err.Error())
+	}
+	bTarget, err := json.Marshal(gin.H{"event": e})
+	if err != nil {
+		logger.Log.Warn(err)
+		return
+	}
+	logger.Log.Debugf("[%s] %s event for %s", e.Family, e.Type, e.Device)
+	SendMessageOverWebsockets(e.Family, "events", bTarget)
+	if UseMQTT {
+		mqtt.PublishEvent(e.Family, e.Device, string(bTarget))
+	}
+	go api.FireWebhooks(e)
+}
+
+func handlerEvents(c *gin.Context) {
+	events, err := func(c *gin.Context) (events []models.Event, err error) {
+		family := strings.ToLower(strings.TrimSpace(c.Param("family")))
+		filter := database.EventFilter{
+			Device: strings.TrimSpace(c.DefaultQuery("device", "")),
+			Zone:   strings.ToLower(strings.TrimSpace(c.DefaultQuery("zone", ""))),
+			Type:   strings.TrimSpace(c.DefaultQuery("type", "")),
+		}
+		if filter.Type != "" && !models.IsEventType(filter.Type) {
+			err = errors.New("unknown event type '" + filter.Type + "'")
+			return
+		}
+		filter.From, err = strconv.ParseInt(c.DefaultQuery("from", "0"), 10, 64)
+		if err != nil {
+			err = errors.Wrap(err, "bad from")
+			return
+		}
+		filter.To, err = strconv.ParseInt(c.DefaultQuery("to", "0"), 10, 64)
+		if err != nil {
+			err = errors.Wrap(err, "bad to")
+			return
+		}
+		filter.Limit, err = strconv.Atoi(c.DefaultQuery("limit", "100"))
+		if err != nil {
+			err = errors.Wrap(err, "bad limit")
+			return
+		}
+		events, err = api.GetEvents(family, filter)
+		return
+	}(c)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"message": err.Error(), "success": false})
+	} else {
+		c.JSON(http.StatusOK, gin.H{"message": "got events", "success": true, "events": events})
+	}
+}
@@ -0,0 +1,192 @@
+package server
+
+import (
+	"encoding/json"
+	"html/template"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+
+	"github.com/Nimaapr/find3/server/main/src/api"
+	"github.com/Nimaapr/find3/server/main/src/models"
+)
+
+func handlerFloorplans(c *gin.Context) {
+	family := strings.ToLower(strings.TrimSpace(c.Param("family")))
+	floorplans, coordinates, err := api.GetFloorplans(family)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"message": err.Error(), "success": false})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "got floorplans", "success": true, "floorplans": floorplans, "coordinates": coordinates})
+}
+
+// handlerUploadFloorplan stores the image of a floor, sent as the "image" file of a multipart form,
+// with its scale in the "pixels_per_meter" field
+func handlerUploadFloorplan(c *gin.Context) {
+	floorplan, err := func(c *gin.Context) (floorplan models.Floorplan, err error) {
+		family := strings.ToLower(strings.TrimSpace(c.Param("family")))
+		pixelsPerMeter := 0.0
+		if s := c.PostForm("pixels_per_meter"); s != "" {
+			pixelsPerMeter, err = strconv.ParseFloat(s, 64)
+			if err != nil {
+				err = errors.Wrap(err, "problem parsing pixels_per_meter")
+				return
+			}
+		}
+		var img []byte
+		if fileHeader, errFile := c.FormFile("image"); errFile == nil {
+			if fileHeader.Size > api.FloorplanMaxSize {
+				err = errors.Errorf("floorplan is larger than %d bytes", api.FloorplanMaxSize)
+				return
+			}
+			file, errOpen := fileHeader.Open()
+			if errOpen != nil {
+				err = errors.Wrap(errOpen, "problem reading image")
+				return
+			}
+			img, err = ioutil.ReadAll(file)
+			file.Close()
+			if err != nil {
+				err = errors.Wrap(err, "problem reading image")
+				return
+			}
+		}
+		floorplan, err = api.SaveFloorplan(family, c.Param("floor"), img, pixelsPerMeter)
+		return
+	}(c)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"message": err.Error(), "success": false})
+	} else {
+		c.JSON(http.StatusOK, gin.H{"message": "saved floorplan of " + floorplan.Floor, "success": true, "floorplan": floorplan})
+	}
+}
+
+func handlerFloorplanImage(c *gin.Context) {
+	family := strings.ToLower(strings.TrimSpace(c.Param("family")))
+	img, contentType, err := api.GetFloorplanImage(family, c.Param("floor"))
+	if err != nil {
+		c.String(http.StatusNotFound, err.Error())
+		return
+	}
+	c.Data(http.StatusOK, contentType, img)
+}
+
+func handlerDeleteFloorplan(c *gin.Context) {
+	family := strings.ToLower(strings.TrimSpace(c.Param("family")))
+	err := api.DeleteFloorplan(family, c.Param("floor"))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"message": err.Error(), "success": false})
+	} else {
+		c.JSON(http.StatusOK, gin.H{"message": "deleted floorplan of " + c.Param("floor"), "success": true})
+	}
+}
+
+// handlerSetLocationCoordinates places the locations in the request on floors
+func handlerSetLocationCoordinates(c *gin.Context) {
+	coordinates, err := func(c *gin.Context) (coordinates map[string]models.LocationCoordinate, err error) {
+		family := strings.ToLower(strings.TrimSpace(c.Param("family")))
+		var changes []models.LocationCoordinate
+		err = c.BindJSON(&changes)
+		if err != nil {
+			err = errors.Wrap(err, "problem binding data")
+			return
+		}
+		coordinates, err = api.SetLocationCoordinates(family, changes)
+		return
+	}(c)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"message": err.Error(), "success": false})
+	} else {
+		c.JSON(http.StatusOK, gin.H{"message": "set coordinates", "success": true, "coordinates": coordinates})
+	}
+}
+
+func handlerDeleteLocationCoordinate(c *gin.Context) {
+	family := strings.ToLower(strings.TrimSpace(c.Param("family")))
+	err := api.DeleteLocationCoordinate(family, c.Param("location"))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"message": err.Error(), "success": false})
+	} else {
+		c.JSON(http.StatusOK, gin.H{"message": "deleted coordinate of " + c.Param("location"), "success": true})
+	}
+}
+
+// handlerFloorplanView shows a floor with its locations, and the devices at them and their
+// tracked positions as they come in over the websocket
+func handlerFloorplanView(c *gin.Context) {
+	family := strings.ToLower(strings.TrimSpace(c.Param("family")))
+	err := func(family string) (err error) {
+		floorplans, coordinates, err := api.GetFloorplans(family)
+		if err != nil {
+			return
+		}
+		if len(floorplans) == 0 {
+			err = errors.New("no floorplans yet, upload one with POST /api/v1/floorplans/" + family + "/FLOOR")
+			return
+		}
+		floorplan := floorplans[0]
+		for _, f := range floorplans {
+			if f.Floor == strings.ToLower(c.Query("floor")) {
+				floorplan = f
+			}
+		}
+
+		// the locations of the floor, in percent of the image so they scale with it
+		type marker struct {
+			X float64 `json:"x"`
+			Y float64 `json:"y"`
+		}
+		markers := make(map[string]marker)
+		for location, coordinate := range coordinates {
+			if coordinate.Floor != floorplan.Floor || floorplan.Width == 0 || floorplan.Height == 0 {
+				continue
+			}
+			x, y, ok := coordinate.Pixels(floorplan)
+			if !ok {
+				continue
+			}
+			markers[location] = marker{X: 100 * x / float64(floorplan.Width), Y: 100 * y / float64(floorplan.Height)}
+		}
+		bMarkers, err := json.Marshal(markers)
+		if err != nil {
+			return
+		}
+		// a meter in percent of the image, for the tracked positions, which are in meters
+		scale := marker{}
+		if floorplan.PixelsPerMeter > 0 && floorplan.Width > 0 && floorplan.Height > 0 {
+			scale = marker{X: 100 * floorplan.PixelsPerMeter / float64(floorplan.Width), Y: 100 * floorplan.PixelsPerMeter / float64(floorplan.Height)}
+		}
+		bScale, err := json.Marshal(scale)
+		if err != nil {
+			return
+		}
+
+		c.HTML(http.StatusOK, "floorplan.tmpl", gin.H{
+			"Floorplan":   true,
+			"Family":      family,
+			"Device":      "all",
+			"FamilyJS":    template.JS(family),
+			"DeviceJS":    template.JS("all"),
+			"Floorplans":  floorplans,
+			"Floor":       floorplan,
+			"LocationsJS": template.JS(bMarkers),
+			"ScaleJS":     template.JS(bScale),
+		})
+		return
+	}(family)
+	if err != nil {
+		c.HTML(http.StatusOK, "floorplan.tmpl", gin.H{
+			"Floorplan":    true,
+			"ErrorMessage": err.Error(),
+			"Family":       family,
+			"Device":       "all",
+			"FamilyJS":     template.JS(family),
+			"DeviceJS":     template.JS("all"),
+		})
+	}
+}
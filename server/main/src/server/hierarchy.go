@@ -0,0 +1,56 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+
+	"github.com/Nimaapr/find3/server/main/src/api"
+	"github.com/Nimaapr/find3/server/main/src/models"
+)
+
+func handlerHierarchy(c *gin.Context) {
+	family := strings.ToLower(strings.TrimSpace(c.Param("family")))
+	hierarchy, settings, err := api.GetHierarchy(family)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"message": err.Error(), "success": false})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "got hierarchy", "success": true, "hierarchy": hierarchy, "settings": settings})
+}
+
+// handlerSetHierarchy places the locations of the request in the hierarchy, and turns
+// two-stage classification on or off when "two_stage" is given
+func handlerSetHierarchy(c *gin.Context) {
+	err := func(c *gin.Context) (err error) {
+		family := strings.ToLower(strings.TrimSpace(c.Param("family")))
+		var request struct {
+			Locations []models.LocationHierarchy `json:"locations"`
+			TwoStage  *bool                      `json:"two_stage"`
+		}
+		err = c.BindJSON(&request)
+		if err != nil {
+			err = errors.Wrap(err, "problem binding data")
+			return
+		}
+		err = api.SetHierarchy(family, request.Locations, request.TwoStage)
+		return
+	}(c)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"message": err.Error(), "success": false})
+	} else {
+		c.JSON(http.StatusOK, gin.H{"message": "set hierarchy, calibrate to use it", "success": true})
+	}
+}
+
+func handlerDeleteHierarchy(c *gin.Context) {
+	family := strings.ToLower(strings.TrimSpace(c.Param("family")))
+	err := api.DeleteHierarchy(family, c.Param("location"))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"message": err.Error(), "success": false})
+	} else {
+		c.JSON(http.StatusOK, gin.H{"message": "removed " + c.Param("location") + " from the hierarchy", "success": true})
+	}
+}
@@ -0,0 +1,60 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+
+	"github.com/Nimaapr/find3/server/main/src/api"
+	"github.com/Nimaapr/find3/server/main/src/models"
+)
+
+// handlerHistory returns the visits and the tracked positions of a device, by default over the last day
+func handlerHistory(c *gin.Context) {
+	var positions []models.TrackedPosition
+	visits, from, to, err := func(c *gin.Context) (visits []models.Visit, from, to int64, err error) {
+		family := strings.ToLower(strings.TrimSpace(c.Param("family")))
+		device := strings.TrimSpace(c.Param("device"))
+		from, to, err = parseTimeRange(c, 24*time.Hour)
+		if err != nil {
+			return
+		}
+		visits, err = api.GetHistory(family, device, from, to)
+		if err != nil {
+			return
+		}
+		positions, err = api.GetTrackedPositions(family, device, from, to)
+		return
+	}(c)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"message": err.Error(), "success": false})
+	} else {
+		c.JSON(http.StatusOK, gin.H{"message": "got history", "success": true, "from": from, "to": to, "visits": visits, "positions": positions})
+	}
+}
+
+// parseTimeRange reads the "from" and "to" query parameters, in milliseconds.
+// "to" defaults to now and "from" to the span before "to".
+func parseTimeRange(c *gin.Context, span time.Duration) (from, to int64, err error) {
+	to = time.Now().UTC().UnixNano() / int64(time.Millisecond)
+	if c.Query("to") != "" {
+		to, err = strconv.ParseInt(c.Query("to"), 10, 64)
+		if err != nil {
+			err = errors.Wrap(err, "bad to")
+			return
+		}
+	}
+	from = to - int64(span/time.Millisecond)
+	if c.Query("from") != "" {
+		from, err = strconv.ParseInt(c.Query("from"), 10, 64)
+		if err != nil {
+			err = errors.Wrap(err, "bad from")
+			return
+		}
+	}
+	return
+}
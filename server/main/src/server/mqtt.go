@@ -0,0 +1,98 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+
+	"github.com/Nimaapr/find3/server/main/src/models"
+	"github.com/Nimaapr/find3/server/main/src/mqtt"
+)
+
+// handlerMQTTCredentials lists the MQTT credentials of a family, without their passwords
+func handlerMQTTCredentials(c *gin.Context) {
+	family := strings.ToLower(strings.TrimSpace(c.Param("family")))
+	credentials, err := mqtt.GetCredentials(family)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"message": err.Error(), "success": false})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "got credentials", "success": true, "credentials": credentials})
+}
+
+// handlerAddMQTTCredential adds a named credential, the response is the only time the password is returned
+func handlerAddMQTTCredential(c *gin.Context) {
+	credential, password, err := func(c *gin.Context) (credential models.MQTTCredential, password string, err error) {
+		family := strings.ToLower(strings.TrimSpace(c.Param("family")))
+		var request models.MQTTCredential
+		err = c.BindJSON(&request)
+		if err != nil {
+			err = errors.Wrap(err, "problem binding data")
+			return
+		}
+		credential, password, err = mqtt.AddCredential(family, request.Name, request.Topics, request.Access)
+		return
+	}(c)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"message": err.Error(), "success": false})
+	} else {
+		c.JSON(http.StatusOK, gin.H{"message": "added credential " + credential.Username, "success": true, "credential": credential, "password": password})
+	}
+}
+
+// handlerRotateMQTTCredential gives a credential a new password, the old one stops working right away
+func handlerRotateMQTTCredential(c *gin.Context) {
+	family := strings.ToLower(strings.TrimSpace(c.Param("family")))
+	password, err := mqtt.RotateCredential(family, c.Param("name"))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"message": err.Error(), "success": false})
+	} else {
+		c.JSON(http.StatusOK, gin.H{"message": "rotated credential " + c.Param("name"), "success": true, "password": password})
+	}
+}
+
+func handlerRevokeMQTTCredential(c *gin.Context) {
+	family := strings.ToLower(strings.TrimSpace(c.Param("family")))
+	err := mqtt.RevokeCredential(family, c.Param("name"))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"message": err.Error(), "success": false})
+	} else {
+		c.JSON(http.StatusOK, gin.H{"message": "revoked credential " + c.Param("name"), "success": true})
+	}
+}
+
+// handlerHomeAssistant returns the Home Assistant discovery settings of a family
+func handlerHomeAssistant(c *gin.Context) {
+	family := strings.ToLower(strings.TrimSpace(c.Param("family")))
+	settings, err := mqtt.GetHomeAssistant(family)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"message": err.Error(), "success": false})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "got home assistant settings", "success": true, "homeassistant": settings})
+}
+
+// handlerSetHomeAssistant turns the Home Assistant discovery of a family on or off
+func handlerSetHomeAssistant(c *gin.Context) {
+	settings, err := func(c *gin.Context) (settings models.HomeAssistant, err error) {
+		family := strings.ToLower(strings.TrimSpace(c.Param("family")))
+		err = c.BindJSON(&settings)
+		if err != nil {
+			err = errors.Wrap(err, "problem binding data")
+			return
+		}
+		err = mqtt.SetHomeAssistant(family, settings)
+		if err != nil {
+			return
+		}
+		settings, err = mqtt.GetHomeAssistant(family)
+		return
+	}(c)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"message": err.Error(), "success": false})
+	} else {
+		c.JSON(http.StatusOK, gin.H{"message": "set home assistant settings", "success": true, "homeassistant": settings})
+	}
+}
@@ -0,0 +1,81 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+
+	"github.com/Nimaapr/find3/server/main/src/api"
+	"github.com/Nimaapr/find3/server/main/src/models"
+)
+
+func handlerRSSIOffsets(c *gin.Context) {
+	family := strings.ToLower(strings.TrimSpace(c.Param("family")))
+	offsets, err := api.GetRSSIOffsets(family)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"message": err.Error(), "success": false})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "got offsets", "success": true, "offsets": offsets})
+}
+
+// handlerSetRSSIOffsets changes the offsets of the devices and scanners in the request
+func handlerSetRSSIOffsets(c *gin.Context) {
+	offsets, err := func(c *gin.Context) (offsets models.RSSIOffsets, err error) {
+		family := strings.ToLower(strings.TrimSpace(c.Param("family")))
+		var changes models.RSSIOffsets
+		err = c.BindJSON(&changes)
+		if err != nil {
+			err = errors.Wrap(err, "problem binding data")
+			return
+		}
+		offsets, err = api.SetRSSIOffsets(family, changes)
+		return
+	}(c)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"message": err.Error(), "success": false})
+	} else {
+		c.JSON(http.StatusOK, gin.H{"message": "set offsets", "success": true, "offsets": offsets})
+	}
+}
+
+// handlerEstimateRSSIOffset estimates the offset of a device, or of a scanner, against a reference
+func handlerEstimateRSSIOffset(c *gin.Context) {
+	var offset float64
+	var samples int
+	message, err := func(c *gin.Context) (message string, err error) {
+		family := strings.ToLower(strings.TrimSpace(c.Param("family")))
+		var request struct {
+			Device    string `json:"device"`
+			Scanner   string `json:"scanner"`
+			Reference string `json:"reference"`
+			Location  string `json:"location"`
+		}
+		err = c.BindJSON(&request)
+		if err != nil {
+			err = errors.Wrap(err, "problem binding data")
+			return
+		}
+		if request.Reference == "" {
+			err = errors.New("need a reference")
+			return
+		}
+		if request.Device != "" {
+			offset, samples, err = api.EstimateDeviceOffset(family, request.Device, request.Reference)
+			message = "estimated offset of " + request.Device
+		} else if request.Scanner != "" {
+			offset, samples, err = api.EstimateScannerOffset(family, request.Scanner, request.Reference, request.Location)
+			message = "estimated offset of " + request.Scanner
+		} else {
+			err = errors.New("need a device or a scanner")
+		}
+		return
+	}(c)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"message": err.Error(), "success": false})
+	} else {
+		c.JSON(http.StatusOK, gin.H{"message": message, "success": true, "offset": offset, "samples": samples})
+	}
+}
@@ -0,0 +1,312 @@
+package server
+
+/*
+This code aggregates the passive data, the fingerprints that scanners send to /passive (or over MQTT) with the signals
+of the devices around them.
+
+The fingerprints of a family are merged in memory into a window: for every tracked device ("SENSOR-MAC") it keeps the
+last signal seen by each scanner ("SCANNER-SENSOR"). A window is flushed once it is older than the time block of the
+family (90 seconds by default). Every tracked device in it with at least MinimumPassive signals becomes one sensor
+data, and they are all saved in one transaction and then classified. In privacy mode the tracked devices are named by
+their hash (see models.Privacy), except the ones being learned.
+
+The sensor data of a window get the time of the end of the window, plus one millisecond for each device in
+alphabetical order, so timestamps are unique and the same data always gets the same timestamps. The timestamps of a
+family never go back, even if the time block is changed.
+
+Only the settings are persisted, in the "ReverseRollingData" entry of the keystore (see handlerReverseSettings):
+the time block, the minimum number of signals and the devices that are being learned at a location. Data that is in
+a window when the server stops is lost.
+*/
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/Nimaapr/find3/server/main/src/api"
+	"github.com/Nimaapr/find3/server/main/src/database"
+	"github.com/Nimaapr/find3/server/main/src/models"
+)
+
+var (
+	// PassiveTimeBlock is the default length of the passive windows
+	PassiveTimeBlock = 90 * time.Second
+	// PassiveFlushInterval is how often the passive windows are checked for flushing
+	PassiveFlushInterval = 1 * time.Second
+)
+
+type passiveWindow struct {
+	start time.Time
+	// sensors of each tracked device, sensor type -> scanner -> signal
+	sensors      map[string]map[string]map[string]interface{}
+	fingerprints int
+}
+
+var passive = struct {
+	windows  map[string]*passiveWindow
+	settings map[string]models.ReverseRollingData
+	// lastTimestamp is the last timestamp given to passive data of each family
+	lastTimestamp map[string]int64
+	sync.Mutex
+}{
+	windows:       make(map[string]*passiveWindow),
+	settings:      make(map[string]models.ReverseRollingData),
+	lastTimestamp: make(map[string]int64),
+}
+
+// passiveFlushing is held while the windows are flushed, from taking them out to saving them, so that
+// forgetPassive waits for the data of an erased device that is being saved
+var passiveFlushing sync.Mutex
+
+var startPassiveOnce sync.Once
+
+func init() {
+	api.EraseHandler = forgetPassive
+}
+
+// processPassiveRequest validates passive sensor data and adds it to the window of its family.
+// It is used for the data posted to /passive and sent over MQTT.
+func processPassiveRequest(d models.SensorData) (message string, err error) {
+	// validate sensor data
+	err = d.Validate()
+	if err != nil {
+		logger.Log.Warn(err)
+		return
+	}
+
+	d.Family = strings.TrimSpace(strings.ToLower(d.Family))
+
+	if d.Location != "" {
+		logger.Log.Debugf("[%s] entered passive fingerprint for %s at %s", d.Family, d.Device, d.Location)
+	} else {
+		logger.Log.Debugf("[%s] entered passive fingerprint for %s", d.Family, d.Device)
+	}
+	if len(d.Sensors) == 0 {
+		err = errors.New("no fingerprints")
+		return
+	}
+
+	// the settings are read before taking the lock, a family whose database is slow to open does not
+	// hold up the passive data of the others
+	settings, err := passiveSettings(d.Family)
+	if err != nil {
+		return
+	}
+	privacy, err := api.CurrentPrivacy(d.Family, time.Now().UTC())
+	if err != nil {
+		return
+	}
+	passive.Lock()
+	w, ok := passive.windows[d.Family]
+	if !ok {
+		w = &passiveWindow{
+			start:   time.Now().UTC(),
+			sensors: make(map[string]map[string]map[string]interface{}),
+		}
+		passive.windows[d.Family] = w
+	}
+	numFingerprints := 0
+	for sensor := range d.Sensors {
+		for mac, rssi := range d.Sensors[sensor] {
+			trackedDeviceName := sensor + "-" + mac
+			// in privacy mode the devices are stored under their hash, unless they are learning
+			if _, learning := settings.DeviceLocation[trackedDeviceName]; !learning {
+				trackedDeviceName = privacy.DeviceID(trackedDeviceName)
+			}
+			if _, ok := w.sensors[trackedDeviceName]; !ok {
+				w.sensors[trackedDeviceName] = map[string]map[string]interface{}{sensor: make(map[string]interface{})}
+			}
+			w.sensors[trackedDeviceName][sensor][d.Device+"-"+sensor] = rssi
+			numFingerprints++
+		}
+	}
+	w.fingerprints += numFingerprints
+	passive.Unlock()
+	recordScan(d)
+	message = fmt.Sprintf("inserted %d fingerprints for %s", numFingerprints, d.Family)
+
+	startPassiveOnce.Do(func() {
+		startPassive(PassiveFlushInterval, ScannerCheckInterval)
+	})
+	return
+}
+
+// startPassive starts flushing the passive windows and checking the scanners, at the intervals given
+func startPassive(flushInterval, checkInterval time.Duration) {
+	go func() {
+		for {
+			time.Sleep(flushInterval)
+			flushPassive(time.Now().UTC(), false)
+		}
+	}()
+	go monitorScanners(checkInterval)
+}
+
+// passiveSettings returns the settings of a family, reading them the first time.
+// It must be called without the lock held, the database is read without it.
+func passiveSettings(family string) (settings models.ReverseRollingData, err error) {
+	passive.Lock()
+	settings, ok := passive.settings[family]
+	passive.Unlock()
+	if ok {
+		return
+	}
+	settings, err = loadPassiveSettings(family)
+	if err != nil {
+		return
+	}
+	passive.Lock()
+	defer passive.Unlock()
+	// unless they were changed or read in the meantime
+	if current, ok := passive.settings[family]; ok {
+		return current, nil
+	}
+	passive.settings[family] = settings
+	return
+}
+
+// loadPassiveSettings reads the settings of a family from its database
+func loadPassiveSettings(family string) (settings models.ReverseRollingData, err error) {
+	db, err := database.Open(family)
+	if err != nil {
+		return
+	}
+	defer db.Close()
+	errGet := db.Get("ReverseRollingData", &settings)
+	if errGet != nil {
+		settings = models.ReverseRollingData{
+			Family:         family,
+			DeviceLocation: make(map[string]string),
+			DeviceGPS:      make(map[string]models.GPS),
+		}
+	} else {
+		// rewrite it without the data that older versions kept in it
+		db.Set("ReverseRollingData", settings)
+	}
+	if settings.TimeBlock.Seconds() == 0 {
+		settings.TimeBlock = PassiveTimeBlock
+	}
+	return
+}
+
+// passiveSettingsChanged is called when the settings of a family are saved
+func passiveSettingsChanged(family string, settings models.ReverseRollingData) {
+	passive.Lock()
+	defer passive.Unlock()
+	if settings.TimeBlock.Seconds() == 0 {
+		settings.TimeBlock = PassiveTimeBlock
+	}
+	passive.settings[family] = settings
+}
+
+// forgetPassive drops a device from the window of its family and from the devices being learned, before
+// it is erased, so that its data is not saved again and the new data is not learned
+func forgetPassive(family, device string) {
+	passiveFlushing.Lock()
+	defer passiveFlushing.Unlock()
+	_, err := passiveSettings(family)
+	passive.Lock()
+	defer passive.Unlock()
+	if w, ok := passive.windows[family]; ok {
+		delete(w.sensors, device)
+	}
+	if err != nil {
+		logger.Log.Warnf("[%s] could not forget %s in the passive settings: %s", family, device, err.Error())
+		return
+	}
+	settings := passive.settings[family]
+	deviceLocation := make(map[string]string)
+	for trackedDeviceName, location := range settings.DeviceLocation {
+		if trackedDeviceName != device {
+			deviceLocation[trackedDeviceName] = location
+		}
+	}
+	deviceGPS := make(map[string]models.GPS)
+	for trackedDeviceName, gps := range settings.DeviceGPS {
+		if trackedDeviceName != device {
+			deviceGPS[trackedDeviceName] = gps
+		}
+	}
+	settings.DeviceLocation = deviceLocation
+	settings.DeviceGPS = deviceGPS
+	passive.settings[family] = settings
+}
+
+// flushPassive saves the windows that are older than their time block, or all of them
+func flushPassive(now time.Time, all bool) {
+	type flush struct {
+		family string
+		datas  []models.SensorData
+	}
+	flushes := []flush{}
+
+	passiveFlushing.Lock()
+	defer passiveFlushing.Unlock()
+	passive.Lock()
+	for family, w := range passive.windows {
+		settings := passive.settings[family]
+		if !all && now.Sub(w.start) < settings.TimeBlock {
+			continue
+		}
+		delete(passive.windows, family)
+		logger.Log.Debugf("[%s] flushing %d passive fingerprints of %d devices", family, w.fingerprints, len(w.sensors))
+
+		trackedDevices := make([]string, 0, len(w.sensors))
+		for trackedDeviceName, sensors := range w.sensors {
+			numPassivePoints := 0
+			for sensorType := range sensors {
+				numPassivePoints += len(sensors[sensorType])
+			}
+			if numPassivePoints < settings.MinimumPassive {
+				logger.Log.Debugf("[%s] skipped saving reverse sensor data for %s, not enough points (< %d)", family, trackedDeviceName, settings.MinimumPassive)
+				continue
+			}
+			trackedDevices = append(trackedDevices, trackedDeviceName)
+		}
+		sort.Strings(trackedDevices)
+
+		timestamp := w.start.Add(settings.TimeBlock).UnixNano() / int64(time.Millisecond)
+		if timestamp <= passive.lastTimestamp[family] {
+			timestamp = passive.lastTimestamp[family] + 1
+		}
+		datas := make([]models.SensorData, len(trackedDevices))
+		for i, trackedDeviceName := range trackedDevices {
+			datas[i] = models.SensorData{
+				Family:    family,
+				Device:    trackedDeviceName,
+				Timestamp: timestamp + int64(i),
+				Sensors:   w.sensors[trackedDeviceName],
+				// if there is a device+location in map, then it is currently doing learning
+				Location: settings.DeviceLocation[trackedDeviceName],
+				GPS:      settings.DeviceGPS[trackedDeviceName],
+			}
+		}
+		if len(datas) > 0 {
+			passive.lastTimestamp[family] = datas[len(datas)-1].Timestamp
+			flushes = append(flushes, flush{family, datas})
+		}
+	}
+	passive.Unlock()
+
+	for _, f := range flushes {
+		for i := range f.datas {
+			if err := api.NormalizeSensorData(&f.datas[i]); err != nil {
+				logger.Log.Warnf("[%s] problem normalizing: %s", f.family, err.Error())
+			}
+		}
+		saved, err := api.SaveSensorDatas(f.family, f.datas)
+		if err != nil {
+			logger.Log.Warnf("[%s] problem saving: %s", f.family, err.Error())
+			continue
+		}
+		logger.Log.Debugf("[%s] saved reverse sensor data for %d devices", f.family, len(saved))
+		for _, d := range saved {
+			goSendOutData(d)
+		}
+	}
+}
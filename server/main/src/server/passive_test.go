@@ -0,0 +1,221 @@
+package server
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/Nimaapr/find3/server/main/src/api"
+	"github.com/Nimaapr/find3/server/main/src/database"
+	"github.com/Nimaapr/find3/server/main/src/models"
+	"github.com/Nimaapr/find3/server/main/src/utils"
+)
+
+// startPassiveForTest starts the passive loops before the first passive data, slowly enough that
+// the windows are only flushed by the tests
+func startPassiveForTest() {
+	startPassiveOnce.Do(func() {
+		startPassive(time.Hour, time.Hour)
+	})
+}
+
+// useTestFolder keeps the databases in a temporary folder until the function returned is called
+func useTestFolder(t *testing.T) func() {
+	// the data sent out are analyzed in the folder they were saved in
+	sending.Wait()
+	folder, err := ioutil.TempDir("", "find3")
+	assert.Nil(t, err)
+	previous := database.DataFolder
+	database.DataFolder = folder
+	return func() {
+		sending.Wait()
+		database.DataFolder = previous
+		os.RemoveAll(folder)
+	}
+}
+
+func TestPassiveWindow(t *testing.T) {
+	defer useTestFolder(t)()
+	startPassiveForTest()
+
+	passiveSettingsChanged("testpassive", models.ReverseRollingData{
+		Family:         "testpassive",
+		TimeBlock:      time.Minute,
+		MinimumPassive: 2,
+		DeviceLocation: map[string]string{"wifi-aa:aa:aa:aa:aa:aa": "kitchen"},
+	})
+	scan := func(scanner string, signals map[string]interface{}) {
+		_, err := processPassiveRequest(models.SensorData{
+			Family:    "testpassive",
+			Device:    scanner,
+			Timestamp: time.Now().UnixNano() / int64(time.Millisecond),
+			Sensors:   map[string]map[string]interface{}{"wifi": signals},
+		})
+		assert.Nil(t, err)
+	}
+	scan("scanner1", map[string]interface{}{"aa:aa:aa:aa:aa:aa": -50, "bb:bb:bb:bb:bb:bb": -60})
+	scan("scanner2", map[string]interface{}{"aa:aa:aa:aa:aa:aa": -70, "bb:bb:bb:bb:bb:bb": -80})
+	scan("scanner2", map[string]interface{}{"aa:aa:aa:aa:aa:aa": -65, "cc:cc:cc:cc:cc:cc": -40})
+
+	passive.Lock()
+	start := passive.windows["testpassive"].start
+	passive.Unlock()
+
+	// not flushed before the end of the time block
+	flushPassive(start.Add(30*time.Second), false)
+	passive.Lock()
+	assert.NotNil(t, passive.windows["testpassive"])
+	passive.Unlock()
+
+	flushPassive(start.Add(time.Minute), false)
+	passive.Lock()
+	assert.Nil(t, passive.windows["testpassive"])
+	passive.Unlock()
+
+	db, err := database.Open("testpassive", true)
+	assert.Nil(t, err)
+	end := start.Add(time.Minute).UnixNano() / int64(time.Millisecond)
+	s, err := db.GetSensorFromTime(end)
+	assert.Nil(t, err)
+	assert.Equal(t, "wifi-aa:aa:aa:aa:aa:aa", s.Device)
+	assert.Equal(t, "kitchen", s.Location)
+	assert.Equal(t, map[string]interface{}{"scanner1-wifi": -50.0, "scanner2-wifi": -65.0}, s.Sensors["wifi"])
+	s, err = db.GetSensorFromTime(end + 1)
+	assert.Nil(t, err)
+	assert.Equal(t, "wifi-bb:bb:bb:bb:bb:bb", s.Device)
+	// cc was only seen by one scanner
+	sensors, err := db.GetAllFromPreparedQuery("SELECT * FROM sensors WHERE timestamp = ?", end+2)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(sensors))
+	db.Close()
+
+	// timestamps keep going up, even when a window ends earlier
+	passiveSettingsChanged("testpassive", models.ReverseRollingData{Family: "testpassive", TimeBlock: time.Second})
+	scan("scanner1", map[string]interface{}{"dd:dd:dd:dd:dd:dd": -50})
+	passive.Lock()
+	passive.windows["testpassive"].start = start
+	passive.Unlock()
+	flushPassive(start.Add(time.Second), false)
+	db, err = database.Open("testpassive", true)
+	assert.Nil(t, err)
+	defer db.Close()
+	s, err = db.GetSensorFromTime(end + 2)
+	assert.Nil(t, err)
+	assert.Equal(t, "wifi-dd:dd:dd:dd:dd:dd", s.Device)
+}
+
+func TestPassivePrivacy(t *testing.T) {
+	defer useTestFolder(t)()
+	startPassiveForTest()
+
+	db, err := database.Open("testprivacy")
+	assert.Nil(t, err)
+	db.Close()
+	_, err = api.SetPrivacy("testprivacy", true, 0, []string{"BB:BB:BB:BB:BB:BB"})
+	assert.Nil(t, err)
+	passiveSettingsChanged("testprivacy", models.ReverseRollingData{
+		Family:         "testprivacy",
+		TimeBlock:      time.Minute,
+		DeviceLocation: map[string]string{"wifi-aa:aa:aa:aa:aa:aa": "kitchen"},
+	})
+	scan := func(signals map[string]interface{}) {
+		_, err := processPassiveRequest(models.SensorData{
+			Family:    "testprivacy",
+			Device:    "scanner1",
+			Timestamp: time.Now().UnixNano() / int64(time.Millisecond),
+			Sensors:   map[string]map[string]interface{}{"wifi": signals},
+		})
+		assert.Nil(t, err)
+	}
+	devices := func() (devices []string) {
+		flushPassive(time.Now(), true)
+		db, err := database.Open("testprivacy", true)
+		assert.Nil(t, err)
+		defer db.Close()
+		devices, err = db.GetDevices()
+		assert.Nil(t, err)
+		return
+	}
+
+	// the device being learned and the allowed one stay in clear text
+	scan(map[string]interface{}{"aa:aa:aa:aa:aa:aa": -50, "bb:bb:bb:bb:bb:bb": -60, "cc:cc:cc:cc:cc:cc": -70})
+	first := devices()
+	assert.Equal(t, 3, len(first))
+	assert.Contains(t, first, "wifi-aa:aa:aa:aa:aa:aa")
+	assert.Contains(t, first, "wifi-bb:bb:bb:bb:bb:bb")
+	assert.NotContains(t, first, "wifi-cc:cc:cc:cc:cc:cc")
+	hashed := ""
+	for _, device := range first {
+		if utils.IsHashedDevice(device) {
+			hashed = device
+		}
+	}
+	assert.True(t, strings.HasPrefix(hashed, "wifi-~"))
+
+	// the same hash until the salt is rotated
+	scan(map[string]interface{}{"cc:cc:cc:cc:cc:cc": -70})
+	assert.Equal(t, 3, len(devices()))
+	_, err = api.RotatePrivacySalt("testprivacy")
+	assert.Nil(t, err)
+	scan(map[string]interface{}{"cc:cc:cc:cc:cc:cc": -70})
+	assert.Equal(t, 4, len(devices()))
+}
+
+func TestPassiveErase(t *testing.T) {
+	defer useTestFolder(t)()
+	startPassiveForTest()
+
+	learning := models.ReverseRollingData{
+		Family:         "testpassiveerase",
+		TimeBlock:      time.Minute,
+		DeviceLocation: map[string]string{"wifi-aa:aa:aa:aa:aa:aa": "kitchen"},
+		DeviceGPS:      map[string]models.GPS{"wifi-aa:aa:aa:aa:aa:aa": {Latitude: 1, Longitude: 2}},
+	}
+	db, err := database.Open("testpassiveerase")
+	assert.Nil(t, err)
+	assert.Nil(t, db.Set("ReverseRollingData", learning))
+	db.Close()
+	passiveSettingsChanged("testpassiveerase", learning)
+	scan := func() {
+		_, err := processPassiveRequest(models.SensorData{
+			Family:    "testpassiveerase",
+			Device:    "scanner1",
+			Timestamp: time.Now().UnixNano() / int64(time.Millisecond),
+			Sensors:   map[string]map[string]interface{}{"wifi": {"aa:aa:aa:aa:aa:aa": -50, "bb:bb:bb:bb:bb:bb": -60}},
+		})
+		assert.Nil(t, err)
+	}
+	devices := func() (devices []string) {
+		flushPassive(time.Now(), true)
+		sending.Wait()
+		db, err := database.Open("testpassiveerase", true)
+		assert.Nil(t, err)
+		defer db.Close()
+		devices, err = db.GetDevices()
+		assert.Nil(t, err)
+		return
+	}
+
+	// the window of the erased device is not saved
+	scan()
+	assert.Equal(t, 2, len(devices()))
+	scan()
+	_, err = eraseDevice("testpassiveerase", "wifi-aa:aa:aa:aa:aa:aa", "test")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"wifi-bb:bb:bb:bb:bb:bb"}, devices())
+
+	// and its new data is not learned at its location any more
+	scan()
+	assert.Equal(t, 2, len(devices()))
+	db, err = database.Open("testpassiveerase", true)
+	assert.Nil(t, err)
+	defer db.Close()
+	s, err := db.GetLatest("wifi-aa:aa:aa:aa:aa:aa")
+	assert.Nil(t, err)
+	assert.Equal(t, "", s.Location)
+	assert.Equal(t, models.GPS{}, s.GPS)
+}
@@ -0,0 +1,52 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+
+	"github.com/Nimaapr/find3/server/main/src/api"
+	"github.com/Nimaapr/find3/server/main/src/models"
+)
+
+func handlerPrivacy(c *gin.Context) {
+	family := strings.ToLower(strings.TrimSpace(c.Param("family")))
+	privacy, err := api.GetPrivacy(family)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"message": err.Error(), "success": false})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "got privacy", "success": true, "privacy": privacy})
+}
+
+// handlerSetPrivacy turns the privacy mode on or off, with the rotation of the salt and the allowlist
+func handlerSetPrivacy(c *gin.Context) {
+	privacy, err := func(c *gin.Context) (privacy models.Privacy, err error) {
+		family := strings.ToLower(strings.TrimSpace(c.Param("family")))
+		var request models.Privacy
+		err = c.BindJSON(&request)
+		if err != nil {
+			err = errors.Wrap(err, "problem binding data")
+			return
+		}
+		privacy, err = api.SetPrivacy(family, request.Enabled, request.RotateEvery, request.Allowlist)
+		return
+	}(c)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"message": err.Error(), "success": false})
+	} else {
+		c.JSON(http.StatusOK, gin.H{"message": "set privacy", "success": true, "privacy": privacy})
+	}
+}
+
+func handlerRotatePrivacy(c *gin.Context) {
+	family := strings.ToLower(strings.TrimSpace(c.Param("family")))
+	privacy, err := api.RotatePrivacySalt(family)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"message": err.Error(), "success": false})
+	} else {
+		c.JSON(http.StatusOK, gin.H{"message": "rotated salt", "success": true, "privacy": privacy})
+	}
+}
@@ -0,0 +1,130 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+
+	"github.com/Nimaapr/find3/server/main/src/api"
+	"github.com/Nimaapr/find3/server/main/src/models"
+	"github.com/Nimaapr/find3/server/main/src/mqtt"
+)
+
+type renameRequest struct {
+	To    string `json:"to"`
+	Merge bool   `json:"merge"`
+}
+
+func handlerLocationMetadata(c *gin.Context) {
+	family := strings.ToLower(strings.TrimSpace(c.Param("family")))
+	metadata, err := api.GetLocationMetadata(family)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"message": err.Error(), "success": false})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "got location metadata", "success": true, "metadata": metadata})
+}
+
+// handlerSetLocationMetadata sets the description and tags of a location
+func handlerSetLocationMetadata(c *gin.Context) {
+	metadata, err := func(c *gin.Context) (metadata models.LocationMetadata, err error) {
+		family := strings.ToLower(strings.TrimSpace(c.Param("family")))
+		err = c.BindJSON(&metadata)
+		if err != nil {
+			err = errors.Wrap(err, "problem binding data")
+			return
+		}
+		metadata.Location = c.Param("location")
+		metadata, err = api.SetLocationMetadata(family, metadata)
+		return
+	}(c)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"message": err.Error(), "success": false})
+	} else {
+		c.JSON(http.StatusOK, gin.H{"message": "set metadata of " + metadata.Location, "success": true, "metadata": metadata})
+	}
+}
+
+// handlerRenameLocation renames a location, or merges it into another, and calibrates again
+func handlerRenameLocation(c *gin.Context) {
+	rename, err := func(c *gin.Context) (rename models.Rename, err error) {
+		family := strings.ToLower(strings.TrimSpace(c.Param("family")))
+		var request renameRequest
+		err = c.BindJSON(&request)
+		if err != nil {
+			err = errors.Wrap(err, "problem binding data")
+			return
+		}
+		rename, err = api.RenameLocation(family, c.Param("location"), request.To, request.Merge)
+		if err != nil {
+			return
+		}
+		if UseMQTT {
+			mqtt.RemoveLocation(family, rename.From)
+		}
+		calibrateAfterRename(family, rename)
+		return
+	}(c)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"message": err.Error(), "success": false})
+	} else {
+		c.JSON(http.StatusOK, gin.H{"message": renameMessage(rename), "success": true, "rename": rename})
+	}
+}
+
+// handlerRenameDevice renames a device, or merges it into another, and calibrates again
+func handlerRenameDevice(c *gin.Context) {
+	rename, err := func(c *gin.Context) (rename models.Rename, err error) {
+		family := strings.ToLower(strings.TrimSpace(c.Param("family")))
+		var request renameRequest
+		err = c.BindJSON(&request)
+		if err != nil {
+			err = errors.Wrap(err, "problem binding data")
+			return
+		}
+		rename, err = api.RenameDevice(family, c.Param("device"), request.To, request.Merge)
+		if err != nil {
+			return
+		}
+
+		// the passive data that is not saved yet, and the settings read again
+		passive.Lock()
+		if w, ok := passive.windows[family]; ok {
+			if _, kept := w.sensors[rename.To]; !kept && w.sensors[rename.From] != nil {
+				w.sensors[rename.To] = w.sensors[rename.From]
+			}
+			delete(w.sensors, rename.From)
+		}
+		delete(passive.settings, family)
+		passive.Unlock()
+		if UseMQTT {
+			if errMQTT := mqtt.RemoveDevice(family, rename.From); errMQTT != nil {
+				logger.Log.Warn(errMQTT)
+			}
+		}
+		calibrateAfterRename(family, rename)
+		return
+	}(c)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"message": err.Error(), "success": false})
+	} else {
+		c.JSON(http.StatusOK, gin.H{"message": renameMessage(rename), "success": true, "rename": rename})
+	}
+}
+
+func renameMessage(rename models.Rename) string {
+	if rename.Merged {
+		return "merged " + rename.From + " into " + rename.To + ", calibrating"
+	}
+	return "renamed " + rename.From + " to " + rename.To + ", calibrating"
+}
+
+func calibrateAfterRename(family string, rename models.Rename) {
+	go func() {
+		if errCalibrate := api.Calibrate(family, true); errCalibrate != nil {
+			logger.Log.Warnf("[%s] problem calibrating after renaming %s: %s", family, rename.From, errCalibrate.Error())
+		}
+	}()
+}
@@ -0,0 +1,92 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+
+	"github.com/Nimaapr/find3/server/main/src/api"
+	"github.com/Nimaapr/find3/server/main/src/models"
+)
+
+// ScannerCheckInterval is how often the health of the passive scanners is checked
+var ScannerCheckInterval = 1 * time.Minute
+
+// monitorScanners checks the passive scanners and sends out the events of the ones whose health changed
+func monitorScanners(interval time.Duration) {
+	for {
+		time.Sleep(interval)
+		for _, family := range api.ScannerFamilies() {
+			events, err := api.CheckScanners(family, time.Now().UTC())
+			if err != nil {
+				logger.Log.Warnf("[%s] problem checking scanners: %s", family, err.Error())
+				continue
+			}
+			for _, e := range events {
+				sendOutEvent(e)
+			}
+		}
+	}
+}
+
+// recordScan adds the signals of passive data to the statistics of its scanner
+func recordScan(d models.SensorData) {
+	rssi := []float64{}
+	for sensor := range d.Sensors {
+		for _, value := range d.Sensors[sensor] {
+			if v, ok := value.(float64); ok {
+				rssi = append(rssi, v)
+			} else if v, ok := value.(int); ok {
+				rssi = append(rssi, float64(v))
+			}
+		}
+	}
+	api.RecordScan(d.Family, d.Device, rssi, time.Now().UTC())
+}
+
+func handlerScanners(c *gin.Context) {
+	family := strings.ToLower(strings.TrimSpace(c.Param("family")))
+	scanners, err := api.GetScanners(family)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"message": err.Error(), "success": false})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "got scanners", "success": true, "scanners": scanners})
+}
+
+// handlerSetScanner sets the placement of a scanner, with "reset_baseline" to take its baseline signal again
+func handlerSetScanner(c *gin.Context) {
+	scanner, err := func(c *gin.Context) (scanner models.Scanner, err error) {
+		family := strings.ToLower(strings.TrimSpace(c.Param("family")))
+		var request struct {
+			Name          string `json:"name"`
+			Location      string `json:"location"`
+			ResetBaseline bool   `json:"reset_baseline"`
+		}
+		err = c.BindJSON(&request)
+		if err != nil {
+			err = errors.Wrap(err, "problem binding data")
+			return
+		}
+		scanner, err = api.SetScanner(family, models.Scanner{Name: request.Name, Location: request.Location}, request.ResetBaseline)
+		return
+	}(c)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"message": err.Error(), "success": false})
+	} else {
+		c.JSON(http.StatusOK, gin.H{"message": "set scanner " + scanner.Name, "success": true, "scanner": scanner})
+	}
+}
+
+func handlerDeleteScanner(c *gin.Context) {
+	family := strings.ToLower(strings.TrimSpace(c.Param("family")))
+	err := api.DeleteScanner(family, c.Param("scanner"))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"message": err.Error(), "success": false})
+	} else {
+		c.JSON(http.StatusOK, gin.H{"message": "deleted scanner " + c.Param("scanner"), "success": true})
+	}
+}
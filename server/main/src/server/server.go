@@ -9,6 +9,7 @@ import (
 	"os/exec"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/gin-contrib/gzip"
@@ -37,9 +38,11 @@ var MinimumPassive = -1
 // DELETE request to /api/v1/location/:family/:location: This handler deletes a specific location for the given family.
 // GET request to /view/analysis/:family: This handler serves the analysis page for a specific family, showing the list of locations.
 // GET request to /view/location_analysis/:family/:location: This handler serves a PNG image of the location analysis for the specified family and location.
+// GET request to /view/analysis_heatmap/:family: This handler serves a PNG image of the median signal of the most distinguishing sensors at each location.
 // GET request to /view/location/:family/:device: This handler serves the location page for a specific family and device.
 // GET request to /view/map2/:family: This handler serves an alternative map view for the specified family, showing the locations with GPS coordinates on a map.
 // GET request to /view/map/:family: This handler serves the map view for the specified family, showing the locations with GPS coordinates on a map.
+// GET request to /view/floorplan/:family: This handler serves the floorplan of a floor (?floor=FLOOR) with its locations and the devices at them, live over the websocket.
 // r.GET("/api/v1/database/:family", ...) - This route retrieves and returns the dumped database for a specified family.
 // r.GET("/api/v1/data/:family", ...) - This route returns all sensor data for a specified family, used for classification purposes.
 // r.GET("/view/gps/:family", ...) - This route returns an HTML template containing GPS data for a specified family, including average latitude and longitude.
@@ -62,15 +65,40 @@ var MinimumPassive = -1
 // r.POST("/api/v1/settings/passive", ...)
 // r.OPTIONS("/api/v1/efficacy/:family", ...)
 // r.GET("/api/v1/efficacy/:family", ...)
+// r.GET("/api/v1/webhooks/:family", ...), r.POST("/api/v1/webhooks/:family", ...), r.DELETE("/api/v1/webhooks/:family/:id", ...)
+// r.GET("/api/v1/webhooks/:family/:id/deliveries", ...)
+// r.GET("/api/v1/zones/:family", ...), r.POST("/api/v1/zones/:family", ...), r.DELETE("/api/v1/zones/:family/:zone", ...)
+// r.GET("/api/v1/events/:family", ...)
+// r.GET("/api/v1/scanners/:family", ...), r.POST("/api/v1/scanners/:family", ...), r.DELETE("/api/v1/scanners/:family/:scanner", ...)
+// r.GET("/api/v1/offsets/:family", ...), r.POST("/api/v1/offsets/:family", ...), r.POST("/api/v1/offsets/:family/estimate", ...)
+// r.GET("/api/v1/privacy/:family", ...), r.POST("/api/v1/privacy/:family", ...), r.POST("/api/v1/privacy/:family/rotate", ...)
+// r.GET("/api/v1/device/:family/:device/export", ...), r.DELETE("/api/v1/device/:family/:device", ...), r.GET("/api/v1/audit/:family", ...)
+// r.GET("/api/v1/floorplans/:family", ...), r.POST("/api/v1/floorplans/:family/:floor", ...), r.DELETE("/api/v1/floorplans/:family/:floor", ...)
+// r.GET("/api/v1/floorplans/:family/:floor/image", ...)
+// r.POST("/api/v1/coordinates/:family", ...), r.DELETE("/api/v1/coordinates/:family/:location", ...)
+// r.GET("/api/v1/hierarchy/:family", ...), r.POST("/api/v1/hierarchy/:family", ...), r.DELETE("/api/v1/hierarchy/:family/:location", ...)
+// r.POST("/api/v1/location/:family/:location/rename", ...), r.POST("/api/v1/device/:family/:device/rename", ...)
+// r.GET("/api/v1/location_metadata/:family", ...), r.POST("/api/v1/location_metadata/:family/:location", ...)
+// r.GET("/api/v1/trilateration/:family", ...), r.POST("/api/v1/trilateration/:family", ...), r.DELETE("/api/v1/trilateration/:family/:mac", ...)
+// r.GET("/api/v1/walkable/:family", ...), r.POST("/api/v1/walkable/:family/:floor", ...), r.DELETE("/api/v1/walkable/:family/:floor", ...)
+// r.GET("/api/v1/beacons/:family", ...), r.POST("/api/v1/beacons/:family", ...), r.DELETE("/api/v1/beacons/:family/:beacon", ...)
+// r.GET("/api/v1/barometer/:family", ...), r.POST("/api/v1/barometer/:family", ...)
+// r.GET("/api/v1/history/:family/:device", ...)
+// r.GET("/api/v1/occupancy/:family", ...)
+// r.GET("/api/v1/dwell/:family", ...), r.GET("/api/v1/transitions/:family", ...)
 
 // Some additional routes for handling various test and utility requests are also included, such as:
 // r.GET("/ping", ...)
 // r.GET("/now", ...)
 // r.GET("/test", ...)
 // r.GET("/ws", ...)
+// r.GET("/api/v1/stream/:family", ...)
 
-// If MQTT is enabled, the following route is added:
+// If MQTT is enabled, the following routes are added:
 // r.GET("/api/v1/mqtt/:family", ...)
+// r.GET("/api/v1/mqtt/:family/credentials", ...), r.POST("/api/v1/mqtt/:family/credentials", ...)
+// r.POST("/api/v1/mqtt/:family/credentials/:name/rotate", ...), r.DELETE("/api/v1/mqtt/:family/credentials/:name", ...)
+// r.GET("/api/v1/homeassistant/:family", ...), r.POST("/api/v1/homeassistant/:family", ...)
 
 // Finally, several routes handle data submission and processing:
 // r.POST("/api/v1/gps", ...)
@@ -86,15 +114,29 @@ func Run() (err error) {
 	defer logger.Log.Flush()
 
 	if UseMQTT {
-		// setup MQTT
+		// setup MQTT, with the JSON sensor data going through the same steps as /data and /passive
+		mqtt.DataHandler = func(d models.SensorData) (string, error) { return processDataRequest(d, false) }
+		mqtt.PassiveHandler = processPassiveRequest
 		err = mqtt.Setup()
 		if err != nil {
 			logger.Log.Warn(err)
 		}
 		logger.Log.Debug("setup mqtt")
+		api.CalibrationHandler = func(summary models.CalibrationSummary) {
+			if errPublish := mqtt.PublishCalibration(summary); errPublish != nil {
+				logger.Log.Warn(errPublish)
+			}
+		}
 	}
 
 	logger.Log.Debug("current families: ", database.GetFamilies())
+	// watch the scanners that were registered before a restart, even if they stay silent
+	if families := api.LoadScanners(); len(families) > 0 {
+		logger.Log.Debugf("watching the scanners of %s", strings.Join(families, ", "))
+		startPassiveOnce.Do(func() {
+			startPassive(PassiveFlushInterval, ScannerCheckInterval)
+		})
+	}
 
 	// setup gin server
 	gin.SetMode(gin.ReleaseMode)
@@ -102,7 +144,7 @@ func Run() (err error) {
 	// Standardize logs
 	r.LoadHTMLGlob("templates/*")
 	r.Static("/static", "./static")
-	r.Use(middleWareHandler(), gin.Recovery(), gzip.Gzip(gzip.DefaultCompression))
+	r.Use(middleWareHandler(), gin.Recovery(), gzipUnlessStreaming())
 	// r.Use(middleWareHandler(), gin.Recovery())
 	r.HEAD("/", func(c *gin.Context) { // handler for the uptime robot
 		c.String(http.StatusOK, "OK")
@@ -144,6 +186,9 @@ func Run() (err error) {
 			err = db.DeleteLocation(c.Param("location"))
 			db.Close()
 			if err == nil {
+				if UseMQTT {
+					mqtt.RemoveLocation(family, c.Param("location"))
+				}
 				c.JSON(200, gin.H{"success": true, "message": "deleted location '" + c.Param("location") + "' for " + family})
 				return
 			}
@@ -180,6 +225,15 @@ func Run() (err error) {
 			c.Data(200, "image/png", img)
 		}
 	})
+	r.GET("/view/analysis_heatmap/:family", func(c *gin.Context) {
+		family := strings.ToLower(c.Param("family"))
+		img, err := api.GetHeatmap(family)
+		if err != nil {
+			c.String(http.StatusBadRequest, fmt.Sprintf("unable to locate heatmap for '%s'", family))
+		} else {
+			c.Data(200, "image/png", img)
+		}
+	})
 	r.GET("/view/location/:family/:device", func(c *gin.Context) {
 		family := strings.ToLower(c.Param("family"))
 		device := c.Param("device")
@@ -333,6 +387,7 @@ func Run() (err error) {
 		}
 		c.JSON(200, gin.H{"success": err == nil, "message": message, "data": sensors})
 	})
+	r.GET("/view/floorplan/:family", handlerFloorplanView)
 	r.GET("/view/gps/:family", func(c *gin.Context) {
 		err := func(family string) (err error) {
 			logger.Log.Debugf("[%s] getting gps", family)
@@ -504,7 +559,6 @@ func Run() (err error) {
 			var rollingData models.ReverseRollingData
 			errRolling := d.Get("ReverseRollingData", &rollingData)
 			passiveTable := []DeviceTable{}
-			scannerList := []string{}
 			if errRolling == nil {
 				passiveTable = make([]DeviceTable, len(rollingData.DeviceLocation))
 				i := 0
@@ -518,27 +572,15 @@ func Run() (err error) {
 					passiveTable[i].LastSeen = time.Unix(0, s.Timestamp*1000000).UTC()
 					i++
 				}
-				sensors, errGet := d.GetSensorFromGreaterTime(60000 * 15)
-				if errGet == nil {
-					allScanners := make(map[string]struct{})
-					for _, s := range sensors {
-						for sensorType := range s.Sensors {
-							for scanner := range s.Sensors[sensorType] {
-								allScanners[scanner] = struct{}{}
-							}
-						}
-					}
-					scannerList = make([]string, len(allScanners))
-					i = 0
-					for scanner := range allScanners {
-						scannerList[i] = scanner
-						i++
-					}
-				}
 			}
 
 			d.Close()
 
+			scanners, errScanners := api.GetScanners(family)
+			if errScanners != nil {
+				logger.Log.Warn(errScanners)
+			}
+
 			logger.Log.Debugf("[%s] getting by_locations for %d devices", family, len(deviceCounts))
 			// logger.Log.Debug(deviceCounts)
 			byLocations, err := api.GetByLocation(family, 15, false, 3, 0, 0, deviceCounts)
@@ -580,7 +622,7 @@ func Run() (err error) {
 				"PassiveDevices": passiveTable,
 				"DeviceList":     template.JS(jsonDeviceList),
 				"LocationList":   template.JS(jsonLocationList),
-				"Scanners":       scannerList,
+				"Scanners":       scanners,
 				"PercentCorrect": percentFloat64,
 				"UseMQTT":        UseMQTT,
 				"MQTTServer":     os.Getenv("MQTT_EXTERNAL"),
@@ -616,12 +658,103 @@ func Run() (err error) {
 	r.POST("/api/v1/settings/passive", handlerReverseSettings)
 	r.OPTIONS("/api/v1/efficacy/:family", func(c *gin.Context) { c.String(200, "OK") })
 	r.GET("/api/v1/efficacy/:family", handlerEfficacy)
+	r.OPTIONS("/api/v1/webhooks/:family", func(c *gin.Context) { c.String(200, "OK") })
+	r.GET("/api/v1/webhooks/:family", handlerWebhooks)
+	r.POST("/api/v1/webhooks/:family", handlerAddWebhook)
+	r.DELETE("/api/v1/webhooks/:family/:id", handlerDeleteWebhook)
+	r.GET("/api/v1/webhooks/:family/:id/deliveries", handlerWebhookDeliveries)
+	r.OPTIONS("/api/v1/zones/:family", func(c *gin.Context) { c.String(200, "OK") })
+	r.GET("/api/v1/zones/:family", handlerZones)
+	r.POST("/api/v1/zones/:family", handlerSetZone)
+	r.DELETE("/api/v1/zones/:family/:zone", handlerDeleteZone)
+	r.OPTIONS("/api/v1/events/:family", func(c *gin.Context) { c.String(200, "OK") })
+	r.GET("/api/v1/events/:family", handlerEvents)
+	r.OPTIONS("/api/v1/scanners/:family", func(c *gin.Context) { c.String(200, "OK") })
+	r.GET("/api/v1/scanners/:family", handlerScanners)
+	r.POST("/api/v1/scanners/:family", handlerSetScanner)
+	r.DELETE("/api/v1/scanners/:family/:scanner", handlerDeleteScanner)
+	r.OPTIONS("/api/v1/offsets/:family", func(c *gin.Context) { c.String(200, "OK") })
+	r.GET("/api/v1/offsets/:family", handlerRSSIOffsets)
+	r.POST("/api/v1/offsets/:family", handlerSetRSSIOffsets)
+	r.OPTIONS("/api/v1/offsets/:family/estimate", func(c *gin.Context) { c.String(200, "OK") })
+	r.POST("/api/v1/offsets/:family/estimate", handlerEstimateRSSIOffset)
+	r.OPTIONS("/api/v1/privacy/:family", func(c *gin.Context) { c.String(200, "OK") })
+	r.GET("/api/v1/privacy/:family", handlerPrivacy)
+	r.POST("/api/v1/privacy/:family", handlerSetPrivacy)
+	r.OPTIONS("/api/v1/privacy/:family/rotate", func(c *gin.Context) { c.String(200, "OK") })
+	r.POST("/api/v1/privacy/:family/rotate", handlerRotatePrivacy)
+	r.OPTIONS("/api/v1/device/:family/:device/export", func(c *gin.Context) { c.String(200, "OK") })
+	r.GET("/api/v1/device/:family/:device/export", handlerExportDevice)
+	r.OPTIONS("/api/v1/device/:family/:device", func(c *gin.Context) { c.String(200, "OK") })
+	r.DELETE("/api/v1/device/:family/:device", handlerEraseDevice)
+	r.OPTIONS("/api/v1/audit/:family", func(c *gin.Context) { c.String(200, "OK") })
+	r.GET("/api/v1/audit/:family", handlerAudit)
+	r.OPTIONS("/api/v1/floorplans/:family", func(c *gin.Context) { c.String(200, "OK") })
+	r.GET("/api/v1/floorplans/:family", handlerFloorplans)
+	r.OPTIONS("/api/v1/floorplans/:family/:floor", func(c *gin.Context) { c.String(200, "OK") })
+	r.POST("/api/v1/floorplans/:family/:floor", handlerUploadFloorplan)
+	r.DELETE("/api/v1/floorplans/:family/:floor", handlerDeleteFloorplan)
+	r.GET("/api/v1/floorplans/:family/:floor/image", handlerFloorplanImage)
+	r.OPTIONS("/api/v1/coordinates/:family", func(c *gin.Context) { c.String(200, "OK") })
+	r.POST("/api/v1/coordinates/:family", handlerSetLocationCoordinates)
+	r.OPTIONS("/api/v1/coordinates/:family/:location", func(c *gin.Context) { c.String(200, "OK") })
+	r.DELETE("/api/v1/coordinates/:family/:location", handlerDeleteLocationCoordinate)
+	r.OPTIONS("/api/v1/hierarchy/:family", func(c *gin.Context) { c.String(200, "OK") })
+	r.GET("/api/v1/hierarchy/:family", handlerHierarchy)
+	r.POST("/api/v1/hierarchy/:family", handlerSetHierarchy)
+	r.OPTIONS("/api/v1/hierarchy/:family/:location", func(c *gin.Context) { c.String(200, "OK") })
+	r.DELETE("/api/v1/hierarchy/:family/:location", handlerDeleteHierarchy)
+	r.POST("/api/v1/location/:family/:location/rename", handlerRenameLocation)
+	r.OPTIONS("/api/v1/device/:family/:device/rename", func(c *gin.Context) { c.String(200, "OK") })
+	r.POST("/api/v1/device/:family/:device/rename", handlerRenameDevice)
+	r.OPTIONS("/api/v1/location_metadata/:family", func(c *gin.Context) { c.String(200, "OK") })
+	r.GET("/api/v1/location_metadata/:family", handlerLocationMetadata)
+	r.OPTIONS("/api/v1/location_metadata/:family/:location", func(c *gin.Context) { c.String(200, "OK") })
+	r.POST("/api/v1/location_metadata/:family/:location", handlerSetLocationMetadata)
+	r.OPTIONS("/api/v1/trilateration/:family", func(c *gin.Context) { c.String(200, "OK") })
+	r.GET("/api/v1/trilateration/:family", handlerTrilateration)
+	r.POST("/api/v1/trilateration/:family", handlerSetTrilateration)
+	r.OPTIONS("/api/v1/trilateration/:family/:mac", func(c *gin.Context) { c.String(200, "OK") })
+	r.DELETE("/api/v1/trilateration/:family/:mac", handlerDeleteAccessPoint)
+	r.OPTIONS("/api/v1/walkable/:family", func(c *gin.Context) { c.String(200, "OK") })
+	r.GET("/api/v1/walkable/:family", handlerWalkableAreas)
+	r.OPTIONS("/api/v1/walkable/:family/:floor", func(c *gin.Context) { c.String(200, "OK") })
+	r.POST("/api/v1/walkable/:family/:floor", handlerSetWalkableArea)
+	r.DELETE("/api/v1/walkable/:family/:floor", handlerDeleteWalkableArea)
+	r.OPTIONS("/api/v1/beacons/:family", func(c *gin.Context) { c.String(200, "OK") })
+	r.GET("/api/v1/beacons/:family", handlerBeacons)
+	r.POST("/api/v1/beacons/:family", handlerSetBeacons)
+	r.OPTIONS("/api/v1/beacons/:family/:beacon", func(c *gin.Context) { c.String(200, "OK") })
+	r.DELETE("/api/v1/beacons/:family/:beacon", handlerDeleteBeacon)
+	r.OPTIONS("/api/v1/barometer/:family", func(c *gin.Context) { c.String(200, "OK") })
+	r.GET("/api/v1/barometer/:family", handlerBarometerReference)
+	r.POST("/api/v1/barometer/:family", handlerSetBarometerReference)
+	r.OPTIONS("/api/v1/history/:family/:device", func(c *gin.Context) { c.String(200, "OK") })
+	r.GET("/api/v1/history/:family/:device", handlerHistory)
+	r.OPTIONS("/api/v1/occupancy/:family", func(c *gin.Context) { c.String(200, "OK") })
+	r.GET("/api/v1/occupancy/:family", handlerOccupancy)
+	r.OPTIONS("/api/v1/dwell/:family", func(c *gin.Context) { c.String(200, "OK") })
+	r.GET("/api/v1/dwell/:family", handlerDwell)
+	r.OPTIONS("/api/v1/transitions/:family", func(c *gin.Context) { c.String(200, "OK") })
+	r.GET("/api/v1/transitions/:family", handlerTransitions)
 	r.GET("/ping", ping)
 	r.GET("/now", handlerNow)
 	r.GET("/test", handleTest)
 	r.GET("/ws", wshandler) // handler for the web sockets (see websockets.go)
+	r.OPTIONS("/api/v1/stream/:family", func(c *gin.Context) { c.String(200, "OK") })
+	r.GET("/api/v1/stream/:family", handlerStream) // handler for the server-sent events (see sse.go)
 	if UseMQTT {
 		r.GET("/api/v1/mqtt/:family", handlerMQTT) // handler for setting MQTT
+		r.OPTIONS("/api/v1/mqtt/:family/credentials", func(c *gin.Context) { c.String(200, "OK") })
+		r.GET("/api/v1/mqtt/:family/credentials", handlerMQTTCredentials)
+		r.POST("/api/v1/mqtt/:family/credentials", handlerAddMQTTCredential)
+		r.OPTIONS("/api/v1/mqtt/:family/credentials/:name", func(c *gin.Context) { c.String(200, "OK") })
+		r.DELETE("/api/v1/mqtt/:family/credentials/:name", handlerRevokeMQTTCredential)
+		r.OPTIONS("/api/v1/mqtt/:family/credentials/:name/rotate", func(c *gin.Context) { c.String(200, "OK") })
+		r.POST("/api/v1/mqtt/:family/credentials/:name/rotate", handlerRotateMQTTCredential)
+		r.OPTIONS("/api/v1/homeassistant/:family", func(c *gin.Context) { c.String(200, "OK") })
+		r.GET("/api/v1/homeassistant/:family", handlerHomeAssistant)
+		r.POST("/api/v1/homeassistant/:family", handlerSetHomeAssistant)
 	}
 	r.POST("/api/v1/gps", handlerGPS)        // typical data handler
 	r.POST("/data", handlerData)             // typical data handler
@@ -732,9 +865,10 @@ func handlerApiV1Locations(c *gin.Context) {
 
 func handlerEfficacy(c *gin.Context) {
 	type Efficacy struct {
-		AccuracyBreakdown   map[string]float64                       `json:"accuracy_breakdown"`
-		ConfusionMetrics    map[string]map[string]models.BinaryStats `json:"confusion_metrics"`
-		LastCalibrationTime time.Time                                `json:"last_calibration_time"`
+		AccuracyBreakdown       map[string]float64                       `json:"accuracy_breakdown"`
+		AccuracyBreakdownLevels map[string]map[string]float64            `json:"accuracy_breakdown_levels,omitempty"`
+		ConfusionMetrics        map[string]map[string]models.BinaryStats `json:"confusion_metrics"`
+		LastCalibrationTime     time.Time                                `json:"last_calibration_time"`
 	}
 	efficacy, err := func(c *gin.Context) (efficacy Efficacy, err error) {
 		family := strings.ToLower(strings.TrimSpace(c.Param("family")))
@@ -755,6 +889,8 @@ func handlerEfficacy(c *gin.Context) {
 			err = errors.Wrap(err, "could not get AccuracyBreakdown")
 			return
 		}
+		// only with a hierarchy of the locations, and after a newer calibration
+		d.Get("AccuracyBreakdownLevels", &efficacy.AccuracyBreakdownLevels)
 		err = d.Get("AlgorithmEfficacy", &efficacy.ConfusionMetrics)
 		if err != nil {
 			err = errors.Wrap(err, "could not get AlgorithmEfficacy")
@@ -903,7 +1039,7 @@ func handlerApiV1Calibrate(c *gin.Context) {
 // This is a handlerMQTT function that handles an HTTP request in a Gin web framework context. The function takes a single argument, c, which is a pointer to a gin.Context object. Here's a brief explanation of the function:
 // The function defines an anonymous function that takes a *gin.Context argument and returns a message string and an err error. This anonymous function is immediately invoked with the c argument.
 // Inside the anonymous function, it first retrieves a URL parameter called "family" and trims and converts it to lowercase. If the "family" parameter is empty, it returns an "invalid family" error.
-// If the "family" parameter is valid, it calls the mqtt.AddFamily function with the "family" parameter. This function presumably adds a new MQTT family and returns a passphrase associated with that family, as well as an error if there's a problem.
+// If the "family" parameter is valid, it calls the mqtt.AddFamily function with the "family" parameter. This function rotates the default credential of the family (making it if needed) and returns its new passphrase, as well as an error if there's a problem.
 // If there's an error, the anonymous function returns the error. Otherwise, it returns a message containing the family name and the generated passphrase.
 // The handlerMQTT function then checks if there was an error. If there was an error, it sends a JSON response with an HTTP status code of http.StatusOK (200 OK), along with a message containing the error and a "success" field set to false.
 // If there was no error, it sends a JSON response with an HTTP status code of http.StatusOK, along with a message containing the success message and a "success" field set to true.
@@ -970,104 +1106,110 @@ func handlerData(c *gin.Context) {
 			err = errors.Wrap(err, "problem binding data")
 			return
 		}
+		message, err = processDataRequest(d, justSave)
+		return
+	}(c)
+	if err != nil {
+		logger.Log.Debugf("[%s] problem parsing: %s", message, err.Error())
+		c.JSON(http.StatusOK, gin.H{"message": err.Error(), "success": false})
+	} else {
+		c.JSON(http.StatusOK, gin.H{"message": message, "success": true})
+	}
+}
 
-		// call Python function for processing equipment
-		sensorsJSON, err := json.Marshal(d.Sensors)
-		timestampStr := strconv.FormatInt(d.Timestamp, 10)
-		cmd := exec.Command("python3", "/app/main/src/server/Eq_process.py", d.Family, string(sensorsJSON), timestampStr, d.Device, d.Location)
+// processDataRequest runs sensor data through the processing scripts, validates it
+// and saves it. It is used for the data posted to /data and sent over MQTT.
+func processDataRequest(d models.SensorData, justSave bool) (message string, err error) {
+	// call Python function for processing equipment
+	sensorsJSON, err := json.Marshal(d.Sensors)
+	timestampStr := strconv.FormatInt(d.Timestamp, 10)
+	cmd := exec.Command("python3", "/app/main/src/server/Eq_process.py", d.Family, string(sensorsJSON), timestampStr, d.Device, d.Location)
 
-		output, err := cmd.CombinedOutput()
-		if err != nil {
-			return
-		}
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return
+	}
 
-		var modifiedSensors map[string]map[string]interface{}
-		err = json.Unmarshal(output, &modifiedSensors)
-		if err != nil {
-			return "", err
-		}
-
-		d.Sensors = modifiedSensors
-		// // use this one to get two outputs from python file
-		// // Collect the output from the Python script
-		// output, err := cmd.CombinedOutput()
-		// if err != nil {
-		// 	return
-		// }
-
-		// // Define a structure to hold the output
-		// type Output struct {
-		// 	Location string
-		// 	Data     map[string]map[string]interface{}
-		// }
-
-		// // Unmarshal the JSON output into the structure
-		// var result Output
-		// err = json.Unmarshal(output, &result)
-		// if err != nil {
-		// 	return models.LocationAnalysis{}, err
-		// }
-
-		// // Extract the modified sensors and location from the result
-		// p.Sensors = result.Data
-		// p.Location = result.Location
-
-		// call Python function for Kalman filter
-		sensorsJSON, err = json.Marshal(d.Sensors)
-		cmd = exec.Command("python3", "/app/main/src/server/Kalman_filter.py", d.Family, string(sensorsJSON))
-
-		output, err = cmd.CombinedOutput()
-		if err != nil {
-			return
-		}
+	var modifiedSensors map[string]map[string]interface{}
+	err = json.Unmarshal(output, &modifiedSensors)
+	if err != nil {
+		return "", err
+	}
 
-		// var modifiedSensors map[string]map[string]interface{}
-		err = json.Unmarshal(output, &modifiedSensors)
-		if err != nil {
-			return "", err
-		}
+	d.Sensors = modifiedSensors
+	// // use this one to get two outputs from python file
+	// // Collect the output from the Python script
+	// output, err := cmd.CombinedOutput()
+	// if err != nil {
+	// 	return
+	// }
 
-		d.Sensors = modifiedSensors
+	// // Define a structure to hold the output
+	// type Output struct {
+	// 	Location string
+	// 	Data     map[string]map[string]interface{}
+	// }
 
-		err = d.Validate()
-		if err != nil {
-			message = d.Family
-			err = errors.Wrap(err, "problem validating data")
-			return
-		}
+	// // Unmarshal the JSON output into the structure
+	// var result Output
+	// err = json.Unmarshal(output, &result)
+	// if err != nil {
+	// 	return models.LocationAnalysis{}, err
+	// }
 
-		// process data
-		d.Family = strings.TrimSpace(strings.ToLower(d.Family))
+	// // Extract the modified sensors and location from the result
+	// p.Sensors = result.Data
+	// p.Location = result.Location
 
-		err = processSensorData(d, justSave)
-		if err != nil {
-			message = d.Family
-			return
-		}
+	// call Python function for Kalman filter
+	sensorsJSON, err = json.Marshal(d.Sensors)
+	cmd = exec.Command("python3", "/app/main/src/server/Kalman_filter.py", d.Family, string(sensorsJSON))
 
-		//***************************************
-		// test python file just to print sensors
-		sensorsJSON, err = json.Marshal(d.Sensors)
-		// timestampStr := strconv.FormatInt(d.Timestamp, 10)
-		cmd = exec.Command("python3", "/app/main/src/server/pytest.py", d.Family, string(sensorsJSON), timestampStr, d.Device, d.Location)
-		err = cmd.Run()
-		if err != nil {
-			fmt.Println(err)
-			return
-		}
-		//***************************************
+	output, err = cmd.CombinedOutput()
+	if err != nil {
+		return
+	}
+
+	// var modifiedSensors map[string]map[string]interface{}
+	err = json.Unmarshal(output, &modifiedSensors)
+	if err != nil {
+		return "", err
+	}
 
-		message = "inserted data"
+	d.Sensors = modifiedSensors
 
-		logger.Log.Debugf("[%s] /data %+v", d.Family, d)
+	err = d.Validate()
+	if err != nil {
+		message = d.Family
+		err = errors.Wrap(err, "problem validating data")
 		return
-	}(c)
+	}
+
+	// process data
+	d.Family = strings.TrimSpace(strings.ToLower(d.Family))
+
+	err = processSensorData(&d, justSave)
 	if err != nil {
-		logger.Log.Debugf("[%s] problem parsing: %s", message, err.Error())
-		c.JSON(http.StatusOK, gin.H{"message": err.Error(), "success": false})
-	} else {
-		c.JSON(http.StatusOK, gin.H{"message": message, "success": true})
+		message = d.Family
+		return
 	}
+
+	//***************************************
+	// test python file just to print sensors
+	sensorsJSON, err = json.Marshal(d.Sensors)
+	// timestampStr := strconv.FormatInt(d.Timestamp, 10)
+	cmd = exec.Command("python3", "/app/main/src/server/pytest.py", d.Family, string(sensorsJSON), timestampStr, d.Device, d.Location)
+	err = cmd.Run()
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	//***************************************
+
+	message = "inserted data"
+
+	logger.Log.Debugf("[%s] /data %+v", d.Family, d)
+	return
 }
 
 func handlerGPS(c *gin.Context) {
@@ -1138,7 +1280,7 @@ func handlerDataClassify(c *gin.Context) {
 		}
 
 		// process data
-		err = processSensorData(d, true)
+		err = processSensorData(&d, true)
 		if err != nil {
 			return
 		}
@@ -1241,6 +1383,9 @@ func handlerReverseSettings(c *gin.Context) {
 		}
 
 		err = db.Set("ReverseRollingData", rollingData)
+		if err == nil {
+			passiveSettingsChanged(d.Family, rollingData)
+		}
 		logger.Log.Debugf("[%s] %s", d.Family, message)
 		return
 	}(c)
@@ -1263,63 +1408,7 @@ func handlerReverse(c *gin.Context) {
 			return
 		}
 
-		// validate sensor data
-		err = d.Validate()
-		if err != nil {
-			logger.Log.Warn(err)
-			return
-		}
-
-		d.Family = strings.TrimSpace(strings.ToLower(d.Family))
-
-		if d.Location != "" {
-			logger.Log.Debugf("[%s] entered passive fingerprint for %s at %s", d.Family, d.Device, d.Location)
-		} else {
-			logger.Log.Debugf("[%s] entered passive fingerprint for %s", d.Family, d.Device)
-		}
-
-		// open database
-		db, err := database.Open(d.Family)
-		if err != nil {
-			return
-		}
-		defer db.Close()
-
-		var rollingData models.ReverseRollingData
-		err = db.Get("ReverseRollingData", &rollingData)
-		if err != nil {
-			// defaults
-			rollingData = models.ReverseRollingData{
-				Family:         d.Family,
-				DeviceLocation: make(map[string]string),
-				TimeBlock:      90 * time.Second,
-			}
-		}
-		if rollingData.TimeBlock.Seconds() == 0 {
-			rollingData.TimeBlock = 90 * time.Second
-		}
-
-		if !rollingData.HasData {
-			rollingData.Timestamp = time.Now().UTC()
-			rollingData.Datas = []models.SensorData{}
-			rollingData.HasData = true
-		}
-		if len(d.Sensors) == 0 {
-			err = errors.New("no fingerprints")
-			return
-		}
-
-		rollingData.Datas = append(rollingData.Datas, d)
-		numFingerprints := 0
-		for sensor := range d.Sensors {
-			numFingerprints += len(d.Sensors[sensor])
-		}
-		err = db.Set("ReverseRollingData", rollingData)
-		message = fmt.Sprintf("inserted %d fingerprints for %s", numFingerprints, d.Family)
-
-		if err == nil {
-			go parseRollingData(d.Family)
-		}
+		message, err = processPassiveRequest(d)
 		return
 	}(c)
 
@@ -1332,77 +1421,6 @@ func handlerReverse(c *gin.Context) {
 
 }
 
-func parseRollingData(family string) (err error) {
-	db, err := database.Open(family)
-	if err != nil {
-		return
-	}
-	defer db.Close()
-
-	var rollingData models.ReverseRollingData
-	err = db.Get("ReverseRollingData", &rollingData)
-	if err != nil {
-		return
-	}
-
-	sensorMap := make(map[string]models.SensorData)
-	if rollingData.HasData && time.Since(rollingData.Timestamp) > rollingData.TimeBlock {
-		logger.Log.Debugf("[%s] New data arrived %s", family, time.Since(rollingData.Timestamp))
-		// merge data
-		for _, data := range rollingData.Datas {
-			for sensor := range data.Sensors {
-				for mac := range data.Sensors[sensor] {
-					rssi := data.Sensors[sensor][mac]
-					trackedDeviceName := sensor + "-" + mac
-					if _, ok := sensorMap[trackedDeviceName]; !ok {
-						location := ""
-						// if there is a device+location in map, then it is currently doing learning
-						if loc, hasMac := rollingData.DeviceLocation[trackedDeviceName]; hasMac {
-							location = loc
-						}
-						var gps models.GPS
-						if g, hasMac := rollingData.DeviceGPS[trackedDeviceName]; hasMac {
-							gps = g
-						}
-						sensorMap[trackedDeviceName] = models.SensorData{
-							Family:    family,
-							Device:    trackedDeviceName,
-							Timestamp: time.Now().UTC().UnixNano() / int64(time.Millisecond),
-							Sensors:   make(map[string]map[string]interface{}),
-							Location:  location,
-							GPS:       gps,
-						}
-						time.Sleep(10 * time.Millisecond)
-						sensorMap[trackedDeviceName].Sensors[sensor] = make(map[string]interface{})
-					}
-					sensorMap[trackedDeviceName].Sensors[sensor][data.Device+"-"+sensor] = rssi
-				}
-			}
-		}
-		rollingData.HasData = false
-	}
-	db.Set("ReverseRollingData", rollingData)
-	db.Close()
-	for sensor := range sensorMap {
-		logger.Log.Debugf("[%s] reverse sensor data: %+v", family, sensorMap[sensor])
-		numPassivePoints := 0
-		for sensorType := range sensorMap[sensor].Sensors {
-			numPassivePoints += len(sensorMap[sensor].Sensors[sensorType])
-		}
-		if numPassivePoints < rollingData.MinimumPassive {
-			logger.Log.Debugf("[%s] skipped saving reverse sensor data for %s, not enough points (< %d)", family, sensor, rollingData.MinimumPassive)
-			continue
-		}
-		err := processSensorData(sensorMap[sensor])
-		if err != nil {
-			logger.Log.Warnf("[%s] problem saving: %s", family, err.Error())
-		}
-		logger.Log.Debugf("[%s] saved reverse sensor data for %s", family, sensor)
-	}
-
-	return
-}
-
 func handlerFIND(c *gin.Context) {
 	var j models.FINDFingerprint
 	var err error
@@ -1413,7 +1431,7 @@ func handlerFIND(c *gin.Context) {
 			j.Location = ""
 		}
 		d := j.Convert()
-		err2 := processSensorData(d)
+		err2 := processSensorData(&d)
 		if err2 == nil {
 			message = "inserted data"
 		} else {
@@ -1427,17 +1445,22 @@ func handlerFIND(c *gin.Context) {
 	}
 }
 
-// The processSensorData function is responsible for processing and handling the sensor data provided to it as an input parameter (p models.SensorData). It takes two arguments:
-// p models.SensorData: The sensor data to be processed, which is an instance of the models.SensorData struct.
+// The processSensorData function is responsible for processing and handling the sensor data provided to it as an input parameter (p *models.SensorData). It takes two arguments:
+// p *models.SensorData: The sensor data to be processed, which is normalized in place with the RSSI offsets of its family.
 // justSave ...bool: A variadic parameter that, if provided and set to true, indicates that the function should only save the sensor data and not perform any further processing.
 
 // The function performs the following steps:
+// It calls the api.NormalizeSensorData(p) function to apply the RSSI offsets of the family to p.
 // It calls the api.SaveSensorData(p) function to save the sensor data p. If there is an error during saving, the function returns the error.
 // It checks whether the justSave parameter is provided and set to true. If so, it returns immediately without performing any further processing.
 // If the justSave parameter is not set to true or not provided, it calls the sendOutData(p) function in a new goroutine. This function is responsible for further processing of the sensor data
 
-func processSensorData(p models.SensorData, justSave ...bool) (err error) {
-	err = api.SaveSensorData(p)
+func processSensorData(p *models.SensorData, justSave ...bool) (err error) {
+	err = api.NormalizeSensorData(p)
+	if err != nil {
+		return
+	}
+	err = api.SaveSensorData(*p)
 	if err != nil {
 		return
 	}
@@ -1445,10 +1468,22 @@ func processSensorData(p models.SensorData, justSave ...bool) (err error) {
 	if len(justSave) > 0 && justSave[0] {
 		return
 	}
-	go sendOutData(p)
+	goSendOutData(*p)
 	return
 }
 
+// sending counts the sensor data that are still being analyzed and sent out
+var sending sync.WaitGroup
+
+// goSendOutData analyzes and sends out sensor data in the background
+func goSendOutData(p models.SensorData) {
+	sending.Add(1)
+	go func() {
+		defer sending.Done()
+		sendOutData(p)
+	}()
+}
+
 // sendOutData(p models.SensorData): This function takes sensor data, analyzes it, and sends the data and analysis results to the Android device using WebSockets and MQTT (if enabled).
 
 // This sendOutData function processes sensor data, analyzes it, and sends the data along with the analysis results to an Android device using WebSockets and MQTT (if enabled). The function takes one argument, p, which is of type models.SensorData.
@@ -1480,14 +1515,6 @@ func sendOutData(p models.SensorData) (analysis models.LocationAnalysis, err err
 		err = errors.New("no guesses")
 		return
 	}
-	type Payload struct {
-		Sensors           models.SensorData           `json:"sensors"`
-		Guesses           []models.LocationPrediction `json:"guesses"`
-		Location          string                      `json:"location"`           // FIND backwards-compatability
-		Time              int64                       `json:"time"`               // FIND backwards-compatability
-		EquipmentLocation string                      `json:"equipment_location"` // New field
-	}
-
 	// determine GPS coordinates
 	gpsData, err := api.GetGPSData(p.Family)
 	_, hasLoc := gpsData[analysis.Guesses[0].Location]
@@ -1567,13 +1594,19 @@ func sendOutData(p models.SensorData) (analysis models.LocationAnalysis, err err
 
 	// *****************************************************
 
-	payload := Payload{
+	tracked, errTrack := api.Track(p.Family, p.Device, p.Timestamp, analysis)
+	if errTrack != nil {
+		logger.Log.Warnf("[%s] problem tracking %s: %s", p.Family, p.Device, errTrack.Error())
+	}
+
+	payload := locationPayload{
 		Sensors:  p,
 		Guesses:  analysis.Guesses,
 		Location: analysis.Guesses[0].Location,
 		Time:     p.Timestamp,
 		// EquipmentLocation: result_eq.Location, // New field
 		EquipmentLocation: "empty",
+		Tracked:           tracked,
 	}
 
 	bTarget, err := json.Marshal(payload)
@@ -1586,6 +1619,25 @@ func sendOutData(p models.SensorData) (analysis models.LocationAnalysis, err err
 	// logger.Log.Debugf("sending data over websockets (%s/%s):%s", p.Family, p.Device, bTarget)
 	SendMessageOverWebsockets(p.Family, p.Device, bTarget)
 	SendMessageOverWebsockets(p.Family, "all", bTarget)
+	streamID := recordStreamEvent(p.Family, p.Device, analysis.Guesses[0].Location, p.Timestamp)
+	SendMessageOverSSE(p.Family, p.Device, analysis.Guesses[0].Location, streamID, bTarget)
+
+	// send out the events when the device moved or entered, left or dwelled in a zone (see events.go)
+	previous, changed := api.DetectLocationChange(p.Family, p.Device, analysis.Guesses[0].Location, p.Timestamp)
+	if changed {
+		sendOutEvent(models.Event{
+			Type:             models.EventLocationChange,
+			Family:           p.Family,
+			Device:           p.Device,
+			Location:         analysis.Guesses[0].Location,
+			PreviousLocation: previous,
+			Probability:      analysis.Guesses[0].Probability,
+			Timestamp:        p.Timestamp,
+		})
+	}
+	for _, e := range api.ProcessZones(p.Family, p.Device, analysis.Guesses[0].Location, previous, analysis.Guesses[0].Probability, p.Timestamp) {
+		sendOutEvent(e)
+	}
 
 	if UseMQTT {
 		logger.Log.Debugf("[%s] sending data over mqtt (%s)", p.Family, p.Device)
@@ -1603,6 +1655,40 @@ func sendOutData(p models.SensorData) (analysis models.LocationAnalysis, err err
 	return
 }
 
+// locationPayload is what sendOutData pushes over websockets, SSE and MQTT
+type locationPayload struct {
+	Sensors           models.SensorData           `json:"sensors"`
+	Guesses           []models.LocationPrediction `json:"guesses"`
+	Location          string                      `json:"location"`           // FIND backwards-compatability
+	Time              int64                       `json:"time"`               // FIND backwards-compatability
+	EquipmentLocation string                      `json:"equipment_location"` // New field
+	// Tracked is the position of the particle filter of the device, on the floorplans
+	Tracked *models.TrackedPosition `json:"tracked,omitempty"`
+}
+
+// newLocationPayload builds the payload for stored guesses, with the GPS of the best guess
+func newLocationPayload(p models.SensorData, guesses []models.LocationPrediction, gpsData map[string]models.SensorData) (bTarget []byte, err error) {
+	if len(guesses) == 0 {
+		err = errors.New("no guesses")
+		return
+	}
+	if gps, ok := gpsData[guesses[0].Location]; ok {
+		p.GPS.Latitude = gps.GPS.Latitude
+		p.GPS.Longitude = gps.GPS.Longitude
+	} else {
+		p.GPS.Latitude = -1
+		p.GPS.Longitude = -1
+	}
+	bTarget, err = json.Marshal(locationPayload{
+		Sensors:           p,
+		Guesses:           guesses,
+		Location:          guesses[0].Location,
+		Time:              p.Timestamp,
+		EquipmentLocation: "empty",
+	})
+	return
+}
+
 func middleWareHandler() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		t := time.Now().UTC()
@@ -1615,6 +1701,18 @@ func middleWareHandler() gin.HandlerFunc {
 	}
 }
 
+// gzipUnlessStreaming compresses every response except the event streams,
+// which would otherwise be held in the gzip buffer instead of being flushed.
+func gzipUnlessStreaming() gin.HandlerFunc {
+	compress := gzip.Gzip(gzip.DefaultCompression)
+	return func(c *gin.Context) {
+		if strings.HasPrefix(c.Request.URL.Path, "/api/v1/stream/") {
+			return
+		}
+		compress(c)
+	}
+}
+
 func addCORS(c *gin.Context) {
 	c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
 	c.Writer.Header().Set("Access-Control-Max-Age", "86400")
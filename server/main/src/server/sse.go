@@ -0,0 +1,274 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/Nimaapr/find3/server/main/src/api"
+	"github.com/Nimaapr/find3/server/main/src/database"
+	"github.com/Nimaapr/find3/server/main/src/models"
+)
+
+// This file implements a Server-Sent Events (SSE) stream of location updates, for consumers that
+// sit behind proxies that drop websockets. Every payload that sendOutData pushes over websockets
+// and MQTT is also handed to SendMessageOverSSE, which fans it out to the subscribers of that family.
+// Each update is recorded in the stream_events table, whose ID, given by the server and only growing,
+// is the id of the event, and which keeps the updates of the last StreamReplayLimit IDs. A client that
+// reconnects with a Last-Event-ID header is first replayed the newest updates it missed, after a "gap"
+// event when there were more than StreamReplayLimit of them, and then continues with the live stream.
+// A client that is ahead of the last ID, after the database was reset, gets a "reset" event instead.
+// Subscribers can filter the stream by device and by (best guess) location.
+
+// StreamHeartbeat is how often a comment is sent to keep idle connections open
+var StreamHeartbeat = 30 * time.Second
+
+// StreamReplayLimit is the maximum number of missed updates replayed on resume, the newest ones,
+// and the number of IDs whose updates are kept
+var StreamReplayLimit = 1000
+
+type streamMessage struct {
+	id       int64
+	device   string
+	location string
+	data     []byte
+}
+
+type streamSubscriber struct {
+	family   string
+	device   string
+	location string
+	messages chan streamMessage
+}
+
+func (s *streamSubscriber) matches(m streamMessage) bool {
+	if s.device != "" && s.device != m.device {
+		return false
+	}
+	if s.location != "" && s.location != m.location {
+		return false
+	}
+	return true
+}
+
+type Streams struct {
+	subscribers map[string]map[*streamSubscriber]struct{}
+	sync.Mutex
+}
+
+var streams Streams
+
+func init() {
+	streams.Lock()
+	defer streams.Unlock()
+	streams.subscribers = make(map[string]map[*streamSubscriber]struct{})
+}
+
+func addStreamSubscriber(s *streamSubscriber) {
+	streams.Lock()
+	defer streams.Unlock()
+	if _, ok := streams.subscribers[s.family]; !ok {
+		streams.subscribers[s.family] = make(map[*streamSubscriber]struct{})
+	}
+	streams.subscribers[s.family][s] = struct{}{}
+}
+
+func removeStreamSubscriber(s *streamSubscriber) {
+	streams.Lock()
+	defer streams.Unlock()
+	delete(streams.subscribers[s.family], s)
+	if len(streams.subscribers[s.family]) == 0 {
+		delete(streams.subscribers, s.family)
+	}
+}
+
+// SendMessageOverSSE will send a location payload to the SSE subscribers of a family.
+// Slow subscribers that have a full buffer miss the message rather than block the sender.
+func SendMessageOverSSE(family, device, location string, id int64, msg []byte) {
+	m := streamMessage{id: id, device: device, location: location, data: msg}
+	streams.Lock()
+	defer streams.Unlock()
+	for s := range streams.subscribers[family] {
+		if !s.matches(m) {
+			continue
+		}
+		select {
+		case s.messages <- m:
+		default:
+			logger.Log.Warnf("[%s] dropped stream message %d, subscriber is too slow", family, id)
+		}
+	}
+}
+
+// handlerStream serves GET /api/v1/stream/:family as text/event-stream,
+// optionally filtered with ?device= and ?location=.
+func handlerStream(c *gin.Context) {
+	family := strings.ToLower(strings.TrimSpace(c.Param("family")))
+	if family == "" {
+		c.String(http.StatusBadRequest, "need family")
+		return
+	}
+	s := &streamSubscriber{
+		family:   family,
+		device:   strings.ToLower(strings.TrimSpace(c.DefaultQuery("device", ""))),
+		location: strings.ToLower(strings.TrimSpace(c.DefaultQuery("location", ""))),
+		messages: make(chan streamMessage, 100),
+	}
+
+	lastEventID := strings.TrimSpace(c.GetHeader("Last-Event-ID"))
+	if lastEventID == "" {
+		lastEventID = strings.TrimSpace(c.DefaultQuery("last_event_id", ""))
+	}
+	var resumeFrom int64
+	if lastEventID != "" {
+		var err error
+		resumeFrom, err = strconv.ParseInt(lastEventID, 10, 64)
+		if err != nil {
+			c.String(http.StatusBadRequest, "Last-Event-ID must be a number")
+			return
+		}
+	}
+
+	// subscribe before replaying so that nothing is missed in between
+	addStreamSubscriber(s)
+	defer removeStreamSubscriber(s)
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+	fmt.Fprintf(c.Writer, "retry: 3000\n\n")
+	c.Writer.Flush()
+
+	lastSent := resumeFrom
+	if resumeFrom > 0 {
+		r, err := replayStream(family, resumeFrom, s.device, s.location)
+		if err != nil {
+			logger.Log.Warnf("[%s] could not replay stream: %s", family, err.Error())
+			// without the last ID it is unknown which live updates the client has
+			lastSent = 0
+		} else if resumeFrom > r.last {
+			// the database was reset, the live updates would never get past the ID of the client
+			fmt.Fprintf(c.Writer, "event: reset\ndata: {\"id\":%d}\n\n", r.last)
+			lastSent = r.last
+		}
+		if r.missed > 0 || r.pruned {
+			fmt.Fprintf(c.Writer, "event: gap\ndata: {\"missed\":%d,\"pruned\":%t}\n\n", r.missed, r.pruned)
+		}
+		for _, m := range r.messages {
+			writeStreamMessage(c, m)
+			lastSent = m.id
+		}
+		c.Writer.Flush()
+	}
+	logger.Log.Debugf("[%s] opened stream (device='%s', location='%s')", family, s.device, s.location)
+
+	heartbeat := time.NewTicker(StreamHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-c.Request.Context().Done():
+			logger.Log.Debugf("[%s] closed stream", family)
+			return
+		case <-heartbeat.C:
+			fmt.Fprintf(c.Writer, ": ping\n\n")
+			c.Writer.Flush()
+		case m := <-s.messages:
+			// skip anything that was already sent during the replay
+			if m.id != 0 && m.id <= lastSent {
+				continue
+			}
+			writeStreamMessage(c, m)
+			c.Writer.Flush()
+		}
+	}
+}
+
+// writeStreamMessage writes an update, without an id when it could not be recorded, so that the
+// client keeps the id it has
+func writeStreamMessage(c *gin.Context, m streamMessage) {
+	if m.id == 0 {
+		fmt.Fprintf(c.Writer, "event: location\ndata: %s\n\n", m.data)
+		return
+	}
+	fmt.Fprintf(c.Writer, "id: %d\nevent: location\ndata: %s\n\n", m.id, m.data)
+}
+
+// recordStreamEvent stores a location update of the stream and returns its ID, 0 when it could not be stored
+func recordStreamEvent(family, device, location string, timestamp int64) (id int64) {
+	d, err := database.Open(family)
+	if err == nil {
+		id, err = d.AddStreamEvent(timestamp, device, location, StreamReplayLimit)
+		d.Close()
+	}
+	if err != nil {
+		logger.Log.Warnf("[%s] could not record stream event: %s", family, err.Error())
+	}
+	return
+}
+
+// streamReplay is what a client that resumes the stream from an ID missed
+type streamReplay struct {
+	// messages are the newest updates after the ID, oldest first
+	messages []streamMessage
+	// missed is the number of the older updates after the ID that were left out
+	missed int
+	// pruned is whether updates after the ID were removed already, then even more were missed
+	pruned bool
+	// last is the last ID given
+	last int64
+}
+
+// replayStream rebuilds the payloads of the newest updates of the stream after an ID, of the device
+// and at the location given when they are not empty
+func replayStream(family string, id int64, device, location string) (r streamReplay, err error) {
+	d, err := database.Open(family, true)
+	if err != nil {
+		return
+	}
+	r.last, err = d.GetLastStreamEventID()
+	if err != nil || id >= r.last {
+		d.Close()
+		return
+	}
+	r.pruned = id < r.last-int64(StreamReplayLimit)
+	events, missed, err := d.GetStreamEventsAfter(id, device, location, StreamReplayLimit)
+	if err != nil {
+		d.Close()
+		return
+	}
+	r.missed = missed
+	sensors := make([]models.SensorData, len(events))
+	guesses := make([][]models.LocationPrediction, len(events))
+	for i, e := range events {
+		sensors[i], _ = d.GetSensorFromTime(e.Timestamp)
+		guesses[i], _ = d.GetPrediction(e.Timestamp)
+	}
+	d.Close()
+
+	gpsData, _ := api.GetGPSData(family)
+	r.messages = make([]streamMessage, 0, len(events))
+	for i, e := range events {
+		if len(guesses[i]) == 0 || sensors[i].Device != e.Device {
+			// erased since
+			continue
+		}
+		bTarget, errPayload := newLocationPayload(sensors[i], guesses[i], gpsData)
+		if errPayload != nil {
+			continue
+		}
+		r.messages = append(r.messages, streamMessage{
+			id:       e.ID,
+			device:   e.Device,
+			location: e.Location,
+			data:     bTarget,
+		})
+	}
+	return
+}
@@ -0,0 +1,186 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/Nimaapr/find3/server/main/src/database"
+	"github.com/Nimaapr/find3/server/main/src/models"
+)
+
+func TestStream(t *testing.T) {
+	router := gin.New()
+	router.GET("/api/v1/stream/:family", handlerStream)
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req, _ := http.NewRequest("GET", ts.URL+"/api/v1/stream/testsse?device=phone1", nil)
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	assert.Nil(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	// wait for the subscription to register
+	for i := 0; i < 100; i++ {
+		streams.Lock()
+		n := len(streams.subscribers["testsse"])
+		streams.Unlock()
+		if n > 0 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	SendMessageOverSSE("testsse", "phone2", "kitchen", 1, []byte(`{"location":"kitchen"}`))
+	SendMessageOverSSE("testsse", "phone1", "bedroom", 2, []byte(`{"location":"bedroom"}`))
+
+	reader := bufio.NewReader(resp.Body)
+	lines := []string{}
+	for len(lines) < 3 {
+		line, err := reader.ReadString('\n')
+		assert.Nil(t, err)
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "retry:") {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	assert.Equal(t, []string{"id: 2", "event: location", `data: {"location":"bedroom"}`}, lines)
+}
+
+func TestStreamSubscriberMatches(t *testing.T) {
+	s := &streamSubscriber{family: "f", location: "kitchen"}
+	assert.True(t, s.matches(streamMessage{device: "a", location: "kitchen"}))
+	assert.False(t, s.matches(streamMessage{device: "a", location: "bedroom"}))
+	s.device = "b"
+	assert.False(t, s.matches(streamMessage{device: "a", location: "kitchen"}))
+}
+
+func TestStreamReplay(t *testing.T) {
+	defer useTestFolder(t)()
+	defer func(limit int) { StreamReplayLimit = limit }(StreamReplayLimit)
+
+	d, err := database.Open("testreplay")
+	assert.Nil(t, err)
+	// the passive data is dated back, so the timestamps are not in the order of the updates
+	updates := []struct {
+		timestamp int64
+		device    string
+		location  string
+	}{
+		{5000, "phone1", "kitchen"},
+		{2000, "phone1", "bedroom"},
+		{6000, "phone2", "kitchen"},
+		{3000, "phone1", "kitchen"},
+		{7000, "phone1", "kitchen"},
+	}
+	for _, u := range updates {
+		assert.Nil(t, d.AddSensor(models.SensorData{Timestamp: u.timestamp, Family: "testreplay", Device: u.device,
+			Sensors: map[string]map[string]interface{}{"wifi": {"aa:aa": -50.0}}}))
+		assert.Nil(t, d.AddPrediction(u.timestamp, []models.LocationPrediction{{Location: u.location, Probability: 0.9}}))
+	}
+	d.Close()
+	ids := []int64{}
+	for _, u := range updates {
+		ids = append(ids, recordStreamEvent("testreplay", u.device, u.location, u.timestamp))
+	}
+	for i := 1; i < len(ids); i++ {
+		assert.True(t, ids[i] > ids[i-1])
+	}
+	StreamReplayLimit = 2
+
+	// the updates of phone1 in the kitchen after the first one
+	r, err := replayStream("testreplay", ids[0], "phone1", "kitchen")
+	assert.Nil(t, err)
+	assert.Equal(t, 0, r.missed)
+	assert.Equal(t, 2, len(r.messages))
+	assert.Equal(t, ids[3], r.messages[0].id)
+	assert.Equal(t, ids[4], r.messages[1].id)
+	assert.True(t, strings.Contains(string(r.messages[0].data), `"t":3000`))
+
+	// only the newest two of all the updates, the other two are missed
+	r, err = replayStream("testreplay", ids[0], "", "")
+	assert.Nil(t, err)
+	assert.Equal(t, 2, r.missed)
+	assert.Equal(t, []int64{ids[3], ids[4]}, []int64{r.messages[0].id, r.messages[1].id})
+
+	// the next update removes the ones of the older IDs, which are then missed without being counted
+	ids = append(ids, recordStreamEvent("testreplay", "phone1", "kitchen", 7000))
+	r, err = replayStream("testreplay", ids[0], "", "")
+	assert.Nil(t, err)
+	assert.True(t, r.pruned)
+	assert.Equal(t, 0, r.missed)
+	assert.Equal(t, []int64{ids[4], ids[5]}, []int64{r.messages[0].id, r.messages[1].id})
+	r, err = replayStream("testreplay", ids[3], "", "")
+	assert.Nil(t, err)
+	assert.False(t, r.pruned)
+	assert.Equal(t, ids[5], r.last)
+
+	// a client ahead of the last ID gets nothing to replay
+	r, err = replayStream("testreplay", ids[5]+10, "", "")
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(r.messages))
+	assert.Equal(t, ids[5], r.last)
+}
+
+func TestStreamResumeAhead(t *testing.T) {
+	defer useTestFolder(t)()
+	last := recordStreamEvent("testahead", "phone1", "kitchen", 1000)
+	assert.True(t, last > 0)
+
+	router := gin.New()
+	router.GET("/api/v1/stream/:family", handlerStream)
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	// the client got its ID before the database was reset
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req, _ := http.NewRequest("GET", ts.URL+"/api/v1/stream/testahead", nil)
+	req.Header.Set("Last-Event-ID", "5000")
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	assert.Nil(t, err)
+	defer resp.Body.Close()
+
+	for i := 0; i < 100; i++ {
+		streams.Lock()
+		n := len(streams.subscribers["testahead"])
+		streams.Unlock()
+		if n > 0 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// the live updates after the last ID are sent, and the ones that could not be recorded without an id
+	SendMessageOverSSE("testahead", "phone1", "bedroom", last+1, []byte(`{"location":"bedroom"}`))
+	SendMessageOverSSE("testahead", "phone1", "kitchen", 0, []byte(`{"location":"kitchen"}`))
+
+	reader := bufio.NewReader(resp.Body)
+	lines := []string{}
+	for len(lines) < 7 {
+		line, err := reader.ReadString('\n')
+		assert.Nil(t, err)
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "retry:") {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	assert.Equal(t, []string{
+		"event: reset", fmt.Sprintf(`data: {"id":%d}`, last),
+		fmt.Sprintf("id: %d", last+1), "event: location", `data: {"location":"bedroom"}`,
+		"event: location", `data: {"location":"kitchen"}`,
+	}, lines)
+}
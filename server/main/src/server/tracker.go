@@ -0,0 +1,52 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+
+	"github.com/Nimaapr/find3/server/main/src/api"
+	"github.com/Nimaapr/find3/server/main/src/models"
+)
+
+func handlerWalkableAreas(c *gin.Context) {
+	family := strings.ToLower(strings.TrimSpace(c.Param("family")))
+	areas, err := api.GetWalkableAreas(family)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"message": err.Error(), "success": false})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "got walkable areas", "success": true, "walkable": areas})
+}
+
+// handlerSetWalkableArea replaces the walkable areas of the floor of the request
+func handlerSetWalkableArea(c *gin.Context) {
+	area, err := func(c *gin.Context) (area models.WalkableArea, err error) {
+		family := strings.ToLower(strings.TrimSpace(c.Param("family")))
+		err = c.BindJSON(&area)
+		if err != nil {
+			err = errors.Wrap(err, "problem binding data")
+			return
+		}
+		area.Floor = c.Param("floor")
+		area, err = api.SetWalkableArea(family, area)
+		return
+	}(c)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"message": err.Error(), "success": false})
+	} else {
+		c.JSON(http.StatusOK, gin.H{"message": "set walkable areas of " + area.Floor, "success": true, "walkable": area})
+	}
+}
+
+func handlerDeleteWalkableArea(c *gin.Context) {
+	family := strings.ToLower(strings.TrimSpace(c.Param("family")))
+	err := api.DeleteWalkableArea(family, c.Param("floor"))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"message": err.Error(), "success": false})
+	} else {
+		c.JSON(http.StatusOK, gin.H{"message": "deleted walkable areas of " + c.Param("floor"), "success": true})
+	}
+}
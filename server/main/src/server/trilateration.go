@@ -0,0 +1,57 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+
+	"github.com/Nimaapr/find3/server/main/src/api"
+	"github.com/Nimaapr/find3/server/main/src/models"
+)
+
+func handlerTrilateration(c *gin.Context) {
+	family := strings.ToLower(strings.TrimSpace(c.Param("family")))
+	trilateration, err := api.GetTrilateration(family)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"message": err.Error(), "success": false})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "got trilateration", "success": true, "trilateration": trilateration})
+}
+
+// handlerSetTrilateration places the access points of the request, and changes the path loss
+// model when it is given
+func handlerSetTrilateration(c *gin.Context) {
+	trilateration, err := func(c *gin.Context) (trilateration models.Trilateration, err error) {
+		family := strings.ToLower(strings.TrimSpace(c.Param("family")))
+		var request struct {
+			TxPower          *float64             `json:"tx_power"`
+			PathLossExponent *float64             `json:"path_loss_exponent"`
+			AccessPoints     []models.AccessPoint `json:"access_points"`
+		}
+		err = c.BindJSON(&request)
+		if err != nil {
+			err = errors.Wrap(err, "problem binding data")
+			return
+		}
+		trilateration, err = api.SetTrilateration(family, request.TxPower, request.PathLossExponent, request.AccessPoints)
+		return
+	}(c)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"message": err.Error(), "success": false})
+	} else {
+		c.JSON(http.StatusOK, gin.H{"message": "set trilateration", "success": true, "trilateration": trilateration})
+	}
+}
+
+func handlerDeleteAccessPoint(c *gin.Context) {
+	family := strings.ToLower(strings.TrimSpace(c.Param("family")))
+	err := api.DeleteAccessPoint(family, c.Param("mac"))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"message": err.Error(), "success": false})
+	} else {
+		c.JSON(http.StatusOK, gin.H{"message": "deleted access point " + c.Param("mac"), "success": true})
+	}
+}
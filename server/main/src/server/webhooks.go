@@ -0,0 +1,76 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+
+	"github.com/Nimaapr/find3/server/main/src/api"
+	"github.com/Nimaapr/find3/server/main/src/models"
+)
+
+// handlerWebhooks lists the webhooks of a family, without their secrets
+func handlerWebhooks(c *gin.Context) {
+	family := strings.ToLower(strings.TrimSpace(c.Param("family")))
+	webhooks, err := api.GetWebhooks(family)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"message": err.Error(), "success": false})
+		return
+	}
+	list := make([]models.Webhook, 0, len(webhooks))
+	for _, w := range webhooks {
+		w.Secret = ""
+		list = append(list, w)
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "got webhooks", "success": true, "webhooks": list})
+}
+
+// handlerAddWebhook adds a webhook, the response is the only time the secret is returned
+func handlerAddWebhook(c *gin.Context) {
+	webhook, err := func(c *gin.Context) (webhook models.Webhook, err error) {
+		family := strings.ToLower(strings.TrimSpace(c.Param("family")))
+		var w models.Webhook
+		err = c.BindJSON(&w)
+		if err != nil {
+			err = errors.Wrap(err, "problem binding data")
+			return
+		}
+		webhook, err = api.AddWebhook(family, w)
+		return
+	}(c)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"message": err.Error(), "success": false})
+	} else {
+		c.JSON(http.StatusOK, gin.H{"message": "added webhook " + webhook.ID, "success": true, "webhook": webhook})
+	}
+}
+
+func handlerDeleteWebhook(c *gin.Context) {
+	family := strings.ToLower(strings.TrimSpace(c.Param("family")))
+	err := api.DeleteWebhook(family, c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"message": err.Error(), "success": false})
+	} else {
+		c.JSON(http.StatusOK, gin.H{"message": "deleted webhook " + c.Param("id"), "success": true})
+	}
+}
+
+func handlerWebhookDeliveries(c *gin.Context) {
+	deliveries, err := func(c *gin.Context) (deliveries []models.WebhookDelivery, err error) {
+		family := strings.ToLower(strings.TrimSpace(c.Param("family")))
+		limit, err := strconv.Atoi(c.DefaultQuery("limit", "100"))
+		if err != nil {
+			return
+		}
+		deliveries, err = api.GetWebhookDeliveries(family, c.Param("id"), limit)
+		return
+	}(c)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"message": err.Error(), "success": false})
+	} else {
+		c.JSON(http.StatusOK, gin.H{"message": "got deliveries", "success": true, "deliveries": deliveries})
+	}
+}
@@ -72,7 +72,7 @@ func wshandler(c *gin.Context) {
 
 	conn, err := wsupgrader.Upgrade(w, r, nil)
 	if err != nil {
-		fmt.Println("Failed to set websocket upgrade: %+v", err)
+		fmt.Printf("Failed to set websocket upgrade: %+v\n", err)
 		return
 	}
 	ws.Lock()
@@ -0,0 +1,52 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+
+	"github.com/Nimaapr/find3/server/main/src/api"
+	"github.com/Nimaapr/find3/server/main/src/models"
+)
+
+func handlerZones(c *gin.Context) {
+	family := strings.ToLower(strings.TrimSpace(c.Param("family")))
+	zones, err := api.GetZones(family)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"message": err.Error(), "success": false})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "got zones", "success": true, "zones": zones})
+}
+
+// handlerSetZone adds a zone, or updates the zone with the same name
+func handlerSetZone(c *gin.Context) {
+	zone, err := func(c *gin.Context) (zone models.Zone, err error) {
+		family := strings.ToLower(strings.TrimSpace(c.Param("family")))
+		var z models.Zone
+		err = c.BindJSON(&z)
+		if err != nil {
+			err = errors.Wrap(err, "problem binding data")
+			return
+		}
+		zone, err = api.SetZone(family, z)
+		return
+	}(c)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"message": err.Error(), "success": false})
+	} else {
+		c.JSON(http.StatusOK, gin.H{"message": "set zone " + zone.Name, "success": true, "zone": zone})
+	}
+}
+
+func handlerDeleteZone(c *gin.Context) {
+	family := strings.ToLower(strings.TrimSpace(c.Param("family")))
+	err := api.DeleteZone(family, c.Param("zone"))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"message": err.Error(), "success": false})
+	} else {
+		c.JSON(http.StatusOK, gin.H{"message": "deleted zone " + c.Param("zone"), "success": true})
+	}
+}
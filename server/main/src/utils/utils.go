@@ -1,11 +1,12 @@
 package utils
 
 import (
+	"crypto/rand"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io/ioutil"
-	"math/rand"
+	mathrand "math/rand"
 	"strconv"
 	"strings"
 	"time"
@@ -30,7 +31,7 @@ func GetVendorFromOUI(s string) (string, error) {
 }
 
 // src is seeds the random generator for generating random strings
-var src = rand.NewSource(time.Now().UnixNano())
+var src = mathrand.NewSource(time.Now().UnixNano())
 
 const letterBytes = "abcdefghijklmnopqrstuvwxyz012345789"
 const (
@@ -58,12 +59,37 @@ func RandomString(n int) string {
 	return string(b)
 }
 
+// SecureRandomString returns a random string of n letters and digits from
+// crypto/rand, for secrets and passwords.
+func SecureRandomString(n int) string {
+	const alphabet = "abcdefghijkmnopqrstuvwxyzABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+	b := make([]byte, n)
+	random := make([]byte, 1)
+	for i := 0; i < n; {
+		if _, err := rand.Read(random); err != nil {
+			panic(err)
+		}
+		// reject the values that would bias the distribution
+		if int(random[0]) >= 256-256%len(alphabet) {
+			continue
+		}
+		b[i] = alphabet[int(random[0])%len(alphabet)]
+		i++
+	}
+	return string(b)
+}
+
 // IsMacRandomized takes a mac address like "wifi-60:57:18:3d:b8:14"
 // or "60:57:18:3d:b8:14" and pulls the first hex digit "60" and computes
 // whether or not it is randomized.
 // Randomized = Second-least-significant bit of first hex is 0
 // (https://en.wikipedia.org/wiki/MAC_address#Universal_vs._local)
+// The hashes of randomized addresses stored in privacy mode, like "wifi-~r3fa9...",
+// are randomized too.
 func IsMacRandomized(mac string) bool {
+	if IsHashedDevice(mac) {
+		return strings.HasPrefix(mac[strings.Index(mac, "-")+1:], "~r")
+	}
 	mac = strings.TrimPrefix(mac, "wifi-")
 	hexes := strings.Split(mac, ":")
 	if len(hexes) != 6 {
@@ -75,3 +101,9 @@ func IsMacRandomized(mac string) bool {
 	v, _ := strconv.ParseUint(hexes[0], 16, 8)
 	return fmt.Sprintf("%08b", v)[6] == byte(49)
 }
+
+// IsHashedDevice returns whether a device name like "wifi-~3fa9..." or "wifi-~r3fa9..."
+// is the hash of a device that was stored in privacy mode
+func IsHashedDevice(device string) bool {
+	return strings.HasPrefix(device[strings.Index(device, "-")+1:], "~")
+}